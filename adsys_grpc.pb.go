@@ -24,11 +24,15 @@ type ServiceClient interface {
 	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (Service_StopClient, error)
 	UpdatePolicy(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (Service_UpdatePolicyClient, error)
 	DumpPolicies(ctx context.Context, in *DumpPoliciesRequest, opts ...grpc.CallOption) (Service_DumpPoliciesClient, error)
+	PolicyHistory(ctx context.Context, in *PolicyHistoryRequest, opts ...grpc.CallOption) (Service_PolicyHistoryClient, error)
+	ExplainPolicy(ctx context.Context, in *ExplainPolicyRequest, opts ...grpc.CallOption) (Service_ExplainPolicyClient, error)
+	PolicyReport(ctx context.Context, in *PolicyReportRequest, opts ...grpc.CallOption) (Service_PolicyReportClient, error)
+	PurgePolicy(ctx context.Context, in *PurgePolicyRequest, opts ...grpc.CallOption) (Service_PurgePolicyClient, error)
 	DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (Service_DumpPoliciesDefinitionsClient, error)
 	GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (Service_GetDocClient, error)
 	ListDoc(ctx context.Context, in *ListDocRequest, opts ...grpc.CallOption) (Service_ListDocClient, error)
 	ListActiveUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ListActiveUsersClient, error)
-	GPOListScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GPOListScriptClient, error)
+	GarbageCollect(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GarbageCollectClient, error)
 }
 
 type serviceClient struct {
@@ -183,7 +187,7 @@ func (c *serviceClient) UpdatePolicy(ctx context.Context, in *UpdatePolicyReques
 }
 
 type Service_UpdatePolicyClient interface {
-	Recv() (*Empty, error)
+	Recv() (*StringResponse, error)
 	grpc.ClientStream
 }
 
@@ -191,8 +195,8 @@ type serviceUpdatePolicyClient struct {
 	grpc.ClientStream
 }
 
-func (x *serviceUpdatePolicyClient) Recv() (*Empty, error) {
-	m := new(Empty)
+func (x *serviceUpdatePolicyClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -215,7 +219,7 @@ func (c *serviceClient) DumpPolicies(ctx context.Context, in *DumpPoliciesReques
 }
 
 type Service_DumpPoliciesClient interface {
-	Recv() (*StringResponse, error)
+	Recv() (*DumpPoliciesResponse, error)
 	grpc.ClientStream
 }
 
@@ -223,7 +227,135 @@ type serviceDumpPoliciesClient struct {
 	grpc.ClientStream
 }
 
-func (x *serviceDumpPoliciesClient) Recv() (*StringResponse, error) {
+func (x *serviceDumpPoliciesClient) Recv() (*DumpPoliciesResponse, error) {
+	m := new(DumpPoliciesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) PolicyHistory(ctx context.Context, in *PolicyHistoryRequest, opts ...grpc.CallOption) (Service_PolicyHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], "/service/PolicyHistory", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePolicyHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_PolicyHistoryClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type servicePolicyHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePolicyHistoryClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) ExplainPolicy(ctx context.Context, in *ExplainPolicyRequest, opts ...grpc.CallOption) (Service_ExplainPolicyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], "/service/ExplainPolicy", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceExplainPolicyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_ExplainPolicyClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceExplainPolicyClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceExplainPolicyClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) PolicyReport(ctx context.Context, in *PolicyReportRequest, opts ...grpc.CallOption) (Service_PolicyReportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], "/service/PolicyReport", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePolicyReportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_PolicyReportClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type servicePolicyReportClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePolicyReportClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) PurgePolicy(ctx context.Context, in *PurgePolicyRequest, opts ...grpc.CallOption) (Service_PurgePolicyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], "/service/PurgePolicy", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePurgePolicyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_PurgePolicyClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type servicePurgePolicyClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePurgePolicyClient) Recv() (*StringResponse, error) {
 	m := new(StringResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
@@ -232,7 +364,7 @@ func (x *serviceDumpPoliciesClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (Service_DumpPoliciesDefinitionsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], "/service/DumpPoliciesDefinitions", opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], "/service/DumpPoliciesDefinitions", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -264,7 +396,7 @@ func (x *serviceDumpPoliciesDefinitionsClient) Recv() (*DumpPolicyDefinitionsRes
 }
 
 func (c *serviceClient) GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (Service_GetDocClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], "/service/GetDoc", opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[11], "/service/GetDoc", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -296,7 +428,7 @@ func (x *serviceGetDocClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) ListDoc(ctx context.Context, in *ListDocRequest, opts ...grpc.CallOption) (Service_ListDocClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], "/service/ListDoc", opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[12], "/service/ListDoc", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -328,7 +460,7 @@ func (x *serviceListDocClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) ListActiveUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ListActiveUsersClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], "/service/ListActiveUsers", opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[13], "/service/ListActiveUsers", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -359,12 +491,12 @@ func (x *serviceListActiveUsersClient) Recv() (*StringResponse, error) {
 	return m, nil
 }
 
-func (c *serviceClient) GPOListScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GPOListScriptClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], "/service/GPOListScript", opts...)
+func (c *serviceClient) GarbageCollect(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GarbageCollectClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[14], "/service/GarbageCollect", opts...)
 	if err != nil {
 		return nil, err
 	}
-	x := &serviceGPOListScriptClient{stream}
+	x := &serviceGarbageCollectClient{stream}
 	if err := x.ClientStream.SendMsg(in); err != nil {
 		return nil, err
 	}
@@ -374,16 +506,16 @@ func (c *serviceClient) GPOListScript(ctx context.Context, in *Empty, opts ...gr
 	return x, nil
 }
 
-type Service_GPOListScriptClient interface {
+type Service_GarbageCollectClient interface {
 	Recv() (*StringResponse, error)
 	grpc.ClientStream
 }
 
-type serviceGPOListScriptClient struct {
+type serviceGarbageCollectClient struct {
 	grpc.ClientStream
 }
 
-func (x *serviceGPOListScriptClient) Recv() (*StringResponse, error) {
+func (x *serviceGarbageCollectClient) Recv() (*StringResponse, error) {
 	m := new(StringResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
@@ -401,11 +533,15 @@ type ServiceServer interface {
 	Stop(*StopRequest, Service_StopServer) error
 	UpdatePolicy(*UpdatePolicyRequest, Service_UpdatePolicyServer) error
 	DumpPolicies(*DumpPoliciesRequest, Service_DumpPoliciesServer) error
+	PolicyHistory(*PolicyHistoryRequest, Service_PolicyHistoryServer) error
+	ExplainPolicy(*ExplainPolicyRequest, Service_ExplainPolicyServer) error
+	PolicyReport(*PolicyReportRequest, Service_PolicyReportServer) error
+	PurgePolicy(*PurgePolicyRequest, Service_PurgePolicyServer) error
 	DumpPoliciesDefinitions(*DumpPolicyDefinitionsRequest, Service_DumpPoliciesDefinitionsServer) error
 	GetDoc(*GetDocRequest, Service_GetDocServer) error
 	ListDoc(*ListDocRequest, Service_ListDocServer) error
 	ListActiveUsers(*Empty, Service_ListActiveUsersServer) error
-	GPOListScript(*Empty, Service_GPOListScriptServer) error
+	GarbageCollect(*Empty, Service_GarbageCollectServer) error
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -431,6 +567,18 @@ func (UnimplementedServiceServer) UpdatePolicy(*UpdatePolicyRequest, Service_Upd
 func (UnimplementedServiceServer) DumpPolicies(*DumpPoliciesRequest, Service_DumpPoliciesServer) error {
 	return status.Errorf(codes.Unimplemented, "method DumpPolicies not implemented")
 }
+func (UnimplementedServiceServer) PolicyHistory(*PolicyHistoryRequest, Service_PolicyHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method PolicyHistory not implemented")
+}
+func (UnimplementedServiceServer) ExplainPolicy(*ExplainPolicyRequest, Service_ExplainPolicyServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExplainPolicy not implemented")
+}
+func (UnimplementedServiceServer) PolicyReport(*PolicyReportRequest, Service_PolicyReportServer) error {
+	return status.Errorf(codes.Unimplemented, "method PolicyReport not implemented")
+}
+func (UnimplementedServiceServer) PurgePolicy(*PurgePolicyRequest, Service_PurgePolicyServer) error {
+	return status.Errorf(codes.Unimplemented, "method PurgePolicy not implemented")
+}
 func (UnimplementedServiceServer) DumpPoliciesDefinitions(*DumpPolicyDefinitionsRequest, Service_DumpPoliciesDefinitionsServer) error {
 	return status.Errorf(codes.Unimplemented, "method DumpPoliciesDefinitions not implemented")
 }
@@ -443,8 +591,8 @@ func (UnimplementedServiceServer) ListDoc(*ListDocRequest, Service_ListDocServer
 func (UnimplementedServiceServer) ListActiveUsers(*Empty, Service_ListActiveUsersServer) error {
 	return status.Errorf(codes.Unimplemented, "method ListActiveUsers not implemented")
 }
-func (UnimplementedServiceServer) GPOListScript(*Empty, Service_GPOListScriptServer) error {
-	return status.Errorf(codes.Unimplemented, "method GPOListScript not implemented")
+func (UnimplementedServiceServer) GarbageCollect(*Empty, Service_GarbageCollectServer) error {
+	return status.Errorf(codes.Unimplemented, "method GarbageCollect not implemented")
 }
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 
@@ -552,7 +700,7 @@ func _Service_UpdatePolicy_Handler(srv interface{}, stream grpc.ServerStream) er
 }
 
 type Service_UpdatePolicyServer interface {
-	Send(*Empty) error
+	Send(*StringResponse) error
 	grpc.ServerStream
 }
 
@@ -560,7 +708,7 @@ type serviceUpdatePolicyServer struct {
 	grpc.ServerStream
 }
 
-func (x *serviceUpdatePolicyServer) Send(m *Empty) error {
+func (x *serviceUpdatePolicyServer) Send(m *StringResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -573,7 +721,7 @@ func _Service_DumpPolicies_Handler(srv interface{}, stream grpc.ServerStream) er
 }
 
 type Service_DumpPoliciesServer interface {
-	Send(*StringResponse) error
+	Send(*DumpPoliciesResponse) error
 	grpc.ServerStream
 }
 
@@ -581,7 +729,91 @@ type serviceDumpPoliciesServer struct {
 	grpc.ServerStream
 }
 
-func (x *serviceDumpPoliciesServer) Send(m *StringResponse) error {
+func (x *serviceDumpPoliciesServer) Send(m *DumpPoliciesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_PolicyHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PolicyHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PolicyHistory(m, &servicePolicyHistoryServer{stream})
+}
+
+type Service_PolicyHistoryServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type servicePolicyHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePolicyHistoryServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_ExplainPolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExplainPolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ExplainPolicy(m, &serviceExplainPolicyServer{stream})
+}
+
+type Service_ExplainPolicyServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceExplainPolicyServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceExplainPolicyServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_PolicyReport_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PolicyReportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PolicyReport(m, &servicePolicyReportServer{stream})
+}
+
+type Service_PolicyReportServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type servicePolicyReportServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePolicyReportServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_PurgePolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PurgePolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PurgePolicy(m, &servicePurgePolicyServer{stream})
+}
+
+type Service_PurgePolicyServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type servicePurgePolicyServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePurgePolicyServer) Send(m *StringResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -669,24 +901,24 @@ func (x *serviceListActiveUsersServer) Send(m *StringResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func _Service_GPOListScript_Handler(srv interface{}, stream grpc.ServerStream) error {
+func _Service_GarbageCollect_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(Empty)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
-	return srv.(ServiceServer).GPOListScript(m, &serviceGPOListScriptServer{stream})
+	return srv.(ServiceServer).GarbageCollect(m, &serviceGarbageCollectServer{stream})
 }
 
-type Service_GPOListScriptServer interface {
+type Service_GarbageCollectServer interface {
 	Send(*StringResponse) error
 	grpc.ServerStream
 }
 
-type serviceGPOListScriptServer struct {
+type serviceGarbageCollectServer struct {
 	grpc.ServerStream
 }
 
-func (x *serviceGPOListScriptServer) Send(m *StringResponse) error {
+func (x *serviceGarbageCollectServer) Send(m *StringResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -728,6 +960,26 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Service_DumpPolicies_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "PolicyHistory",
+			Handler:       _Service_PolicyHistory_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExplainPolicy",
+			Handler:       _Service_ExplainPolicy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PolicyReport",
+			Handler:       _Service_PolicyReport_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PurgePolicy",
+			Handler:       _Service_PurgePolicy_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "DumpPoliciesDefinitions",
 			Handler:       _Service_DumpPoliciesDefinitions_Handler,
@@ -749,8 +1001,8 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 		},
 		{
-			StreamName:    "GPOListScript",
-			Handler:       _Service_GPOListScript_Handler,
+			StreamName:    "GarbageCollect",
+			Handler:       _Service_GarbageCollect_Handler,
 			ServerStreams: true,
 		},
 	},
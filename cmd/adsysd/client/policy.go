@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"gopkg.in/yaml.v3"
 )
 
 func (a *App) installPolicy() {
@@ -44,6 +46,7 @@ func (a *App) installPolicy() {
 	policyCmd.AddCommand(mainCmd)
 
 	var details, all, nocolor *bool
+	var format *string
 	appliedCmd := &cobra.Command{
 		Use:   "applied [USER_NAME]",
 		Short: i18n.G("Print last applied GPOs for current or given user/machine"),
@@ -60,33 +63,130 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				target = args[0]
 			}
-			return a.dumpPolicies(target, *details, *all, *nocolor)
+			return a.dumpPolicies(target, *details, *all, *nocolor, *format)
 		},
 	}
 	details = appliedCmd.Flags().BoolP("details", "", false, i18n.G("show applied rules in addition to GPOs."))
 	all = appliedCmd.Flags().BoolP("all", "a", false, i18n.G("show overridden rules in each GPOs."))
 	nocolor = appliedCmd.Flags().BoolP("no-color", "", false, i18n.G("don't display colorized version."))
+	format = appliedCmd.Flags().StringP("format", "", "", i18n.G("machine-readable output format: json or yaml. It always contains every rule, with their overridden state."))
 	policyCmd.AddCommand(appliedCmd)
 	cmdhandler.RegisterAlias(appliedCmd, &a.rootCmd)
 
-	debugCmd := &cobra.Command{
-		Use:    "debug",
-		Short:  i18n.G("Debug various policy infos"),
-		Hidden: true,
-		Args:   cmdhandler.SubcommandsRequiredWithSuggestions,
-		RunE:   cmdhandler.NoCmd,
+	historyCmd := &cobra.Command{
+		Use:   "history [USER_NAME]",
+		Short: i18n.G("Print the history of applied policies for current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.completeWithConnectedUsers()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.policyHistory(target, false, "")
+		},
 	}
-	policyCmd.AddCommand(debugCmd)
-	gpoListCmd := &cobra.Command{
-		Use:               "gpolist-script",
-		Short:             i18n.G("Write GPO list python embeeded script in current directory"),
-		Args:              cobra.NoArgs,
-		ValidArgsFunction: cmdhandler.NoValidArgs,
-		RunE:              func(cmd *cobra.Command, args []string) error { return a.dumpGPOListScript() },
+	policyCmd.AddCommand(historyCmd)
+
+	var since *string
+	diffCmd := &cobra.Command{
+		Use:   "diff [USER_NAME]",
+		Short: i18n.G("Print the rules changes of applied policies for current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.completeWithConnectedUsers()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.policyHistory(target, true, *since)
+		},
+	}
+	since = diffCmd.Flags().StringP("since", "", "1", i18n.G("snapshot to compare with: number of refreshes back as listed by history, duration like 24h, or date like 2006-01-02 15:04."))
+	policyCmd.AddCommand(diffCmd)
+
+	explainCmd := &cobra.Command{
+		Use:   "explain KEY [USER_NAME]",
+		Short: i18n.G("Print which GPOs define a policy key for current or given user/machine, and which one wins"),
+		Long: i18n.G(`Print which GPOs define a policy key for current or given user/machine, and which one wins.
+KEY is the rule domain followed by the key, like dconf/org/gnome/desktop/interface/clock-format. Without a rule domain,
+the key is searched in all of them.`),
+		Args: cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.completeWithConnectedUsers()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 1 {
+				target = args[1]
+			}
+			return a.explainPolicy(args[0], target)
+		},
 	}
-	debugCmd.AddCommand(gpoListCmd)
+	policyCmd.AddCommand(explainCmd)
+
+	var html *string
+	reportCmd := &cobra.Command{
+		Use:   "report [USER_NAME]",
+		Short: i18n.G("Write a report of the applied policies for current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
 
-	var updateMachine, updateAll *bool
+			return a.completeWithConnectedUsers()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.policyReport(target, *html)
+		},
+	}
+	html = reportCmd.Flags().StringP("html", "", "", i18n.G("write the report as an HTML page to this file."))
+	policyCmd.AddCommand(reportCmd)
+
+	var allStale *bool
+	purgeCmd := &cobra.Command{
+		Use:   "purge [USER_NAME]",
+		Short: i18n.G("Remove the policies left behind by given user or by all users which don't exist anymore"),
+		Long: i18n.G(`Remove the policies left behind by given user or by all users which don't exist anymore.
+Only adsys dconf databases, profile lines, apparmor hats and rules cache are removed: other lines of the dconf profiles are kept.
+With --all-stale, users without any dconf policy anymore also get their dconf databases removed.`),
+		Args: cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.purgePolicy(target, *allStale)
+		},
+	}
+	allStale = purgeCmd.Flags().BoolP("all-stale", "", false, i18n.G("purge all users which don't exist anymore or have no dconf policy. USER_NAME cannot be used with this option."))
+	policyCmd.AddCommand(purgeCmd)
+
+	var updateMachine, updateAll, updateDryRun *bool
 	updateCmd := &cobra.Command{
 		Use:   "update [USER_NAME KERBEROS_TICKET_PATH]",
 		Short: i18n.G("Updates/Create a policy for current user or given user with its kerberos ticket"),
@@ -113,11 +213,12 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				user, krb5cc = args[0], args[1]
 			}
-			return a.update(*updateMachine, *updateAll, user, krb5cc)
+			return a.update(*updateMachine, *updateAll, *updateDryRun, user, krb5cc)
 		},
 	}
 	updateMachine = updateCmd.Flags().BoolP("machine", "m", false, i18n.G("machine updates the policy of the computer."))
 	updateAll = updateCmd.Flags().BoolP("all", "a", false, i18n.G("all updates the policy of the computer and all the logged in users. -m or USER_NAME/TICKET cannot be used with this option."))
+	updateDryRun = updateCmd.Flags().BoolP("dry-run", "", false, i18n.G("dry-run downloads and parses the GPOs, then displays the planned changes without applying them."))
 	policyCmd.AddCommand(updateCmd)
 	cmdhandler.RegisterAlias(updateCmd, &a.rootCmd)
 
@@ -167,7 +268,11 @@ func (a App) getPolicyDefinitions(format, distroID string) (err error) {
 	return nil
 }
 
-func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor bool) error {
+func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor bool, format string) error {
+	if format != "" && format != "json" && format != "yaml" {
+		return fmt.Errorf(i18n.G("unsupported format %q: only json and yaml are supported"), format)
+	}
+
 	// incompatible options
 	if showOverridden && !showDetails {
 		showDetails = true
@@ -197,11 +302,31 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor b
 		return err
 	}
 
-	policies, err := singleMsg(stream)
-	if err != nil {
-		return err
+	var r *adsys.DumpPoliciesResponse
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if r != nil {
+			return fmt.Errorf("multiple answers from service streamed while we expected only one.\nWe already got:\n%s\n\nAnd now we are getting:\n%s", r.GetMsg(), msg.GetMsg())
+		}
+		r = msg
 	}
 
+	if format != "" {
+		out, err := formatAppliedPolicies(r.GetGpos(), format)
+		if err != nil {
+			return err
+		}
+		fmt.Print(out)
+		return nil
+	}
+
+	policies := r.GetMsg()
 	if nocolor {
 		color.NoColor = true
 	}
@@ -214,24 +339,171 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor b
 	return nil
 }
 
-func (a *App) dumpGPOListScript() error {
+// policyHistory prints the history of applied policies for target, or its changes since a given snapshot.
+func (a *App) policyHistory(target string, diff bool, since string) error {
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	stream, err := client.GPOListScript(a.ctx, &adsys.Empty{})
+	// History for current user
+	if target == "" {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve current user: %v", err)
+		}
+		target = u.Username
+	}
+
+	stream, err := client.PolicyHistory(a.ctx, &adsys.PolicyHistoryRequest{
+		Target: target,
+		Diff:   diff,
+		Since:  since,
+	})
 	if err != nil {
 		return err
 	}
 
-	script, err := singleMsg(stream)
+	msg, err := singleMsg(stream)
 	if err != nil {
 		return err
 	}
+	fmt.Print(msg)
 
-	return os.WriteFile("adsys-gpolist", []byte(script), 0750)
+	return nil
+}
+
+// explainPolicy prints the GPOs defining key for target, in priority order.
+func (a *App) explainPolicy(key, target string) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Explanation for current user
+	if target == "" {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve current user: %v", err)
+		}
+		target = u.Username
+	}
+
+	stream, err := client.ExplainPolicy(a.ctx, &adsys.ExplainPolicyRequest{
+		Target: target,
+		Key:    key,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+
+	return nil
+}
+
+// policyReport writes to path an HTML report of the applied policies for target.
+func (a *App) policyReport(target, path string) error {
+	if path == "" {
+		return errors.New(i18n.G("an output file is required with --html"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Report for current user
+	if target == "" {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve current user: %v", err)
+		}
+		target = u.Username
+	}
+
+	stream, err := client.PolicyReport(a.ctx, &adsys.PolicyReportRequest{
+		Target: target,
+	})
+	if err != nil {
+		return err
+	}
+
+	report, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(report), 0600)
+}
+
+// appliedGPO is the machine-readable representation of an applied GPO.
+type appliedGPO struct {
+	ID         string        `json:"id" yaml:"id"`
+	Name       string        `json:"name" yaml:"name"`
+	Origin     string        `json:"origin" yaml:"origin"`
+	Extensions []string      `json:"extensions" yaml:"extensions"`
+	Rules      []appliedRule `json:"rules" yaml:"rules"`
+}
+
+// appliedRule is the machine-readable representation of a rule of an applied GPO.
+type appliedRule struct {
+	Domain     string `json:"domain" yaml:"domain"`
+	Key        string `json:"key" yaml:"key"`
+	Value      string `json:"value" yaml:"value"`
+	Disabled   bool   `json:"disabled" yaml:"disabled"`
+	Overridden bool   `json:"overridden" yaml:"overridden"`
+}
+
+// formatAppliedPolicies returns the applied GPOs serialized in format, json or yaml.
+func formatAppliedPolicies(gpos []*adsys.AppliedGPO, format string) (string, error) {
+	out := make([]appliedGPO, 0, len(gpos))
+	for _, g := range gpos {
+		origin := "user"
+		if g.GetIsComputer() {
+			origin = "machine"
+		}
+		gpo := appliedGPO{
+			ID:         g.GetId(),
+			Name:       g.GetName(),
+			Origin:     origin,
+			Extensions: append(make([]string, 0, len(g.GetExtensions())), g.GetExtensions()...),
+			Rules:      make([]appliedRule, 0, len(g.GetRules())),
+		}
+		for _, r := range g.GetRules() {
+			gpo.Rules = append(gpo.Rules, appliedRule{
+				Domain:     r.GetDomain(),
+				Key:        r.GetKey(),
+				Value:      r.GetValue(),
+				Disabled:   r.GetDisabled(),
+				Overridden: r.GetOverridden(),
+			})
+		}
+		out = append(out, gpo)
+	}
+
+	var d []byte
+	var err error
+	switch format {
+	case "json":
+		d, err = json.MarshalIndent(out, "", "  ")
+		d = append(d, '\n')
+	case "yaml":
+		d, err = yaml.Marshal(out)
+	default:
+		return "", fmt.Errorf(i18n.G("unsupported format %q: only json and yaml are supported"), format)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
 }
 
 func colorizePolicies(policies string) (string, error) {
@@ -278,6 +550,10 @@ func colorizePolicies(policies string) (string, error) {
 			e = strings.TrimSpace(e)
 			out.Println(fmt.Sprintf("    - %s", bold.Sprint(e)))
 
+		} else if e := strings.TrimPrefix(l, "  "); e != l {
+			// Client-side extensions of the GPO
+			out.Println(fmt.Sprintf("    %s", color.HiBlackString(strings.TrimSpace(e))))
+
 		} else if e := strings.TrimPrefix(l, "*"); e != l {
 			// GPO
 			e = strings.TrimSpace(e)
@@ -313,7 +589,7 @@ func (s *stringsBuilderWithError) Println(l string) {
 	_, s.err = s.Builder.WriteString(l)
 }
 
-func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
+func (a *App) update(isComputer, updateAll, dryRun bool, target, krb5cc string) error {
 	// incompatible options
 	if updateAll && (isComputer || target != "" || krb5cc != "") {
 		return errors.New(i18n.G("machine or user arguments cannot be used with update all"))
@@ -355,15 +631,58 @@ func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
 		IsComputer: isComputer,
 		All:        updateAll,
 		Target:     target,
-		Krb5Cc:     krb5cc})
+		Krb5Cc:     krb5cc,
+		DryRun:     dryRun})
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		// Only dry runs report planned changes
+		fmt.Print(msg.GetMsg())
+	}
+
+	return nil
+}
+
+// purgePolicy removes the policies left behind by target, or by all stale users.
+func (a *App) purgePolicy(target string, allStale bool) error {
+	if (target == "") == !allStale {
+		return errors.New(i18n.G("exactly one of USER_NAME or --all-stale is required"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
 		return err
 	}
+	defer client.Close()
 
-	if _, err := stream.Recv(); err != nil && err != io.EOF {
+	stream, err := client.PurgePolicy(a.ctx, &adsys.PurgePolicyRequest{
+		Target:   target,
+		AllStale: allStale,
+	})
+	if err != nil {
 		return err
 	}
 
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		fmt.Printf(i18n.G("Purged policies of %s\n"), msg.GetMsg())
+	}
+
 	return nil
 }
 
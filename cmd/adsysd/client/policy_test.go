@@ -2,10 +2,12 @@ package client
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/fatih/color"
 	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys"
 )
 
 func TestColorizePolicies(t *testing.T) {
@@ -17,6 +19,7 @@ func TestColorizePolicies(t *testing.T) {
 ** scripts:
 ***+ path/to/key3
 * GPOName2 ({GPOId2})
+  Extensions: Registry, Scripts
 ** dconf:
 *** path/to/keyGpo2-1: ValueOfKeyGpo2-1
 Policies from user configuration:
@@ -51,3 +54,46 @@ Policies from user configuration:
 
 	require.Equal(t, string(want), got, "colorizePolicies returned expected formatted output")
 }
+
+func TestFormatAppliedPolicies(t *testing.T) {
+	t.Parallel()
+
+	gpos := []*adsys.AppliedGPO{
+		{Id: "{GPOId1}", Name: "GPOName1", IsComputer: true, Rules: []*adsys.AppliedRule{
+			{Domain: "dconf", Key: "path/to/key1", Value: "ValueOfKey1"},
+			{Domain: "scripts", Key: "path/to/key2", Disabled: true},
+		}},
+		{Id: "{GPOId2}", Name: "GPOName2", Extensions: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}"}, Rules: []*adsys.AppliedRule{
+			{Domain: "dconf", Key: "path/to/key1", Value: "ValueOfKey1\nOn\nMultilines", Overridden: true},
+		}},
+		{Id: "{GPOId3}", Name: "GPO without rules"},
+	}
+
+	tests := map[string]struct {
+		format string
+
+		wantErr bool
+	}{
+		"json": {format: "json"},
+		"yaml": {format: "yaml"},
+
+		"Error on unsupported format": {format: "xml", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := formatAppliedPolicies(gpos, tc.format)
+			if tc.wantErr {
+				require.Error(t, err, "formatAppliedPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "formatAppliedPolicies should not return an error")
+
+			want, err := os.ReadFile(filepath.Join("testdata", "golden", "applied."+tc.format+".golden"))
+			require.NoError(t, err, "Setup: failed to read formatted policies golden file")
+			require.Equal(t, string(want), got, "formatAppliedPolicies returned expected output")
+		})
+	}
+}
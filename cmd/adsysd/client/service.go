@@ -50,6 +50,16 @@ func (a *App) installService() {
 	}
 	stopForce = cmd.Flags().BoolP("force", "f", false, i18n.G("force will shut it down immediately and drop existing connections."))
 	mainCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:               "gc",
+		Short:             i18n.G("Remove from cache the GPOs which are not applied anymore"),
+		Long:              i18n.G("Remove from cache the GPOs which are not applied to the machine or any user anymore, and were not used during the grace period configured in the daemon."),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.serviceGC() },
+	}
+	mainCmd.AddCommand(cmd)
 }
 
 func (a *App) serviceCat() error {
@@ -124,3 +134,30 @@ func (a *App) serviceStop(force bool) error {
 
 	return nil
 }
+
+// serviceGC requests the service to remove from cache the GPOs which are not used anymore.
+func (a *App) serviceGC() error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.GarbageCollect(a.ctx, &adsys.Empty{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		fmt.Printf(i18n.G("Removed GPO %s from cache\n"), msg.GetMsg())
+	}
+
+	return nil
+}
@@ -39,12 +39,16 @@ type daemonConfig struct {
 	CacheDir string `mapstructure:"cache_dir"`
 	RunDir   string `mapstructure:"run_dir"`
 
-	DconfDir    string `mapstructure:"dconf_dir"`
-	SSSCacheDir string `mapstructure:"sss_cache_dir"`
+	DconfDir            string `mapstructure:"dconf_dir"`
+	GSettingsSchemasDir string `mapstructure:"gsettings_schemas_dir"`
+	SSSCacheDir         string `mapstructure:"sss_cache_dir"`
 
 	ServiceTimeout int    `mapstructure:"service_timeout"`
 	ADServer       string `mapstructure:"ad_server"`
 	ADDomain       string `mapstructure:"ad_domain"`
+	GPOSourceDir   string `mapstructure:"gpo_source_dir"`
+
+	GPOCacheGracePeriod int `mapstructure:"gpo_cache_grace_period"`
 }
 
 // New registers commands and return a new App.
@@ -103,7 +107,10 @@ func New() *App {
 				adsysservice.WithCacheDir(a.config.CacheDir),
 				adsysservice.WithRunDir(a.config.RunDir),
 				adsysservice.WithDconfDir(a.config.DconfDir),
+				adsysservice.WithGSettingsSchemasDir(a.config.GSettingsSchemasDir),
 				adsysservice.WithSSSCacheDir(a.config.SSSCacheDir),
+				adsysservice.WithGPOSourceDir(a.config.GPOSourceDir),
+				adsysservice.WithGPOCacheGracePeriod(time.Duration(a.config.GPOCacheGracePeriod)*24*time.Hour),
 			)
 			if err != nil {
 				close(a.ready)
@@ -143,10 +150,17 @@ func New() *App {
 	decorate.LogOnError(a.viper.BindPFlag("ad_server", a.rootCmd.PersistentFlags().Lookup("ad-server")))
 	a.rootCmd.PersistentFlags().StringP("ad-domain", "D", "", i18n.G("AD domain to use. Empty to let ADSys parsing sssd.conf."))
 	decorate.LogOnError(a.viper.BindPFlag("ad_domain", a.rootCmd.PersistentFlags().Lookup("ad-domain")))
+	a.rootCmd.PersistentFlags().StringP("gpo-source-dir", "", "", i18n.G("local directory of GPOs to apply instead of the ones from Active Directory, for testing or pre-staging without a domain controller."))
+	decorate.LogOnError(a.viper.BindPFlag("gpo_source_dir", a.rootCmd.PersistentFlags().Lookup("gpo-source-dir")))
+	a.rootCmd.PersistentFlags().IntP("gpo-cache-grace-period", "", consts.DefaultGPOCacheGracePeriod, i18n.G("time in days a GPO which is not applied to any user or machine anymore is kept in cache after its last use."))
+	decorate.LogOnError(a.viper.BindPFlag("gpo_cache_grace_period", a.rootCmd.PersistentFlags().Lookup("gpo-cache-grace-period")))
 
 	// subcommands
 	cmdhandler.InstallCompletionCmd(&a.rootCmd)
 	a.installVersion()
+	a.installRunScripts()
+	a.installMount()
+	a.installEnvironment()
 
 	return &a
 }
@@ -0,0 +1,25 @@
+package daemon
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/config"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+)
+
+func (a *App) installEnvironment() {
+	cmd := &cobra.Command{
+		Use:    "environment ENVIRONMENT_FILE",
+		Short:  i18n.G("Prints the expanded variables of ENVIRONMENT_FILE for a systemd environment generator"),
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetVerboseMode(a.config.Verbose)
+			return environment.Generate(context.Background(), os.Stdout, args[0])
+		},
+	}
+	a.rootCmd.AddCommand(cmd)
+}
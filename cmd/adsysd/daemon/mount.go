@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/config"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/mount"
+)
+
+func (a *App) installMount() {
+	cmd := &cobra.Command{
+		Use:    "mount MOUNTS_DIR",
+		Short:  i18n.G("Mounts network shares staged in MOUNTS_DIR for the current user"),
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetVerboseMode(a.config.Verbose)
+			return mount.RunMounts(context.Background(), args[0])
+		},
+	}
+	a.rootCmd.AddCommand(cmd)
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/config"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
+)
+
+func (a *App) installRunScripts() {
+	var allowOrderMissing *bool
+	cmd := &cobra.Command{
+		Use:    "runscripts ORDER_FILE",
+		Short:  i18n.G("Runs scripts listed in ORDER_FILE"),
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetVerboseMode(a.config.Verbose)
+			return scripts.RunScripts(context.Background(), args[0], *allowOrderMissing)
+		},
+	}
+	allowOrderMissing = cmd.Flags().BoolP("allow-order-missing", "", false, i18n.G("allow ORDER_FILE or the scripts staging to be missing."))
+	a.rootCmd.AddCommand(cmd)
+}
@@ -84,16 +84,15 @@ socket: %s`, socket)), 0644)
 	tests := map[string]struct {
 		args []string
 	}{
-		"doc":                         {args: []string{"doc"}},
-		"doc chapter":                 {args: []string{"doc", "chapter"}},
-		"policy admx all":             {args: []string{"policy", "admx", "all"}},
-		"policy applied":              {args: []string{"policy", "applied"}},
-		"policy debug gpolist-script": {args: []string{"policy", "debug", "gpolist-script"}},
-		"policy update":               {args: []string{"policy", "update"}},
-		"service cat":                 {args: []string{"service", "cat"}},
-		"service status":              {args: []string{"service", "status"}},
-		"service stop":                {args: []string{"service", "stop"}},
-		"version":                     {args: []string{"version"}},
+		"doc":             {args: []string{"doc"}},
+		"doc chapter":     {args: []string{"doc", "chapter"}},
+		"policy admx all": {args: []string{"policy", "admx", "all"}},
+		"policy applied":  {args: []string{"policy", "applied"}},
+		"policy update":   {args: []string{"policy", "update"}},
+		"service cat":     {args: []string{"service", "cat"}},
+		"service status":  {args: []string{"service", "status"}},
+		"service stop":    {args: []string{"service", "stop"}},
+		"version":         {args: []string{"version"}},
 	}
 	for name, tc := range tests {
 		tc := tc
@@ -205,8 +204,9 @@ ad_domain: example.com
 
 # Those are more for tests
 dconf_dir: %s/dconf
+gsettings_schemas_dir: %s/schemas
 sss_cache_dir: %s/sss_cache
-`, dir, dir, dir, dir, dir)), 0644)
+`, dir, dir, dir, dir, dir, dir)), 0644)
 	require.NoError(t, err, "Setup: config file should be created")
 
 	require.NoError(t, os.MkdirAll(filepath.Join(dir, "dconf"), 0755), "Setup: should create dconf dir")
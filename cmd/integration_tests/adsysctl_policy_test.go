@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
@@ -172,8 +171,6 @@ func TestPolicyUpdate(t *testing.T) {
 		err := exec.Command("pkg-config", "--exists", "nss_wrapper").Run()
 		require.NoError(t, err, "libnss_wrapper is not installed on disk, either skip integration tests or install it")
 
-		testutils.PythonCoverageToGoFormat(t, "../../internal/policies/ad/adsys-gpolist", true)
-
 		var subArgs []string
 		// We are going to only reexec ourself: only take options (without -run)
 		// and redirect coverage file
@@ -203,9 +200,6 @@ func TestPolicyUpdate(t *testing.T) {
 
 		cmd := exec.Command(subArgs[0], subArgs[1:]...)
 
-		admock, err := filepath.Abs("../../internal/testutils/admock")
-		require.NoError(t, err, "Setup: Failed to get current absolute path for ad mock")
-
 		passwd := modifyAndAddUsers(t, currentUser, "UserIntegrationTest@example.com")
 
 		// Setup correct child environment, including LD_PRELOAD for nss mock
@@ -216,9 +210,6 @@ func TestPolicyUpdate(t *testing.T) {
 			fmt.Sprintf("DBUS_SYSTEM_BUS_ADDRESS_YES=%s", systemSockets["yes"]),
 			fmt.Sprintf("DBUS_SYSTEM_BUS_ADDRESS_NO=%s", systemSockets["no"]),
 
-			// mock for ad python samba code
-			fmt.Sprintf("PYTHONPATH=%s", admock),
-
 			// override user and host database
 			"LD_PRELOAD=libnss_wrapper.so",
 			fmt.Sprintf("NSS_WRAPPER_PASSWD=%s", passwd),
@@ -804,51 +795,6 @@ func TestPolicyUpdate(t *testing.T) {
 	}
 }
 
-func TestPolicyDebugGPOListScript(t *testing.T) {
-	gpolistSrc, err := ioutil.ReadFile("../../internal/policies/ad/adsys-gpolist")
-	require.NoError(t, err, "Setup: failed to load source of adsys-gpolist")
-
-	tests := map[string]struct {
-		systemAnswer     string
-		daemonNotStarted bool
-
-		wantErr bool
-	}{
-		"Get adsys-gpolist script":     {systemAnswer: "yes"},
-		"Version is always authorized": {systemAnswer: "no"},
-		"Daemon not responding":        {daemonNotStarted: true, wantErr: true},
-	}
-	for name, tc := range tests {
-		tc := tc
-		t.Run(name, func(t *testing.T) {
-			systemAnswer(t, tc.systemAnswer)
-
-			conf := createConf(t, "")
-			if !tc.daemonNotStarted {
-				defer runDaemon(t, conf)()
-			}
-
-			chdir(t, os.TempDir())
-
-			_, err := runClient(t, conf, "policy", "debug", "gpolist-script")
-			if tc.wantErr {
-				require.Error(t, err, "client should exit with an error")
-				return
-			}
-
-			f, err := os.Stat("adsys-gpolist")
-			require.NoError(t, err, "gpo list script should exists")
-
-			require.NotEqual(t, 0, f.Mode()&0111, "Script should be executable")
-
-			got, err := os.ReadFile("adsys-gpolist")
-			require.NoError(t, err, "gpo list script is not readable")
-
-			require.Equal(t, string(gpolistSrc), string(got), "Script content should match source")
-		})
-	}
-}
-
 func modifyAndAddUsers(t *testing.T, new string, users ...string) (passwd string) {
 	t.Helper()
 	dest := filepath.Join(t.TempDir(), "passwd")
@@ -51,15 +51,20 @@ type state struct {
 
 	adServer string
 	adDomain string
+
+	gpoCacheGracePeriod time.Duration
 }
 
 type options struct {
-	cacheDir    string
-	runDir      string
-	dconfDir    string
-	sssCacheDir string
-	sssdConf    string
-	authorizer  authorizerer
+	cacheDir            string
+	runDir              string
+	dconfDir            string
+	schemasDir          string
+	sssCacheDir         string
+	sssdConf            string
+	gpoSourceDir        string
+	gpoCacheGracePeriod time.Duration
+	authorizer          authorizerer
 }
 type option func(*options) error
 
@@ -91,6 +96,14 @@ func WithDconfDir(p string) func(o *options) error {
 	}
 }
 
+// WithGSettingsSchemasDir specifies a personalized /usr/share/glib-2.0/schemas
+func WithGSettingsSchemasDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.schemasDir = p
+		return nil
+	}
+}
+
 // WithSSSCacheDir specifies a personalized /
 func WithSSSCacheDir(p string) func(o *options) error {
 	return func(o *options) error {
@@ -99,15 +112,32 @@ func WithSSSCacheDir(p string) func(o *options) error {
 	}
 }
 
+// WithGPOSourceDir specifies a local directory of GPOs to apply instead of the ones from Active Directory
+func WithGPOSourceDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.gpoSourceDir = p
+		return nil
+	}
+}
+
+// WithGPOCacheGracePeriod specifies how long a GPO which is not applied anymore is kept in cache after its last use
+func WithGPOCacheGracePeriod(d time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.gpoCacheGracePeriod = d
+		return nil
+	}
+}
+
 // New returns a new instance of an AD service.
 // If url or domain is empty, we load the missing parameters from sssd.conf, taking first
-// domain in the list if not provided.
+// domain in the list if not provided. sssd.conf is not needed when GPOs are taken from a local directory.
 func New(ctx context.Context, url, domain string, opts ...option) (s *Service, err error) {
 	defer decorate.OnError(&err, i18n.G("couldn't create adsys service"))
 
 	// defaults
 	args := options{
-		sssdConf: consts.DefaultSSSConf,
+		sssdConf:            consts.DefaultSSSConf,
+		gpoCacheGracePeriod: consts.DefaultGPOCacheGracePeriod * 24 * time.Hour,
 	}
 	// applied options
 	for _, o := range opts {
@@ -116,14 +146,40 @@ func New(ctx context.Context, url, domain string, opts ...option) (s *Service, e
 		}
 	}
 
-	url, domain, err = loadServerInfo(args.sssdConf, url, domain)
+	var policyOptions []policies.Option
+	if args.cacheDir != "" {
+		policyOptions = append(policyOptions, policies.WithCacheDir(args.cacheDir))
+	}
+	if args.runDir != "" {
+		policyOptions = append(policyOptions, policies.WithRunDir(args.runDir))
+	}
+	if args.dconfDir != "" {
+		policyOptions = append(policyOptions, policies.WithDconfDir(args.dconfDir))
+	}
+	if args.schemasDir != "" {
+		policyOptions = append(policyOptions, policies.WithGSettingsSchemasDir(args.schemasDir))
+	}
+	m, err := policies.New(policyOptions...)
 	if err != nil {
 		return nil, err
 	}
-	if !strings.HasPrefix(url, "ldap://") {
-		url = fmt.Sprintf("ldap://%s", url)
+
+	// Only download the GPOs having settings handled by our policy managers
+	adOptions := []ad.Option{ad.WithExtensions(m.Extensions())}
+	if args.gpoSourceDir != "" {
+		adOptions = append(adOptions, ad.WithGPOSourceDir(args.gpoSourceDir))
+		if url == "" {
+			url = fmt.Sprintf(i18n.G("local GPO directory %s"), args.gpoSourceDir)
+		}
+	} else {
+		url, domain, err = loadServerInfo(args.sssdConf, url, domain)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(url, "ldap://") {
+			url = fmt.Sprintf("ldap://%s", url)
+		}
 	}
-	var adOptions []ad.Option
 	if args.cacheDir != "" {
 		adOptions = append(adOptions, ad.WithCacheDir(args.cacheDir))
 	}
@@ -160,21 +216,14 @@ func New(ctx context.Context, url, domain string, opts ...option) (s *Service, e
 		}
 	}
 
-	var policyOptions []policies.Option
-	if args.cacheDir != "" {
-		policyOptions = append(policyOptions, policies.WithCacheDir(args.cacheDir))
-	}
-	if args.dconfDir != "" {
-		policyOptions = append(policyOptions, policies.WithDconfDir(args.dconfDir))
-	}
-	m, err := policies.New(policyOptions...)
-	if err != nil {
-		return nil, err
-	}
-
 	// Init system reference time
 	initSysTime := initSystemTime(bus)
 
+	// Clean up GPOs which were not used for a while, as they are kept in cache forever otherwise
+	if _, err := adc.GarbageCollect(ctx, args.gpoCacheGracePeriod); err != nil {
+		log.Warningf(ctx, i18n.G("Can't clean up GPO cache: %v"), err)
+	}
+
 	return &Service{
 		adc:           adc,
 		policyManager: m,
@@ -186,6 +235,8 @@ func New(ctx context.Context, url, domain string, opts ...option) (s *Service, e
 			sssCacheDir: args.sssCacheDir,
 			adServer:    url,
 			adDomain:    domain,
+
+			gpoCacheGracePeriod: args.gpoCacheGracePeriod,
 		},
 		initSystemTime: initSysTime,
 		bus:            bus,
@@ -2,12 +2,14 @@ package adsysservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/ubuntu/adsys"
 	"github.com/ubuntu/adsys/internal/adsysservice/actions"
 	"github.com/ubuntu/adsys/internal/authorizer"
+	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
@@ -35,41 +37,70 @@ func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Servic
 		objectClass = ad.ComputerObject
 	}
 
+	dryRun := r.GetDryRun()
+
 	if r.GetIsComputer() || r.GetAll() {
-		err = s.updatePolicyFor(stream.Context(), true, target, ad.ComputerObject, "")
+		var plan string
+		plan, err = s.updatePolicyFor(stream.Context(), true, target, ad.ComputerObject, "", dryRun)
+		sendPlan(stream, plan)
 
 		if r.GetAll() {
 			users, err := s.adc.ListActiveUsers(stream.Context())
 			if err != nil {
 				return err
 			}
+			plans := make([]string, len(users))
 			errg := new(errgroup.Group)
-			for _, user := range users {
-				user := user
+			for i, user := range users {
+				i, user := i, user
 				errg.Go(func() (err error) {
-					return s.updatePolicyFor(stream.Context(), false, user, ad.UserObject, "")
+					plans[i], err = s.updatePolicyFor(stream.Context(), false, user, ad.UserObject, "", dryRun)
+					return err
 				})
 			}
 			if err := errg.Wait(); err != nil {
 				return fmt.Errorf("one or more error for updating all users: %v", err)
 			}
+			for _, plan := range plans {
+				sendPlan(stream, plan)
+			}
 		}
 
 		return err
 	}
 	// Update a single user
-	return s.updatePolicyFor(stream.Context(), r.GetIsComputer(), target, objectClass, r.Krb5Cc)
+	plan, err := s.updatePolicyFor(stream.Context(), r.GetIsComputer(), target, objectClass, r.Krb5Cc, dryRun)
+	if err != nil {
+		return err
+	}
+	sendPlan(stream, plan)
+	return nil
 }
 
-// updatePolicyFor updates the policy for a given object
-func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string) error {
+// updatePolicyFor updates the policy for a given object.
+// In dry run mode, the policy is not applied and the planned changes are returned instead.
+func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string, dryRun bool) (plan string, err error) {
 	gpos, err := s.adc.GetPolicies(ctx, target, objectClass, krb5cc)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return s.policyManager.ApplyPolicy(ctx, target, isComputer, gpos)
+	if dryRun {
+		return s.policyManager.PlanPolicy(ctx, target, isComputer, gpos)
+	}
+	return "", s.policyManager.ApplyPolicy(ctx, target, isComputer, gpos)
+}
 
+// sendPlan sends the planned changes of a dry run to the client, if any.
+func sendPlan(stream adsys.Service_UpdatePolicyServer, plan string) {
+	if plan == "" {
+		return
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: plan,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send planned changes to client: %v", err)
+	}
 }
 
 // DumpPolicies displays all applied policies for a given user.
@@ -93,50 +124,194 @@ func (s *Service) DumpPolicies(r *adsys.DumpPoliciesRequest, stream adsys.Servic
 	if err != nil {
 		return err
 	}
+	applied, err := s.policyManager.AppliedPolicies(stream.Context(), r.GetTarget())
+	if err != nil {
+		return err
+	}
+
+	var gpos []*adsys.AppliedGPO
+	for _, g := range applied {
+		gpo := &adsys.AppliedGPO{
+			Id:         g.ID,
+			Name:       g.Name,
+			IsComputer: g.IsComputer,
+			Extensions: g.Extensions,
+		}
+		for _, r := range g.Rules {
+			gpo.Rules = append(gpo.Rules, &adsys.AppliedRule{
+				Domain:     r.Domain,
+				Key:        r.Key,
+				Value:      r.Value,
+				Disabled:   r.Disabled,
+				Overridden: r.Overridden,
+			})
+		}
+		gpos = append(gpos, gpo)
+	}
+
+	if err := stream.Send(&adsys.DumpPoliciesResponse{
+		Msg:  msg,
+		Gpos: gpos,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send currently applied policies to client: %v", err)
+	}
+
+	return nil
+}
+
+// PolicyHistory lists the previously applied policies for a given user, or shows the changes since one of them.
+func (s *Service) PolicyHistory(r *adsys.PolicyHistoryRequest, stream adsys.Service_PolicyHistoryServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while displaying policy history"))
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	// hostname policy history display is allowed to all users
+	if r.GetTarget() != hostname {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, r.GetTarget()),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	var msg string
+	if r.GetDiff() {
+		msg, err = s.policyManager.DiffPolicies(stream.Context(), r.GetTarget(), r.GetSince())
+	} else {
+		msg, err = s.policyManager.PolicyHistory(stream.Context(), r.GetTarget())
+	}
+	if err != nil {
+		return err
+	}
 	if err := stream.Send(&adsys.StringResponse{
 		Msg: msg,
 	}); err != nil {
-		log.Warningf(stream.Context(), "couldn't send currently applied policies to client: %v", err)
+		log.Warningf(stream.Context(), "couldn't send policy history to client: %v", err)
 	}
 
 	return nil
 }
 
-// DumpPoliciesDefinitions dumps requested policy definitions stored in daemon at build time.
-func (s *Service) DumpPoliciesDefinitions(r *adsys.DumpPolicyDefinitionsRequest, stream adsys.Service_DumpPoliciesDefinitionsServer) (err error) {
-	defer decorate.OnError(&err, i18n.G("error while dumping policy definitions"))
+// ExplainPolicy shows which GPOs define a key for a given user or machine, and which one wins.
+func (s *Service) ExplainPolicy(r *adsys.ExplainPolicyRequest, stream adsys.Service_ExplainPolicyServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while explaining policy"))
 
-	if err := s.authorizer.IsAllowedFromContext(stream.Context(), authorizer.ActionAlwaysAllowed); err != nil {
+	hostname, err := os.Hostname()
+	if err != nil {
 		return err
 	}
 
-	admx, adml, err := ad.GetPolicyDefinitions(stream.Context(), r.Format, r.GetDistroID())
+	// hostname policy explanation is allowed to all users
+	if r.GetTarget() != hostname {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, r.GetTarget()),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	msg, err := s.policyManager.ExplainPolicy(stream.Context(), r.GetTarget(), r.GetKey())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy explanation to client: %v", err)
+	}
+
+	return nil
+}
+
+// PolicyReport renders an HTML report of the policies applied to a given user or machine.
+func (s *Service) PolicyReport(r *adsys.PolicyReportRequest, stream adsys.Service_PolicyReportServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while generating policy report"))
+
+	hostname, err := os.Hostname()
 	if err != nil {
 		return err
 	}
 
-	if err := stream.Send(&adsys.DumpPolicyDefinitionsResponse{
-		Admx: admx,
-		Adml: adml,
+	// hostname policy report is allowed to all users
+	if r.GetTarget() != hostname {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, r.GetTarget()),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	displayNames, err := ad.GetPolicyDisplayNames(stream.Context(), "all", consts.DistroID)
+	if err != nil {
+		return err
+	}
+
+	report, err := s.policyManager.HTMLReport(stream.Context(), r.GetTarget(), s.adc.IsOffline, displayNames)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: report,
 	}); err != nil {
-		log.Warningf(stream.Context(), "couldn't send policy definition to client: %v", err)
+		log.Warningf(stream.Context(), "couldn't send policy report to client: %v", err)
 	}
 
 	return nil
 }
 
-// DumpPolicies displays all applied policies for a given user.
-func (s *Service) GPOListScript(r *adsys.Empty, stream adsys.Service_GPOListScriptServer) (err error) {
-	defer decorate.OnError(&err, i18n.G("error while getting gpo list script"))
+// PurgePolicy removes the policies left behind by a given user, or by every user which doesn't exist anymore or
+// doesn't have any dconf policy.
+func (s *Service) PurgePolicy(r *adsys.PurgePolicyRequest, stream adsys.Service_PurgePolicyServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while purging policy"))
+
+	if (r.GetTarget() == "") == !r.GetAllStale() {
+		return errors.New(i18n.G("either an user or all stale users should be purged"))
+	}
+
+	// Purging removes enforced settings: users can't purge their own policies.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, "root"),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	purged := []string{r.GetTarget()}
+	if r.GetAllStale() {
+		if purged, err = s.policyManager.PurgeStale(stream.Context()); err != nil {
+			return err
+		}
+	} else if err := s.policyManager.Purge(stream.Context(), r.GetTarget()); err != nil {
+		return err
+	}
+
+	for _, u := range purged {
+		if err := stream.Send(&adsys.StringResponse{
+			Msg: u,
+		}); err != nil {
+			log.Warningf(stream.Context(), "couldn't send purged user to client: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// DumpPoliciesDefinitions dumps requested policy definitions stored in daemon at build time.
+func (s *Service) DumpPoliciesDefinitions(r *adsys.DumpPolicyDefinitionsRequest, stream adsys.Service_DumpPoliciesDefinitionsServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while dumping policy definitions"))
 
 	if err := s.authorizer.IsAllowedFromContext(stream.Context(), authorizer.ActionAlwaysAllowed); err != nil {
 		return err
 	}
 
-	if err := stream.Send(&adsys.StringResponse{
-		Msg: ad.AdsysGpoListCode,
+	admx, adml, err := ad.GetPolicyDefinitions(stream.Context(), r.Format, r.GetDistroID())
+	if err != nil {
+		return err
+	}
+
+	if err := stream.Send(&adsys.DumpPolicyDefinitionsResponse{
+		Admx: admx,
+		Adml: adml,
 	}); err != nil {
-		log.Warningf(stream.Context(), "couldn't send gpo list to client: %v", err)
+		log.Warningf(stream.Context(), "couldn't send policy definition to client: %v", err)
 	}
 
 	return nil
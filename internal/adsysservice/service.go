@@ -168,6 +168,30 @@ func (s *Service) Stop(r *adsys.StopRequest, stream adsys.Service_StopServer) (e
 	return nil
 }
 
+// GarbageCollect removes from the cache the GPOs which are not applied anymore and were not used during the grace
+// period.
+func (s *Service) GarbageCollect(r *adsys.Empty, stream adsys.Service_GarbageCollectServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while collecting GPO cache garbage"))
+
+	if err := s.authorizer.IsAllowedFromContext(stream.Context(), actions.ActionServiceManage); err != nil {
+		return err
+	}
+
+	removed, err := s.adc.GarbageCollect(stream.Context(), s.state.gpoCacheGracePeriod)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range removed {
+		if err := stream.Send(&adsys.StringResponse{
+			Msg: id,
+		}); err != nil {
+			log.Warningf(stream.Context(), "couldn't send removed GPO to client: %v", err)
+		}
+	}
+	return nil
+}
+
 // ListActiveUsers returns the list of currently active users.
 func (s *Service) ListActiveUsers(r *adsys.Empty, stream adsys.Service_ListActiveUsersServer) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while trying to get the list of active users"))
@@ -30,6 +30,12 @@ const (
 	DefaultSSSConf = "/etc/sssd/sssd.conf"
 	// DefaultDconfDir is the default dconf directory
 	DefaultDconfDir = "/etc/dconf"
+	// DefaultGSettingsSchemasDir is the default directory of installed gsettings schemas
+	DefaultGSettingsSchemasDir = "/usr/share/glib-2.0/schemas"
+	// DefaultApparmorDir is the default directory where adsys stores apparmor profiles
+	DefaultApparmorDir = "/etc/apparmor.d/adsys"
+	// DefaultMountsDir is the default directory under which adsys mounts machine network shares
+	DefaultMountsDir = "/media/adsys"
 
 	// DefaultClientTimeout is the maximum default time in seconds between 2 server activities before the client returns and abort the request.
 	DefaultClientTimeout = 30
@@ -37,6 +43,9 @@ const (
 	// DefaultServiceTimeout is the default time in seconds without any active request before the service exits.
 	DefaultServiceTimeout = 120
 
+	// DefaultGPOCacheGracePeriod is the default time in days an unreferenced GPO is kept in cache after its last use.
+	DefaultGPOCacheGracePeriod = 30
+
 	// DistroID is the distro ID which can be overridden at build time
 	DistroID = "Ubuntu"
 )
@@ -0,0 +1,18 @@
+// Package fileutils contains file helpers shared by the policy managers.
+package fileutils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ChownTree changes owner of every file and directory in root, including root.
+// Symlinks are changed themselves, not their targets.
+func ChownTree(root string, uid, gid int) error {
+	return filepath.Walk(root, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
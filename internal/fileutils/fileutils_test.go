@@ -0,0 +1,40 @@
+package fileutils_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/fileutils"
+)
+
+func TestChownTree(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir", "subdir"), 0750), "Setup: can't create directories")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "file"), nil, 0600), "Setup: can't create file")
+	require.NoError(t, os.Symlink("/doesnotexist", filepath.Join(root, "dir", "dangling")), "Setup: can't create symlink")
+
+	// Only our own ids are allowed when not running as root
+	uid, gid := os.Getuid(), os.Getgid()
+	err := fileutils.ChownTree(root, uid, gid)
+	require.NoError(t, err, "ChownTree failed but shouldn't have")
+
+	for _, p := range []string{"", "dir", "dir/subdir", "dir/file", "dir/dangling"} {
+		fi, err := os.Lstat(filepath.Join(root, p))
+		require.NoError(t, err, "Can't stat %s", p)
+		stat := fi.Sys().(*syscall.Stat_t)
+		require.Equal(t, uint32(uid), stat.Uid, "%s should be owned by uid", p)
+		require.Equal(t, uint32(gid), stat.Gid, "%s should be owned by gid", p)
+	}
+}
+
+func TestChownTreeErrorOnMissingRoot(t *testing.T) {
+	t.Parallel()
+
+	err := fileutils.ChownTree(filepath.Join(t.TempDir(), "doesnotexist"), os.Getuid(), os.Getgid())
+	require.Error(t, err, "ChownTree should have failed but didn't")
+}
@@ -1,27 +1,22 @@
 package ad
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 
-	// embed gpolist python binary
-	_ "embed"
-
+	"github.com/go-ldap/ldap/v3"
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
 	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/ad/gpp"
 	"github.com/ubuntu/adsys/internal/policies/ad/registry"
 	"github.com/ubuntu/adsys/internal/policies/entry"
-	"github.com/ubuntu/adsys/internal/smbsafe"
 )
 
 // ObjectClass is the type of object in the directory. It can be a computer or a user
@@ -34,11 +29,31 @@ const (
 	ComputerObject = "computer"
 )
 
+// User policy loopback processing modes, as set by the "gpo/loopback" machine rule.
+const (
+	loopbackMerge   = "merge"
+	loopbackReplace = "replace"
+)
+
+// gpoFilesDirs lists, per type of rules, the GPO subdirectory where the referenced files are stored.
+var gpoFilesDirs = map[string]string{
+	"scripts":  "Scripts",
+	"apparmor": "Apparmor",
+}
+
 type gpo struct {
 	name string
 	url  string
 	mu   *sync.RWMutex
 
+	// extensions are the GUIDs of the client-side extensions having settings in the GPO for the listed object class.
+	// It is nil if the source doesn't advertise them.
+	extensions []string
+
+	// version is the version of the GPO content in the cache, recorded on fetch. It is nil until then, or if the
+	// cached GPT.INI can't be read.
+	version *gpoVersion
+
 	// This property is used to instrument the tests for concurrent download and parsing of GPOs
 	// Cf internal_test::TestFetchOneGPOWhileParsingItConcurrently()
 	testConcurrent bool
@@ -49,6 +64,7 @@ type AD struct {
 	IsOffline bool
 
 	hostname string
+	domain   string
 	url      string
 
 	versionID        string
@@ -57,11 +73,16 @@ type AD struct {
 	krb5CacheDir     string
 	sssCCName        string
 
+	// gpos are the GPOs fetched in the cache, by ID.
 	gpos map[string]*gpo
 	sync.RWMutex
 
+	// extensions are the client-side extensions GUIDs we process GPOs for. nil means all of them.
+	extensions map[string]struct{}
+
 	withoutKerberos bool
-	gpoListCmd      []string
+	dialLDAP        ldapDialer
+	source          gpoSource
 }
 
 type options struct {
@@ -69,8 +90,10 @@ type options struct {
 	runDir          string
 	cacheDir        string
 	sssCacheDir     string
+	gpoSourceDir    string
+	extensions      []string
 	withoutKerberos bool
-	gpoListCmd      []string
+	dialLDAP        ldapDialer
 }
 
 // Option reprents an optional function to change AD behavior.
@@ -100,10 +123,23 @@ func WithSSSCacheDir(cacheDir string) Option {
 	}
 }
 
-// AdsysGpoListCode is the embedded script which request
-// Samba to get our GPO list for the given object.
-//go:embed adsys-gpolist
-var AdsysGpoListCode string
+// WithExtensions only downloads and parses the GPOs having settings for one of the client-side extensions
+// GUIDs. By default, all GPOs are processed.
+func WithExtensions(extensions []string) Option {
+	return func(o *options) error {
+		o.extensions = extensions
+		return nil
+	}
+}
+
+// WithGPOSourceDir uses GPOs from a local directory instead of the ones from Active Directory.
+// The directory contains GPMC backups or GPO trees like {GUID}/Machine/Registry.pol, applied to every object.
+func WithGPOSourceDir(dir string) Option {
+	return func(o *options) error {
+		o.gpoSourceDir = dir
+		return nil
+	}
+}
 
 // New returns an AD object to manage concurrency, with a local kr5 ticket from machine keytab
 func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err error) {
@@ -119,7 +155,7 @@ func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err e
 		runDir:      consts.DefaultRunDir,
 		cacheDir:    consts.DefaultCacheDir,
 		sssCacheDir: consts.DefaultSSSCacheDir,
-		gpoListCmd:  []string{"python3", "-c", AdsysGpoListCode},
+		dialLDAP:    defaultLDAPDialer,
 		versionID:   versionID,
 	}
 	// applied options
@@ -129,11 +165,11 @@ func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err e
 		}
 	}
 
-	krb5CacheDir := filepath.Join(args.runDir, "krb5cc")
+	krb5CacheDir := filepath.Join(args.runDir, entry.Krb5CCBaseName)
 	if err := os.MkdirAll(krb5CacheDir, 0700); err != nil {
 		return nil, err
 	}
-	gpoCacheDir := filepath.Join(args.cacheDir, "gpo_cache")
+	gpoCacheDir := filepath.Join(args.cacheDir, entry.GPOCacheBaseName)
 	if err := os.MkdirAll(gpoCacheDir, 0700); err != nil {
 		return nil, err
 	}
@@ -152,8 +188,9 @@ func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err e
 	// local machine sssd krb5 cache
 	sssCCName := filepath.Join(args.sssCacheDir, "ccache_"+strings.ToUpper(domain))
 
-	return &AD{
+	ad = &AD{
 		hostname:         hostname,
+		domain:           domain,
 		url:              url,
 		versionID:        args.versionID,
 		gpoCacheDir:      gpoCacheDir,
@@ -161,8 +198,20 @@ func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err e
 		krb5CacheDir:     krb5CacheDir,
 		sssCCName:        sssCCName,
 		gpos:             make(map[string]*gpo),
-		gpoListCmd:       args.gpoListCmd,
-	}, nil
+		dialLDAP:         args.dialLDAP,
+	}
+	if args.extensions != nil {
+		ad.extensions = make(map[string]struct{})
+		for _, e := range args.extensions {
+			ad.extensions[strings.ToUpper(e)] = struct{}{}
+		}
+	}
+	ad.source = sysvolSource{ad: ad}
+	if args.gpoSourceDir != "" {
+		ad.source = localSource{dir: args.gpoSourceDir}
+	}
+
+	return ad, nil
 }
 
 // GetPolicies returns all policy entries, stacked in order of priority.GetPolicies
@@ -170,6 +219,7 @@ func New(ctx context.Context, url, domain string, opts ...Option) (ad *AD, err e
 // It users the given krb5 ticket reference to authenticate to AD.
 // userKrb5CCName has no impact for computer object and is ignored. If empty, we will expect to find one cached
 // ticket <krb5CCDir>/<objectName>.
+// User policies follow the loopback processing mode of the last applied machine policies.
 func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass ObjectClass, userKrb5CCName string) (r []entry.GPO, err error) {
 	defer decorate.OnError(&err, i18n.G("can't get policies for %q"), objectName)
 
@@ -183,38 +233,11 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	if objectClass == ComputerObject && objectName != ad.hostname {
 		return nil, fmt.Errorf(i18n.G("requested a type computer of %q which isn't current host %q"), objectName, ad.hostname)
 	}
-	// Create a ccache symlink on first fetch for futur calls (on refresh for instance)
-	if userKrb5CCName != "" || objectClass == ComputerObject {
-		src := userKrb5CCName
-		// there is no env var for machine: get sss ccache
-		if objectClass == ComputerObject {
-			src = ad.sssCCName
-		}
-		if err := ad.ensureKrb5CCName(src, krb5CCPath); err != nil {
-			return nil, err
-		}
-	}
-
-	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
-	cmdArgs := append(args, "--objectclass", string(objectClass), ad.url, objectName)
-	// #nosec G204 - cmdArgs is under our control (python embedded script or mock for tests)
-	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CCPath))
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	smbsafe.WaitExec()
-	err = cmd.Run()
-	smbsafe.DoneExec()
-	if err != nil {
-		if cmd.ProcessState.ExitCode() != 2 {
-			return nil, fmt.Errorf(i18n.G("failed to retrieve the list of GPO: %v\n%s"), err, stderr.String())
-		}
 
-		// Exit status 2 is a network error (host of network unreadchable)
-		// In this case we assume an offline connection and try to load the GPOs from cache
-		// Otherwise we fail with an error.
+	orderedGPOs, err := ad.source.listGPOs(ctx, objectName, objectClass, userKrb5CCName, krb5CCPath)
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		// A network error (host or network unreachable) is considered as an offline connection:
+		// we try to load the GPOs from cache. Otherwise we fail with an error.
 		ad.Lock()
 		ad.IsOffline = true
 		ad.Unlock()
@@ -224,32 +247,37 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 
 		log.Infof(ctx, "Can't reach AD: machine is offline and %q policies are applied using previous online update", objectName)
 		return r, nil
+	} else if err != nil {
+		return nil, fmt.Errorf(i18n.G("failed to retrieve the list of GPO: %v"), err)
 	}
 
 	ad.Lock()
 	ad.IsOffline = false
 	ad.Unlock()
-	gpos := make(map[string]string)
-	var orderedGPOs []gpo
-	scanner := bufio.NewScanner(&stdout)
-	for scanner.Scan() {
-		t := scanner.Text()
-		res := strings.SplitN(t, "\t", 2)
-		gpoName, gpoURL := res[0], res[1]
-		log.Debugf(ctx, "GPO %q for %q available at %q", gpoName, objectName, gpoURL)
-		gpos[gpoName] = gpoURL
-		orderedGPOs = append(orderedGPOs, gpo{name: gpoName, url: gpoURL})
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+
+	var gpos []gpo
+	for _, g := range orderedGPOs {
+		log.Debugf(ctx, "GPO %q for %q available at %q", g.name, objectName, g.url)
+		if !ad.handles(g) {
+			log.Debugf(ctx, "Skipping GPO %q for %q: no supported client-side extension in %v", g.name, objectName, g.extensions)
+			continue
+		}
+		gpos = append(gpos, g)
 	}
 
-	if err = ad.fetch(ctx, krb5CCPath, gpos); err != nil {
+	if err = ad.fetch(ctx, krb5CCPath, gpos, objectClass); err != nil {
 		return nil, err
 	}
 
+	// Preferences items are targeted against the local facts of the machine and user
+	var user string
+	if objectClass == UserObject {
+		user = objectName
+	}
+	facts := gpp.NewFacts(ctx, ad.hostname, ad.domain, user)
+
 	// Parse policies
-	r, err = ad.parseGPOs(ctx, orderedGPOs, objectClass)
+	r, err = ad.parseGPOs(ctx, orderedGPOs, objectClass, facts)
 	if err != nil {
 		return nil, err
 	}
@@ -257,6 +285,86 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	return r, nil
 }
 
+// handles returns if g has settings for one of the client-side extensions we process.
+// GPOs whose extensions are unknown are always processed.
+func (ad *AD) handles(g gpo) bool {
+	if ad.extensions == nil || g.extensions == nil {
+		return true
+	}
+	for _, e := range g.extensions {
+		if _, ok := ad.extensions[e]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listGPOs returns the ordered list of GPOs applying to objectName.
+// For users, the user GPOs linked to the machine containers are combined with the user ones if loopback processing
+// is enabled in the machine rules. In merge mode, they are placed first so that they take precedence once passed
+// to entry.GetUniqueRules, while in replace mode, they are the only ones returned.
+func (ad *AD) listGPOs(ctx context.Context, conn ldapConn, objectName string, objectClass ObjectClass) ([]gpo, error) {
+	if objectClass != UserObject {
+		return listGPOs(ctx, conn, objectName, objectClass)
+	}
+
+	mode := ad.loopbackMode(ctx)
+	if mode == "" {
+		return listGPOs(ctx, conn, objectName, objectClass)
+	}
+
+	log.Debugf(ctx, "Loopback processing in %s mode for %q", mode, objectName)
+	machineGPOs, err := listLoopbackGPOs(ctx, conn, objectName, ad.hostname)
+	if err != nil {
+		return nil, err
+	}
+	if mode == loopbackReplace {
+		return machineGPOs, nil
+	}
+
+	userGPOs, err := listGPOs(ctx, conn, objectName, objectClass)
+	if err != nil {
+		return nil, err
+	}
+	// The same GPO can be linked to both machine and user containers: keep the highest priority one.
+	// GPOs are compared by ID, as different GPOs can share the same display name.
+	gpos := machineGPOs
+	seen := make(map[string]struct{})
+	for _, g := range machineGPOs {
+		seen[filepath.Base(g.url)] = struct{}{}
+	}
+	for _, g := range userGPOs {
+		if _, ok := seen[filepath.Base(g.url)]; ok {
+			continue
+		}
+		gpos = append(gpos, g)
+	}
+	return gpos, nil
+}
+
+// loopbackMode returns the user policy loopback processing mode from the last applied machine rules.
+// It is empty if loopback processing is not enabled or if no machine policy was applied yet.
+func (ad *AD) loopbackMode(ctx context.Context) string {
+	gpos, err := entry.NewGPOs(filepath.Join(ad.gpoRulesCacheDir, ad.hostname))
+	if err != nil {
+		log.Debugf(ctx, "No machine rules to check loopback processing mode: %v", err)
+		return ""
+	}
+
+	for _, e := range entry.GetUniqueRules(gpos)["gpo"] {
+		if e.Key != "loopback" || e.Disabled {
+			continue
+		}
+		switch e.Value {
+		case loopbackMerge, loopbackReplace:
+			return e.Value
+		default:
+			log.Warningf(ctx, "Unknown loopback processing mode %q, ignoring", e.Value)
+		}
+	}
+	return ""
+}
+
 // ListActiveUsers return the list of active users on the system
 func (ad *AD) ListActiveUsers(ctx context.Context) (users []string, err error) {
 	defer decorate.OnError(&err, i18n.G("can't list users from cache"))
@@ -312,7 +420,7 @@ func (ad *AD) ensureKrb5CCName(srcKrb5CCName, dstKrb5CCName string) (err error)
 	return nil
 }
 
-func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass) ([]entry.GPO, error) {
+func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass, facts gpp.Facts) ([]entry.GPO, error) {
 	var r []entry.GPO
 
 	keyFilterPrefix := fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, consts.DistroID)
@@ -324,82 +432,159 @@ func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass
 			Name:  name,
 			Rules: make(map[string][]entry.Entry),
 		}
+		if len(g.extensions) > 0 {
+			gpoRules.Extensions = g.extensions
+		}
 		r = append(r, gpoRules)
+		// GPOs without any supported extension were not downloaded
+		if !ad.handles(g) {
+			continue
+		}
 		if err := func() error {
 			ad.RLock()
-			ad.gpos[name].mu.RLock()
-			defer ad.gpos[name].mu.RUnlock()
-			_ = ad.gpos[name].testConcurrent
+			ad.gpos[gpoRules.ID].mu.RLock()
+			defer ad.gpos[gpoRules.ID].mu.RUnlock()
+			_ = ad.gpos[gpoRules.ID].testConcurrent
 			ad.RUnlock()
 
 			class := "User"
 			if objectClass == ComputerObject {
 				class = "Machine"
 			}
-			f, err := os.Open(filepath.Join(ad.gpoCacheDir, filepath.Base(url), class, "Registry.pol"))
-			if err != nil && os.IsExist(err) {
+			if err := ad.parseRegistryPolicy(ctx, gpoRules, class, keyFilterPrefix); err != nil {
 				return err
-			} else if err != nil && os.IsNotExist(err) {
-				log.Debugf(ctx, "Policy %q doesn't have any policy for class %q %s", name, objectClass, err)
-				return nil
 			}
-			defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+			return parsePreferences(ctx, gpoRules, filepath.Join(ad.gpoCacheDir, gpoRules.ID, class), facts)
+		}(); err != nil {
+			return nil, err
+		}
+	}
 
-			// Decode and apply policies in gpo order. First win
-			pols, err := registry.DecodePolicy(f)
-			if err != nil {
-				return fmt.Errorf(i18n.G("%s :%v"), f.Name(), err)
+	return r, nil
+}
+
+// parseRegistryPolicy adds to the GPO rules the supported keys of the Registry.pol file of class.
+func (ad *AD) parseRegistryPolicy(ctx context.Context, gpoRules entry.GPO, class, keyFilterPrefix string) error {
+	f, err := os.Open(filepath.Join(ad.gpoCacheDir, gpoRules.ID, class, "Registry.pol"))
+	if err != nil && os.IsExist(err) {
+		return err
+	} else if err != nil && os.IsNotExist(err) {
+		log.Debugf(ctx, "Policy %q doesn't have any policy for class %q %s", gpoRules.Name, class, err)
+		return nil
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	// Decode and apply policies in gpo order. First win
+	pols, err := registry.DecodePolicy(f)
+	if err != nil {
+		return fmt.Errorf(i18n.G("%s :%v"), f.Name(), err)
+	}
+
+	// filter keys to be overridden
+	var currentKey string
+	var overrideEnabled bool
+	// enforced are the values of the Enforce option of the policies, by key type and key.
+	enforced := make(map[string]map[string]bool)
+	for _, pol := range pols {
+		// Only consider supported policies for this distro
+		if !strings.HasPrefix(pol.Key, keyFilterPrefix) {
+			continue
+		}
+		pol.Key = strings.TrimPrefix(pol.Key, keyFilterPrefix)
+
+		// Some keys can be overridden
+		releaseID := filepath.Base(pol.Key)
+		keyType := strings.Split(pol.Key, "/")[0]
+		pol.Key = filepath.Dir(strings.TrimPrefix(pol.Key, keyType+"/"))
+
+		// Values are enforced, unless the Enforce option is unchecked.
+		// The option can be listed before the policy values: it is only applied once all of them are collected.
+		if pol.Meta == adcommon.EnforceMeta {
+			if enforced[keyType] == nil {
+				enforced[keyType] = make(map[string]bool)
 			}
+			enforced[keyType][pol.Key] = pol.Disabled || pol.Value != "false"
+			continue
+		}
 
-			// filter keys to be overridden
-			var currentKey string
-			var overrideEnabled bool
-			for _, pol := range pols {
-				// Only consider supported policies for this distro
-				if !strings.HasPrefix(pol.Key, keyFilterPrefix) {
-					continue
-				}
-				pol.Key = strings.TrimPrefix(pol.Key, keyFilterPrefix)
+		if releaseID == "all" {
+			currentKey = pol.Key
+			overrideEnabled = false
+			gpoRules.Rules[keyType] = append(gpoRules.Rules[keyType], pol)
+			continue
+		}
 
-				// Some keys can be overridden
-				releaseID := filepath.Base(pol.Key)
-				keyType := strings.Split(pol.Key, "/")[0]
-				pol.Key = filepath.Dir(strings.TrimPrefix(pol.Key, keyType+"/"))
+		// This is not an "all" key and the key name don’t match
+		// This shouldn’t happen with our admx, but just to stay safe…
+		if currentKey != pol.Key {
+			continue
+		}
 
-				if releaseID == "all" {
-					currentKey = pol.Key
-					overrideEnabled = false
-					gpoRules.Rules[keyType] = append(gpoRules.Rules[keyType], pol)
-					continue
-				}
+		if strings.HasPrefix(releaseID, "Override"+ad.versionID) && pol.Value == "true" {
+			overrideEnabled = true
+			continue
+		}
+		// Check we have a matching override
+		if !overrideEnabled || releaseID != ad.versionID {
+			continue
+		}
 
-				// This is not an "all" key and the key name don’t match
-				// This shouldn’t happen with our admx, but just to stay safe…
-				if currentKey != pol.Key {
-					continue
-				}
+		// Matching enabled override
+		// Replace value with the override content
+		iLast := len(gpoRules.Rules[keyType]) - 1
+		p := gpoRules.Rules[keyType][iLast]
+		p.Value = pol.Value
+		p.ReleaseOverride = ad.versionID
+		gpoRules.Rules[keyType][iLast] = p
+	}
+	for keyType, keys := range enforced {
+		for i, e := range gpoRules.Rules[keyType] {
+			if enforce, ok := keys[e.Key]; ok && !enforce {
+				gpoRules.Rules[keyType][i].DefaultOnly = true
+			}
+		}
+	}
 
-				if strings.HasPrefix(releaseID, "Override"+ad.versionID) && pol.Value == "true" {
-					overrideEnabled = true
-					continue
-				}
-				// Check we have a matching override
-				if !overrideEnabled || releaseID != ad.versionID {
+	// Some files are referenced relative to a GPO directory:
+	// make them relative to the GPO cache directory so that they can be staged.
+	for keyType, dir := range gpoFilesDirs {
+		for i, e := range gpoRules.Rules[keyType] {
+			if e.Disabled {
+				continue
+			}
+			var files []string
+			for _, f := range strings.Split(e.Value, "\n") {
+				f = strings.TrimSpace(f)
+				if f == "" {
 					continue
 				}
-
-				// Matching enabled override
-				// Replace value with the override content
-				iLast := len(gpoRules.Rules[keyType]) - 1
-				p := gpoRules.Rules[keyType][iLast]
-				p.Value = pol.Value
-				gpoRules.Rules[keyType][iLast] = p
+				files = append(files, filepath.Join(gpoRules.ID, class, dir, f))
 			}
-			return nil
-		}(); err != nil {
-			return nil, err
+			e.Value = strings.Join(files, "\n")
+			gpoRules.Rules[keyType][i] = e
 		}
 	}
+	return nil
+}
 
-	return r, nil
+// parsePreferences adds to the GPO rules the entries of the Group Policy Preferences stored in classDir.
+// Only items whose item-level targeting matches facts are kept.
+func parsePreferences(ctx context.Context, gpoRules entry.GPO, classDir string, facts gpp.Facts) error {
+	for _, p := range gpp.Preferences {
+		f, err := os.Open(filepath.Join(classDir, p.Path()))
+		if err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		entries, err := p.Decode(ctx, f, facts)
+		decorate.LogFuncOnErrorContext(ctx, f.Close)
+		if err != nil {
+			return fmt.Errorf(i18n.G("%s :%v"), f.Name(), err)
+		}
+		log.Debugf(ctx, "Policy %q has %d %s preferences", gpoRules.Name, len(entries), p.Name)
+		gpoRules.Rules[p.Domain] = append(gpoRules.Rules[p.Domain], entries...)
+	}
+	return nil
 }
@@ -2,18 +2,24 @@ package ad_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-ldap/ldap/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/ubuntu/adsys/internal/policies/ad"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
 	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils/admock"
 )
 
 func TestNew(t *testing.T) {
@@ -67,6 +73,11 @@ func TestGetPolicies(t *testing.T) {
 
 	hostname, err := os.Hostname()
 	require.NoError(t, err, "Setup: failed to get hostname")
+	// Computer accounts are truncated at 15 characters
+	computerAccount := hostname
+	if len(computerAccount) > 15 {
+		computerAccount = computerAccount[:15]
+	}
 
 	standardGPO := entry.GPO{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{
 		"dconf": {
@@ -105,6 +116,8 @@ func TestGetPolicies(t *testing.T) {
 
 		dontCreateOriginalKrb5CCName bool
 		turnKrb5CCRO                 bool
+		loopbackMode                 string
+		loopbackDisabled             bool
 
 		want    []entry.GPO
 		wantErr bool
@@ -169,6 +182,98 @@ func TestGetPolicies(t *testing.T) {
 					}}},
 			}},
 
+		// Loopback processing cases
+		"Loopback merge mode, machine GPOs take precedence over user ones": {
+			gpoListArgs:        "DEPENDS:bob@user-only:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "merge",
+			want: []entry.GPO{
+				standardGPO,
+				{ID: "user-only", Name: "user-only-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+			}},
+		"Loopback replace mode, only machine GPOs apply": {
+			gpoListArgs:        "DEPENDS:bob@user-only:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "replace",
+			want:               []entry.GPO{standardGPO},
+		},
+		"Loopback merge mode, GPO linked to both machine and user is listed once": {
+			gpoListArgs:        "DEPENDS:bob@user-only:bob@standard:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "merge",
+			want: []entry.GPO{
+				standardGPO,
+				{ID: "user-only", Name: "user-only-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+			}},
+		"Loopback merge mode, different GPOs sharing a display name are all listed": {
+			gpoListArgs:        "DEPENDS:bob@user-only=standard-name:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "merge",
+			want: []entry.GPO{
+				standardGPO,
+				{ID: "user-only", Name: "standard-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+			}},
+		"Loopback disabled, only user GPOs apply": {
+			gpoListArgs:        "DEPENDS:bob@user-only:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "replace",
+			loopbackDisabled:   true,
+			want: []entry.GPO{
+				{ID: "user-only", Name: "user-only-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+			}},
+		"Unknown loopback mode is ignored": {
+			gpoListArgs:        "DEPENDS:bob@user-only:" + computerAccount + "@standard",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			loopbackMode:       "unknown",
+			want: []entry.GPO{
+				{ID: "user-only", Name: "user-only-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+			}},
+		"Loopback mode does not affect computer policies": {
+			gpoListArgs:  "DEPENDS:bob@user-only:" + computerAccount + "@standard",
+			objectName:   hostname,
+			objectClass:  ad.ComputerObject,
+			loopbackMode: "replace",
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "standardA"},
+						{Key: "D", Value: "standardD"},
+						{Key: "E", Value: "standardE"},
+					}}},
+			}},
+
 		// Multi releases cases
 		"Enabled override": {
 			versionID:          "21.04",
@@ -178,7 +283,7 @@ func TestGetPolicies(t *testing.T) {
 			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
 			want: []entry.GPO{{ID: "multiple-releases-one-enabled", Name: "multiple-releases-one-enabled-name", Rules: map[string][]entry.Entry{
 				"dconf": {
-					{Key: "A", Value: "21.04Value"},
+					{Key: "A", Value: "21.04Value", ReleaseOverride: "21.04"},
 				}}},
 			}},
 		"Disabled override": {
@@ -200,7 +305,7 @@ func TestGetPolicies(t *testing.T) {
 			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
 			want: []entry.GPO{{ID: "multiple-releases", Name: "multiple-releases-name", Rules: map[string][]entry.Entry{
 				"dconf": {
-					{Key: "A", Value: "21.04Value"},
+					{Key: "A", Value: "21.04Value", ReleaseOverride: "21.04"},
 				}}},
 			}},
 		"Disable override for matching release, other releases override ignored": {
@@ -228,6 +333,21 @@ func TestGetPolicies(t *testing.T) {
 
 		// No override option for this release
 
+		// Enforce option
+		"Values are only defaults when not enforced, whatever the option order": {
+			gpoListArgs:        "dconf-default-only",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			want: []entry.GPO{{ID: "dconf-default-only", Name: "dconf-default-only-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue", Meta: "s", DefaultOnly: true},
+					{Key: "B", Value: "BValue", Meta: "s"},
+					{Key: "C", Value: "CValue", Meta: "s"},
+					{Key: "D", Value: "DValue", Meta: "s", DefaultOnly: true},
+				}}},
+			}},
+
 		// Multi domain cases
 		"Multiple domains, same GPO": {
 			gpoListArgs:        "multiple-domains",
@@ -394,6 +514,29 @@ func TestGetPolicies(t *testing.T) {
 					}}},
 			}},
 
+		// Scripts cases
+		"Scripts are relative to gpo cache, user object": {
+			gpoListArgs:        "scripts",
+			objectName:         "bob@EXAMPLE.COM",
+			objectClass:        ad.UserObject,
+			userKrb5CCBaseName: "kbr5cc_adsys_tests_bob",
+			want: []entry.GPO{
+				{ID: "scripts", Name: "scripts-name", Rules: map[string][]entry.Entry{
+					"scripts": {
+						{Key: "logon", Value: "scripts/User/Scripts/logon/script-user-logon\nscripts/User/Scripts/other-script-user-logon"},
+					}}},
+			}},
+		"Scripts are relative to gpo cache, computer object": {
+			gpoListArgs: "scripts",
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			want: []entry.GPO{
+				{ID: "scripts", Name: "scripts-name", Rules: map[string][]entry.Entry{
+					"scripts": {
+						{Key: "startup", Value: "scripts/Machine/Scripts/startup/script-machine-startup"},
+					}}},
+			}},
+
 		// Error cases
 		"Machine doesn’t match": {
 			gpoListArgs:        "standard",
@@ -471,10 +614,18 @@ func TestGetPolicies(t *testing.T) {
 			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
 				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 				ad.WithSSSCacheDir(sssCacheDir),
-				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs)),
+				ad.WithLDAPDialer(mockLDAPDialer(tc.gpoListArgs)),
 				ad.WithVersionID(tc.versionID))
 			require.NoError(t, err, "Setup: cannot create ad object")
 
+			if tc.loopbackMode != "" {
+				machineGPOs := []entry.GPO{{ID: "loopback", Name: "loopback-name", Rules: map[string][]entry.Entry{
+					"gpo": {{Key: "loopback", Value: tc.loopbackMode, Disabled: tc.loopbackDisabled}},
+				}}}
+				err := entry.SaveGPOs(machineGPOs, filepath.Join(cachedir, entry.GPORulesCacheBaseName, hostname))
+				require.NoError(t, err, "Setup: cannot save machine rules")
+			}
+
 			if tc.turnKrb5CCRO {
 				require.NoError(t, os.Chmod(adc.Krb5CacheDir(), 0400), "Setup: can’t set krb5 origin cache directory read only")
 				defer func() {
@@ -496,6 +647,240 @@ func TestGetPolicies(t *testing.T) {
 	}
 }
 
+func TestGetPoliciesFromLocalSource(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	standardUserRules := map[string][]entry.Entry{
+		"dconf": {
+			{Key: "A", Value: "standardA"},
+			{Key: "B", Value: "standardB"},
+			{Key: "C", Value: "standardC"},
+		}}
+	userOnlyRules := map[string][]entry.Entry{
+		"dconf": {
+			{Key: "A", Value: "userOnlyA"},
+			{Key: "B", Value: "userOnlyB"},
+		}}
+
+	tests := map[string]struct {
+		sourceDir   string
+		objectName  string
+		objectClass ad.ObjectClass
+		extensions  []string
+
+		want    []entry.GPO
+		wantErr bool
+	}{
+		"Plain GPO trees, user object": {
+			sourceDir: "plain",
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard", Rules: standardUserRules},
+				{ID: "user-only", Name: "user-only", Rules: userOnlyRules},
+			}},
+		"Plain GPO trees, computer object": {
+			sourceDir:   "plain",
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "standardA"},
+						{Key: "D", Value: "standardD"},
+						{Key: "E", Value: "standardE"},
+					}}},
+				{ID: "user-only", Name: "user-only", Rules: make(map[string][]entry.Entry)},
+			}},
+		"GPMC backups are named after the saved GPO": {
+			sourceDir: "gpmc",
+			want: []entry.GPO{
+				{ID: "{0B5B2F43-6D24-4F1B-9C6B-0A1E4D2B3C4D}", Name: "Standard GPO", Rules: standardUserRules},
+				{ID: "{A7E1C3D5-2B4F-4A6C-8E0D-1F3B5D7C9E2A}", Name: "User only GPO", Rules: userOnlyRules},
+			}},
+		"Local GPOs are processed whatever the supported extensions": {
+			sourceDir:  "plain",
+			extensions: []string{"{00000000-0000-0000-0000-000000000000}"},
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard", Rules: standardUserRules},
+				{ID: "user-only", Name: "user-only", Rules: userOnlyRules},
+			}},
+		"Group Policy Preferences are parsed in their own rule domains, user object": {
+			sourceDir: "preferences",
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard", Rules: map[string][]entry.Entry{
+					"dconf": standardUserRules["dconf"],
+					"environment": {
+						{Key: "JAVA_HOME", Value: "/usr/lib/jvm/default-java",
+							Meta: `{"action":"C","name":"JAVA_HOME","partial":"0","user":"1","value":"/usr/lib/jvm/default-java"}`},
+						{Key: "OLD_TOOLS", Disabled: true,
+							Meta: `{"action":"D","name":"OLD_TOOLS","partial":"0","user":"1","value":""}`},
+					}}},
+			}},
+		"Group Policy Preferences are parsed in their own rule domains, computer object": {
+			sourceDir:   "preferences",
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			want: []entry.GPO{
+				{ID: "standard", Name: "standard", Rules: map[string][]entry.Entry{
+					"groups": {
+						{Key: "sudo", Value: "ADD EXAMPLE\\Domain Admins", Meta: `{"action":"U","groupName":"sudo"}`},
+					}}},
+			}},
+		"Plain GPO trees and GPMC backups are ordered by directory names": {
+			sourceDir: "mixed",
+			want: []entry.GPO{
+				{ID: "user-only", Name: "user-only", Rules: userOnlyRules},
+				{ID: "{0B5B2F43-6D24-4F1B-9C6B-0A1E4D2B3C4D}", Name: "Standard GPO", Rules: standardUserRules},
+			}},
+		"GPOs sharing a display name are kept apart": {
+			sourceDir: "same-name",
+			want: []entry.GPO{
+				{ID: "{0B5B2F43-6D24-4F1B-9C6B-0A1E4D2B3C4D}", Name: "Standard GPO", Rules: standardUserRules},
+				{ID: "{11111111-2222-3333-4444-555555555555}", Name: "Standard GPO", Rules: userOnlyRules},
+			}},
+
+		// Error cases
+		"Error on computer which is not current host": {sourceDir: "plain", objectName: "otherhost", objectClass: ad.ComputerObject, wantErr: true},
+		"Error on missing source directory":           {sourceDir: "doesnotexist", wantErr: true},
+		"Error on GPMC backup without GPO name":       {sourceDir: "no-display-name", wantErr: true},
+		"Error on invalid GPMC backup info":           {sourceDir: "invalid-backup-info", wantErr: true},
+		"Error on invalid preferences file":           {sourceDir: "invalid-preferences", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.objectName == "" {
+				tc.objectName = "bob@EXAMPLE.COM"
+			}
+			if tc.objectClass == "" {
+				tc.objectClass = ad.UserObject
+			}
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), "", "example.com",
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir),
+				ad.WithGPOSourceDir(filepath.Join("testdata", "localsource", tc.sourceDir)),
+				ad.WithExtensions(tc.extensions),
+				ad.WithLDAPDialer(func(string, string) (ad.LDAPConn, error) {
+					return nil, errors.New("Active Directory should not be contacted with a local GPO source")
+				}))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			// No Kerberos ticket is needed
+			entries, err := adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, "")
+			if tc.wantErr {
+				require.Error(t, err, "GetPolicies should have errored out")
+				return
+			}
+			require.NoError(t, err, "GetPolicies should return no error")
+			require.Equal(t, tc.want, entries, "GetPolicies returns expected gpo entries in correct order")
+			assert.False(t, adc.IsOffline, "We report that we are online")
+
+			// Refreshing picks up the same policies from the cache
+			entries, err = adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, "")
+			require.NoError(t, err, "GetPolicies should return no error on refresh")
+			require.Equal(t, tc.want, entries, "GetPolicies returns expected gpo entries on refresh")
+		})
+	}
+}
+
+func TestGetPoliciesWithExtensions(t *testing.T) {
+	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
+
+	standardRules := map[string][]entry.Entry{
+		"dconf": {
+			{Key: "A", Value: "standardA"},
+			{Key: "B", Value: "standardB"},
+			{Key: "C", Value: "standardC"},
+		}}
+
+	tests := map[string]struct {
+		gpoListArgs    string
+		extensionNames map[string]string
+		extensions     []string
+
+		want           []entry.GPO
+		wantCachedGPOs []string
+	}{
+		"GPO with a supported extension is processed": {
+			gpoListArgs:    "standard",
+			extensionNames: map[string]string{"standard": "[{35378EAC-683F-11D2-A89A-0080C7A4D040}{D02B1F73-3407-48AE-BA88-E8213C6761F1}]"},
+			extensions:     []string{adcommon.RegistryExtension},
+			want: []entry.GPO{{ID: "standard", Name: "standard-name", Rules: standardRules,
+				Extensions: []string{adcommon.RegistryExtension}}},
+			wantCachedGPOs: []string{"standard"},
+		},
+		"GPO without supported extension is not downloaded": {
+			gpoListArgs:    "standard",
+			extensionNames: map[string]string{"standard": "[{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B66650-4972-11D1-A7CA-0000F87571E3}]"},
+			extensions:     []string{adcommon.RegistryExtension},
+			want: []entry.GPO{{ID: "standard", Name: "standard-name", Rules: make(map[string][]entry.Entry),
+				Extensions: []string{adcommon.ScriptsExtension}}},
+		},
+		"GPO without any extension is not downloaded": {
+			gpoListArgs: "standard",
+			extensions:  []string{adcommon.RegistryExtension},
+			want:        []entry.GPO{{ID: "standard", Name: "standard-name", Rules: make(map[string][]entry.Entry)}},
+		},
+		"Only GPOs with supported extensions are downloaded": {
+			gpoListArgs: "one-value_standard",
+			extensionNames: map[string]string{
+				"one-value": "[{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B66650-4972-11D1-A7CA-0000F87571E3}]",
+				"standard":  "[{35378EAC-683F-11D2-A89A-0080C7A4D040}{D02B1F73-3407-48AE-BA88-E8213C6761F1}][{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B66650-4972-11D1-A7CA-0000F87571E3}]",
+			},
+			extensions: []string{adcommon.RegistryExtension},
+			want: []entry.GPO{
+				{ID: "one-value", Name: "one-value-name", Rules: make(map[string][]entry.Entry),
+					Extensions: []string{adcommon.ScriptsExtension}},
+				{ID: "standard", Name: "standard-name", Rules: standardRules,
+					Extensions: []string{adcommon.RegistryExtension, adcommon.ScriptsExtension}},
+			},
+			wantCachedGPOs: []string{"standard"},
+		},
+		"Every GPO is processed without supported extensions": {
+			gpoListArgs:    "standard",
+			extensionNames: map[string]string{"standard": "[{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B66650-4972-11D1-A7CA-0000F87571E3}]"},
+			want: []entry.GPO{{ID: "standard", Name: "standard-name", Rules: standardRules,
+				Extensions: []string{adcommon.ScriptsExtension}}},
+			wantCachedGPOs: []string{"standard"},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
+
+			krb5CCName := setKrb5CC(t, "kbr5cc_adsys_tests_bob")
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+				ad.WithSSSCacheDir("testdata/sss/db"),
+				ad.WithLDAPDialer(mockLDAPDialerWithExtensions(tc.gpoListArgs, tc.extensionNames)),
+				ad.WithExtensions(tc.extensions))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			entries, err := adc.GetPolicies(context.Background(), "bob@EXAMPLE.COM", ad.UserObject, krb5CCName)
+			require.NoError(t, err, "GetPolicies should return no error")
+			require.Equal(t, tc.want, entries, "GetPolicies returns expected gpo entries in correct order")
+
+			cached, err := os.ReadDir(adc.GpoCacheDir())
+			require.NoError(t, err, "Teardown: can't read GPO cache")
+			var gotCachedGPOs []string
+			for _, d := range cached {
+				gotCachedGPOs = append(gotCachedGPOs, d.Name())
+			}
+			require.Equal(t, tc.wantCachedGPOs, gotCachedGPOs, "Only GPOs with supported extensions are downloaded")
+		})
+	}
+}
+
 func TestGetPoliciesOffline(t *testing.T) {
 	t.Parallel()
 
@@ -518,6 +903,7 @@ func TestGetPoliciesOffline(t *testing.T) {
 
 	tests := map[string]struct {
 		getFromCache bool
+		gpoListArgs  string
 
 		want    []entry.GPO
 		wantErr bool
@@ -526,6 +912,16 @@ func TestGetPoliciesOffline(t *testing.T) {
 			getFromCache: true,
 			want:         gpos,
 		},
+		"Offline after dialing, get from cache": {
+			getFromCache: true,
+			gpoListArgs:  "-ExitOnAccountSearch-",
+			want:         gpos,
+		},
+		"Offline while reading GPOs, get from cache": {
+			getFromCache: true,
+			gpoListArgs:  "-ExitOnGPOSearch-",
+			want:         gpos,
+		},
 		"Error offline with no cache": {
 			getFromCache: false,
 			wantErr:      true,
@@ -537,10 +933,14 @@ func TestGetPoliciesOffline(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
+			if tc.gpoListArgs == "" {
+				tc.gpoListArgs = "-Exit2-"
+			}
+
 			cachedir, rundir := t.TempDir(), t.TempDir()
 			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
 				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
-				ad.WithGPOListCmd(mockGPOListCmd(t, "-Exit2-")))
+				ad.WithLDAPDialer(mockLDAPDialer(tc.gpoListArgs)))
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			objectName := "useroffline@EXAMPLE.COM"
@@ -631,7 +1031,7 @@ func TestGetPoliciesWorkflows(t *testing.T) {
 			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
 				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 				ad.WithSSSCacheDir("testdata/sss/db"),
-				ad.WithGPOListCmd(mockGPOListCmd(t, gpoListArgs)))
+				ad.WithLDAPDialer(mockLDAPDialer(gpoListArgs)))
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			// First call
@@ -653,7 +1053,7 @@ func TestGetPoliciesWorkflows(t *testing.T) {
 				adc, err = ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
 					ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 					ad.WithSSSCacheDir("testdata/sss/db"),
-					ad.WithGPOListCmd(mockGPOListCmd(t, gpoListArgs)))
+					ad.WithLDAPDialer(mockLDAPDialer(gpoListArgs)))
 				require.NoError(t, err, "Cannot create second ad object")
 			}
 
@@ -755,7 +1155,7 @@ func TestGetPoliciesConcurrently(t *testing.T) {
 			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
 				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 				ad.WithSSSCacheDir("testdata/sss/db"),
-				ad.WithGPOListCmd(mockGPOListCmd(t, gpoListMeta)))
+				ad.WithLDAPDialer(mockLDAPDialer(gpoListMeta)))
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			wg := sync.WaitGroup{}
@@ -854,69 +1254,218 @@ func TestListUsersFromCache(t *testing.T) {
 	}
 }
 
-func TestMockGPOList(t *testing.T) {
-	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
-		return
+func TestGarbageCollect(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		gracePeriod       time.Duration
+		invalidRulesCache bool
+
+		want    []string
+		wantErr bool
+	}{
+		"Unreferenced GPOs not used during the grace period are removed": {gracePeriod: 24 * time.Hour, want: []string{"{OLD-UNREFERENCED}"}},
+		"Every unreferenced GPO is removed without grace period":         {want: []string{"{OLD-UNREFERENCED}", "{RECENT-UNREFERENCED}"}},
+		"Nothing is removed when unreferenced GPOs are all recent":       {gracePeriod: 30 * 24 * time.Hour},
+
+		// Error cases
+		"Error on invalid rules cache": {invalidRulesCache: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), "ldap://UNUSED:1636/", "example.com",
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			// Referenced GPOs are kept whatever their age
+			old := time.Now().Add(-7 * 24 * time.Hour)
+			for _, id := range []string{"{MACHINE}", "{USER}", "{OLD-UNREFERENCED}", "{RECENT-UNREFERENCED}"} {
+				p := filepath.Join(adc.GpoCacheDir(), id)
+				require.NoError(t, os.MkdirAll(filepath.Join(p, "User"), 0700), "Setup: can't create cached GPO")
+				if id != "{RECENT-UNREFERENCED}" {
+					require.NoError(t, os.Chtimes(p, old, old), "Setup: can't age cached GPO")
+				}
+			}
+			require.NoError(t, entry.SaveGPOs([]entry.GPO{{ID: "{MACHINE}", Name: "machine-gpo"}},
+				filepath.Join(adc.GpoRulesCacheDir(), "myhost")), "Setup: can't create machine rules cache")
+			require.NoError(t, entry.SaveGPOs([]entry.GPO{{ID: "{USER}", Name: "user-gpo"}},
+				filepath.Join(adc.GpoRulesCacheDir(), "bob@EXAMPLE.COM")), "Setup: can't create user rules cache")
+			if tc.invalidRulesCache {
+				require.NoError(t, os.WriteFile(filepath.Join(adc.GpoRulesCacheDir(), "sponge@EXAMPLE.COM"), []byte("invalid: [yaml"), 0600),
+					"Setup: can't create invalid rules cache")
+			}
+
+			got, err := adc.GarbageCollect(context.Background(), tc.gracePeriod)
+			if tc.wantErr {
+				require.Error(t, err, "GarbageCollect should have errored out")
+				cached, err := os.ReadDir(adc.GpoCacheDir())
+				require.NoError(t, err, "Can't read GPO cache")
+				require.Len(t, cached, 4, "GarbageCollect should not remove anything on error")
+				return
+			}
+			require.NoError(t, err, "GarbageCollect should return no error")
+			require.Equal(t, tc.want, got, "GarbageCollect should return the removed GPOs")
+
+			for _, id := range []string{"{MACHINE}", "{USER}", "{OLD-UNREFERENCED}", "{RECENT-UNREFERENCED}"} {
+				removed := false
+				for _, r := range got {
+					removed = removed || r == id
+				}
+				_, err := os.Stat(filepath.Join(adc.GpoCacheDir(), id))
+				if removed {
+					require.ErrorIs(t, err, os.ErrNotExist, "Removed GPO %s should not be in cache anymore", id)
+					continue
+				}
+				require.NoError(t, err, "GPO %s should be kept in cache", id)
+			}
+		})
 	}
-	defer os.Exit(0)
+}
+
+func TestGarbageCollectFetchedGPOs(t *testing.T) {
+	t.Parallel()
+
+	cachedir, rundir := t.TempDir(), t.TempDir()
+	adc, err := ad.New(context.Background(), "", "example.com",
+		ad.WithCacheDir(cachedir), ad.WithRunDir(rundir),
+		ad.WithGPOSourceDir(filepath.Join("testdata", "localsource", "plain")))
+	require.NoError(t, err, "Setup: cannot create ad object")
 
-	krb5File := os.Getenv("KRB5CCNAME")
-	if _, err := os.Lstat(krb5File); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Expecting symlink %s to exists", krb5File)
-		os.Exit(1)
+	want, err := adc.GetPolicies(context.Background(), "bob@EXAMPLE.COM", ad.UserObject, "")
+	require.NoError(t, err, "Setup: GetPolicies should return no error")
+	require.Equal(t, []string{"standard", "user-only"}, adc.LoadedGPOs(), "Setup: fetched GPOs should be loaded")
+
+	// Just fetched GPOs are kept during the grace period, even if no rules referencing them were saved
+	got, err := adc.GarbageCollect(context.Background(), time.Hour)
+	require.NoError(t, err, "GarbageCollect should return no error")
+	require.Empty(t, got, "GarbageCollect should keep recently fetched GPOs")
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, id := range []string{"standard", "user-only"} {
+		require.NoError(t, os.Chtimes(filepath.Join(adc.GpoCacheDir(), id), old, old), "Setup: can't age cached GPO")
 	}
-	if _, err := os.Stat(krb5File); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Expecting file pointed by %s to exists", krb5File)
-		os.Exit(1)
+	got, err = adc.GarbageCollect(context.Background(), time.Hour)
+	require.NoError(t, err, "GarbageCollect should return no error")
+	require.Equal(t, []string{"standard", "user-only"}, got, "GarbageCollect should remove unused GPOs")
+	require.Empty(t, adc.LoadedGPOs(), "Removed GPOs should be pruned from memory")
+
+	// Removed GPOs are fetched again and fetching them marks them as used
+	entries, err := adc.GetPolicies(context.Background(), "bob@EXAMPLE.COM", ad.UserObject, "")
+	require.NoError(t, err, "GetPolicies should return no error after garbage collection")
+	require.Equal(t, want, entries, "GetPolicies should return the same policies after garbage collection")
+	for _, id := range []string{"standard", "user-only"} {
+		require.NoError(t, os.Chtimes(filepath.Join(adc.GpoCacheDir(), id), old, old), "Setup: can't age cached GPO")
 	}
+	_, err = adc.GetPolicies(context.Background(), "bob@EXAMPLE.COM", ad.UserObject, "")
+	require.NoError(t, err, "GetPolicies should return no error on refresh")
+	got, err = adc.GarbageCollect(context.Background(), time.Hour)
+	require.NoError(t, err, "GarbageCollect should return no error")
+	require.Empty(t, got, "GarbageCollect should keep GPOs used by the last refresh")
+}
+
+// mockLDAPDialer returns a dialer to a directory where every searched account is linked to gpos.
+// gpos is a list of GPO ids separated by _. "-Exit2-" simulates an unreachable server and
+// "DEPENDS:user@gpo:user@gpo…" lists the GPOs linked per user.
+func mockLDAPDialer(gpos string) func(string, string) (ad.LDAPConn, error) {
+	return mockLDAPDialerWithExtensions(gpos, nil)
+}
+
+// mockLDAPDialerWithExtensions is a mockLDAPDialer where GPOs have the client-side extensions names of extensionNames,
+// for both machines and users.
+func mockLDAPDialerWithExtensions(gpos string, extensionNames map[string]string) func(string, string) (ad.LDAPConn, error) {
+	return func(_, krb5CCPath string) (ad.LDAPConn, error) {
+		if _, err := os.Lstat(krb5CCPath); err != nil {
+			return nil, fmt.Errorf("expecting symlink %s to exist: %v", krb5CCPath, err)
+		}
+		if _, err := os.Stat(krb5CCPath); err != nil {
+			return nil, fmt.Errorf("expecting file pointed by %s to exist: %v", krb5CCPath, err)
+		}
 
-	args := os.Args
-	for len(args) > 0 {
-		if args[0] != "--" {
-			args = args[1:]
-			continue
+		// simulating offline mode
+		if gpos == "-Exit2-" {
+			return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("error during gpo list requested with network error"))
 		}
-		args = args[1:]
-		break
+
+		return &mockDirectory{Directory: admock.NewDirectory(admock.ExampleBaseDN), gpos: gpos, extensionNames: extensionNames}, nil
 	}
+}
+
+var accountSearchRe = regexp.MustCompile(`\(sAMAccountName=([^)$]*)\)`)
+
+// mockDirectory is a directory creating searched accounts on the fly, in their own OU linked to their GPOs.
+type mockDirectory struct {
+	*admock.Directory
+	gpos           string
+	extensionNames map[string]string
+}
+
+func (d *mockDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	m := accountSearchRe.FindStringSubmatch(req.Filter)
 
-	// simulating offline mode with Exit 2
-	if args[0] == "-Exit2-" {
-		fmt.Fprint(os.Stderr, "Error during gpo list requested with exit 2")
-		os.Exit(2)
+	// simulating the connection being lost after dialing
+	if (d.gpos == "-ExitOnAccountSearch-" && m != nil) ||
+		(d.gpos == "-ExitOnGPOSearch-" && strings.Contains(req.BaseDN, ",CN=Policies,")) {
+		return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed during search"))
 	}
 
-	// as in gpolist, we split on the @ if any
-	objectName := args[len(args)-1]
-	objectName = strings.Split(objectName, "@")[0]
+	if m != nil {
+		d.addAccount(m[1], strings.Contains(req.Filter, "(objectClass=computer)"))
+	}
+	return d.Directory.Search(req)
+}
 
+func (d *mockDirectory) addAccount(name string, isComputer bool) {
 	var gpos []string
-
 	// Parameterized on user gpos
-	if strings.HasPrefix(args[0], "DEPENDS:") {
-		v := strings.TrimPrefix(args[0], "DEPENDS:")
-		gpoItems := strings.Split(v, ":")
-		for _, gpoItem := range gpoItems {
+	if strings.HasPrefix(d.gpos, "DEPENDS:") {
+		for _, gpoItem := range strings.Split(strings.TrimPrefix(d.gpos, "DEPENDS:"), ":") {
 			i := strings.SplitN(gpoItem, "@", 2)
-			if i[0] == objectName {
+			if i[0] == name {
 				gpos = append(gpos, i[1])
 			}
 		}
 	} else {
-		gpos = strings.Split(args[0], "_")
+		gpos = strings.Split(d.gpos, "_")
 	}
 
-	for _, gpo := range gpos {
-		fmt.Fprintf(os.Stdout, "%s-name\tsmb://localhost:%d/SYSVOL/example.com/Policies/%s\n", gpo, ad.SmbPort, gpo)
+	var gPLink string
+	for _, g := range gpos {
+		// GPOs are named after their ID, unless a display name is given with id=name
+		displayName := g + "-name"
+		if i := strings.SplitN(g, "=", 2); len(i) == 2 {
+			g, displayName = i[0], i[1]
+		}
+		dn := fmt.Sprintf("CN=%s,CN=Policies,CN=System,%s", g, admock.ExampleBaseDN)
+		gPLink += fmt.Sprintf("[LDAP://%s;0]", dn)
+		attrs := map[string][]string{
+			"objectClass":          {"top", "container", "groupPolicyContainer"},
+			"displayName":          {displayName},
+			"flags":                {"0"},
+			"nTSecurityDescriptor": {admock.DefaultGPOSecurityDescriptor},
+			"gPCFileSysPath":       {fmt.Sprintf(`\\localhost:%d\SYSVOL\example.com\Policies\%s`, ad.SmbPort, g)},
+		}
+		if names := d.extensionNames[g]; names != "" {
+			attrs["gPCMachineExtensionNames"] = []string{names}
+			attrs["gPCUserExtensionNames"] = []string{names}
+		}
+		d.Add(dn, attrs)
 	}
-}
-
-func mockGPOListCmd(t *testing.T, args ...string) []string {
-	t.Helper()
+	ou := fmt.Sprintf("OU=%s,%s", name, admock.ExampleBaseDN)
+	d.Add(ou, map[string][]string{"objectClass": {"top", "organizationalUnit"}, "gPLink": {gPLink}})
 
-	cmdArgs := []string{"env", "GO_WANT_HELPER_PROCESS=1", os.Args[0], "-test.run=TestMockGPOList", "--"}
-	cmdArgs = append(cmdArgs, args...)
-	return cmdArgs
+	objectClass := []string{"top", "person", "organizationalPerson", "user"}
+	if isComputer {
+		objectClass = append(objectClass, "computer")
+	}
+	d.Add(fmt.Sprintf("CN=%s,%s", name, ou), map[string][]string{
+		"objectClass":    objectClass,
+		"sAMAccountName": {name},
+		"objectSid":      {admock.EncodeSID(admock.ExampleAccountSID)},
+	})
 }
 
 // setKrb5CC create a temporary file for a KRB5 ticket.
@@ -178,6 +178,10 @@ func (g generator) generateExpandedCategories(categories []category, policies []
 		// assign "all" elements and default to highest release description
 		releasesElements["all"] = releasesElements[highestRelease]
 		metas["all"] = releasesElements["all"].Meta
+		// dconf values can be only defaults: declare the Enforce option next to the policy elements
+		if typePol == "dconf" {
+			metas["Enforce"] = map[string]string{"meta": adcommon.EnforceMeta}
+		}
 		explainText := releasesElements["all"].ExplainText
 
 		// Keep only all if there is one supported release on this key
@@ -311,6 +315,12 @@ type policyForADMX struct {
 	ParentCategory string
 }
 
+// HasEnforceOption returns true if the policy values can be only defaults instead of being enforced.
+// The option is only available for dconf policies, where unenforced values are not locked.
+func (p policyForADMX) HasEnforceOption() bool {
+	return p.ReleasesElements["all"].Type == "dconf"
+}
+
 // GetOrderedPolicyElements returns all the policy elements order by release in decreasing order
 func (p policyForADMX) GetOrderedPolicyElements() []common.ExpandedPolicy {
 	var policies []common.ExpandedPolicy
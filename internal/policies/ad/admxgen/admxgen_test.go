@@ -124,6 +124,7 @@ func TestExpandedCategoriesToADMX(t *testing.T) {
 		"nested categories":   {},
 		"multiple categories": {},
 		"other distro":        {distroID: "Debian"},
+		"not dconf policy":    {},
 
 		// Types
 		"boolean":               {},
@@ -208,17 +209,20 @@ func TestMainExpand(t *testing.T) {
 
 		wantErr bool
 	}{
-		"dconf": {root: "simple"},
+		"dconf":   {root: "simple"},
+		"scripts": {root: "simple"},
 
 		"ignore categories and non yaml files": {root: "simple"},
 
 		/* Error cases */
-		"no release file":         {root: "no release file", wantErr: true},
-		"no version_id":           {root: "no version id", wantErr: true},
-		"unsupported policy type": {root: "simple", wantErr: true},
-		"no source directory":     {root: "simple", wantErr: true},
-		"invalid dconf.yaml":      {root: "simple", wantErr: true},
-		"dconf generation fails":  {root: "unsupported dconf type", wantErr: true},
+		"no release file":          {root: "no release file", wantErr: true},
+		"no version_id":            {root: "no version id", wantErr: true},
+		"unsupported policy type":  {root: "simple", wantErr: true},
+		"no source directory":      {root: "simple", wantErr: true},
+		"invalid dconf.yaml":       {root: "simple", wantErr: true},
+		"dconf generation fails":   {root: "unsupported dconf type", wantErr: true},
+		"invalid scripts.yaml":     {root: "simple", wantErr: true},
+		"scripts generation fails": {root: "simple", wantErr: true},
 	}
 	for name, tc := range tests {
 		name := name
@@ -250,7 +254,9 @@ func TestMainExpand(t *testing.T) {
 			expandedPoliciesByType := make(map[string][]common.ExpandedPolicy)
 			var types []string
 			for _, p := range got {
-				types = append(types, p.Type)
+				if _, ok := expandedPoliciesByType[p.Type]; !ok {
+					types = append(types, p.Type)
+				}
 				expandedPoliciesByType[p.Type] = append(expandedPoliciesByType[p.Type], p)
 			}
 			sort.Strings(types)
@@ -2,9 +2,11 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/ubuntu/adsys/internal/decorate"
 	"github.com/ubuntu/adsys/internal/i18n"
 )
 
@@ -78,3 +80,25 @@ func ValidClass(class string) (string, error) {
 
 	return c, nil
 }
+
+// Generate completes policies which are directly defined as expanded policies for a given release and policy type.
+func Generate(policies []ExpandedPolicy, release, policyType string) (ep []ExpandedPolicy, err error) {
+	defer decorate.OnError(&err, i18n.G("can't generate %s policies"), policyType)
+
+	for _, p := range policies {
+		if p.Key == "" {
+			return nil, errors.New(i18n.G("policy without key"))
+		}
+		if p.ElementType == "" {
+			return nil, fmt.Errorf(i18n.G("policy %s has no element type"), p.Key)
+		}
+		if p.Class, err = ValidClass(p.Class); err != nil {
+			return nil, err
+		}
+		p.Release = release
+		p.Type = policyType
+		ep = append(ep, p)
+	}
+
+	return ep, nil
+}
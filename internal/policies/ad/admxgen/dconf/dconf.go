@@ -16,6 +16,7 @@ import (
 	"github.com/ubuntu/adsys/internal/decorate"
 	"github.com/ubuntu/adsys/internal/i18n"
 	"github.com/ubuntu/adsys/internal/policies/ad/admxgen/common"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvariant"
 	"gopkg.in/ini.v1"
 )
 
@@ -35,11 +36,14 @@ type Policy struct {
 // schemasPath is the path to the directory that contains dconf schemas and overrides
 const schemasPath = "usr/share/glib-2.0/schemas/"
 
+// typeMetadata is how a key type is edited in the ADMX, and the value of an empty entry.
+type typeMetadata struct {
+	widgetType common.WidgetType
+	emptyValue string
+}
+
 var (
-	schemaTypeToMetadata = map[string]struct {
-		widgetType common.WidgetType
-		emptyValue string
-	}{
+	schemaTypeToMetadata = map[string]typeMetadata{
 		"s":  {common.WidgetTypeText, "''"},
 		"b":  {common.WidgetTypeBool, "false"},
 		"i":  {common.WidgetTypeDecimal, "0"},
@@ -132,7 +136,10 @@ func inflateToExpandedPolicies(policies []Policy, release, currentSessions strin
 		m, ok := schemaTypeToMetadata[s.Type]
 		// enums are converted to choices and have no type
 		if !ok && len(s.Choices) == 0 {
-			return nil, fmt.Errorf("listed type %q is not supported in schemaTypeToMetadata. Please add it", s.Type)
+			var err error
+			if m, err = gvariantTypeMetadata(s.Type); err != nil {
+				return nil, fmt.Errorf("listed type %q is not supported in schemaTypeToMetadata. Please add it: %v", s.Type, err)
+			}
 		}
 		ep.ElementType = m.widgetType
 		if len(s.Choices) > 0 {
@@ -168,6 +175,19 @@ func inflateToExpandedPolicies(policies []Policy, release, currentSessions strin
 	return r, nil
 }
 
+// gvariantTypeMetadata returns the metadata of any other valid GVariant type, edited in its text format.
+// Arrays and dictionaries are entered one element per line.
+func gvariantTypeMetadata(keyType string) (typeMetadata, error) {
+	empty, err := gvariant.Zero(keyType)
+	if err != nil {
+		return typeMetadata{}, err
+	}
+	if keyType[0] == 'a' {
+		return typeMetadata{common.WidgetTypeMultiText, empty}, nil
+	}
+	return typeMetadata{common.WidgetTypeText, empty}, nil
+}
+
 // default are separated in a different map as defaults can be different for different object path from the same schema.
 // it is thus indexed only by object path.
 type schemaEntry struct {
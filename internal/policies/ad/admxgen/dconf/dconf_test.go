@@ -43,6 +43,11 @@ func TestGenerate(t *testing.T) {
 		"Array of integers":                    {root: "simple"},
 		"Double key":                           {root: "simple"},
 		"Double key with range":                {root: "simple"},
+		"Uint64 key":                           {root: "simple"},
+		"Tuple key":                            {root: "simple"},
+		"Array of tuples":                      {root: "simple"},
+		"Dictionary":                           {root: "simple"},
+		"Dictionary of variants":               {root: "simple"},
 
 		// Override cases
 		"Override without session":                                    {root: "simple", currentSessions: "-"},
@@ -143,6 +143,17 @@ func expand(src, dst, root, currentSession string) error {
 					return err
 				}
 				expandedPoliciesStream <- ep
+			case "scripts", "apparmor", "privilege", "mount", "environment", "proxy", "gpo":
+				var policies []common.ExpandedPolicy
+				if err = yaml.Unmarshal(data, &policies); err != nil {
+					return err
+				}
+
+				ep, err := common.Generate(policies, release, t)
+				if err != nil {
+					return err
+				}
+				expandedPoliciesStream <- ep
 			default:
 				return fmt.Errorf("unsupported policy type: %s", t)
 			}
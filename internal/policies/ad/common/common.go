@@ -15,6 +15,9 @@ import (
 // KeyPrefix is the prefix for all our policies in the GPO
 const KeyPrefix = "Software/Policies"
 
+// EnforceMeta is the meta, in the metaValues container of a policy, of its option to enforce its values.
+const EnforceMeta = "enforce"
+
 // GetVersionID returns from root a the VERSION_ID field of os-release
 func GetVersionID(root string) (versionID string, err error) {
 	defer decorate.OnError(&err, i18n.G("cannot get versionID"))
@@ -45,3 +45,25 @@ func TestGetVersionID(t *testing.T) {
 		})
 	}
 }
+
+func TestExtensionName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		guid string
+
+		want string
+	}{
+		"Known extension":                     {guid: adcommon.RegistryExtension, want: "Registry"},
+		"Known extension is case insensitive": {guid: "{35378eac-683f-11d2-a89a-0080c7a4d040}", want: "Registry"},
+		"Unknown extension returns its GUID":  {guid: "{00000000-0000-0000-0000-000000000000}", want: "{00000000-0000-0000-0000-000000000000}"},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.want, adcommon.ExtensionName(tc.guid), "ExtensionName returns expected name")
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package adcommon
+
+import "strings"
+
+// GUIDs of the client-side extensions (CSE) processing the settings of a GPO.
+// A GPO lists the extensions having settings for machines and users in its gPCMachineExtensionNames and
+// gPCUserExtensionNames attributes.
+const (
+	// RegistryExtension applies the administrative templates, stored in the Registry.pol files of the GPO.
+	RegistryExtension = "{35378EAC-683F-11D2-A89A-0080C7A4D040}"
+	// ScriptsExtension runs the startup, shutdown, logon and logoff scripts.
+	ScriptsExtension = "{42B5FAAE-6536-11D2-AE5A-0000F87571E3}"
+	// SecurityExtension applies the security settings.
+	SecurityExtension = "{827D319E-6EAC-11D2-A4EA-00C04F79F83A}"
+	// EnvironmentExtension applies the environment variables of the Group Policy Preferences.
+	EnvironmentExtension = "{0E28E245-9368-4853-AD84-6DA3BA35BB75}"
+	// InternetSettingsExtension applies the internet settings of the Group Policy Preferences.
+	InternetSettingsExtension = "{E47248BA-94CC-49C4-BBB5-9EB7F05183D0}"
+)
+
+// extensionNames are the display names of well-known client-side extensions, as shown in the Group Policy
+// Management Console.
+var extensionNames = map[string]string{
+	RegistryExtension:         "Registry",
+	ScriptsExtension:          "Scripts",
+	SecurityExtension:         "Security",
+	EnvironmentExtension:      "Group Policy Environment",
+	InternetSettingsExtension: "Group Policy Internet Settings",
+
+	"{25537BA6-77A8-11D2-9B6C-0000F8080861}": "Folder Redirection",
+	"{C6DC5466-785A-11D2-84D0-00C04FB169F7}": "Software Installation",
+	"{B1BE8D72-6EAC-11D2-A4EA-00C04F79F83A}": "EFS Recovery",
+	"{E437BC1C-AA7D-11D2-A382-00C04F991E27}": "IP Security",
+	"{0ACDD40C-75AC-47AB-BAA0-BF6DE7E7FE63}": "Wireless Group Policy",
+	"{5794DAFD-BE60-433F-88A2-1A31939AC01F}": "Group Policy Drive Maps",
+	"{7150F9BF-48AD-4DA4-A49C-29EF4A8369BA}": "Group Policy Files",
+	"{6232C319-91AC-4931-9385-E70C2B099F0E}": "Group Policy Folders",
+	"{74EE6C03-5363-4554-B161-627540339CAB}": "Group Policy Ini Files",
+	"{17D89FEC-5C44-4972-B12D-241CAEF74509}": "Group Policy Local Users and Groups",
+	"{BC75B1ED-5833-4858-9BB8-CBF0B166DF9D}": "Group Policy Printers",
+	"{B087BE9D-ED37-454F-AF9C-04291E351182}": "Group Policy Registry",
+	"{AADCED64-746C-4633-A97C-D61349046527}": "Group Policy Scheduled Tasks",
+	"{91FBB303-0CD5-4055-BF42-E512A681B325}": "Group Policy Services",
+	"{C418DD9D-0D14-4EFB-8FBF-CFE535C8FAC7}": "Group Policy Shortcuts",
+}
+
+// ExtensionName returns the display name of the client-side extension guid, or the guid itself if it is unknown.
+func ExtensionName(guid string) string {
+	if name, ok := extensionNames[strings.ToUpper(guid)]; ok {
+		return name
+	}
+	return guid
+}
@@ -2,11 +2,14 @@ package ad
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
 	policydefinitions "github.com/ubuntu/adsys/policies"
 )
 
@@ -28,3 +31,72 @@ func GetPolicyDefinitions(ctx context.Context, format, distroID string) (admx st
 
 	return string(admxData), string(admlData), nil
 }
+
+// GetPolicyDisplayNames returns the display names of the policies defined in the ADMX and ADML of the given type t
+// of policies. They are indexed by object class (Machine or User), then by rule domain and key, as in GPO rules, like
+// dconf/org/gnome/desktop/interface/clock-format.
+func GetPolicyDisplayNames(ctx context.Context, format, distroID string) (names map[string]map[string]string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't get policy display names"))
+
+	log.Debugf(ctx, "GetPolicyDisplayNames for %q (%q)", distroID, format)
+
+	admxData, admlData, err := GetPolicyDefinitions(ctx, format, distroID)
+	if err != nil {
+		return nil, err
+	}
+
+	var admx struct {
+		Policies []struct {
+			Class       string `xml:"class,attr"`
+			DisplayName string `xml:"displayName,attr"`
+			Key         string `xml:"key,attr"`
+		} `xml:"policies>policy"`
+	}
+	if err := xml.Unmarshal([]byte(admxData), &admx); err != nil {
+		return nil, err
+	}
+	var adml struct {
+		Strings []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"resources>stringTable>string"`
+	}
+	if err := xml.Unmarshal([]byte(admlData), &adml); err != nil {
+		return nil, err
+	}
+
+	strs := make(map[string]string)
+	for _, s := range adml.Strings {
+		strs[s.ID] = s.Value
+	}
+
+	keyPrefix := fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, distroID)
+	names = map[string]map[string]string{"Machine": {}, "User": {}}
+	for _, p := range admx.Policies {
+		key := strings.ReplaceAll(p.Key, `\`, "/")
+		if !strings.HasPrefix(key, keyPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, keyPrefix)
+
+		// References to the string table are in the form $(string.ID)
+		id := strings.TrimSuffix(strings.TrimPrefix(p.DisplayName, "$(string."), ")")
+		name, ok := strs[id]
+		if !ok {
+			return nil, fmt.Errorf(i18n.G("no display name %q for policy %q"), id, p.Key)
+		}
+
+		classes := []string{p.Class}
+		if p.Class == "Both" {
+			classes = []string{"Machine", "User"}
+		}
+		for _, c := range classes {
+			if names[c] == nil {
+				continue
+			}
+			names[c][key] = name
+		}
+	}
+
+	return names, nil
+}
@@ -64,3 +64,61 @@ func TestGetPolicyDefinitions(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPolicyDisplayNames(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		format   string
+		distroID string
+
+		wantClass string
+		wantKey   string
+		wantName  string
+		wantErr   bool
+	}{
+		"User policy": {
+			format:    "all",
+			wantClass: "User",
+			wantKey:   "dconf/org/gnome/desktop/interface/clock-format",
+			wantName:  "Whether the clock displays in 24h or 12h format",
+		},
+		"Machine policy": {
+			format:    "lts-only",
+			wantClass: "Machine",
+			wantKey:   "gdm/dconf/org/gnome/desktop/notifications/show-in-lock-screen",
+			wantName:  "Show notifications in the lock screen",
+		},
+
+		"Definitions do not exist for this format": {
+			format:  "NotExist",
+			wantErr: true,
+		},
+		"Definitions do not exist for this distro": {
+			format:   "lts-only",
+			distroID: "NotExist",
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.distroID == "" {
+				tc.distroID = "Ubuntu"
+			}
+
+			names, err := ad.GetPolicyDisplayNames(context.Background(), tc.format, tc.distroID)
+			if tc.wantErr {
+				require.NotNil(t, err, "GetPolicyDisplayNames returned no error when expecting one")
+				return
+			}
+			require.NoError(t, err, "GetPolicyDisplayNames returned an error when expecting none")
+
+			require.Equal(t, tc.wantName, names[tc.wantClass][tc.wantKey], "expected display name doesn't match")
+		})
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mvo5/libsmbclient-go"
 	"github.com/ubuntu/adsys/internal/decorate"
@@ -54,11 +55,13 @@ import (
 )
 
 /*
-fetch downloads a list of gpos from a url for a given kerberosTicket and stores the downloaded files in dest.
-Each gpo entry must be a gpo, with a name, url of the form: smb://<server>/SYSVOL/<AD domain>/<GPO_ID> and mutex.
+fetch downloads a list of gpos from the GPO source and stores the downloaded files in the GPO cache.
+Each gpo has a display name and a url, like smb://<server>/SYSVOL/<AD domain>/<GPO_ID> for SYSVOL. GPOs are
+identified by the base of their url, as display names are not unique.
 If krb5Ticket is empty, no authentication is done on samba.
+A cached GPO is only downloaded again if the part of it applying to objectClass changed.
 */
-func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]string) (err error) {
+func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos []gpo, objectClass ObjectClass) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't download all gpos"))
 
 	dest := ad.gpoCacheDir
@@ -67,36 +70,23 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]stri
 	ad.Lock()
 	defer ad.Unlock()
 
-	// Set kerberos ticket.
-	const krb5TicketEnv = "KRB5CCNAME"
-	oldKrb5Ticket := os.Getenv(krb5TicketEnv)
-	if err := os.Setenv(krb5TicketEnv, krb5Ticket); err != nil {
+	downloader, err := ad.source.newDownloader(ctx, krb5Ticket)
+	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := os.Setenv(krb5TicketEnv, oldKrb5Ticket); err != nil {
-			log.Errorf(ctx, "Couln't restore initial value for %s: %v", krb5Ticket, err)
-		}
-	}()
-
-	client := libsmbclient.New()
-	defer client.Close()
-	// When testing we cannot use kerberos without a real kerberos server
-	// So we don't use kerberos in this case
-	if !ad.withoutKerberos {
-		client.SetUseKerberos()
-	}
+	defer downloader.close(ctx)
 
 	var errg errgroup.Group
-	for name, url := range gpos {
-		g, ok := ad.gpos[name]
+	for _, listed := range gpos {
+		id := filepath.Base(listed.url)
+		g, ok := ad.gpos[id]
 		if !ok {
-			ad.gpos[name] = &gpo{
-				name: name,
-				url:  url,
+			ad.gpos[id] = &gpo{
+				name: listed.name,
+				url:  listed.url,
 				mu:   &sync.RWMutex{},
 			}
-			g = ad.gpos[name]
+			g = ad.gpos[id]
 		}
 		errg.Go(func() (err error) {
 			defer decorate.OnError(&err, i18n.G("can't download GPO %q"), g.name)
@@ -109,11 +99,16 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]stri
 			dest := filepath.Join(dest, filepath.Base(g.url))
 
 			// Look at GPO version and compare with the one on AD to decide if we redownload or not
-			shouldDownload, err := gpoNeedsDownload(ctx, client, g, dest)
+			shouldDownload, err := downloader.needsDownload(ctx, g, dest, objectClass)
 			if err != nil {
 				return err
 			}
 			if !shouldDownload {
+				// Mark the cached GPO as used, to prevent garbage collecting it
+				now := time.Now()
+				if err := os.Chtimes(dest, now, now); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
 				return nil
 			}
 
@@ -133,7 +128,7 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]stri
 					log.Info(ctx, i18n.G("Could not clean up temporary directory:"), err)
 				}
 			}()
-			if err := downloadRecursive(client, g.url, tmpdest); err != nil {
+			if err := downloader.download(g.url, tmpdest); err != nil {
 				return err
 			}
 			// Remove previous GPO
@@ -144,6 +139,7 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]stri
 			if err := os.Rename(tmpdest, dest); err != nil {
 				return err
 			}
+			g.version = readGPOVersion(ctx, g.name, dest)
 
 			return nil
 		})
@@ -156,21 +152,57 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, gpos map[string]stri
 	return nil
 }
 
-func gpoNeedsDownload(ctx context.Context, client *libsmbclient.Client, g *gpo, localPath string) (updateNeeded bool, err error) {
-	defer decorate.OnError(&err, i18n.G("can't check if %s needs refreshing"), g.name)
+// smbDownloader downloads GPOs from SYSVOL with a samba client.
+// The Kerberos ticket is set in the environment for the lifetime of the downloader.
+type smbDownloader struct {
+	client        *libsmbclient.Client
+	oldKrb5Ticket string
+}
 
-	g.mu.RLock()
-	defer g.mu.RUnlock()
+const krb5TicketEnv = "KRB5CCNAME"
 
-	var localVersion, remoteVersion int
-	gptIniPath := filepath.Join(localPath, "GPT.INI")
-	if f, err := os.Open(filepath.Clean(gptIniPath)); err == nil {
-		defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+// newDownloader returns a samba client authenticated with krb5Ticket.
+func (s sysvolSource) newDownloader(ctx context.Context, krb5Ticket string) (gpoDownloader, error) {
+	// Set kerberos ticket.
+	oldKrb5Ticket := os.Getenv(krb5TicketEnv)
+	if err := os.Setenv(krb5TicketEnv, krb5Ticket); err != nil {
+		return nil, err
+	}
 
-		if localVersion, err = getGPOVersion(f); err != nil {
-			log.Warningf(ctx, "Invalid local GPT.INI for %s: %v\nDownloading GPO…", g.name, err)
-		}
+	client := libsmbclient.New()
+	// When testing we cannot use kerberos without a real kerberos server
+	// So we don't use kerberos in this case
+	if !s.ad.withoutKerberos {
+		client.SetUseKerberos()
+	}
+
+	return &smbDownloader{client: client, oldKrb5Ticket: oldKrb5Ticket}, nil
+}
+
+func (d *smbDownloader) needsDownload(ctx context.Context, g *gpo, localPath string, objectClass ObjectClass) (bool, error) {
+	return gpoNeedsDownload(ctx, d.client, g, localPath, objectClass)
+}
+
+func (d *smbDownloader) download(url, dest string) error {
+	return downloadRecursive(d.client, url, dest)
+}
+
+func (d *smbDownloader) close(ctx context.Context) {
+	d.client.Close()
+	if err := os.Setenv(krb5TicketEnv, d.oldKrb5Ticket); err != nil {
+		log.Errorf(ctx, "Couln't restore initial value for %s: %v", krb5TicketEnv, err)
 	}
+}
+
+// gpoNeedsDownload compares the version of the part of the GPO applying to objectClass in the cache and on AD.
+// The version of the GPO content in the cache is recorded in g.
+func gpoNeedsDownload(ctx context.Context, client *libsmbclient.Client, g *gpo, localPath string, objectClass ObjectClass) (updateNeeded bool, err error) {
+	defer decorate.OnError(&err, i18n.G("can't check if %s needs refreshing"), g.name)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	localVersion := readGPOVersion(ctx, g.name, localPath)
 
 	f, err := client.Open(fmt.Sprintf("%s/GPT.INI", g.url), 0, 0)
 	if err != nil {
@@ -179,33 +211,73 @@ func gpoNeedsDownload(ctx context.Context, client *libsmbclient.Client, g *gpo,
 	defer f.Close()
 	// Read() is on *libsmbclient.File, not libsmbclient.File
 	pf := &f
-	if remoteVersion, err = getGPOVersion(pf); err != nil {
+	remoteVersion, err := getGPOVersion(pf)
+	if err != nil {
 		return false, err
 	}
 
-	if localVersion >= remoteVersion {
-		return false, nil
+	if localVersion == nil || localVersion.forObjectClass(objectClass) < remoteVersion.forObjectClass(objectClass) {
+		return true, nil
 	}
 
-	return true, nil
+	if *localVersion != remoteVersion {
+		log.Debugf(ctx, "GPO %q changed on AD, but not for %s: keeping cached version", g.name, objectClass)
+	}
+	g.version = localVersion
+	return false, nil
+}
+
+// readGPOVersion returns the version of the GPO cached in localPath, or nil if it can't be read.
+func readGPOVersion(ctx context.Context, name, localPath string) *gpoVersion {
+	f, err := os.Open(filepath.Clean(filepath.Join(localPath, "GPT.INI")))
+	if err != nil {
+		return nil
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	v, err := getGPOVersion(f)
+	if err != nil {
+		log.Warningf(ctx, "Invalid local GPT.INI for %s: %v\nDownloading GPO…", name, err)
+		return nil
+	}
+	return &v
+}
+
+// gpoVersion is the version of a GPO, as stored in its GPT.INI file.
+// Active Directory increments separately the user part of the GPO, in the high 16 bits of the version, and the
+// machine part, in the low 16 bits.
+type gpoVersion struct {
+	user    int
+	machine int
+}
+
+// forObjectClass returns the version of the part of the GPO applying to objectClass.
+func (v gpoVersion) forObjectClass(objectClass ObjectClass) int {
+	if objectClass == ComputerObject {
+		return v.machine
+	}
+	return v.user
 }
 
-func getGPOVersion(r io.Reader) (version int, err error) {
+func getGPOVersion(r io.Reader) (version gpoVersion, err error) {
 	defer decorate.OnError(&err, i18n.G("invalid remote GPT.INI"))
 
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		t := scanner.Text()
 		if strings.HasPrefix(t, "Version=") {
-			version, err := strconv.Atoi(strings.TrimPrefix(t, "Version="))
+			v, err := strconv.ParseUint(strings.TrimPrefix(t, "Version="), 10, 32)
 			if err != nil {
-				return 0, fmt.Errorf("version is not an int: %v", err)
+				return gpoVersion{}, fmt.Errorf("version is not a 32 bits unsigned int: %v", err)
 			}
-			return version, nil
+			return gpoVersion{
+				user:    int(v >> 16),
+				machine: int(v & 0xFFFF),
+			}, nil
 		}
 	}
 
-	return 0, errors.New("version not found")
+	return gpoVersion{}, errors.New("version not found")
 }
 
 func downloadRecursive(client *libsmbclient.Client, url string, dest string) error {
@@ -1,10 +1,14 @@
 package ad
 
+import "sort"
+
 var (
 	WithoutKerberos = withoutKerberos
-	WithGPOListCmd  = withGPOListCmd
+	WithLDAPDialer  = withLDAPDialer
 )
 
+type LDAPConn = ldapConn
+
 func (ad *AD) GpoCacheDir() string {
 	return ad.gpoCacheDir
 }
@@ -14,3 +18,16 @@ func (ad *AD) GpoRulesCacheDir() string {
 func (ad *AD) Krb5CacheDir() string {
 	return ad.krb5CacheDir
 }
+
+// LoadedGPOs returns the sorted IDs of the GPOs loaded in memory.
+func (ad *AD) LoadedGPOs() []string {
+	ad.RLock()
+	defer ad.RUnlock()
+
+	var ids []string
+	for id := range ad.gpos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
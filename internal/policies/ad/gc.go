@@ -0,0 +1,107 @@
+package ad
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+/*
+	Notes:
+	Every GPO ever fetched is kept in the GPO cache, named after its ID. A GPO is referenced while it is part of the
+	rules cache of any object, which lists the GPOs last applied to it.
+	The modification time of a GPO cache directory is refreshed each time the GPO is fetched, even if it is not
+	downloaded again. Unreferenced GPOs are only removed once they haven't been fetched for the grace period: this
+	keeps GPOs which were just fetched for an object whose rules are not saved yet, and avoids downloading again
+	GPOs of users which log in from time to time.
+*/
+
+// GarbageCollect removes from the GPO cache the GPOs which are not referenced by the rules cache of any object and
+// were not fetched during the last gracePeriod. It returns the IDs of the removed GPOs.
+func (ad *AD) GarbageCollect(ctx context.Context, gracePeriod time.Duration) (removed []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't garbage collect GPO cache"))
+
+	log.Debugf(ctx, "Garbage collecting GPOs not used for %v", gracePeriod)
+
+	// Prevent any fetch or parsing of GPOs while we are removing them
+	ad.Lock()
+	defer ad.Unlock()
+
+	referenced, err := ad.referencedGPOs()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := os.ReadDir(ad.gpoCacheDir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, d := range cached {
+		if !d.IsDir() {
+			continue
+		}
+		id := d.Name()
+		if _, ok := referenced[id]; ok {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return removed, err
+		}
+		if now.Sub(info.ModTime()) < gracePeriod {
+			log.Debugf(ctx, "Keeping unreferenced GPO %q fetched at %v", id, info.ModTime())
+			continue
+		}
+
+		log.Infof(ctx, "Removing unreferenced GPO %q from cache", id)
+		if err := ad.removeCachedGPO(id, ad.gpos[id]); err != nil {
+			return removed, err
+		}
+		removed = append(removed, id)
+	}
+
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// referencedGPOs returns the IDs of the GPOs listed in the rules cache of any object.
+func (ad *AD) referencedGPOs() (map[string]struct{}, error) {
+	objects, err := os.ReadDir(ad.gpoRulesCacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]struct{})
+	for _, o := range objects {
+		if o.IsDir() {
+			continue
+		}
+		gpos, err := entry.NewGPOs(filepath.Join(ad.gpoRulesCacheDir, o.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range gpos {
+			referenced[g.ID] = struct{}{}
+		}
+	}
+	return referenced, nil
+}
+
+// removeCachedGPO removes the GPO id from the cache and forgets about it, once nothing is reading it anymore.
+// g is the GPO loaded in memory, if any.
+func (ad *AD) removeCachedGPO(id string, g *gpo) error {
+	if g != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(ad.gpos, id)
+	}
+	return os.RemoveAll(filepath.Join(ad.gpoCacheDir, id))
+}
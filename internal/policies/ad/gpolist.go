@@ -0,0 +1,486 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+const (
+	// gPLink options
+	gpLinkOptDisable = 1 << 0
+	gpLinkOptEnforce = 1 << 1
+
+	// gPOptions on a container
+	gpoBlockInheritance = 1 << 0
+
+	// flags on a GPO
+	gpoFlagUserDisable    = 1 << 0
+	gpoFlagMachineDisable = 1 << 1
+
+	// sdFlagsControlOID requests only owner, group and DACL of nTSecurityDescriptor, which are readable without privileges.
+	sdFlagsControlOID = "1.2.840.113556.1.4.801"
+	// sdFlagsOwnerGroupDACL is the BER encoding of the OWNER|GROUP|DACL security information flags.
+	sdFlagsOwnerGroupDACL = "\x30\x03\x02\x01\x07"
+
+	krb5ConfPath    = "/etc/krb5.conf"
+	ldapDialTimeout = 10 * time.Second
+)
+
+// ldapConn is a connection to the directory, bound to a user or computer.
+type ldapConn interface {
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+	// LocalIP is the address used to reach the server, which determines the site of the machine.
+	LocalIP() net.IP
+}
+
+// ldapDialer connects to the directory at url and authenticates with the Kerberos ticket cache at krb5CCPath.
+// Network errors when reaching the server are reported as ldap.ErrorNetwork, which switches to offline mode.
+type ldapDialer func(url, krb5CCPath string) (ldapConn, error)
+
+// defaultLDAPDialer is the dialer used when none is set in options.
+var defaultLDAPDialer ldapDialer = dialLDAPWithGSSAPI
+
+// dialLDAPWithGSSAPI connects to the LDAP server at url and binds with GSSAPI using the ticket cache at krb5CCPath.
+func dialLDAPWithGSSAPI(ldapURL, krb5CCPath string) (c ldapConn, err error) {
+	u, err := url.Parse(ldapURL)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("invalid LDAP URL %q: %v"), ldapURL, err)
+	}
+
+	conn, err := ldap.DialURL(ldapURL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapDialTimeout}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	client, err := gssapi.NewClientFromCCache(krb5CCPath, krb5ConfPath)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("can't load Kerberos ticket from %q: %v"), krb5CCPath, err)
+	}
+	defer client.Close()
+
+	if err := conn.GSSAPIBind(client, "ldap/"+u.Hostname(), ""); err != nil {
+		return nil, fmt.Errorf(i18n.G("can't authenticate to %q: %w"), ldapURL, err)
+	}
+
+	return gssapiConn{Conn: conn, localIP: routeSourceIP(u.Hostname())}, nil
+}
+
+// gssapiConn is a bound LDAP connection remembering the local address used to reach the server.
+type gssapiConn struct {
+	*ldap.Conn
+	localIP net.IP
+}
+
+// LocalIP returns the address used to reach the server.
+func (c gssapiConn) LocalIP() net.IP {
+	return c.localIP
+}
+
+// routeSourceIP returns the local address the kernel picks to reach host, or nil if there is none.
+// Connecting an UDP socket doesn’t send any packet.
+func routeSourceIP(host string) net.IP {
+	c, err := net.DialTimeout("udp", net.JoinHostPort(host, "389"), ldapDialTimeout)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	addr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	return addr.IP
+}
+
+// gpLink is a GPO linked to a container.
+type gpLink struct {
+	dn      string
+	options int
+}
+
+// listGPOs returns the GPOs applying to objectName, ordered by decreasing priority.
+// It walks up the containers of the object to the domain, then to the site of the machine, following GPO links
+// while considering enforced links, blocked inheritance, security filtering and the GPO user and machine flags.
+func listGPOs(ctx context.Context, conn ldapConn, objectName string, objectClass ObjectClass) (gpos []gpo, err error) {
+	// Errors are wrapped to keep ldap.ErrorNetwork detectable, which switches to offline mode.
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf(i18n.G("can't list GPOs for %q: %w"), objectName, err)
+		}
+	}()
+
+	baseDN, configDN, err := namingContexts(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := findAccount(conn, baseDN, objectName, objectClass)
+	if err != nil {
+		return nil, err
+	}
+	token, err := securityToken(conn, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return gposFor(ctx, conn, baseDN, configDN, account.DN, token, objectClass)
+}
+
+// listLoopbackGPOs returns the GPOs linked to the containers of computerName applying to userName as user policies,
+// ordered by decreasing priority.
+// As in Windows loopback processing, the security filtering is done against the user and not the computer.
+func listLoopbackGPOs(ctx context.Context, conn ldapConn, userName, computerName string) (gpos []gpo, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf(i18n.G("can't list loopback GPOs of %q for %q: %w"), computerName, userName, err)
+		}
+	}()
+
+	baseDN, configDN, err := namingContexts(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findAccount(conn, baseDN, userName, UserObject)
+	if err != nil {
+		return nil, err
+	}
+	token, err := securityToken(conn, user)
+	if err != nil {
+		return nil, err
+	}
+	computer, err := findAccount(conn, baseDN, computerName, ComputerObject)
+	if err != nil {
+		return nil, err
+	}
+
+	return gposFor(ctx, conn, baseDN, configDN, computer.DN, token, UserObject)
+}
+
+// gposFor returns the GPOs linked to the containers of dn and to the machine site, applying to token for objectClass.
+func gposFor(ctx context.Context, conn ldapConn, baseDN, configDN, dn string, token map[string]struct{}, objectClass ObjectClass) (gpos []gpo, err error) {
+	var containers []string
+	for container := parentDN(dn); ; container = parentDN(container) {
+		if container == "" {
+			return nil, fmt.Errorf(i18n.G("%q is not in the domain %q"), dn, baseDN)
+		}
+		containers = append(containers, container)
+		if strings.EqualFold(container, baseDN) {
+			break
+		}
+	}
+	// Site GPOs apply after local ones and before the domain ones, so they have the lowest priority.
+	if site := siteOf(ctx, conn, configDN); site != "" {
+		containers = append(containers, site)
+	}
+
+	inherit := true
+	for _, container := range containers {
+		res, err := conn.Search(ldap.NewSearchRequest(container, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{"gPLink", "gPOptions"}, nil))
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("can't read GPO links of %q: %w"), container, err)
+		}
+		if len(res.Entries) != 1 {
+			return nil, fmt.Errorf(i18n.G("can't find container %q"), container)
+		}
+		links, err := parseGPLink(res.Entries[0].GetEqualFoldAttributeValue("gPLink"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range links {
+			if !inherit && l.options&gpLinkOptEnforce == 0 {
+				log.Debugf(ctx, "GPO %q linked to %q is skipped: inheritance is blocked", l.dn, container)
+				continue
+			}
+			if l.options&gpLinkOptDisable != 0 {
+				log.Debugf(ctx, "GPO %q linked to %q is skipped: link is disabled", l.dn, container)
+				continue
+			}
+
+			g, applies, err := gpoFor(ctx, conn, l.dn, token, objectClass)
+			if err != nil {
+				return nil, err
+			}
+			if !applies {
+				continue
+			}
+			log.Debugf(ctx, "GPO %q (%s) linked to %q applies (enforced: %t)", g.name, l.dn, container, l.options&gpLinkOptEnforce != 0)
+
+			// Enforced policy (higher wins)
+			if l.options&gpLinkOptEnforce != 0 {
+				gpos = append([]gpo{g}, gpos...)
+				continue
+			}
+			// Others (higher have less weight)
+			gpos = append(gpos, g)
+		}
+
+		// check if this blocks inheritance
+		if opts, _ := strconv.Atoi(res.Entries[0].GetEqualFoldAttributeValue("gPOptions")); opts&gpoBlockInheritance != 0 {
+			inherit = false
+		}
+	}
+
+	return gpos, nil
+}
+
+// namingContexts returns the base DN of the domain and of the configuration partition, as advertised by the root DSE.
+// The configuration partition is optional and only used to find the site of the machine.
+func namingContexts(conn ldapConn) (baseDN, configDN string, err error) {
+	res, err := conn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"defaultNamingContext", "configurationNamingContext"}, nil))
+	if err != nil {
+		return "", "", fmt.Errorf(i18n.G("can't read root DSE: %w"), err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].GetAttributeValue("defaultNamingContext") == "" {
+		return "", "", errors.New(i18n.G("no default naming context advertised by the server"))
+	}
+	return res.Entries[0].GetAttributeValue("defaultNamingContext"), res.Entries[0].GetAttributeValue("configurationNamingContext"), nil
+}
+
+// siteOf returns the DN of the site of the machine, or an empty string if it can't be determined.
+// As DsGetSiteName does, the site is the one of the most specific subnet object containing the local address
+// used to reach the server. Failing to detect the site only skips site GPOs.
+func siteOf(ctx context.Context, conn ldapConn, configDN string) string {
+	ip := conn.LocalIP()
+	if configDN == "" || ip == nil {
+		log.Debug(ctx, "No site detection: missing configuration partition or local address")
+		return ""
+	}
+
+	subnetsDN := "CN=Subnets,CN=Sites," + configDN
+	res, err := conn.Search(ldap.NewSearchRequest(subnetsDN, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=subnet)", []string{"cn", "siteObject"}, nil))
+	if err != nil {
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			log.Warningf(ctx, "Can't read subnets from %q, ignoring site GPOs: %v", subnetsDN, err)
+		}
+		return ""
+	}
+
+	var site string
+	bestPrefix := -1
+	for _, e := range res.Entries {
+		_, subnet, err := net.ParseCIDR(e.GetEqualFoldAttributeValue("cn"))
+		if err != nil {
+			log.Warningf(ctx, "Ignoring invalid subnet %q: %v", e.DN, err)
+			continue
+		}
+		if !subnet.Contains(ip) {
+			continue
+		}
+		if prefix, _ := subnet.Mask.Size(); prefix > bestPrefix {
+			bestPrefix = prefix
+			site = e.GetEqualFoldAttributeValue("siteObject")
+		}
+	}
+
+	if site == "" {
+		log.Debugf(ctx, "No site found for address %s", ip)
+		return ""
+	}
+	log.Debugf(ctx, "Machine with address %s is in site %q", ip, site)
+	return site
+}
+
+// findAccount returns the account entry of objectName in the domain, checking it is of the requested objectClass.
+func findAccount(conn ldapConn, baseDN, objectName string, objectClass ObjectClass) (*ldap.Entry, error) {
+	// Computer objects are limited to 15 characters.
+	// Users don’t need the domain, as we already have the specific-domain ticket.
+	accountName := objectName
+	if objectClass == ComputerObject {
+		if len(accountName) > 15 {
+			accountName = accountName[:15]
+		}
+	} else {
+		accountName = strings.Split(accountName, "@")[0]
+	}
+
+	filter := fmt.Sprintf("(&(|(sAMAccountName=%s)(sAMAccountName=%s$))(objectClass=%s))",
+		ldap.EscapeFilter(accountName), ldap.EscapeFilter(accountName), ldap.EscapeFilter(string(objectClass)))
+	res, err := conn.Search(ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{"objectClass", "objectSid"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("failed to search for account %q: %w"), accountName, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, fmt.Errorf(i18n.G("failed to find account %q"), accountName)
+	}
+	account := res.Entries[0]
+
+	// Check that the object is really a computer or user if requested as such
+	var isComputer bool
+	for _, c := range account.GetEqualFoldAttributeValues("objectClass") {
+		if strings.EqualFold(c, "computer") {
+			isComputer = true
+		}
+	}
+	if isComputer != (objectClass == ComputerObject) {
+		return nil, fmt.Errorf(i18n.G("failed to find %s account %q"), objectClass, accountName)
+	}
+
+	return account, nil
+}
+
+// securityToken returns the SIDs the account is acting as.
+func securityToken(conn ldapConn, account *ldap.Entry) (token map[string]struct{}, err error) {
+	objectSID, err := decodeSID(account.GetEqualFoldRawAttributeValue("objectSid"))
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("invalid objectSid for %q: %v"), account.DN, err)
+	}
+	token = map[string]struct{}{
+		objectSID:             {},
+		sidEveryone:           {},
+		sidAuthenticatedUsers: {},
+	}
+
+	// tokenGroups is computed by the server and contains all groups, nested ones included.
+	res, err := conn.Search(ldap.NewSearchRequest(account.DN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"tokenGroups"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("failed to get groups of %q: %w"), account.DN, err)
+	}
+	for _, e := range res.Entries {
+		for _, v := range e.GetEqualFoldRawAttributeValues("tokenGroups") {
+			sid, err := decodeSID(v)
+			if err != nil {
+				return nil, fmt.Errorf(i18n.G("invalid group SID for %q: %v"), account.DN, err)
+			}
+			token[sid] = struct{}{}
+		}
+	}
+
+	return token, nil
+}
+
+// gpoFor reads the GPO at dn and returns if it applies to the token for this object class.
+// GPOs that are unreadable are skipped, as AD does for windows clients, unless the server can't be reached anymore.
+func gpoFor(ctx context.Context, conn ldapConn, dn string, token map[string]struct{}, objectClass ObjectClass) (g gpo, applies bool, err error) {
+	res, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"name", "displayName", "flags", "nTSecurityDescriptor", "gPCFileSysPath",
+			"gPCMachineExtensionNames", "gPCUserExtensionNames"},
+		[]ldap.Control{ldap.NewControlString(sdFlagsControlOID, true, sdFlagsOwnerGroupDACL)}))
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return gpo{}, false, fmt.Errorf(i18n.G("can't fetch GPO object %q: %w"), dn, err)
+	}
+	if err != nil || len(res.Entries) != 1 {
+		log.Warningf(ctx, "Failed to fetch GPO object %q: %v", dn, err)
+		return gpo{}, false, nil
+	}
+	e := res.Entries[0]
+	rawSD := e.GetEqualFoldRawAttributeValue("nTSecurityDescriptor")
+	if len(rawSD) == 0 {
+		log.Warningf(ctx, "Failed to fetch GPO object %q: no nTSecurityDescriptor available", dn)
+		return gpo{}, false, nil
+	}
+	sd, err := parseSecurityDescriptor(rawSD)
+	if err != nil {
+		log.Warningf(ctx, "Failed to read security descriptor of GPO %q: %v", dn, err)
+		return gpo{}, false, nil
+	}
+
+	if !sd.accessCheck(token, readControl|adsList|adsReadProp) {
+		return gpo{}, false, fmt.Errorf(i18n.G("failed access check on %q"), dn)
+	}
+
+	if !sd.canApplyGPO(token) {
+		log.Debugf(ctx, "GPO %q is filtered out by its security descriptor", dn)
+		return gpo{}, false, nil
+	}
+
+	// check the flags on the GPO
+	flags, _ := strconv.Atoi(e.GetEqualFoldAttributeValue("flags"))
+	if objectClass == ComputerObject && flags&gpoFlagMachineDisable != 0 {
+		log.Debugf(ctx, "GPO %q is skipped: its computer configuration is disabled", dn)
+		return gpo{}, false, nil
+	}
+	if objectClass == UserObject && flags&gpoFlagUserDisable != 0 {
+		log.Debugf(ctx, "GPO %q is skipped: its user configuration is disabled", dn)
+		return gpo{}, false, nil
+	}
+
+	extensionsAttr := "gPCUserExtensionNames"
+	if objectClass == ComputerObject {
+		extensionsAttr = "gPCMachineExtensionNames"
+	}
+	extensions, err := parseExtensionNames(e.GetEqualFoldAttributeValue(extensionsAttr))
+	if err != nil {
+		log.Warningf(ctx, "Invalid %s on GPO %q, processing it for all extensions: %v", extensionsAttr, dn, err)
+	}
+
+	return gpo{
+		name:       e.GetEqualFoldAttributeValue("displayName"),
+		url:        "smb:" + strings.ReplaceAll(e.GetEqualFoldAttributeValue("gPCFileSysPath"), `\`, "/"),
+		extensions: extensions,
+	}, true, nil
+}
+
+// parseExtensionNames returns the client-side extensions GUIDs of a gPCMachineExtensionNames or gPCUserExtensionNames
+// attribute of the form [{extension}{tool}…][{extension}{tool}…]…
+// An empty attribute means that the GPO has no setting for this object class.
+func parseExtensionNames(extensionNames string) (extensions []string, err error) {
+	extensions = []string{}
+	for _, e := range strings.Split(extensionNames, "]") {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		end := strings.Index(e, "}")
+		if !strings.HasPrefix(e, "[{") || end < 0 {
+			return nil, fmt.Errorf(i18n.G("badly formed extension names %q"), e+"]")
+		}
+		extensions = append(extensions, strings.ToUpper(e[1:end+1]))
+	}
+	return extensions, nil
+}
+
+// parseGPLink parses a gPLink attribute of the form [LDAP://dn;options][LDAP://dn;options]…
+func parseGPLink(gPLink string) (links []gpLink, err error) {
+	const prefix = "[LDAP://"
+
+	for _, l := range strings.Split(gPLink, "]") {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		d := strings.Split(l, ";")
+		if len(d) != 2 || len(d[0]) < len(prefix) || !strings.EqualFold(d[0][:len(prefix)], prefix) {
+			return nil, fmt.Errorf(i18n.G("badly formed gPLink %q"), l)
+		}
+		opts, err := strconv.Atoi(d[1])
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("badly formed gPLink options %q: %v"), l, err)
+		}
+		links = append(links, gpLink{dn: d[0][len(prefix):], options: opts})
+	}
+	return links, nil
+}
+
+// parentDN returns the parent DN of dn, or an empty string for a root DN.
+func parentDN(dn string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return strings.TrimSpace(dn[i+1:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,329 @@
+package ad
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/testutils/admock"
+)
+
+func TestListGPOs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		objectName       string
+		objectClass      ObjectClass
+		loopbackComputer string
+		localIP          string
+		directory        ldapConn
+
+		wantErr bool
+	}{
+		"Return one gpo":             {objectName: "UserAtRoot@EXAMPLE.COM"},
+		"Return hierarchy":           {objectName: "RnDUser@EXAMPLE.COM"},
+		"Multiple GPOs in same OU":   {objectName: "RnDUserDep1@EXAMPLE.COM"},
+		"Machine GPOs":               {objectName: "hostname1", objectClass: ComputerObject},
+		"Machine security filtering": {objectName: "hostname3", objectClass: ComputerObject},
+		"Disabled GPOs":              {objectName: "RnDUserDep3@EXAMPLE.COM"},
+		"No GPO on OU":               {objectName: "UserNoGPO@EXAMPLE.COM"},
+
+		// Filtering cases
+		"Filter user only GPOs":    {objectName: "hostname2", objectClass: ComputerObject},
+		"Filter machine only GPOs": {objectName: "RnDUserDep7@EXAMPLE.COM"},
+
+		// Forced GPOs and inheritance handling
+		"Forced GPO are first by reverse order": {objectName: "RndUserSubDep2ForcedPolicy@EXAMPLE.COM"},
+		"Block inheritance":                     {objectName: "RnDUserWithBlockedInheritance@EXAMPLE.COM"},
+		"Forced GPO and blocked inheritance":    {objectName: "RnDUserWithBlockedInheritanceAndForcedPolicies@EXAMPLE.COM"},
+
+		// Site cases
+		"Site GPOs have the lowest priority":                {objectName: "RnDUser@EXAMPLE.COM", localIP: "10.1.5.5"},
+		"Machine site GPOs":                                 {objectName: "hostname1", objectClass: ComputerObject, localIP: "10.1.5.5"},
+		"Most specific subnet defines the site":             {objectName: "RnDUser@EXAMPLE.COM", localIP: "10.1.2.3"},
+		"IPv6 address in site subnet":                       {objectName: "RnDUser@EXAMPLE.COM", localIP: "2001:db8::1"},
+		"Forced site GPOs are before forced OU GPOs":        {objectName: "RndUserSubDep2ForcedPolicy@EXAMPLE.COM", localIP: "10.1.2.3"},
+		"Block inheritance only keeps forced site GPOs":     {objectName: "RnDUserWithBlockedInheritance@EXAMPLE.COM", localIP: "10.1.2.3"},
+		"Site without GPO":                                  {objectName: "RnDUser@EXAMPLE.COM", localIP: "10.2.0.1"},
+		"Address outside of any subnet has no site":         {objectName: "RnDUser@EXAMPLE.COM", localIP: "192.168.1.1"},
+		"Subnet search failure ignores site GPOs":           {objectName: "RnDUser@EXAMPLE.COM", directory: failingDirectory{failOn: "siteObject", localIP: net.ParseIP("10.1.5.5")}},
+		"No configuration naming context ignores site GPOs": {objectName: "RnDUser@EXAMPLE.COM", directory: noConfigurationDirectory(net.ParseIP("10.1.5.5"))},
+
+		// Loopback cases
+		"Loopback lists user GPOs of the computer containers":  {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname1"},
+		"Loopback keeps user only GPOs of the computer":        {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname2"},
+		"Loopback includes site GPOs":                          {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname1", localIP: "10.1.2.3"},
+		"Loopback security filtering is done against the user": {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname3"},
+		"Loopback computer name is truncated at 15 characters": {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostnameWithLongName"},
+		"Error on loopback with non existent computer":         {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "nonexistent", wantErr: true},
+		"Error on loopback with non existent user":             {objectName: "nonexistent@EXAMPLE.COM", loopbackComputer: "hostname1", wantErr: true},
+		"Error on loopback with user requested as computer":    {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "UserAtRoot", wantErr: true},
+		"Error on loopback with computer requested as user":    {objectName: "hostname1", loopbackComputer: "hostname2", wantErr: true},
+		"Error on loopback with no default naming context":     {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname1", directory: failingDirectory{failOn: "defaultNamingContext"}, wantErr: true},
+		"Error on loopback with user groups search error":      {objectName: "RnDUser@EXAMPLE.COM", loopbackComputer: "hostname1", directory: failingDirectory{failOn: "tokenGroups"}, wantErr: true},
+
+		// Access cases
+		"Security descriptor missing ignores GPO":          {objectName: "RnDUserDep4@EXAMPLE.COM"}, // AD is doing that for windows client
+		"Security descriptor access denied ignores GPO":    {objectName: "RnDUserDep6@EXAMPLE.COM"},
+		"Security descriptor accepted is for another user": {objectName: "RnDUserDep8@EXAMPLE.COM"},
+
+		"No gPOptions fallbacks to 0": {objectName: "UserNogPOptions@EXAMPLE.COM"},
+
+		// Client-side extensions
+		"GPOs with their client-side extensions": {objectName: "RnDUserDep9@EXAMPLE.COM"},
+
+		// Special object name cases
+		"No @ in user name returns the same thing": {objectName: "UserAtRoot"},
+		"Computers are truncated at 15 characters": {objectName: "hostnameWithLongName", objectClass: ComputerObject},
+
+		// Error cases
+		"Fail on security descriptor access failure": {objectName: "RnDUserDep5@EXAMPLE.COM", wantErr: true},
+		"Fail on non existent account":               {objectName: "nonexistent@EXAMPLE.COM", wantErr: true},
+		"Fail on user requested but found machine":   {objectName: "hostname1", objectClass: UserObject, wantErr: true},
+		"Fail on computer requested but found user":  {objectName: "UserAtRoot@EXAMPLE.COM", objectClass: ComputerObject, wantErr: true},
+		"Fail invalid GPO link":                      {objectName: "UserInvalidLink@EXAMPLE.COM", wantErr: true},
+		"Fail on no default naming context":          {objectName: "UserAtRoot@EXAMPLE.COM", directory: failingDirectory{failOn: "defaultNamingContext"}, wantErr: true},
+		"Fail on account search error":               {objectName: "UserAtRoot@EXAMPLE.COM", directory: failingDirectory{failOn: "objectSid"}, wantErr: true},
+		"Fail on group search error":                 {objectName: "UserAtRoot@EXAMPLE.COM", directory: failingDirectory{failOn: "tokenGroups"}, wantErr: true},
+		"Fail on GPO links search error":             {objectName: "UserAtRoot@EXAMPLE.COM", directory: failingDirectory{failOn: "gPLink"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.objectClass == "" {
+				tc.objectClass = UserObject
+			}
+			if tc.directory == nil {
+				d := admock.Example()
+				d.SetLocalIP(net.ParseIP(tc.localIP))
+				tc.directory = d
+			}
+
+			var gpos []gpo
+			var err error
+			if tc.loopbackComputer != "" {
+				gpos, err = listLoopbackGPOs(context.Background(), tc.directory, tc.objectName, tc.loopbackComputer)
+			} else {
+				gpos, err = listGPOs(context.Background(), tc.directory, tc.objectName, tc.objectClass)
+			}
+			if tc.wantErr {
+				require.Error(t, err, "listing GPOs should have failed but didn’t")
+				return
+			}
+			require.NoError(t, err, "listing GPOs should return no error")
+
+			var got strings.Builder
+			for _, g := range gpos {
+				extensions := "unknown extensions"
+				if g.extensions != nil {
+					extensions = strings.Join(g.extensions, "")
+				}
+				fmt.Fprintf(&got, "%s\t%s\t%s\n", g.name, g.url, extensions)
+			}
+
+			goldPath := filepath.Join("testdata", "gpolist", "golden", name)
+			// Update golden file
+			if Update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got.String()), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load gpo list golden file")
+
+			require.Equal(t, string(want), got.String(), "listGPOs returns expected GPOs in order")
+		})
+	}
+}
+
+func TestAccessCheck(t *testing.T) {
+	t.Parallel()
+
+	owner := admock.ExampleDomainSID + "-512"
+	user := admock.ExampleAccountSID
+	token := map[string]struct{}{user: {}, sidAuthenticatedUsers: {}, sidEveryone: {}}
+
+	tests := map[string]struct {
+		securityDescriptor []byte
+
+		wantAccess bool
+		wantApply  bool
+		wantErr    bool
+	}{
+		"Default GPO grants read and apply": {securityDescriptor: []byte(admock.DefaultGPOSecurityDescriptor), wantAccess: true, wantApply: true},
+		"Generic read grants read access": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: genericRead, SID: sidEveryone}), wantAccess: true},
+		"Owner gets read control": {securityDescriptor: sd(user,
+			admock.ACE{Type: admock.AccessAllowed, Mask: adsList | adsReadProp, SID: user}), wantAccess: true},
+		"Read rights can be granted by multiple ACEs": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: adsList, SID: sidAuthenticatedUsers},
+			admock.ACE{Type: admock.AccessAllowed, Mask: readControl | adsReadProp, SID: user}), wantAccess: true},
+		"Denied rights after being granted are ignored": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: admock.GenericRead, SID: user},
+			admock.ACE{Type: admock.AccessDenied, Mask: admock.GenericRead, SID: user}), wantAccess: true},
+		"Apply right denied to one group wins": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: admock.GenericRead, SID: sidAuthenticatedUsers},
+			admock.ACE{Type: admock.AccessAllowedObject, Mask: admock.ControlAccess, ObjectType: admock.ApplyGroupPolicyGUID, SID: user},
+			admock.ACE{Type: admock.AccessDeniedObject, Mask: admock.ControlAccess, ObjectType: admock.ApplyGroupPolicyGUID, SID: sidAuthenticatedUsers}), wantAccess: true},
+		"Empty DACL denies everything": {securityDescriptor: sd(owner)},
+
+		"Missing rights denies access": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: adsList, SID: user})},
+		"Inherit only ACEs are ignored": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Flags: admock.InheritOnly, Mask: admock.GenericRead, SID: user})},
+		"Object ACEs don't grant access to the whole object": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowedObject, Mask: admock.GenericRead, ObjectType: admock.ApplyGroupPolicyGUID, SID: user}), wantApply: true},
+		"ACEs for other SIDs are ignored": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessAllowed, Mask: admock.GenericRead, SID: admock.ExampleDomainSID + "-1104"},
+			admock.ACE{Type: admock.AccessAllowedObject, Mask: admock.ControlAccess, ObjectType: admock.ApplyGroupPolicyGUID, SID: admock.ExampleDomainSID + "-1104"})},
+		"Denied read for the user": {securityDescriptor: sd(owner,
+			admock.ACE{Type: admock.AccessDenied, Mask: adsReadProp, SID: user},
+			admock.ACE{Type: admock.AccessAllowed, Mask: admock.GenericRead, SID: sidAuthenticatedUsers})},
+
+		// Error cases
+		"Error on truncated security descriptor": {securityDescriptor: []byte(admock.DefaultGPOSecurityDescriptor)[:30], wantErr: true},
+		"Error on too short security descriptor": {securityDescriptor: []byte{1, 0, 4, 0x80}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			sd, err := parseSecurityDescriptor(tc.securityDescriptor)
+			if tc.wantErr {
+				require.Error(t, err, "parseSecurityDescriptor should have failed but didn’t")
+				return
+			}
+			require.NoError(t, err, "parseSecurityDescriptor should return no error")
+
+			require.Equal(t, tc.wantAccess, sd.accessCheck(token, readControl|adsList|adsReadProp), "accessCheck returns expected access")
+			require.Equal(t, tc.wantApply, sd.canApplyGPO(token), "canApplyGPO returns expected right")
+		})
+	}
+}
+
+func TestParseGPLink(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		gPLink string
+
+		want    []gpLink
+		wantErr bool
+	}{
+		"Empty link":            {gPLink: ""},
+		"One link":              {gPLink: "[LDAP://cn={A},cn=policies,cn=system,DC=example,DC=com;0]", want: []gpLink{{dn: "cn={A},cn=policies,cn=system,DC=example,DC=com"}}},
+		"Lowercase ldap scheme": {gPLink: "[ldap://cn={A},DC=example,DC=com;2]", want: []gpLink{{dn: "cn={A},DC=example,DC=com", options: 2}}},
+		"Multiple links with options": {gPLink: "[LDAP://cn={A},DC=example,DC=com;1][LDAP://cn={B},DC=example,DC=com;2]",
+			want: []gpLink{{dn: "cn={A},DC=example,DC=com", options: 1}, {dn: "cn={B},DC=example,DC=com", options: 2}}},
+
+		// Error cases
+		"Error on missing scheme":   {gPLink: "[cn={A},DC=example,DC=com;0]", wantErr: true},
+		"Error on missing options":  {gPLink: "[LDAP://cn={A},DC=example,DC=com]", wantErr: true},
+		"Error on invalid options":  {gPLink: "[LDAP://cn={A},DC=example,DC=com;a]", wantErr: true},
+		"Error on too many options": {gPLink: "[LDAP://cn={A},DC=example,DC=com;0;1]", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseGPLink(tc.gPLink)
+			if tc.wantErr {
+				require.Error(t, err, "parseGPLink should have failed but didn’t")
+				return
+			}
+			require.NoError(t, err, "parseGPLink should return no error")
+			require.Equal(t, tc.want, got, "parseGPLink returns expected links")
+		})
+	}
+}
+
+func TestParseExtensionNames(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		extensionNames string
+
+		want    []string
+		wantErr bool
+	}{
+		"No extension": {extensionNames: "", want: []string{}},
+		"One extension": {extensionNames: "[{35378EAC-683F-11D2-A89A-0080C7A4D040}{0F6B957D-509E-11D1-A7CC-0000F87571E3}]",
+			want: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}"}},
+		"Extension without tool": {extensionNames: "[{35378EAC-683F-11D2-A89A-0080C7A4D040}]",
+			want: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}"}},
+		"Multiple extensions and tools": {extensionNames: "[{35378EAC-683F-11D2-A89A-0080C7A4D040}{0F6B957D-509E-11D1-A7CC-0000F87571E3}{D02B1F72-3407-48AE-BA88-E8213C6761F1}][{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B6664F-4972-11D1-A7CA-0000F87571E3}]",
+			want: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}", "{42B5FAAE-6536-11D2-AE5A-0000F87571E3}"}},
+		"Extensions are uppercased": {extensionNames: "[{35378eac-683f-11d2-a89a-0080c7a4d040}{0f6b957d-509e-11d1-a7cc-0000f87571e3}]",
+			want: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}"}},
+
+		"Error on missing bracket":      {extensionNames: "{35378EAC-683F-11D2-A89A-0080C7A4D040}", wantErr: true},
+		"Error on missing GUID":         {extensionNames: "[]", wantErr: true},
+		"Error on unterminated GUID":    {extensionNames: "[{35378EAC-683F-11D2-A89A-0080C7A4D040]", wantErr: true},
+		"Error on text between entries": {extensionNames: "[{35378EAC-683F-11D2-A89A-0080C7A4D040}] [{42B5FAAE-6536-11D2-AE5A-0000F87571E3}]", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseExtensionNames(tc.extensionNames)
+			if tc.wantErr {
+				require.Error(t, err, "parseExtensionNames should have failed but didn’t")
+				return
+			}
+			require.NoError(t, err, "parseExtensionNames should return no error")
+			require.Equal(t, tc.want, got, "parseExtensionNames returns expected extensions")
+		})
+	}
+}
+
+func sd(owner string, aces ...admock.ACE) []byte {
+	return []byte(admock.EncodeSecurityDescriptor(owner, owner, aces...))
+}
+
+// failingDirectory is the example directory failing on searches requesting the failOn attribute.
+type failingDirectory struct {
+	failOn  string
+	localIP net.IP
+}
+
+func (d failingDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	for _, a := range req.Attributes {
+		if a == d.failOn {
+			return nil, fmt.Errorf("search on %q failed as requested", a)
+		}
+	}
+	return admock.Example().Search(req)
+}
+
+func (d failingDirectory) Close() error {
+	return nil
+}
+
+func (d failingDirectory) LocalIP() net.IP {
+	return d.localIP
+}
+
+// noConfigurationDirectory returns a directory with only the RnD OU and no configuration partition.
+func noConfigurationDirectory(localIP net.IP) ldapConn {
+	d := admock.NewDirectory(admock.ExampleBaseDN)
+	d.SetLocalIP(localIP)
+	d.Add("OU=RnD,"+admock.ExampleBaseDN, map[string][]string{"objectClass": {"top", "organizationalUnit"}})
+	d.Add("CN=RnDUser,OU=RnD,"+admock.ExampleBaseDN, map[string][]string{
+		"objectClass":    {"top", "person", "organizationalPerson", "user"},
+		"sAMAccountName": {"RnDUser"},
+		"objectSid":      {admock.EncodeSID(admock.ExampleAccountSID)},
+	})
+	return d
+}
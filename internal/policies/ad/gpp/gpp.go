@@ -0,0 +1,453 @@
+// Package gpp parses the Group Policy Preferences (GPP) items of a GPO.
+//
+// Preferences are stored, per class, in Preferences/<Type>/<Type>.xml files. Each file contains a list of items,
+// optionally grouped in collections, sharing a common model: an action on the item (create, replace, update or delete),
+// some common flags and an optional set of item-level targeting filters.
+package gpp
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// Action is what a preference item does on the target system.
+type Action string
+
+const (
+	// ActionCreate creates the item if it doesn't exist.
+	ActionCreate Action = "C"
+	// ActionReplace deletes and recreates the item.
+	ActionReplace Action = "R"
+	// ActionUpdate modifies the item, creating it if needed. This is the default.
+	ActionUpdate Action = "U"
+	// ActionDelete removes the item.
+	ActionDelete Action = "D"
+)
+
+// Item is a preference item, with the properties of its type.
+type Item struct {
+	// Type is the element name of the item, like EnvironmentVariable, Group or TaskV2.
+	Type  string
+	CLSID string
+	Name  string
+	UID   string
+
+	Action Action
+	// Disabled items are ignored when applying the preferences.
+	Disabled bool
+	// RemovePolicy asks to remove the item when the GPO doesn't apply anymore.
+	RemovePolicy bool
+	BypassErrors bool
+	UserContext  bool
+
+	// Properties are the attributes of the Properties element of the item.
+	Properties map[string]string
+	// Members are the members added or removed from a local group.
+	Members []Member
+	// Commands are the command lines that a scheduled task runs.
+	Commands []string
+
+	// Filters are the item-level targeting filters of the item, including the ones of its parent collections.
+	Filters []Filter
+}
+
+// Member is a user or group added to or removed from a local group.
+type Member struct {
+	Name   string
+	Action string
+	SID    string
+}
+
+// Filter is an item-level targeting filter.
+type Filter struct {
+	// Type is the element name of the filter without its Filter prefix, like Computer, Group or Collection.
+	Type string
+	// Or is set when the filter is combined with the previous ones with a logical OR instead of an AND.
+	Or bool
+	// Not negates the filter result.
+	Not bool
+	// Attributes are the type specific attributes of the filter.
+	Attributes map[string]string
+	// Filters are the children of a Collection filter.
+	Filters []Filter
+}
+
+// element is a generic XML element, as GPP types don't share a schema beside their common attributes.
+type element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []element  `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+func (e element) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (e element) flag(name string) bool {
+	return e.attr(name) == "1"
+}
+
+func (e element) child(name string) (element, bool) {
+	for _, c := range e.Children {
+		if c.XMLName.Local == name {
+			return c, true
+		}
+	}
+	return element{}, false
+}
+
+func (e element) attrs() map[string]string {
+	r := make(map[string]string)
+	for _, a := range e.Attrs {
+		r[a.Name.Local] = a.Value
+	}
+	return r
+}
+
+// DecodeItems parses a preferences file and returns its items in file order.
+// Items of disabled collections are disabled.
+func DecodeItems(r io.Reader) (items []Item, err error) {
+	defer decorate.OnError(&err, i18n.G("can't parse preferences"))
+
+	var root element
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New(i18n.G("empty file"))
+		}
+		return nil, err
+	}
+
+	return decodeItems(root, false, nil)
+}
+
+// decodeItems walks a container (the root element or a collection) and returns its items with their parents state.
+func decodeItems(parent element, disabled bool, filters []Filter) (items []Item, err error) {
+	for _, e := range parent.Children {
+		switch e.XMLName.Local {
+		case "Filters":
+			// Already handled by the parent collection
+			continue
+		case "Collection":
+			f, err := collectionFilters(e)
+			if err != nil {
+				return nil, err
+			}
+			children, err := decodeItems(e, disabled || e.flag("disabled"), append(filters[:len(filters):len(filters)], f...))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, children...)
+			continue
+		}
+
+		item, err := decodeItem(e)
+		if err != nil {
+			return nil, err
+		}
+		item.Disabled = item.Disabled || disabled
+		item.Filters = append(filters[:len(filters):len(filters)], item.Filters...)
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// collectionFilters returns the filters of a collection, grouped so that they are evaluated together.
+func collectionFilters(e element) ([]Filter, error) {
+	f, ok := e.child("Filters")
+	if !ok {
+		return nil, nil
+	}
+	children, err := decodeFilters(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, nil
+	}
+	return []Filter{{Type: "Collection", Filters: children}}, nil
+}
+
+func decodeItem(e element) (item Item, err error) {
+	props, ok := e.child("Properties")
+	if !ok {
+		return Item{}, fmt.Errorf(i18n.G("%s item %q has no properties"), e.XMLName.Local, e.attr("name"))
+	}
+
+	action := Action(strings.ToUpper(props.attr("action")))
+	switch action {
+	case "":
+		action = ActionUpdate
+	case ActionCreate, ActionReplace, ActionUpdate, ActionDelete:
+	default:
+		return Item{}, fmt.Errorf(i18n.G("%s item %q has an invalid action %q"), e.XMLName.Local, e.attr("name"), action)
+	}
+
+	item = Item{
+		Type:         e.XMLName.Local,
+		CLSID:        e.attr("clsid"),
+		Name:         e.attr("name"),
+		UID:          e.attr("uid"),
+		Action:       action,
+		Disabled:     e.flag("disabled"),
+		RemovePolicy: e.flag("removePolicy"),
+		BypassErrors: e.flag("bypassErrors"),
+		UserContext:  e.flag("userContext"),
+		Properties:   props.attrs(),
+	}
+
+	if members, ok := props.child("Members"); ok {
+		for _, m := range members.Children {
+			item.Members = append(item.Members, Member{
+				Name:   m.attr("name"),
+				Action: strings.ToUpper(m.attr("action")),
+				SID:    m.attr("sid"),
+			})
+		}
+	}
+	item.Commands = taskCommands(item.Type, props)
+
+	if f, ok := e.child("Filters"); ok {
+		if item.Filters, err = decodeFilters(f); err != nil {
+			return Item{}, err
+		}
+	}
+
+	return item, nil
+}
+
+// taskCommands returns the command lines of a scheduled task, with their arguments.
+// Legacy tasks hold them in their properties while newer ones embed a Task Scheduler definition.
+func taskCommands(itemType string, props element) []string {
+	if itemType == "Task" || itemType == "ImmediateTask" {
+		cmd := strings.TrimSpace(props.attr("appName") + " " + props.attr("args"))
+		if cmd == "" {
+			return nil
+		}
+		return []string{cmd}
+	}
+
+	task, ok := props.child("Task")
+	if !ok {
+		return nil
+	}
+	actions, ok := task.child("Actions")
+	if !ok {
+		return nil
+	}
+	var cmds []string
+	for _, a := range actions.Children {
+		if a.XMLName.Local != "Exec" {
+			continue
+		}
+		var cmd, args string
+		if c, ok := a.child("Command"); ok {
+			cmd = strings.TrimSpace(c.Text)
+		}
+		if c, ok := a.child("Arguments"); ok {
+			args = strings.TrimSpace(c.Text)
+		}
+		if cmd == "" {
+			continue
+		}
+		cmds = append(cmds, strings.TrimSpace(cmd+" "+args))
+	}
+	return cmds
+}
+
+func decodeFilters(parent element) (filters []Filter, err error) {
+	for _, e := range parent.Children {
+		name := e.XMLName.Local
+		if !strings.HasPrefix(name, "Filter") || name == "Filter" {
+			return nil, fmt.Errorf(i18n.G("unexpected element %q in filters"), name)
+		}
+
+		var or bool
+		switch b := strings.ToUpper(e.attr("bool")); b {
+		case "", "AND":
+		case "OR":
+			or = true
+		default:
+			return nil, fmt.Errorf(i18n.G("invalid boolean operator %q for %s"), b, name)
+		}
+
+		attrs := e.attrs()
+		delete(attrs, "bool")
+		delete(attrs, "not")
+		f := Filter{
+			Type:       strings.TrimPrefix(name, "Filter"),
+			Or:         or,
+			Not:        e.flag("not"),
+			Attributes: attrs,
+		}
+		if f.Type == "Collection" {
+			if f.Filters, err = decodeFilters(e); err != nil {
+				return nil, err
+			}
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// Preference is a preference type, whose items are converted to entries of a rule domain.
+type Preference struct {
+	// Name is the name of the preference directory and file, like EnvironmentVariables.
+	Name string
+	// Domain is the rule domain of the entries.
+	Domain string
+
+	// key identifies the target of an item. Items with the same key override each other.
+	key func(Item) string
+	// value is the main setting of an item.
+	value func(Item) string
+}
+
+// Preferences are the preference types we convert to rules.
+var Preferences = []Preference{
+	{
+		Name:   "Drives",
+		Domain: "drives",
+		key: func(i Item) string {
+			if l := i.Properties["letter"]; l != "" && i.Properties["useLetter"] != "0" {
+				return l + ":"
+			}
+			return i.Properties["path"]
+		},
+		value: property("path"),
+	},
+	{
+		Name:   "EnvironmentVariables",
+		Domain: "environment",
+		key:    property("name"),
+		value:  property("value"),
+	},
+	{
+		Name:   "Files",
+		Domain: "files",
+		key:    property("targetPath"),
+		value:  property("fromPath"),
+	},
+	{
+		Name:   "Groups",
+		Domain: "groups",
+		key: func(i Item) string {
+			if i.Type == "User" {
+				return i.Properties["userName"]
+			}
+			return i.Properties["groupName"]
+		},
+		value: func(i Item) string {
+			var members []string
+			for _, m := range i.Members {
+				members = append(members, fmt.Sprintf("%s %s", m.Action, m.Name))
+			}
+			return strings.Join(members, "\n")
+		},
+	},
+	{
+		Name:   "ScheduledTasks",
+		Domain: "scheduledtasks",
+		key:    property("name"),
+		value: func(i Item) string {
+			return strings.Join(i.Commands, "\n")
+		},
+	},
+}
+
+func property(name string) func(Item) string {
+	return func(i Item) string {
+		return i.Properties[name]
+	}
+}
+
+// Path is the path of the preference file, relative to a GPO class directory.
+func (p Preference) Path() string {
+	return filepath.Join("Preferences", p.Name, p.Name+".xml")
+}
+
+// Decode parses a preferences file of this type and returns the entries of the items targeting facts.
+func (p Preference) Decode(ctx context.Context, r io.Reader, facts Facts) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, i18n.G("can't decode %s"), p.Name)
+
+	items, err := DecodeItems(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.Entries(ctx, items, facts)
+}
+
+// Entries converts enabled items whose item-level targeting matches facts to entries, in file order.
+// The reason why an item applies or not is logged.
+// A deleted item is a disabled entry. When multiple items have the same key, the last one wins, like on Windows.
+// The properties of the item, its action and removePolicy flag are stored as JSON in the entry Meta.
+func (p Preference) Entries(ctx context.Context, items []Item, facts Facts) (entries []entry.Entry, err error) {
+	keys := make([]string, len(items))
+	last := make(map[string]int)
+	for i, item := range items {
+		if item.Disabled {
+			log.Debugf(ctx, "%s preference item %q is disabled", p.Name, item.Name)
+			continue
+		}
+		applies, reason := facts.Applies(item.Filters)
+		if !applies {
+			log.Infof(ctx, "Skipping %s preference item %q: %s", p.Name, item.Name, reason)
+			continue
+		}
+		log.Debugf(ctx, "%s preference item %q applies: %s", p.Name, item.Name, reason)
+		keys[i] = p.key(item)
+		if keys[i] == "" {
+			return nil, fmt.Errorf(i18n.G("%s item %q doesn't define its target"), item.Type, item.Name)
+		}
+		last[keys[i]] = i
+	}
+
+	for i, item := range items {
+		// Disabled and not targeted items have no key
+		if keys[i] == "" || last[keys[i]] != i {
+			continue
+		}
+
+		meta := make(map[string]string)
+		for k, v := range item.Properties {
+			meta[k] = v
+		}
+		meta["action"] = string(item.Action)
+		if item.RemovePolicy {
+			meta["removePolicy"] = "1"
+		}
+		m, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+
+		e := entry.Entry{
+			Key:      keys[i],
+			Disabled: item.Action == ActionDelete,
+			Meta:     string(m),
+		}
+		if !e.Disabled {
+			e.Value = p.value(item)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,234 @@
+package gpp_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/ad/gpp"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"gopkg.in/yaml.v3"
+)
+
+var update bool
+
+func TestDecodeItems(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		wantErr bool
+	}{
+		"environment variables":      {},
+		"default action is update":   {},
+		"disabled items":             {},
+		"collections":                {},
+		"nested filter collections":  {},
+		"groups":                     {},
+		"scheduled tasks":            {},
+		"files":                      {},
+		"drives":                     {},
+		"same target last item wins": {},
+
+		"empty file":                    {wantErr: true},
+		"invalid xml":                   {wantErr: true},
+		"item without properties":       {wantErr: true},
+		"invalid action":                {wantErr: true},
+		"invalid filter operator":       {wantErr: true},
+		"unexpected element in filters": {wantErr: true},
+		"invalid filter in collection":  {wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(preferencesFilePath(name))
+			require.NoError(t, err, "Setup: can't open preferences file")
+			defer f.Close()
+
+			items, err := gpp.DecodeItems(f)
+			if tc.wantErr {
+				require.Error(t, err, "DecodeItems should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "DecodeItems failed but shouldn't have")
+
+			got, err := yaml.Marshal(items)
+			require.NoError(t, err, "Setup: can't marshal items")
+
+			goldPath := filepath.Join("testdata", "golden", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, got, 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load items golden file")
+
+			require.Equal(t, string(want), string(got), "DecodeItems returned expected items")
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		preference string
+		facts      gpp.Facts
+
+		wantDomain string
+		want       []entry.Entry
+		wantErr    bool
+	}{
+		"environment variables": {
+			preference: "EnvironmentVariables",
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "JAVA_HOME", Value: "/usr/lib/jvm/default-java",
+					Meta: `{"action":"C","name":"JAVA_HOME","partial":"0","user":"0","value":"/usr/lib/jvm/default-java"}`},
+				{Key: "http_proxy", Value: "http://proxy.example.com:3128",
+					Meta: `{"action":"U","name":"http_proxy","partial":"0","removePolicy":"1","user":"0","value":"http://proxy.example.com:3128"}`},
+				{Key: "OLD_TOOLS", Disabled: true,
+					Meta: `{"action":"D","name":"OLD_TOOLS","partial":"0","user":"0","value":""}`},
+			}},
+		"default action is update": {
+			preference: "EnvironmentVariables",
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "EDITOR", Value: "vim", Meta: `{"action":"U","name":"EDITOR","value":"vim"}`},
+			}},
+		"disabled items are ignored": {
+			preference: "EnvironmentVariables",
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "ENABLED", Value: "yes", Meta: `{"action":"U","name":"ENABLED","value":"yes"}`},
+			}},
+		"items of disabled collections are ignored": {
+			preference: "EnvironmentVariables",
+			facts:      gpp.Facts{Hostname: "devbox", Groups: []string{"developers@example.com"}},
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "GOPATH", Value: "/opt/go", Meta: `{"action":"U","name":"GOPATH","value":"/opt/go"}`},
+				{Key: "EDITOR", Value: "nano", Meta: `{"action":"U","name":"EDITOR","value":"nano"}`},
+			}},
+		"items not targeting facts are ignored": {
+			preference: "EnvironmentVariables",
+			facts:      gpp.Facts{Hostname: "laptop", Groups: []string{"developers@example.com"}},
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "EDITOR", Value: "nano", Meta: `{"action":"U","name":"EDITOR","value":"nano"}`},
+			}},
+		"same target last item wins": {
+			preference: "EnvironmentVariables",
+			wantDomain: "environment",
+			want: []entry.Entry{
+				{Key: "EDITOR", Value: "vim", Meta: `{"action":"U","name":"EDITOR","value":"vim"}`},
+				{Key: "PAGER", Value: "less", Meta: `{"action":"U","name":"PAGER","value":"less"}`},
+			}},
+		"groups": {
+			preference: "Groups",
+			wantDomain: "groups",
+			want: []entry.Entry{
+				{Key: "Administrators (built-in)", Value: "ADD EXAMPLE\\Domain Admins\nREMOVE EXAMPLE\\Interns",
+					Meta: `{"action":"U","deleteAllGroups":"0","deleteAllUsers":"0","description":"","groupName":"Administrators (built-in)","groupSid":"S-1-5-32-544","newName":"","removeAccounts":"0"}`},
+				{Key: "support",
+					Meta: `{"acctDisabled":"0","action":"C","changeLogon":"0","cpassword":"","description":"","fullName":"Local support","neverExpires":"1","noChange":"1","userName":"support"}`},
+			}},
+		"scheduled tasks": {
+			preference: "ScheduledTasks",
+			wantDomain: "scheduledtasks",
+			want: []entry.Entry{
+				{Key: "Inventory", Value: "/usr/local/bin/inventory --report",
+					Meta: `{"action":"C","appName":"/usr/local/bin/inventory","args":"--report","comment":"","enabled":"1","name":"Inventory","startIn":""}`},
+				{Key: "Cleanup", Value: "/usr/bin/find /tmp -mtime +7 -delete\n/usr/bin/sync",
+					Meta: `{"action":"U","logonType":"S4U","name":"Cleanup","runAs":"NT AUTHORITY\\System"}`},
+			}},
+		"files": {
+			preference: "Files",
+			wantDomain: "files",
+			want: []entry.Entry{
+				{Key: "/etc/motd", Value: `\\example.com\netlogon\motd`,
+					Meta: `{"action":"C","archive":"1","fromPath":"\\\\example.com\\netlogon\\motd","hidden":"0","readOnly":"0","suppress":"0","targetPath":"/etc/motd"}`},
+			}},
+		"drives": {
+			preference: "Drives",
+			wantDomain: "drives",
+			want: []entry.Entry{
+				{Key: "H:", Value: `\\fileserver\home`,
+					Meta: `{"action":"U","allDrives":"NOCHANGE","label":"Home","letter":"H","path":"\\\\fileserver\\home","persistent":"1","thisDrive":"NOCHANGE","useLetter":"1","userName":""}`},
+				{Key: `\\fileserver\shared`, Value: `\\fileserver\shared`,
+					Meta: `{"action":"U","allDrives":"NOCHANGE","label":"Shared","letter":"","path":"\\\\fileserver\\shared","persistent":"0","thisDrive":"NOCHANGE","useLetter":"0","userName":""}`},
+			}},
+
+		"error on item without target": {preference: "EnvironmentVariables", wantDomain: "environment", wantErr: true},
+		"error on invalid file":        {preference: "EnvironmentVariables", wantDomain: "environment", wantErr: true},
+	}
+
+	files := map[string]string{
+		"disabled items are ignored":                "disabled items",
+		"items of disabled collections are ignored": "collections",
+		"items not targeting facts are ignored":     "collections",
+		"error on item without target":              "item without target",
+		"error on invalid file":                     "invalid xml",
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := preference(t, tc.preference)
+			require.Equal(t, tc.wantDomain, p.Domain, "Preference has expected rule domain")
+			require.Equal(t, filepath.Join("Preferences", tc.preference, tc.preference+".xml"), p.Path(), "Preference has expected path")
+
+			file := name
+			if f, ok := files[name]; ok {
+				file = f
+			}
+			f, err := os.Open(preferencesFilePath(file))
+			require.NoError(t, err, "Setup: can't open preferences file")
+			defer f.Close()
+
+			got, err := p.Decode(context.Background(), f, tc.facts)
+			if tc.wantErr {
+				require.Error(t, err, "Decode should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Decode failed but shouldn't have")
+
+			require.Equal(t, tc.want, got, "Decode returned expected entries")
+		})
+	}
+}
+
+func preference(t *testing.T, name string) gpp.Preference {
+	t.Helper()
+
+	for _, p := range gpp.Preferences {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("Setup: no preference type named %q", name)
+	return gpp.Preference{}
+}
+
+func preferencesFilePath(name string) string {
+	return filepath.Join("testdata", strings.ReplaceAll(name, " ", "_")+".xml")
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
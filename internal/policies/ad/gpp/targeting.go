@@ -0,0 +1,225 @@
+package gpp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+)
+
+// netbiosNameMaxLen is the maximum length of a computer NetBIOS name. Longer hostnames are truncated.
+const netbiosNameMaxLen = 15
+
+// Facts are the local properties of the machine, and of the user for user preferences, that item-level targeting
+// filters are evaluated against.
+type Facts struct {
+	// Hostname is the short host name of the machine.
+	Hostname string
+	// Domain is the Active Directory domain of the machine.
+	Domain string
+	// User is the user the preferences are applied to, in the user@domain form. It is empty for the machine.
+	User string
+	// Groups are the names of the groups the user is member of.
+	Groups []string
+	// IPs are the addresses of the machine network interfaces.
+	IPs []net.IP
+	// Env is the environment of the daemon.
+	Env map[string]string
+}
+
+// NewFacts gathers the facts of the machine and of the user, if not empty.
+// Facts which can't be collected are logged and left empty, which makes the corresponding filters not match.
+func NewFacts(ctx context.Context, hostname, domain, userName string) Facts {
+	f := Facts{
+		Hostname: hostname,
+		Domain:   domain,
+		User:     userName,
+		Env:      make(map[string]string),
+	}
+
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		f.Env[kv[0]] = kv[1]
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Warningf(ctx, "Can't list network addresses for item-level targeting: %v", err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			f.IPs = append(f.IPs, ipnet.IP)
+		}
+	}
+
+	if userName == "" {
+		return f
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		log.Debugf(ctx, "Can't find groups of %q for item-level targeting: %v", userName, err)
+		return f
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		log.Debugf(ctx, "Can't find groups of %q for item-level targeting: %v", userName, err)
+		return f
+	}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			log.Debugf(ctx, "Can't find name of group %s for item-level targeting: %v", gid, err)
+			continue
+		}
+		f.Groups = append(f.Groups, g.Name)
+	}
+
+	return f
+}
+
+// Applies evaluates the item-level targeting filters and returns if the item applies, with the reason of the decision.
+// Filters are combined from left to right, without any operator precedence, like on Windows.
+func (f Facts) Applies(filters []Filter) (bool, string) {
+	if len(filters) == 0 {
+		return true, "no item-level targeting"
+	}
+
+	var applies bool
+	var reasons []string
+	for i, filter := range filters {
+		match, reason := f.match(filter)
+		if filter.Not {
+			match = !match
+			reason = fmt.Sprintf("NOT (%s)", reason)
+		}
+
+		switch {
+		case i == 0:
+			applies = match
+		case filter.Or:
+			applies = applies || match
+			reason = "OR " + reason
+		default:
+			applies = applies && match
+			reason = "AND " + reason
+		}
+		reasons = append(reasons, reason)
+	}
+
+	return applies, strings.Join(reasons, " ")
+}
+
+// match evaluates a single filter, without considering its negation.
+func (f Facts) match(filter Filter) (bool, string) {
+	attr := filter.Attributes
+	switch filter.Type {
+	case "Collection":
+		applies, reason := f.Applies(filter.Filters)
+		return applies, fmt.Sprintf("(%s)", reason)
+
+	case "Computer":
+		name := f.Hostname
+		if strings.ToUpper(attr["type"]) == "DNS" {
+			if f.Domain != "" && !strings.Contains(name, ".") {
+				name = name + "." + f.Domain
+			}
+		} else if len(name) > netbiosNameMaxLen {
+			name = name[:netbiosNameMaxLen]
+		}
+		return compare("computer name", name, attr["name"], strings.EqualFold(name, attr["name"]))
+
+	case "Domain":
+		netbiosDomain := strings.Split(f.Domain, ".")[0]
+		match := strings.EqualFold(f.Domain, attr["name"]) || strings.EqualFold(netbiosDomain, attr["name"])
+		return compare("domain", f.Domain, attr["name"], match)
+
+	case "User":
+		if f.User == "" {
+			return false, fmt.Sprintf("user is %q but preferences are for the machine", attr["name"])
+		}
+		return compare("user", f.User, attr["name"], strings.EqualFold(accountName(f.User), accountName(attr["name"])))
+
+	case "Group":
+		if f.User != "" && attr["userContext"] == "0" {
+			return false, fmt.Sprintf("membership of computer to group %q is unknown", attr["name"])
+		}
+		for _, g := range f.Groups {
+			if strings.EqualFold(accountName(g), accountName(attr["name"])) {
+				return true, fmt.Sprintf("member of group %q", attr["name"])
+			}
+		}
+		return false, fmt.Sprintf("not member of group %q", attr["name"])
+
+	case "IpRange":
+		return f.matchIPRange(attr["min"], attr["max"], attr["useIPv6"] == "1")
+
+	case "Variable":
+		v, ok := f.Env[attr["name"]]
+		if !ok {
+			return false, fmt.Sprintf("environment variable %s is not set", attr["name"])
+		}
+		return compare("environment variable "+attr["name"], v, attr["value"], v == attr["value"])
+
+	case "File":
+		if t := strings.ToUpper(attr["type"]); t != "" && t != "EXISTS" {
+			return false, fmt.Sprintf("unsupported file check %q on %q", t, attr["path"])
+		}
+		if _, err := os.Stat(attr["path"]); err != nil {
+			return false, fmt.Sprintf("file %q doesn't exist", attr["path"])
+		}
+		return true, fmt.Sprintf("file %q exists", attr["path"])
+
+	case "Os":
+		return false, fmt.Sprintf("operating system is not Windows %s", attr["version"])
+	}
+
+	return false, fmt.Sprintf("unsupported %s targeting", filter.Type)
+}
+
+// matchIPRange returns if any address of the machine is in the [min, max] range.
+// IPv4 ranges only apply to IPv4 addresses, and IPv6 ranges to IPv6 addresses.
+func (f Facts) matchIPRange(min, max string, ipv6 bool) (bool, string) {
+	lower, upper := net.ParseIP(min), net.ParseIP(max)
+	if !ipv6 {
+		lower, upper = lower.To4(), upper.To4()
+	}
+	if lower == nil || upper == nil {
+		return false, fmt.Sprintf("invalid IP range %s-%s", min, max)
+	}
+
+	for _, ip := range f.IPs {
+		if ip4 := ip.To4(); (ip4 != nil) == ipv6 {
+			continue
+		} else if !ipv6 {
+			ip = ip4
+		}
+		if bytes.Compare(ip, lower) >= 0 && bytes.Compare(ip, upper) <= 0 {
+			return true, fmt.Sprintf("address %s in range %s-%s", ip, min, max)
+		}
+	}
+	return false, fmt.Sprintf("no address in range %s-%s", min, max)
+}
+
+// compare returns the result of a comparison between a fact and the filter value, with its description.
+func compare(fact, got, want string, match bool) (bool, string) {
+	if match {
+		return true, fmt.Sprintf("%s is %q", fact, want)
+	}
+	return false, fmt.Sprintf("%s %q is not %q", fact, got, want)
+}
+
+// accountName returns the account name of a DOMAIN\name or name@domain account.
+func accountName(account string) string {
+	if i := strings.LastIndex(account, `\`); i >= 0 {
+		account = account[i+1:]
+	}
+	return strings.Split(account, "@")[0]
+}
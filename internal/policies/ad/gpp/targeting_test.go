@@ -0,0 +1,247 @@
+package gpp_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/ad/gpp"
+)
+
+func TestApplies(t *testing.T) {
+	t.Parallel()
+
+	existingFile := filepath.Join(t.TempDir(), "exists")
+	require.NoError(t, os.WriteFile(existingFile, nil, 0600), "Setup: can't create file")
+
+	facts := gpp.Facts{
+		Hostname: "ubuntu-workstation-42",
+		Domain:   "example.com",
+		User:     "bob@example.com",
+		Groups:   []string{"domain users@example.com", "developers"},
+		IPs:      []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.0.12.5"), net.ParseIP("fd00::12:5")},
+		Env:      map[string]string{"XDG_SESSION_TYPE": "wayland"},
+	}
+	machineFacts := facts
+	machineFacts.User = ""
+	machineFacts.Groups = nil
+
+	filter := func(filterType string, attrs ...string) gpp.Filter {
+		f := gpp.Filter{Type: filterType, Attributes: make(map[string]string)}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			f.Attributes[attrs[i]] = attrs[i+1]
+		}
+		return f
+	}
+	not := func(f gpp.Filter) gpp.Filter {
+		f.Not = true
+		return f
+	}
+	or := func(f gpp.Filter) gpp.Filter {
+		f.Or = true
+		return f
+	}
+
+	tests := map[string]struct {
+		filters []gpp.Filter
+		facts   *gpp.Facts
+
+		want       bool
+		wantReason string
+	}{
+		"No filter": {want: true, wantReason: "no item-level targeting"},
+
+		// Computer
+		"NetBIOS computer name is truncated": {
+			filters:    []gpp.Filter{filter("Computer", "type", "NETBIOS", "name", "UBUNTU-WORKSTAT")},
+			want:       true,
+			wantReason: `computer name is "UBUNTU-WORKSTAT"`},
+		"DNS computer name includes the domain": {
+			filters:    []gpp.Filter{filter("Computer", "type", "DNS", "name", "ubuntu-workstation-42.example.com")},
+			want:       true,
+			wantReason: `computer name is "ubuntu-workstation-42.example.com"`},
+		"Other computer name": {
+			filters:    []gpp.Filter{filter("Computer", "type", "NETBIOS", "name", "DEVBOX")},
+			wantReason: `computer name "ubuntu-workstat" is not "DEVBOX"`},
+
+		// Domain
+		"Domain DNS name":     {filters: []gpp.Filter{filter("Domain", "name", "EXAMPLE.COM")}, want: true, wantReason: `domain is "EXAMPLE.COM"`},
+		"Domain NetBIOS name": {filters: []gpp.Filter{filter("Domain", "name", "EXAMPLE")}, want: true, wantReason: `domain is "EXAMPLE"`},
+		"Other domain": {
+			filters:    []gpp.Filter{filter("Domain", "name", "OTHER")},
+			wantReason: `domain "example.com" is not "OTHER"`},
+
+		// User
+		"User": {filters: []gpp.Filter{filter("User", "name", `EXAMPLE\bob`)}, want: true, wantReason: `user is "EXAMPLE\\bob"`},
+		"Other user": {
+			filters:    []gpp.Filter{filter("User", "name", `EXAMPLE\alice`)},
+			wantReason: `user "bob@example.com" is not "EXAMPLE\\alice"`},
+		"User filter does not apply to machine": {
+			filters:    []gpp.Filter{filter("User", "name", `EXAMPLE\bob`)},
+			facts:      &machineFacts,
+			wantReason: `user is "EXAMPLE\\bob" but preferences are for the machine`},
+
+		// Group
+		"Member of domain group": {
+			filters:    []gpp.Filter{filter("Group", "name", `EXAMPLE\Domain Users`, "userContext", "1")},
+			want:       true,
+			wantReason: `member of group "EXAMPLE\\Domain Users"`},
+		"Member of group without domain": {
+			filters:    []gpp.Filter{filter("Group", "name", `EXAMPLE\Developers`)},
+			want:       true,
+			wantReason: `member of group "EXAMPLE\\Developers"`},
+		"Not member of group": {
+			filters:    []gpp.Filter{filter("Group", "name", `EXAMPLE\Sales`, "userContext", "1")},
+			wantReason: `not member of group "EXAMPLE\\Sales"`},
+		"Computer membership is unknown for users": {
+			filters:    []gpp.Filter{filter("Group", "name", `EXAMPLE\Domain Users`, "userContext", "0")},
+			wantReason: `membership of computer to group "EXAMPLE\\Domain Users" is unknown`},
+
+		// IP range
+		"IPv4 address in range": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "10.0.0.1", "max", "10.0.255.254", "useIPv6", "0")},
+			want:       true,
+			wantReason: "address 10.0.12.5 in range 10.0.0.1-10.0.255.254"},
+		"IPv6 address in range": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "fd00::1", "max", "fd00::ffff:ffff", "useIPv6", "1")},
+			want:       true,
+			wantReason: "address fd00::12:5 in range fd00::1-fd00::ffff:ffff"},
+		"No address in range": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "192.168.0.1", "max", "192.168.0.254")},
+			wantReason: "no address in range 192.168.0.1-192.168.0.254"},
+		"IPv6 range is invalid for IPv4 addresses": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "::", "max", "ffff::", "useIPv6", "0")},
+			wantReason: "invalid IP range ::-ffff::"},
+		"IPv6 range does not match IPv4 addresses": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "::", "max", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "useIPv6", "1")},
+			want:       true,
+			wantReason: "address fd00::12:5 in range ::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		"Invalid IP range": {
+			filters:    []gpp.Filter{filter("IpRange", "min", "10.0.0.1", "max", "not an IP")},
+			wantReason: "invalid IP range 10.0.0.1-not an IP"},
+
+		// Environment variable
+		"Environment variable": {
+			filters:    []gpp.Filter{filter("Variable", "name", "XDG_SESSION_TYPE", "value", "wayland")},
+			want:       true,
+			wantReason: `environment variable XDG_SESSION_TYPE is "wayland"`},
+		"Environment variable with other value": {
+			filters:    []gpp.Filter{filter("Variable", "name", "XDG_SESSION_TYPE", "value", "x11")},
+			wantReason: `environment variable XDG_SESSION_TYPE "wayland" is not "x11"`},
+		"Environment variable not set": {
+			filters:    []gpp.Filter{filter("Variable", "name", "JAVA_HOME", "value", "/usr")},
+			wantReason: "environment variable JAVA_HOME is not set"},
+
+		// File
+		"File exists": {
+			filters:    []gpp.Filter{filter("File", "path", existingFile, "type", "EXISTS")},
+			want:       true,
+			wantReason: `file "` + existingFile + `" exists`},
+		"File doesn't exist": {
+			filters:    []gpp.Filter{filter("File", "path", "/doesnotexist", "type", "EXISTS")},
+			wantReason: `file "/doesnotexist" doesn't exist`},
+		"Unsupported file check": {
+			filters:    []gpp.Filter{filter("File", "path", existingFile, "type", "VERSION")},
+			wantReason: `unsupported file check "VERSION" on "` + existingFile + `"`},
+
+		// Unsupported targeting
+		"Windows version never matches": {
+			filters:    []gpp.Filter{filter("Os", "class", "NT", "version", "WIN10")},
+			wantReason: "operating system is not Windows WIN10"},
+		"Unsupported filter never matches": {
+			filters:    []gpp.Filter{filter("Wmi", "query", "SELECT * FROM Win32_Battery")},
+			wantReason: "unsupported Wmi targeting"},
+
+		// Combinations
+		"Negated filter": {
+			filters:    []gpp.Filter{not(filter("Os", "version", "WIN10"))},
+			want:       true,
+			wantReason: "NOT (operating system is not Windows WIN10)"},
+		"All AND filters must match": {
+			filters:    []gpp.Filter{filter("Domain", "name", "EXAMPLE"), filter("User", "name", `EXAMPLE\alice`)},
+			wantReason: `domain is "EXAMPLE" AND user "bob@example.com" is not "EXAMPLE\\alice"`},
+		"Any OR filter can match": {
+			filters:    []gpp.Filter{filter("User", "name", `EXAMPLE\alice`), or(filter("Domain", "name", "EXAMPLE"))},
+			want:       true,
+			wantReason: `user "bob@example.com" is not "EXAMPLE\\alice" OR domain is "EXAMPLE"`},
+		"Filters are evaluated from left to right": {
+			// (alice OR domain) AND sales, and not alice OR (domain AND sales)
+			filters: []gpp.Filter{
+				filter("User", "name", `EXAMPLE\alice`),
+				or(filter("Domain", "name", "EXAMPLE")),
+				filter("Group", "name", `EXAMPLE\Sales`),
+			},
+			wantReason: `user "bob@example.com" is not "EXAMPLE\\alice" OR domain is "EXAMPLE" AND not member of group "EXAMPLE\\Sales"`},
+		"Collections are evaluated as a whole": {
+			filters: []gpp.Filter{
+				filter("Group", "name", `EXAMPLE\Sales`),
+				or(gpp.Filter{Type: "Collection", Filters: []gpp.Filter{
+					filter("Domain", "name", "EXAMPLE"),
+					filter("User", "name", `EXAMPLE\bob`),
+				}}),
+			},
+			want:       true,
+			wantReason: `not member of group "EXAMPLE\\Sales" OR (domain is "EXAMPLE" AND user is "EXAMPLE\\bob")`},
+		"Empty collection matches": {
+			filters:    []gpp.Filter{{Type: "Collection"}},
+			want:       true,
+			wantReason: "(no item-level targeting)"},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := facts
+			if tc.facts != nil {
+				f = *tc.facts
+			}
+
+			got, reason := f.Applies(tc.filters)
+			require.Equal(t, tc.want, got, "Applies returns expected decision")
+			require.Equal(t, tc.wantReason, reason, "Applies returns expected reason")
+		})
+	}
+}
+
+func TestNewFacts(t *testing.T) {
+	t.Parallel()
+
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	tests := map[string]struct {
+		user string
+
+		wantGroups bool
+	}{
+		"Machine facts":             {},
+		"User facts":                {user: u.Username, wantGroups: true},
+		"Unknown user has no group": {user: "doesnotexist@example.com"},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := gpp.NewFacts(context.Background(), "myhost", "example.com", tc.user)
+
+			require.Equal(t, "myhost", f.Hostname, "NewFacts sets hostname")
+			require.Equal(t, "example.com", f.Domain, "NewFacts sets domain")
+			require.Equal(t, tc.user, f.User, "NewFacts sets user")
+			require.NotEmpty(t, f.IPs, "NewFacts lists local addresses")
+			require.Equal(t, os.Getenv("PATH"), f.Env["PATH"], "NewFacts collects environment")
+			if tc.wantGroups {
+				require.NotEmpty(t, f.Groups, "NewFacts lists groups of the user")
+			} else {
+				require.Empty(t, f.Groups, "NewFacts has no group")
+			}
+		})
+	}
+}
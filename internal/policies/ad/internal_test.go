@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/internal/policies/ad/gpp"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -30,6 +31,7 @@ func TestFetchGPO(t *testing.T) {
 		gpos                   []string
 		concurrentGposDownload []string
 		existingGpos           map[string]string
+		objectClass            ObjectClass
 
 		want    map[string]string
 		wantErr bool
@@ -133,6 +135,35 @@ func TestFetchGPO(t *testing.T) {
 			},
 		},
 
+		// User and machine versions
+		"computer gpo is not refreshed when only user part changed": {
+			gpos:         []string{"user_and_machine"},
+			existingGpos: map[string]string{"user_and_machine": "user_and_machine_old_user"},
+			want:         map[string]string{"user_and_machine": "user_and_machine_old_user"},
+		},
+		"computer gpo is refreshed when machine part changed": {
+			gpos:         []string{"user_and_machine"},
+			existingGpos: map[string]string{"user_and_machine": "user_and_machine_old_machine"},
+			want:         map[string]string{"user_and_machine": "user_and_machine"},
+		},
+		"user gpo is refreshed when user part changed": {
+			gpos:         []string{"user_and_machine"},
+			existingGpos: map[string]string{"user_and_machine": "user_and_machine_old_user"},
+			objectClass:  UserObject,
+			want:         map[string]string{"user_and_machine": "user_and_machine"},
+		},
+		"user gpo is not refreshed when only machine part changed": {
+			gpos:         []string{"user_and_machine"},
+			existingGpos: map[string]string{"user_and_machine": "user_and_machine_old_machine"},
+			objectClass:  UserObject,
+			want:         map[string]string{"user_and_machine": "user_and_machine_old_machine"},
+		},
+		"new user gpo is downloaded": {
+			gpos:        []string{"user_and_machine"},
+			objectClass: UserObject,
+			want:        map[string]string{"user_and_machine": "user_and_machine"},
+		},
+
 		"Local gpo redownloaded on missing GPT.INI": {
 			gpos:         []string{"gpo1"},
 			existingGpos: map[string]string{"gpo1": "missing_gpt_ini"},
@@ -187,6 +218,10 @@ func TestFetchGPO(t *testing.T) {
 			t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
 			dest, rundir := t.TempDir(), t.TempDir()
 
+			if tc.objectClass == "" {
+				tc.objectClass = ComputerObject
+			}
+
 			adc, err := New(context.Background(), "ldap://UNUSED:1636/", "localdomain",
 				WithCacheDir(dest), WithRunDir(rundir), withoutKerberos(), WithSSSCacheDir("testdata/sss/db"))
 
@@ -202,31 +237,31 @@ func TestFetchGPO(t *testing.T) {
 					"Setup: can't copy initial gpo directory")
 			}
 
-			gpos := make(map[string]string)
+			var gpos []gpo
 			for _, n := range tc.gpos {
 				// differentiate the gpo name from the url base path
-				gpos[n+"-name"] = fmt.Sprintf("smb://localhost:%d/%s/%s", SmbPort, policyPath, n)
+				gpos = append(gpos, gpo{name: n + "-name", url: fmt.Sprintf("smb://localhost:%d/%s/%s", SmbPort, policyPath, n)})
 			}
 
 			if tc.concurrentGposDownload == nil {
-				err = adc.fetch(context.Background(), "", gpos)
+				err = adc.fetch(context.Background(), "", gpos, tc.objectClass)
 				if tc.wantErr {
 					require.NotNil(t, err, "fetch should return an error but didn't")
 				} else {
 					require.NoError(t, err, "fetch returned an error but shouldn't")
 				}
 			} else {
-				concurrentGpos := make(map[string]string)
+				var concurrentGpos []gpo
 				for _, n := range tc.concurrentGposDownload {
 					// differentiate the gpo name from the url base path
-					concurrentGpos[n+"-name"] = fmt.Sprintf("smb://localhost:%d/%s/%s", SmbPort, policyPath, n)
+					concurrentGpos = append(concurrentGpos, gpo{name: n + "-name", url: fmt.Sprintf("smb://localhost:%d/%s/%s", SmbPort, policyPath, n)})
 				}
 
 				wg := sync.WaitGroup{}
 				wg.Add(2)
 				go func() {
 					defer wg.Done()
-					err := adc.fetch(context.Background(), "", gpos)
+					err := adc.fetch(context.Background(), "", gpos, tc.objectClass)
 					if tc.wantErr {
 						require.NotNil(t, err, "fetch should return an error but didn't")
 					} else {
@@ -235,7 +270,7 @@ func TestFetchGPO(t *testing.T) {
 				}()
 				go func() {
 					defer wg.Done()
-					err := adc.fetch(context.Background(), "", concurrentGpos)
+					err := adc.fetch(context.Background(), "", concurrentGpos, tc.objectClass)
 					if tc.wantErr {
 						require.NotNil(t, err, "fetch should return an error but didn't")
 					} else {
@@ -270,9 +305,7 @@ func TestFetchGPOWithUnreadableFile(t *testing.T) {
 
 	// Prepare GPO with unreadable file.
 	// Defer will work after all tests are done because we don’t run it in parallel
-	gpos := map[string]string{
-		"gpo1-name": fmt.Sprintf("smb://localhost:%d/broken/%s/%s", SmbPort, policyPath, "gpo1"),
-	}
+	gpos := []gpo{{name: "gpo1-name", url: fmt.Sprintf("smb://localhost:%d/broken/%s/%s", SmbPort, policyPath, "gpo1")}}
 	require.NoError(t,
 		shutil.CopyTree(
 			filepath.Join("testdata", "AD", policyPath, "gpo1"),
@@ -311,7 +344,7 @@ func TestFetchGPOWithUnreadableFile(t *testing.T) {
 					"Setup: can't copy initial gpo directory")
 			}
 
-			err = adc.fetch(context.Background(), "", gpos)
+			err = adc.fetch(context.Background(), "", gpos, ComputerObject)
 			require.NotNil(t, err, "fetch should return an error but didn't")
 
 			if !tc.withExistingGPO {
@@ -355,7 +388,7 @@ func TestFetchGPOTweakGPOCacheDir(t *testing.T) {
 				require.NoError(t, os.Chmod(adc.gpoCacheDir, 0400), "Setup: can’t set gpoCacheDir to Read only")
 			}
 
-			err = adc.fetch(context.Background(), "", map[string]string{"gpo1-name": fmt.Sprintf("smb://localhost:%d/%s/gpo1", SmbPort, policyPath)})
+			err = adc.fetch(context.Background(), "", []gpo{{name: "gpo1-name", url: fmt.Sprintf("smb://localhost:%d/%s/gpo1", SmbPort, policyPath)}}, ComputerObject)
 
 			require.NotNil(t, err, "fetch should return an error but didn't")
 			assert.NoDirExists(t, filepath.Join(adc.gpoCacheDir, "gpo1"), "gpo1 shouldn't be downloaded")
@@ -381,30 +414,28 @@ func TestFetchOneGPOWhileParsingItConcurrently(t *testing.T) {
 			&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
 		"Setup: can't copy initial gpo directory")
 	// create the lock made by fetch which is always called before parseGPOs in the public API
-	adc.gpos["standard-name"] = &gpo{
+	adc.gpos["standard"] = &gpo{
 		name: "standard-name",
 		url:  fmt.Sprintf("smb://localhost:%d/%s/standard", SmbPort, policyPath),
 		mu:   &sync.RWMutex{},
 	}
 
 	// concurrent downloads and parsing
-	gpos := map[string]string{
-		"standard-name": adc.gpos["standard-name"].url,
-	}
-	orderedGPOs := []gpo{{name: "standard-name", url: gpos["standard-name"]}}
+	gpos := []gpo{{name: "standard-name", url: adc.gpos["standard"].url}}
+	orderedGPOs := gpos
 
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
 
-		err := adc.fetch(context.Background(), "", gpos)
+		err := adc.fetch(context.Background(), "", gpos, ComputerObject)
 		require.NoError(t, err, "fetch returned an error but shouldn't")
 	}()
 	go func() {
 		defer wg.Done()
 		// we can’t test returned values as it’s either the old of new version of the gpo
-		_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
+		_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject, gpp.Facts{})
 		require.NoError(t, err, "parseGPOs returned an error but shouldn't")
 	}()
 	wg.Wait()
@@ -421,11 +452,9 @@ func TestParseGPOConcurrent(t *testing.T) {
 	require.NoError(t, err, "Setup: cannot create ad object")
 
 	// Fetch the GPO to set it up
-	gpos := map[string]string{
-		"standard-name": fmt.Sprintf("smb://localhost:%d/%s/standard", SmbPort, policyPath),
-	}
-	orderedGPOs := []gpo{{name: "standard-name", url: gpos["standard-name"]}}
-	err = adc.fetch(context.Background(), "", gpos)
+	gpos := []gpo{{name: "standard-name", url: fmt.Sprintf("smb://localhost:%d/%s/standard", SmbPort, policyPath)}}
+	orderedGPOs := gpos
+	err = adc.fetch(context.Background(), "", gpos, ComputerObject)
 	require.NoError(t, err, "Setup: couldn’t do initial GPO fetch as returned an error but shouldn't")
 
 	// concurrent parsing of GPO
@@ -435,7 +464,7 @@ func TestParseGPOConcurrent(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			// we can’t test returned values as it’s either the old of new version of the gpo
-			_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
+			_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject, gpp.Facts{})
 			require.NoError(t, err, "parseGPOs returned an error but shouldn't")
 		}()
 	}
@@ -446,6 +475,43 @@ const SmbPort = 1445
 
 var brokenSmbDirShare string
 
+func TestGetGPOVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+
+		want    gpoVersion
+		wantErr bool
+	}{
+		"machine only version": {content: "[General]\r\nVersion=3\r\n", want: gpoVersion{machine: 3}},
+		"user only version":    {content: "[General]\r\nVersion=131072\r\n", want: gpoVersion{user: 2}},
+		"user and machine":     {content: "[General]\r\nVersion=131075\r\n", want: gpoVersion{user: 2, machine: 3}},
+		"highest versions":     {content: "Version=4294967295", want: gpoVersion{user: 65535, machine: 65535}},
+
+		"Error on negative version": {content: "Version=-1", wantErr: true},
+		"Error on too big version":  {content: "Version=4294967296", wantErr: true},
+		"Error on NaN version":      {content: "Version=NaN", wantErr: true},
+		"Error on no version":       {content: "[General]\r\ndisplayName=foo\r\n", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := getGPOVersion(strings.NewReader(tc.content))
+			if tc.wantErr {
+				require.Error(t, err, "getGPOVersion should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "getGPOVersion returned an error but shouldn't")
+			assert.Equal(t, tc.want, got, "getGPOVersion returns expected version")
+			assert.Equal(t, tc.want.user, got.forObjectClass(UserObject), "user version is the high part")
+			assert.Equal(t, tc.want.machine, got.forObjectClass(ComputerObject), "machine version is the low part")
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.BoolVar(&Update, "update", false, "update golden files")
 	flag.Parse()
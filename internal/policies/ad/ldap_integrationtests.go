@@ -0,0 +1,13 @@
+//go:build integrationtests
+// +build integrationtests
+
+package ad
+
+import "github.com/ubuntu/adsys/internal/testutils/admock"
+
+// Integration tests run the daemon against the in-memory example directory instead of a real AD server.
+func init() {
+	defaultLDAPDialer = func(url, krb5CCPath string) (ldapConn, error) {
+		return admock.Dial(url, krb5CCPath)
+	}
+}
@@ -0,0 +1,139 @@
+package ad
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+const (
+	// gpmcBackupContentDir is where the GPO content is stored in a GPMC backup.
+	gpmcBackupContentDir = "DomainSysvol/GPO"
+	// gpmcBackupInfo is the GPMC backup file describing the saved GPO.
+	gpmcBackupInfo = "bkupInfo.xml"
+)
+
+// localSource lists and copies GPOs from a local directory, to apply policies without a domain controller.
+// Every GPO of the directory applies to all objects. The lexical order of the GPO directory names is their priority
+// order, the first one winning.
+// A GPO is either a GPMC "Backup GPO" export, with its content under DomainSysvol/GPO and its name in bkupInfo.xml,
+// or a plain GPO tree, like {GUID}/Machine/Registry.pol, named after its directory.
+type localSource struct {
+	dir string
+}
+
+// listGPOs returns all GPOs of the local directory. No authentication is needed.
+func (s localSource) listGPOs(ctx context.Context, objectName string, objectClass ObjectClass, _, _ string) (gpos []gpo, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list GPOs in %q"), s.dir)
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(s.dir, e.Name())
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			log.Debugf(ctx, "Ignoring %q which is not a GPO directory", path)
+			continue
+		}
+
+		name := e.Name()
+		if isGPMCBackup(path) {
+			if name, err = gpmcBackupName(path); err != nil {
+				return nil, err
+			}
+		}
+
+		log.Debugf(ctx, "Local GPO %q for %q in %q", name, objectName, path)
+		gpos = append(gpos, gpo{name: name, url: path})
+	}
+
+	return gpos, nil
+}
+
+// newDownloader returns a downloader copying local GPOs.
+func (s localSource) newDownloader(ctx context.Context, _ string) (gpoDownloader, error) {
+	return localDownloader{}, nil
+}
+
+// isGPMCBackup returns if the GPO directory at path is a GPMC backup.
+func isGPMCBackup(path string) bool {
+	_, err := os.Stat(filepath.Join(path, gpmcBackupInfo))
+	return err == nil
+}
+
+// gpmcBackupName returns the display name of the GPO saved in the GPMC backup at path.
+func gpmcBackupName(path string) (name string, err error) {
+	defer decorate.OnError(&err, i18n.G("invalid GPMC backup %q"), path)
+
+	data, err := os.ReadFile(filepath.Join(path, gpmcBackupInfo))
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		GPODisplayName string `xml:"GPODisplayName"`
+	}
+	if err := xml.Unmarshal(data, &info); err != nil {
+		return "", err
+	}
+	if name = strings.TrimSpace(info.GPODisplayName); name == "" {
+		return "", errors.New(i18n.G("no GPO display name"))
+	}
+	return name, nil
+}
+
+// localDownloader copies GPOs from a local directory.
+type localDownloader struct{}
+
+// needsDownload always refreshes the cache, as local GPOs can be edited without changing their version.
+func (localDownloader) needsDownload(ctx context.Context, g *gpo, localPath string, objectClass ObjectClass) (bool, error) {
+	return true, nil
+}
+
+// download copies the GPO content at url, which is a local path, to dest.
+func (localDownloader) download(url, dest string) error {
+	src := url
+	if isGPMCBackup(url) {
+		src = filepath.Join(url, filepath.FromSlash(gpmcBackupContentDir))
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		entityDest := filepath.Join(dest, relPath)
+
+		if d.IsDir() {
+			if err := os.MkdirAll(entityDest, 0700); err != nil {
+				return fmt.Errorf(i18n.G("can't create %q: %v"), entityDest, err)
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf(i18n.G("unsupported type %q for entry %s"), d.Type(), path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(entityDest, data, 0700)
+	})
+}
+
+func (localDownloader) close(context.Context) {}
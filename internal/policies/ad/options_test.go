@@ -7,9 +7,9 @@ func withoutKerberos() Option {
 	}
 }
 
-func withGPOListCmd(cmd []string) Option {
+func withLDAPDialer(dial ldapDialer) Option {
 	return func(o *options) error {
-		o.gpoListCmd = cmd
+		o.dialLDAP = dial
 		return nil
 	}
 }
@@ -0,0 +1,277 @@
+package ad
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+const (
+	// gpoApplyGUID is the extended right to apply a GPO.
+	gpoApplyGUID = "edacfd8f-ffb3-11d1-b41d-00a0c968f939"
+
+	sidEveryone           = "S-1-1-0"
+	sidAuthenticatedUsers = "S-1-5-11"
+
+	// Access rights we check on GPOs
+	adsList       = 0x00000004
+	adsReadProp   = 0x00000010
+	adsListObject = 0x00000080
+	readControl   = 0x00020000
+	writeDAC      = 0x00040000
+	genericAll    = 0x10000000
+	genericRead   = 0x80000000
+
+	// ACE types
+	aceTypeAccessAllowed       = 0x00
+	aceTypeAccessDenied        = 0x01
+	aceTypeAccessAllowedObject = 0x05
+	aceTypeAccessDeniedObject  = 0x06
+
+	aceFlagInheritOnly      = 0x08
+	aceObjectTypePresent    = 0x01
+	aceInheritedTypePresent = 0x02
+
+	sdControlDACLPresent = 0x0004
+)
+
+// ace is an access control entry of a DACL.
+// objectType is empty for non object ACEs or object ACEs applying to the whole object.
+type ace struct {
+	aceType    uint8
+	flags      uint8
+	mask       uint32
+	objectType string
+	sid        string
+}
+
+// securityDescriptor is the subset of a security descriptor needed for GPO access checks.
+type securityDescriptor struct {
+	owner       string
+	daclPresent bool
+	dacl        []ace
+}
+
+// parseSecurityDescriptor decodes a self-relative binary security descriptor, as stored in nTSecurityDescriptor.
+func parseSecurityDescriptor(b []byte) (sd securityDescriptor, err error) {
+	if len(b) < 20 {
+		return sd, errors.New(i18n.G("security descriptor is too short"))
+	}
+	control := binary.LittleEndian.Uint16(b[2:4])
+	ownerOffset := binary.LittleEndian.Uint32(b[4:8])
+	daclOffset := binary.LittleEndian.Uint32(b[16:20])
+
+	if ownerOffset != 0 {
+		if int(ownerOffset) >= len(b) {
+			return sd, errors.New(i18n.G("invalid owner offset in security descriptor"))
+		}
+		if sd.owner, _, err = decodeSIDPrefix(b[ownerOffset:]); err != nil {
+			return sd, err
+		}
+	}
+
+	if control&sdControlDACLPresent == 0 || daclOffset == 0 {
+		return sd, nil
+	}
+	sd.daclPresent = true
+
+	if int(daclOffset)+8 > len(b) {
+		return sd, errors.New(i18n.G("invalid DACL offset in security descriptor"))
+	}
+	acl := b[daclOffset:]
+	aclSize := int(binary.LittleEndian.Uint16(acl[2:4]))
+	aceCount := int(binary.LittleEndian.Uint16(acl[4:6]))
+	if aclSize > len(acl) || aclSize < 8 {
+		return sd, errors.New(i18n.G("invalid DACL size in security descriptor"))
+	}
+	acl = acl[8:aclSize]
+
+	for i := 0; i < aceCount; i++ {
+		if len(acl) < 4 {
+			return sd, errors.New(i18n.G("truncated ACE in security descriptor"))
+		}
+		size := int(binary.LittleEndian.Uint16(acl[2:4]))
+		if size < 8 || size > len(acl) {
+			return sd, errors.New(i18n.G("invalid ACE size in security descriptor"))
+		}
+		a, err := parseACE(acl[:size])
+		if err != nil {
+			return sd, err
+		}
+		acl = acl[size:]
+
+		// Audit or other ACE types are not relevant for access checks.
+		if a == nil {
+			continue
+		}
+		sd.dacl = append(sd.dacl, *a)
+	}
+
+	return sd, nil
+}
+
+// parseACE decodes a single ACE. It returns nil for unsupported ACE types.
+func parseACE(b []byte) (*ace, error) {
+	a := ace{
+		aceType: b[0],
+		flags:   b[1],
+		mask:    binary.LittleEndian.Uint32(b[4:8]),
+	}
+	body := b[8:]
+
+	switch a.aceType {
+	case aceTypeAccessAllowed, aceTypeAccessDenied:
+	case aceTypeAccessAllowedObject, aceTypeAccessDeniedObject:
+		if len(body) < 4 {
+			return nil, errors.New(i18n.G("truncated object ACE in security descriptor"))
+		}
+		flags := binary.LittleEndian.Uint32(body[:4])
+		body = body[4:]
+		if flags&aceObjectTypePresent != 0 {
+			if len(body) < 16 {
+				return nil, errors.New(i18n.G("truncated object ACE in security descriptor"))
+			}
+			a.objectType = decodeGUID(body[:16])
+			body = body[16:]
+		}
+		if flags&aceInheritedTypePresent != 0 {
+			if len(body) < 16 {
+				return nil, errors.New(i18n.G("truncated object ACE in security descriptor"))
+			}
+			body = body[16:]
+		}
+	default:
+		return nil, nil
+	}
+
+	sid, _, err := decodeSIDPrefix(body)
+	if err != nil {
+		return nil, err
+	}
+	a.sid = sid
+	return &a, nil
+}
+
+// accessCheck returns if all desired rights are granted to the token.
+// ACEs are evaluated in order, the first denial of a still requested right wins.
+func (sd securityDescriptor) accessCheck(token map[string]struct{}, desired uint32) bool {
+	// No DACL means full access
+	if !sd.daclPresent {
+		return true
+	}
+
+	remaining := desired
+	// The owner can always read and change permissions
+	if _, ok := token[sd.owner]; ok {
+		remaining &^= readControl | writeDAC
+	}
+
+	for _, a := range sd.dacl {
+		if remaining == 0 {
+			break
+		}
+		if a.flags&aceFlagInheritOnly != 0 {
+			continue
+		}
+		if _, ok := token[a.sid]; !ok {
+			continue
+		}
+		// Object specific ACEs only affect a property set or an extended right, not the whole object.
+		if a.objectType != "" {
+			continue
+		}
+
+		mask := mapGenericRights(a.mask)
+		switch a.aceType {
+		case aceTypeAccessAllowed, aceTypeAccessAllowedObject:
+			remaining &^= mask
+		case aceTypeAccessDenied, aceTypeAccessDeniedObject:
+			if mask&remaining != 0 {
+				return false
+			}
+		}
+	}
+
+	return remaining == 0
+}
+
+// canApplyGPO returns if the token has the extended right to apply the GPO.
+// We need at least one allowed access to be applied and one denial is enough to deny the whole policy.
+func (sd securityDescriptor) canApplyGPO(token map[string]struct{}) bool {
+	var applied bool
+	for _, a := range sd.dacl {
+		if !strings.EqualFold(a.objectType, gpoApplyGUID) {
+			continue
+		}
+		if _, ok := token[a.sid]; !ok {
+			continue
+		}
+
+		switch a.aceType {
+		case aceTypeAccessAllowedObject:
+			applied = true
+		case aceTypeAccessDeniedObject:
+			return false
+		}
+	}
+	return applied
+}
+
+// mapGenericRights maps generic rights to the specific rights of a directory object.
+func mapGenericRights(mask uint32) uint32 {
+	if mask&genericAll != 0 {
+		mask |= 0x000f01ff
+	}
+	if mask&genericRead != 0 {
+		mask |= readControl | adsList | adsListObject | adsReadProp
+	}
+	return mask
+}
+
+// decodeSID returns the string representation of a binary SID.
+func decodeSID(b []byte) (string, error) {
+	sid, n, err := decodeSIDPrefix(b)
+	if err != nil {
+		return "", err
+	}
+	if n != len(b) {
+		return "", fmt.Errorf(i18n.G("unexpected trailing data after SID %s"), sid)
+	}
+	return sid, nil
+}
+
+// decodeSIDPrefix decodes the binary SID at the start of b and returns its string representation and length.
+func decodeSIDPrefix(b []byte) (sid string, n int, err error) {
+	if len(b) < 8 {
+		return "", 0, errors.New(i18n.G("SID is too short"))
+	}
+	subAuthorityCount := int(b[1])
+	n = 8 + 4*subAuthorityCount
+	if len(b) < n {
+		return "", 0, errors.New(i18n.G("SID is truncated"))
+	}
+
+	var authority uint64
+	for _, v := range b[2:8] {
+		authority = authority<<8 | uint64(v)
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "S-%d-%d", b[0], authority)
+	for i := 0; i < subAuthorityCount; i++ {
+		fmt.Fprintf(&s, "-%d", binary.LittleEndian.Uint32(b[8+4*i:]))
+	}
+	return s.String(), n, nil
+}
+
+// decodeGUID returns the string representation of a mixed-endian binary GUID.
+func decodeGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10], b[10:16])
+}
@@ -0,0 +1,55 @@
+package ad
+
+import (
+	"context"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+)
+
+// gpoSource is where the GPOs applying to an object are listed and downloaded from.
+type gpoSource interface {
+	// listGPOs returns the GPOs applying to objectName, ordered by decreasing priority.
+	// userKrb5CCName is the user ticket, if any, to cache as krb5CCPath for authenticating later requests.
+	// An ldap.ErrorNetwork error means that the source is unreachable.
+	listGPOs(ctx context.Context, objectName string, objectClass ObjectClass, userKrb5CCName, krb5CCPath string) ([]gpo, error)
+	// newDownloader returns a downloader of GPO content, authenticated with the ticket at krb5CCPath if needed.
+	newDownloader(ctx context.Context, krb5CCPath string) (gpoDownloader, error)
+}
+
+// gpoDownloader copies GPOs from their source to the GPO cache.
+type gpoDownloader interface {
+	// needsDownload returns if the part of the GPO content cached in localPath applying to objectClass is outdated.
+	needsDownload(ctx context.Context, g *gpo, localPath string, objectClass ObjectClass) (bool, error)
+	// download copies the GPO content at url to dest.
+	download(url, dest string) error
+	// close releases the resources of the downloader.
+	close(ctx context.Context)
+}
+
+// sysvolSource lists GPOs from Active Directory and downloads them from SYSVOL, authenticating with Kerberos.
+type sysvolSource struct {
+	ad *AD
+}
+
+// listGPOs returns the GPOs linked to the object containers in Active Directory.
+func (s sysvolSource) listGPOs(ctx context.Context, objectName string, objectClass ObjectClass, userKrb5CCName, krb5CCPath string) ([]gpo, error) {
+	// Create a ccache symlink on first fetch for futur calls (on refresh for instance)
+	if userKrb5CCName != "" || objectClass == ComputerObject {
+		src := userKrb5CCName
+		// there is no env var for machine: get sss ccache
+		if objectClass == ComputerObject {
+			src = s.ad.sssCCName
+		}
+		if err := s.ad.ensureKrb5CCName(src, krb5CCPath); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := s.ad.dialLDAP(s.ad.url, krb5CCPath)
+	if err != nil {
+		return nil, err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, conn.Close)
+
+	return s.ad.listGPOs(ctx, conn, objectName, objectClass)
+}
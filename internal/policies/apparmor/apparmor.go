@@ -0,0 +1,387 @@
+// Package apparmor deploys, loads and unloads apparmor profiles referenced by GPOs.
+package apparmor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+)
+
+/*
+	Notes:
+	Profiles are referenced in the "apparmor" rules as a list of paths, one per line, relative to the GPO cache directory:
+	  <GPO ID>/<Machine|User>/Apparmor/<path to profile>
+
+	Machine profiles ("apparmor-machine" key) are copied to <apparmor dir>/machine/ and loaded in the kernel.
+	Profiles which are not referenced anymore are unloaded and removed.
+
+	User profiles ("apparmor-users" key) are hat definitions used by pam_apparmor. They are merged into a
+	single ^<user> hat written to <apparmor dir>/users/<user>.
+	Machine profiles confining PAM applications can include those hats with:
+	  #include if exists <adsys/users>
+	Machine profiles are thus reloaded each time any user hat changes.
+*/
+
+const (
+	machineKey = "apparmor-machine"
+	usersKey   = "apparmor-users"
+)
+
+// Manager serializes ApplyPolicy calls, as machine profiles and user hats are all loaded by reloading the machine
+// profiles with apparmor_parser.
+type Manager struct {
+	mu sync.Mutex
+
+	apparmorDir       string
+	gpoCacheDir       string
+	apparmorParserCmd []string
+}
+
+type options struct {
+	apparmorDir       string
+	gpoCacheDir       string
+	apparmorParserCmd []string
+}
+type option func(*options) error
+
+// WithApparmorDir specifies a personalized directory where to store profiles
+func WithApparmorDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.apparmorDir = p
+		return nil
+	}
+}
+
+// WithGPOCacheDir specifies a personalized directory where GPOs are downloaded
+func WithGPOCacheDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.gpoCacheDir = p
+		return nil
+	}
+}
+
+// WithApparmorParserCmd specifies a personalized apparmor_parser command
+func WithApparmorParserCmd(cmd []string) func(o *options) error {
+	return func(o *options) error {
+		o.apparmorParserCmd = cmd
+		return nil
+	}
+}
+
+// New returns a new manager for apparmor policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new apparmor handler manager"))
+
+	// defaults
+	args := options{
+		apparmorDir:       consts.DefaultApparmorDir,
+		gpoCacheDir:       filepath.Join(consts.DefaultCacheDir, entry.GPOCacheBaseName),
+		apparmorParserCmd: []string{"apparmor_parser"},
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		apparmorDir:       args.apparmorDir,
+		gpoCacheDir:       args.gpoCacheDir,
+		apparmorParserCmd: args.apparmorParserCmd,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the apparmor manager.
+// Only registry settings are needed: they list the profiles, whose files are downloaded with the files of the GPO.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
+// ApplyPolicy deploys and loads machine profiles, or generates user hats and reload machine profiles including them.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply apparmor policy to %s"), objectName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy apparmor policy to %s", objectName)
+
+	key := machineKey
+	if !isComputer {
+		key = usersKey
+	}
+
+	var profiles []string
+	sources := make(map[string]string)
+	for _, e := range entries {
+		if e.Key != key {
+			log.Warningf(ctx, i18n.G("Ignoring unsupported apparmor key %q for %s"), e.Key, objectName)
+			continue
+		}
+		if e.Disabled {
+			continue
+		}
+		for _, p := range strings.Split(e.Value, "\n") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if _, ok := sources[p]; ok {
+				continue
+			}
+			src, err := gpoProfilePath(m.gpoCacheDir, p)
+			if err != nil {
+				return err
+			}
+			sources[p] = src
+			profiles = append(profiles, p)
+		}
+	}
+
+	if isComputer {
+		return m.applyMachinePolicy(ctx, profiles, sources)
+	}
+	return m.applyUserPolicy(ctx, objectName, profiles, sources)
+}
+
+// gpoProfilePath returns the path in gpoCacheDir of the profile p, referenced as
+// <GPO ID>/<Machine|User>/Apparmor/<path to profile>, with all symlinks resolved.
+// The resolved path must stay in the Apparmor directory of the GPO referencing it.
+func gpoProfilePath(gpoCacheDir, p string) (string, error) {
+	if c := filepath.Clean(p); filepath.IsAbs(p) || c == ".." || strings.HasPrefix(c, "../") {
+		return "", fmt.Errorf(i18n.G("profile path %q is outside of the GPO cache directory"), p)
+	}
+	parts := strings.SplitN(p, "/", 4)
+	if len(parts) != 4 {
+		return "", fmt.Errorf(i18n.G("profile path %q should be <GPO ID>/<Machine|User>/Apparmor/<profile>"), p)
+	}
+	for _, part := range parts[:3] {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf(i18n.G("profile path %q should be <GPO ID>/<Machine|User>/Apparmor/<profile>"), p)
+		}
+	}
+
+	profilesDir, err := filepath.EvalSymlinks(filepath.Join(gpoCacheDir, parts[0], parts[1], parts[2]))
+	if err != nil {
+		return "", err
+	}
+	profile, err := filepath.EvalSymlinks(filepath.Join(gpoCacheDir, p))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(profile, profilesDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf(i18n.G("profile path %q is outside of the apparmor directory of its GPO"), p)
+	}
+	return profile, nil
+}
+
+// applyMachinePolicy validates, deploys and loads machine profiles, read from their sources.
+// Profiles not referenced anymore are unloaded.
+func (m *Manager) applyMachinePolicy(ctx context.Context, profiles []string, sources map[string]string) (err error) {
+	machineDir := filepath.Join(m.apparmorDir, "machine")
+
+	// Stage new profiles and only commit them if they are all valid.
+	stagingDir := machineDir + ".new"
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Info(ctx, i18n.G("Could not clean up temporary directory:"), err)
+		}
+	}()
+	newProfiles := make(map[string]struct{})
+	for _, p := range profiles {
+		newProfiles[p] = struct{}{}
+		d, err := os.ReadFile(sources[p])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(stagingDir, p)), 0755); err != nil {
+			return err
+		}
+		// #nosec G306 - apparmor profiles are world readable
+		if err := os.WriteFile(filepath.Join(stagingDir, p), d, 0644); err != nil {
+			return err
+		}
+	}
+	if err := m.forEachProfile(ctx, stagingDir, profiles, "-QTK"); err != nil {
+		return err
+	}
+
+	// Unload profiles which are not referenced anymore
+	oldProfiles, err := listProfiles(machineDir)
+	if err != nil {
+		return err
+	}
+	var removedProfiles []string
+	for _, p := range oldProfiles {
+		if _, ok := newProfiles[p]; ok {
+			continue
+		}
+		removedProfiles = append(removedProfiles, p)
+	}
+	if err := m.forEachProfile(ctx, machineDir, removedProfiles, "-R"); err != nil {
+		// Failing to unload a profile (already unloaded for instance) should not prevent deploying the new ones.
+		log.Info(ctx, i18n.G("Some apparmor profiles could not be unloaded"))
+	}
+
+	// Commit new profiles
+	if err := os.RemoveAll(machineDir); err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		log.Debug(ctx, "No apparmor machine profiles to load")
+		return nil
+	}
+	if err := os.Rename(stagingDir, machineDir); err != nil {
+		return err
+	}
+
+	return m.forEachProfile(ctx, machineDir, profiles, "-r")
+}
+
+// applyUserPolicy merges user profiles, read from their sources, in a single hat for the user and reload machine
+// profiles if it changed.
+func (m *Manager) applyUserPolicy(ctx context.Context, objectName string, profiles []string, sources map[string]string) (err error) {
+	if strings.Contains(objectName, "/") || objectName == "." || objectName == ".." {
+		return fmt.Errorf(i18n.G("invalid user name %q"), objectName)
+	}
+	usersDir := filepath.Join(m.apparmorDir, "users")
+	hatPath := filepath.Join(usersDir, objectName)
+
+	// No profile: remove any previous hat
+	if len(profiles) == 0 {
+		if err := os.Remove(hatPath); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return m.reloadMachineProfiles(ctx)
+	}
+
+	hat := fmt.Sprintf("^%q {\n", objectName)
+	for _, p := range profiles {
+		d, err := os.ReadFile(sources[p])
+		if err != nil {
+			return err
+		}
+		hat += fmt.Sprintf("  # %s\n", p)
+		for _, l := range strings.Split(strings.TrimRight(string(d), "\n"), "\n") {
+			hat += "  " + l + "\n"
+		}
+	}
+	hat += "}\n"
+
+	// Nothing changed: no need to reload profiles
+	if old, err := os.ReadFile(hatPath); err == nil && string(old) == hat {
+		log.Debugf(ctx, "Apparmor hat for %s didn't change", objectName)
+		return nil
+	}
+
+	// Validate the hat in a standalone profile.
+	validationDir, err := os.MkdirTemp("", "adsys_apparmor_validation_")
+	if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, func() error { return os.RemoveAll(validationDir) })
+	validationProfile := "adsys_hat_validation"
+	content := fmt.Sprintf("profile %s {\n%s}\n", validationProfile, hat)
+	if err := os.WriteFile(filepath.Join(validationDir, validationProfile), []byte(content), 0600); err != nil {
+		return err
+	}
+	if err := m.forEachProfile(ctx, validationDir, []string{validationProfile}, "-QTK"); err != nil {
+		return err
+	}
+
+	// Write the hat atomically
+	if err := os.MkdirAll(usersDir, 0755); err != nil {
+		return err
+	}
+	// #nosec G306 - apparmor profiles are world readable
+	if err := os.WriteFile(hatPath+".new", []byte(hat), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(hatPath+".new", hatPath); err != nil {
+		return err
+	}
+
+	return m.reloadMachineProfiles(ctx)
+}
+
+// reloadMachineProfiles reloads all deployed machine profiles.
+func (m *Manager) reloadMachineProfiles(ctx context.Context) error {
+	machineDir := filepath.Join(m.apparmorDir, "machine")
+	profiles, err := listProfiles(machineDir)
+	if err != nil {
+		return err
+	}
+	return m.forEachProfile(ctx, machineDir, profiles, "-r")
+}
+
+// forEachProfile runs apparmor_parser with flag on each profile in dir.
+// Errors are reported per profile and don't prevent processing the other ones.
+func (m *Manager) forEachProfile(ctx context.Context, dir string, profiles []string, flag string) error {
+	var errMsgs []string
+	for _, p := range profiles {
+		args := append([]string{}, m.apparmorParserCmd...) // Copy apparmorParserCmd to prevent data race
+		args = append(args, flag, filepath.Join(dir, p))
+		// #nosec G204 - we control the input
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		smbsafe.WaitExec()
+		out, err := cmd.CombinedOutput()
+		smbsafe.DoneExec()
+		if err != nil {
+			msg := fmt.Sprintf(i18n.G("- error on %s: %v\n%s"), p, err, strings.TrimSpace(string(out)))
+			log.Warning(ctx, msg)
+			errMsgs = append(errMsgs, msg)
+		}
+	}
+	if errMsgs != nil {
+		return fmt.Errorf(i18n.G("apparmor_parser %s failed:\n%s"), flag, strings.Join(errMsgs, "\n"))
+	}
+	return nil
+}
+
+// listProfiles returns the sorted list of profiles, relative to dir.
+func listProfiles(dir string) (profiles []string, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
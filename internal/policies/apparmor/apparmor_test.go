@@ -0,0 +1,207 @@
+package apparmor_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/internal/policies/apparmor"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+var update bool
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	machineProfiles := "{GPOId}/Machine/Apparmor"
+	userProfiles := "{GPOId}/User/Apparmor"
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		isUser   bool
+		existing string
+
+		wantErr bool
+	}{
+		// machine cases
+		"machine, one profile": {entries: []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo"}}},
+		"machine, multiple profiles": {entries: []entry.Entry{{Key: "apparmor-machine", Value: strings.Join([]string{
+			machineProfiles + "/usr.bin.foo",
+			machineProfiles + "/nested/usr.bin.baz",
+			machineProfiles + "/usr.bin.bar"}, "\n")}}},
+		"machine, profile symlink in its apparmor directory": {entries: []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.link"}}},
+		"machine, duplicated profiles are loaded once": {entries: []entry.Entry{{Key: "apparmor-machine", Value: strings.Join([]string{
+			machineProfiles + "/usr.bin.foo",
+			machineProfiles + "/usr.bin.foo"}, "\n")}}},
+		"machine, user key is ignored": {entries: []entry.Entry{
+			{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo"},
+			{Key: "apparmor-users", Value: userProfiles + "/hat_foo"}}},
+		"machine, disabled entry loads nothing": {entries: []entry.Entry{
+			{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo", Disabled: true}}},
+		"machine, no entries loads nothing": {},
+		"machine, profiles not referenced anymore are unloaded": {
+			entries:  []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo"}},
+			existing: "machine"},
+		"machine, no entries unloads all profiles": {existing: "machine"},
+		"machine, unload failure does not prevent loading new profiles": {
+			entries:  []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo"}},
+			existing: "unload_fails"},
+
+		// user cases
+		"user, one hat": {entries: []entry.Entry{{Key: "apparmor-users", Value: userProfiles + "/hat_foo"}}, isUser: true},
+		"user, multiple profiles are merged in one hat": {entries: []entry.Entry{{Key: "apparmor-users", Value: strings.Join([]string{
+			userProfiles + "/hat_foo",
+			userProfiles + "/hat_bar"}, "\n")}}, isUser: true},
+		"user, machine key is ignored": {entries: []entry.Entry{
+			{Key: "apparmor-users", Value: userProfiles + "/hat_foo"},
+			{Key: "apparmor-machine", Value: machineProfiles + "/usr.bin.foo"}}, isUser: true},
+		"user, changed hat reloads machine profiles": {
+			entries:  []entry.Entry{{Key: "apparmor-users", Value: userProfiles + "/hat_bar"}},
+			isUser:   true,
+			existing: "user"},
+		"user, unchanged hat does not reload machine profiles": {
+			entries:  []entry.Entry{{Key: "apparmor-users", Value: userProfiles + "/hat_foo"}},
+			isUser:   true,
+			existing: "user"},
+		"user, no entries removes hat and reloads machine profiles": {isUser: true, existing: "user"},
+		"user, no entries and no hat does nothing":                  {isUser: true},
+
+		// error cases
+		"error on invalid machine profile keeps previous profiles": {
+			entries: []entry.Entry{{Key: "apparmor-machine", Value: strings.Join([]string{
+				machineProfiles + "/invalid",
+				machineProfiles + "/usr.bin.foo"}, "\n")}},
+			existing: "machine",
+			wantErr:  true},
+		"error on loading machine profile": {entries: []entry.Entry{{Key: "apparmor-machine", Value: strings.Join([]string{
+			machineProfiles + "/fail_load",
+			machineProfiles + "/usr.bin.foo"}, "\n")}}, wantErr: true},
+		"error on missing machine profile":      {entries: []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/doesnotexist"}}, wantErr: true},
+		"error on profile outside of gpo cache": {entries: []entry.Entry{{Key: "apparmor-machine", Value: "../../../etc/passwd"}}, wantErr: true},
+		"error on profile in another gpo": {entries: []entry.Entry{{Key: "apparmor-machine",
+			Value: machineProfiles + "/../../../{OtherGPOId}/Machine/Apparmor/usr.bin.other"}}, wantErr: true},
+		"error on profile symlink outside of its apparmor directory": {entries: []entry.Entry{{Key: "apparmor-machine", Value: machineProfiles + "/escaping_symlink"}}, wantErr: true},
+		"error on profile not in an apparmor directory":              {entries: []entry.Entry{{Key: "apparmor-machine", Value: "{GPOId}/Machine"}}, wantErr: true},
+		"error on invalid user hat keeps previous hat": {
+			entries:  []entry.Entry{{Key: "apparmor-users", Value: userProfiles + "/invalid_hat"}},
+			isUser:   true,
+			existing: "user",
+			wantErr:  true},
+		"error on missing user profile": {entries: []entry.Entry{{Key: "apparmor-users", Value: userProfiles + "/doesnotexist"}}, isUser: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rootDir := t.TempDir()
+			apparmorDir := filepath.Join(rootDir, "etc", "apparmor.d", "adsys")
+			callsFile := filepath.Join(rootDir, "apparmor_parser.calls")
+			// Always create the calls file so that the golden tree is never empty
+			require.NoError(t, os.WriteFile(callsFile, nil, 0600), "Setup: can't create apparmor_parser calls file")
+
+			if tc.existing != "" {
+				require.NoError(t,
+					shutil.CopyTree(filepath.Join("testdata", "existing", tc.existing), apparmorDir,
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't copy existing apparmor directory")
+			}
+
+			m, err := apparmor.New(
+				apparmor.WithApparmorDir(apparmorDir),
+				apparmor.WithGPOCacheDir(filepath.Join("testdata", "gpo_cache")),
+				apparmor.WithApparmorParserCmd(mockApparmorParserCmd(t, rootDir, callsFile)))
+			require.NoError(t, err, "Setup: can't create apparmor manager")
+
+			objectName := "hostname"
+			if tc.isUser {
+				objectName = "user@example.com"
+			}
+
+			err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			testutils.RemoveEmptyDirs(t, rootDir)
+
+			testutils.CompareTreesWithFiltering(t, rootDir, filepath.Join("testdata", "golden", name), update)
+		})
+	}
+}
+
+func TestMockApparmorParser(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 {
+		if args[0] != "--" {
+			args = args[1:]
+			continue
+		}
+		args = args[1:]
+		break
+	}
+
+	action, profile := args[0], args[1]
+
+	// Record call with a path independent of the test run
+	var p string
+	if rel, err := filepath.Rel(os.Getenv("ADSYS_TEST_ROOT"), profile); err == nil && !strings.HasPrefix(rel, "..") {
+		p = rel
+	} else {
+		p = filepath.Base(profile)
+	}
+	f, err := os.OpenFile(os.Getenv("ADSYS_TEST_CALLS_FILE"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open calls file: %v", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(f, "%s %s\n", action, p)
+	f.Close()
+
+	d, err := os.ReadFile(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't read profile: %v", err)
+		os.Exit(1)
+	}
+
+	failOn := map[string]string{
+		"-QTK": "INVALID",
+		"-r":   "FAIL_LOAD",
+		"-R":   "FAIL_UNLOAD",
+	}
+	if marker, ok := failOn[action]; ok && strings.Contains(string(d), marker) {
+		fmt.Fprintf(os.Stderr, "apparmor_parser %s failed on %s", action, p)
+		os.Exit(1)
+	}
+}
+
+func mockApparmorParserCmd(t *testing.T, rootDir, callsFile string) []string {
+	t.Helper()
+
+	return []string{"env", "GO_WANT_HELPER_PROCESS=1",
+		"ADSYS_TEST_ROOT=" + rootDir, "ADSYS_TEST_CALLS_FILE=" + callsFile,
+		os.Args[0], "-test.run=TestMockApparmorParser", "--"}
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
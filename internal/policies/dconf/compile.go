@@ -0,0 +1,277 @@
+package dconf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvariant"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvdb"
+)
+
+/*
+	Notes:
+	This replaces dconf update for a single system database <name>, as it does:
+	- every keyfile of db/<name>.d is read in file name order, later files overriding the same keys.
+	- every file of db/<name>.d/locks lists locked keys, one per line.
+	- values are parsed as GVariant text, and stored in the db/<name> GVDB file. Each key has its parent directories
+	  as parents, up to "/", and locks are stored in a nested ".locks" table.
+	The previous database is invalidated after the new one replaced it, which makes running dconf clients reload it.
+*/
+
+// compileDB compiles the keyfiles and locks of the system database name in dbsPath to its binary form.
+func compileDB(dbsPath, name string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't compile dconf database %s"), name)
+
+	dir := filepath.Join(dbsPath, name+".d")
+	values, err := readKeyfiles(dir)
+	if err != nil {
+		return err
+	}
+	locks, err := readLocks(filepath.Join(dir, "locks"))
+	if err != nil {
+		return err
+	}
+
+	table := gvdb.New()
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := gvariant.Parse(values[k], "")
+		if err != nil {
+			return fmt.Errorf(i18n.G("invalid value %q for %s: %v"), values[k], k, err)
+		}
+		item := table.Insert(k)
+		item.SetValue(v)
+		item.SetParent(parentItem(table, k))
+	}
+	if len(locks) > 0 {
+		locksTable := table.InsertTable(".locks")
+		for _, l := range locks {
+			locksTable.InsertString(l, "")
+		}
+	}
+
+	data, err := table.Bytes()
+	if err != nil {
+		return err
+	}
+	return replaceDB(filepath.Join(dbsPath, name), data)
+}
+
+// parentItem returns the item of the parent directory of key, creating it and its own parents if needed.
+func parentItem(table *gvdb.Table, key string) *gvdb.Item {
+	dir := key[:strings.LastIndex(strings.TrimSuffix(key, "/"), "/")+1]
+	if parent := table.Lookup(dir); parent != nil {
+		return parent
+	}
+
+	parent := table.Insert(dir)
+	if dir != "/" {
+		parent.SetParent(parentItem(table, dir))
+	}
+	return parent
+}
+
+// readKeyfiles returns the values of all keys of the keyfiles in dir, indexed by key path.
+func readKeyfiles(dir string) (values map[string]string, err error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	values = make(map[string]string)
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var group string
+		s := bufio.NewScanner(bytes.NewReader(content))
+		for n := 1; s.Scan(); n++ {
+			l := strings.TrimSpace(s.Text())
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+				group = strings.TrimSpace(l[1 : len(l)-1])
+				if group == "" || strings.HasPrefix(group, "/") || strings.HasSuffix(group, "/") || strings.Contains(group, "//") {
+					return nil, fmt.Errorf(i18n.G("%s:%d: invalid group [%s]"), path, n, group)
+				}
+				continue
+			}
+			i := strings.Index(l, "=")
+			if i < 0 || group == "" {
+				return nil, fmt.Errorf(i18n.G("%s:%d: expected a key=value line in a group, got %q"), path, n, l)
+			}
+			key := strings.TrimSpace(l[:i])
+			if key == "" || strings.Contains(key, "/") {
+				return nil, fmt.Errorf(i18n.G("%s:%d: invalid key %q"), path, n, key)
+			}
+			values["/"+group+"/"+key] = strings.TrimSpace(l[i+1:])
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// readLocks returns the sorted list of locked keys in the files of dir, which can be missing.
+func readLocks(dir string) (locks []string, err error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range strings.Split(string(content), "\n") {
+			l = strings.TrimSpace(l)
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			if !strings.HasPrefix(l, "/") || strings.HasSuffix(l, "/") || strings.Contains(l, "//") {
+				return nil, fmt.Errorf(i18n.G("%s: invalid lock %q"), path, l)
+			}
+			if _, ok := seen[l]; ok {
+				continue
+			}
+			seen[l] = struct{}{}
+			locks = append(locks, l)
+		}
+	}
+	sort.Strings(locks)
+
+	return locks, nil
+}
+
+// replaceDB atomically replaces the binary database at path with data, and invalidates the previous one.
+func replaceDB(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Database must be readable by everyone
+	// #nosec G302
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	// Keep the previous database open to invalidate it once replaced
+	old, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		if old != nil {
+			old.Close()
+		}
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	defer old.Close()
+
+	// Zeroing the header signals to clients which mapped the previous database that they need to reopen it
+	if _, err := old.WriteAt(make([]byte, 8), 0); err != nil {
+		return fmt.Errorf(i18n.G("can't invalidate previous database: %v"), err)
+	}
+	return nil
+}
+
+// removeDB removes the binary database at path, invalidating it first for the clients which mapped it.
+func removeDB(path string) error {
+	db, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.WriteAt(make([]byte, 8), 0); err != nil {
+		return fmt.Errorf(i18n.G("can't invalidate database: %v"), err)
+	}
+	return os.Remove(path)
+}
+
+// dbNeedsCompile returns true if the binary database name in dbsPath is missing or older than any of its keyfiles
+// or locks.
+func dbNeedsCompile(dbsPath, name string) (bool, error) {
+	info, err := os.Stat(filepath.Join(dbsPath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	compiled := info.ModTime()
+
+	dir := filepath.Join(dbsPath, name+".d")
+	for _, d := range []string{dir, filepath.Join(dir, "locks")} {
+		dirInfo, err := os.Stat(d)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		// The directory modification time changes when files are added, removed or renamed.
+		if dirInfo.ModTime().After(compiled) {
+			return true, nil
+		}
+		files, err := os.ReadDir(d)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range files {
+			fInfo, err := f.Info()
+			if err != nil {
+				return false, err
+			}
+			if fInfo.ModTime().After(compiled) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -6,20 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/godbus/dbus/v5"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvariant"
 	"github.com/ubuntu/adsys/internal/policies/entry"
-	"github.com/ubuntu/adsys/internal/smbsafe"
 )
 
 /*
@@ -41,24 +42,124 @@ import (
 	     -> the lock will "stick" the desired value to the layer of current value of Machine. As machine doesn’t have any
 		 value and is the lowest in the stack (the first one to be processed), this will thus enforce the default system
 		 configuration for that setting.
+
+	  4. Machine is configured but not enforced (value, no lock) -> this is only a default value: the user configuration
+	     and the user own changes, in upper layers, take precedence.
+
+	Keys which are not enforced are the same in the user database: they are a default value that the user can change.
 */
 
-// Manager prevents running multiple dconf update process in parallel while parsing policy in ApplyPolicy
+// Manager prevents compiling dconf databases in parallel, or while policies are written in ApplyPolicy
 type Manager struct {
 	dconfMu sync.RWMutex
 
-	dconfDir string
+	dconfDir   string
+	schemasDir string
+
+	// schemas are loaded once, and again only when the schemas directory changes.
+	schemasMu      sync.Mutex
+	schemas        *schemas
+	schemasModTime time.Time
+	schemasLoaded  bool
+}
+
+// Option is a functional option to personalize the manager.
+type Option func(*Manager)
+
+// WithSchemasDir specifies a personalized directory of gsettings schemas to check the policies against.
+func WithSchemasDir(dir string) Option {
+	return func(m *Manager) {
+		m.schemasDir = dir
+	}
 }
 
 // NewWithDconfDir creates a manager with a specific dconf directory
-func NewWithDconfDir(dir string) *Manager {
-	return &Manager{dconfDir: dir}
+func NewWithDconfDir(dir string, opts ...Option) *Manager {
+	m := &Manager{dconfDir: dir}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the dconf manager:
+// dconf policies are administrative templates.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
 }
 
 // ApplyPolicy generates a dconf computer or user policy based on a list of entries
 func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't apply dconf policy to %s"), objectName)
 
+	return m.applyKeyfile(ctx, objectName, isComputer, "adsys", entries)
+}
+
+// ApplyKeyfilePolicy generates a dconf computer or user policy in the keyfile and locks named keyfile of the database,
+// next to the adsys ones. This allows other policy managers to set dconf keys without overriding the dconf policy.
+func (m *Manager) ApplyKeyfilePolicy(ctx context.Context, objectName string, isComputer bool, keyfile string, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply dconf policy to %s in %s"), objectName, keyfile)
+
+	if keyfile == "" || keyfile == "adsys" || keyfile != filepath.Base(keyfile) {
+		return fmt.Errorf(i18n.G("invalid keyfile name %q"), keyfile)
+	}
+
+	// Without any entry, only reset a previously written keyfile: don't create an empty one and compile the database.
+	if len(entries) == 0 {
+		exists, err := m.keyfileExists(objectName, isComputer, keyfile)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Debugf(ctx, "No dconf policy to apply to %s in %s", objectName, keyfile)
+			return nil
+		}
+	}
+	return m.applyKeyfile(ctx, objectName, isComputer, keyfile, entries)
+}
+
+// keyfileExists returns if the keyfile or locks named keyfile exists in the database of objectName.
+func (m *Manager) keyfileExists(objectName string, isComputer bool, keyfile string) (bool, error) {
+	dconfDir := m.dconfDir
+	if dconfDir == "" {
+		dconfDir = consts.DefaultDconfDir
+	}
+	if isComputer {
+		objectName = "machine"
+	}
+	dbPath := filepath.Join(dconfDir, "db", objectName+".d")
+
+	m.dconfMu.RLock()
+	defer m.dconfMu.RUnlock()
+
+	for _, p := range []string{filepath.Join(dbPath, keyfile), filepath.Join(dbPath, "locks", keyfile)} {
+		_, err := os.Stat(p)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// KeyDefined returns if key is defined in the installed gsettings schemas.
+// Keys are always considered as defined if there is no schema to check them against.
+func (m *Manager) KeyDefined(ctx context.Context, key string) (bool, error) {
+	schemas, err := m.installedSchemas(ctx)
+	if err != nil {
+		return false, err
+	}
+	if schemas == nil {
+		return true, nil
+	}
+	return schemas.check(key, "", "", true) == nil, nil
+}
+
+// applyKeyfile writes the entries to the keyfile and locks named keyfile of the database of objectName, and compiles
+// the databases which need it.
+func (m *Manager) applyKeyfile(ctx context.Context, objectName string, isComputer bool, keyfile string, entries []entry.Entry) error {
 	dconfDir := m.dconfDir
 	if dconfDir == "" {
 		dconfDir = consts.DefaultDconfDir
@@ -82,6 +183,13 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		}
 	}
 
+	// Generate defaults and locks content from policy, before writing anything
+	defaults, locks, err := m.policyContent(ctx, entries)
+	if err != nil {
+		m.dconfMu.RUnlock()
+		return err
+	}
+
 	// Create profiles for users only
 	if !isComputer {
 		// Profile must be readable by everyone
@@ -96,33 +204,306 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		}
 	}
 
-	// Generate defaults and locks content from policy
+	var needsRefresh bool
+
+	// Commit on disk
+	// Locks must be readable by everyone
+	// #nosec G301
+	if err := os.MkdirAll(filepath.Join(dbPath, "locks"), 0755); err != nil {
+		m.dconfMu.RUnlock()
+		return err
+	}
+
+	defaultPath := filepath.Join(dbPath, keyfile)
+	changed, err := writeIfChanged(defaultPath, defaults)
+	if err != nil {
+		m.dconfMu.RUnlock()
+		return err
+	}
+	needsRefresh = needsRefresh || changed
+
+	locksPath := filepath.Join(dbPath, "locks", keyfile)
+	changed, err = writeIfChanged(locksPath, locks)
+	if err != nil {
+		m.dconfMu.RUnlock()
+		return err
+	}
+	needsRefresh = needsRefresh || changed
+
+	m.dconfMu.RUnlock()
+
+	// Compile the databases now that we released the read lock: the one of the policy if it changed, and the
+	// machine one for users, if it is missing or outdated.
+	m.dconfMu.Lock()
+	defer m.dconfMu.Unlock()
+	dbs := []string{objectName}
+	if !isComputer {
+		dbs = append(dbs, "machine")
+	}
+	for _, db := range dbs {
+		needsCompile := db == objectName && needsRefresh
+		if !needsCompile {
+			if needsCompile, err = dbNeedsCompile(dbsPath, db); err != nil {
+				return err
+			}
+		}
+		if !needsCompile {
+			continue
+		}
+		log.Debugf(ctx, "Compiling dconf database %s", db)
+		if err := compileDB(dbsPath, db); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PlanPolicy returns the changes ApplyPolicy would make for a list of entries, as unified diffs of the dconf
+// keyfile, locks and user profile against their current content on disk.
+// Nothing is written and an empty plan means that the policy is already applied.
+func (m *Manager) PlanPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (plan string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't plan dconf policy for %s"), objectName)
+
+	dconfDir := m.dconfDir
+	if dconfDir == "" {
+		dconfDir = consts.DefaultDconfDir
+	}
+
+	m.dconfMu.RLock()
+	defer m.dconfMu.RUnlock()
+
+	log.Debugf(ctx, "PlanPolicy dconf policy for %s", objectName)
+
+	if isComputer {
+		objectName = "machine"
+	}
+	dbPath := filepath.Join(dconfDir, "db", objectName+".d")
+
+	defaults, locks, err := m.policyContent(ctx, entries)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	// Profiles are only created for users
+	if !isComputer {
+		profilePath := filepath.Join(dconfDir, "profile", objectName)
+		content, err := readIfExists(profilePath)
+		if err != nil {
+			return "", err
+		}
+		diff, err := fileDiff(profilePath, content, profileContent(objectName, content))
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(diff)
+	}
+	for _, f := range []struct {
+		path    string
+		content string
+	}{
+		{filepath.Join(dbPath, "adsys"), defaults},
+		{filepath.Join(dbPath, "locks", "adsys"), locks},
+	} {
+		current, err := readIfExists(f.path)
+		if err != nil {
+			return "", err
+		}
+		diff, err := fileDiff(f.path, current, f.content)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(diff)
+	}
+
+	return out.String(), nil
+}
+
+// Purge removes the dconf artifacts adsys created for the user objectName: its keyfile and locks, its compiled
+// database if adsys was the only one to provide it, and the adsys databases from its profile.
+// Other keyfiles of the database and lines of the profile are left untouched.
+func (m *Manager) Purge(ctx context.Context, objectName string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't purge dconf policy of %s"), objectName)
+
+	if objectName == "machine" {
+		return errors.New(i18n.G("machine dconf database is shared by every user and can't be purged"))
+	}
+
+	dconfDir := m.dconfDir
+	if dconfDir == "" {
+		dconfDir = consts.DefaultDconfDir
+	}
+
+	m.dconfMu.Lock()
+	defer m.dconfMu.Unlock()
+
+	log.Debugf(ctx, "Purge dconf policy of %s", objectName)
+
+	dbsPath := filepath.Join(dconfDir, "db")
+	dbPath := filepath.Join(dbsPath, objectName+".d")
+	locksPath := filepath.Join(dbPath, "locks")
+
+	var needsRefresh bool
+	for _, p := range []string{filepath.Join(dbPath, "adsys"), filepath.Join(locksPath, "adsys")} {
+		if err := os.Remove(p); err == nil {
+			needsRefresh = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	// Only remove the directories if nothing else is left in them
+	for _, d := range []string{locksPath, dbPath} {
+		if err := removeIfEmpty(d); err != nil {
+			return err
+		}
+	}
+
+	// Recompile the database with the keyfiles left by others, or remove it if there is none.
+	if needsRefresh {
+		_, err := os.Stat(dbPath)
+		switch {
+		case err == nil:
+			log.Debugf(ctx, "Compiling dconf database %s", objectName)
+			if err := compileDB(dbsPath, objectName); err != nil {
+				return err
+			}
+		case errors.Is(err, os.ErrNotExist):
+			log.Debugf(ctx, "Remove dconf database %s", objectName)
+			if err := removeDB(filepath.Join(dbsPath, objectName)); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+
+	return purgeProfile(ctx, objectName, filepath.Join(dconfDir, "profile"))
+}
+
+// Users returns the users for which adsys wrote a dconf database or added its databases to their profile.
+// The machine and the gdm greeter databases are not user ones and are never listed.
+func (m *Manager) Users() (users []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list users with a dconf policy"))
+
+	dconfDir := m.dconfDir
+	if dconfDir == "" {
+		dconfDir = consts.DefaultDconfDir
+	}
+
+	m.dconfMu.RLock()
+	defer m.dconfMu.RUnlock()
+
+	found := make(map[string]struct{})
+	dbs, err := filepath.Glob(filepath.Join(dconfDir, "db", "*.d", "adsys"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range dbs {
+		found[strings.TrimSuffix(filepath.Base(filepath.Dir(p)), ".d")] = struct{}{}
+	}
+
+	profiles, err := os.ReadDir(filepath.Join(dconfDir, "profile"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, p := range profiles {
+		if p.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dconfDir, "profile", p.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !hasAdsysDBs(content) {
+			continue
+		}
+		found[p.Name()] = struct{}{}
+	}
+
+	delete(found, "machine")
+	delete(found, "gdm")
+	for u := range found {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// ExplainLock describes how the dconf lock of a key applied to an user or the machine behaves, depending on the
+// configuration its effective rule comes from, if this rule is disabled and if it is only a default value.
+// Every key set by adsys is locked unless its policy is not enforced, and machine locks take precedence over user ones.
+func ExplainLock(isComputer, fromMachine, disabled, defaultOnly bool) string {
+	// Disabled keys are locked even when not enforced
+	defaultOnly = defaultOnly && !disabled
+
+	switch {
+	case isComputer && disabled:
+		return i18n.G("dconf: locked without value in the machine database, the system default value is enforced.")
+	case isComputer && defaultOnly:
+		return i18n.G("dconf: not enforced, default value in the machine database: users and user policies can change it.")
+	case isComputer:
+		return i18n.G("dconf: locked in the machine database, users can't change it.")
+	case fromMachine && disabled:
+		return i18n.G("dconf: locked without value by the machine configuration, the system default value is enforced and the user configuration is ignored.")
+	case fromMachine && defaultOnly:
+		return i18n.G("dconf: not enforced, default value from the machine configuration: the user configuration and the user own changes take precedence.")
+	case fromMachine:
+		return i18n.G("dconf: locked by the machine configuration, the user configuration is ignored.")
+	case disabled:
+		return i18n.G("dconf: not configured on the machine, locked without value in the user database: the system default value is enforced.")
+	case defaultOnly:
+		return i18n.G("dconf: not enforced, default value in the user database: the user can change it.")
+	default:
+		return i18n.G("dconf: not configured on the machine, locked in the user database: the user can't change it.")
+	}
+}
+
+// policyContent returns the content of the dconf keyfile and locks file for a list of entries.
+// It fails if any entry is not defined in the installed gsettings schemas, or if any enabled entry does not match its
+// gsettings signature or the constraints of its schema.
+func (m *Manager) policyContent(ctx context.Context, entries []entry.Entry) (defaults, locks string, err error) {
+	schemas, err := m.installedSchemas(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
 	dataWithGroups := make(map[string][]string)
-	var locks []string
+	var lockedKeys []string
 	var errMsgs []string
 	for _, e := range entries {
 		log.Debugf(ctx, "Analyzing entry %+v", e)
 
 		if !e.Disabled {
-			section := filepath.Dir(e.Key)
-
 			// normalize common user error cases and check gsettings schema signature match.
 			e.Value = normalizeValue(e.Meta, e.Value)
 			if err := checkSignature(e.Meta, e.Value); err != nil {
 				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
 				continue
 			}
+		}
+		if schemas != nil {
+			if err := schemas.check(e.Key, e.Meta, e.Value, e.Disabled); err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
+				continue
+			}
+		}
 
+		if !e.Disabled {
+			section := filepath.Dir(e.Key)
 			l := fmt.Sprintf("%s=%s", filepath.Base(e.Key), e.Value)
 			dataWithGroups[section] = append(dataWithGroups[section], l)
 		}
-		locks = append(locks, "/"+e.Key)
+		// Disabled keys are always locked to enforce the system default value
+		if e.DefaultOnly && !e.Disabled {
+			continue
+		}
+		lockedKeys = append(lockedKeys, "/"+e.Key)
 	}
 
 	// Stop on any error
 	if errMsgs != nil {
-		m.dconfMu.RUnlock()
-		return errors.New(strings.Join(errMsgs, "\n"))
+		return "", "", errors.New(strings.Join(errMsgs, "\n"))
 	}
 
 	// Prepare file contents
@@ -138,56 +519,41 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		data = append(data, dataWithGroups[s]...)
 	}
 
-	var needsRefresh bool
+	return strings.Join(data, "\n") + "\n", strings.Join(lockedKeys, "\n") + "\n", nil
+}
 
-	// Commit on disk
-	// Locks must be readable by everyone
-	// #nosec G301
-	if err := os.MkdirAll(filepath.Join(dbPath, "locks"), 0755); err != nil {
-		m.dconfMu.RUnlock()
-		return err
+// fileDiff returns an unified diff between the current content of path and its planned content.
+// A nil current content is a file to create. It returns an empty string if there is no change.
+func fileDiff(path string, current []byte, planned string) (string, error) {
+	if current != nil && string(current) == planned {
+		return "", nil
 	}
 
-	defaultPath := filepath.Join(dbPath, "adsys")
-	changed, err := writeIfChanged(defaultPath, strings.Join(data, "\n")+"\n")
-	if err != nil {
-		m.dconfMu.RUnlock()
-		return err
+	fromFile := path
+	if current == nil {
+		fromFile = "/dev/null"
 	}
-	needsRefresh = needsRefresh || changed
-
-	locksPath := filepath.Join(dbPath, "locks", "adsys")
-	changed, err = writeIfChanged(locksPath, strings.Join(locks, "\n")+"\n")
-	if err != nil {
-		m.dconfMu.RUnlock()
-		return err
-	}
-	needsRefresh = needsRefresh || changed
-
-	m.dconfMu.RUnlock()
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(current)),
+		B:        splitLines(planned),
+		FromFile: fromFile,
+		ToFile:   path,
+		Context:  3,
+	})
+}
 
-	// update if any profile changed, or if any compiled db is missing
-	needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(dbsPath, "machine"))
-	if !isComputer {
-		needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(dbsPath, objectName))
-	}
-	if !needsRefresh {
+// splitLines splits s in lines, each of them ending with a newline.
+func splitLines(s string) []string {
+	if s == "" {
 		return nil
 	}
-
-	// request an update now that we released the read lock
-	// we will call update multiple times.
-	smbsafe.WaitExec()
-	m.dconfMu.Lock()
-	// #nosec G204 - we control the input
-	out, errExec := exec.Command("dconf", "update", filepath.Join(dconfDir, "db")).CombinedOutput()
-	m.dconfMu.Unlock()
-	smbsafe.DoneExec()
-	if errExec != nil {
-		err = fmt.Errorf(i18n.G("dconf update failed: %v"), out)
+	lines := strings.SplitAfter(s, "\n")
+	if last := lines[len(lines)-1]; last == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] = last + "\n"
 	}
-
-	return nil
+	return lines
 }
 
 // writeIfChanged will only write to path if content is different from current content.
@@ -217,16 +583,39 @@ func writeProfile(ctx context.Context, user, profilesPath string) (err error) {
 	profilePath := filepath.Join(profilesPath, user)
 	log.Debugf(ctx, "Update user profile %s", profilePath)
 
+	// Read existing content and create file if doesn’t exists
+	content, err := readIfExists(profilePath)
+	if err != nil {
+		return err
+	}
+	newContent := profileContent(user, content)
+	if content == nil {
+		return os.WriteFile(profilePath, []byte(newContent), 0644)
+	}
+
+	// Is file already up to date?
+	if string(content) == newContent {
+		return nil
+	}
+
+	// Otherwise, update the file.
+	if err := os.WriteFile(profilePath+".adsys.new", []byte(newContent), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(profilePath+".adsys.new", profilePath); err != nil {
+		return err
+	}
+	return nil
+}
+
+// profileContent returns the profile of user with the adsys databases appended, from its current content.
+// A nil content means that the profile does not exist yet.
+func profileContent(user string, content []byte) string {
 	adsysMachineDB := "system-db:machine"
 	adsysUserDB := fmt.Sprintf("system-db:%s", user)
 
-	// Read existing content and create file if doesn’t exists
-	content, err := os.ReadFile(profilePath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-		return os.WriteFile(profilePath, []byte(fmt.Sprintf("user-db:user\n%s\n%s", adsysUserDB, adsysMachineDB)), 0644)
+	if content == nil {
+		return fmt.Sprintf("user-db:user\n%s\n%s", adsysUserDB, adsysMachineDB)
 	}
 
 	// Read file to insert them at the end, removing duplicates
@@ -240,33 +629,94 @@ func writeProfile(ctx context.Context, user, profilesPath string) (err error) {
 	}
 	out = append(out, adsysUserDB, adsysMachineDB)
 
-	newContent := []byte(strings.Join(out, "\n"))
+	return strings.Join(out, "\n")
+}
 
-	// Is file already up to date?
-	if string(content) == string(newContent) {
+// purgeProfile removes the adsys databases from the profile of user, keeping any other line.
+// The profile is deleted if only the default user database is left, as it was created by adsys.
+func purgeProfile(ctx context.Context, user, profilesPath string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't purge user profile %s"), profilesPath)
+
+	profilePath := filepath.Join(profilesPath, user)
+	content, err := readIfExists(profilePath)
+	if err != nil || content == nil {
+		return err
+	}
+	// Profiles without the machine database were not written by adsys, even if they list a system-db of the same
+	// name, like the gdm one.
+	if !hasAdsysDBs(content) {
 		return nil
 	}
 
-	// Otherwise, update the file.
-	if err := os.WriteFile(profilePath+".adsys.new", newContent, 0644); err != nil {
-		return err
+	var out []string
+	for _, d := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+		if isAdsysDB(user, string(d)) {
+			continue
+		}
+		out = append(out, string(d))
 	}
-	if err := os.Rename(profilePath+".adsys.new", profilePath); err != nil {
+	newContent := strings.TrimSpace(strings.Join(out, "\n"))
+
+	if newContent == "" || newContent == "user-db:user" {
+		log.Debugf(ctx, "Remove user profile %s", profilePath)
+		return os.Remove(profilePath)
+	}
+	if newContent == string(bytes.TrimSpace(content)) {
+		return nil
+	}
+
+	log.Debugf(ctx, "Remove adsys databases from user profile %s", profilePath)
+	if err := os.WriteFile(profilePath+".adsys.new", []byte(newContent), 0644); err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(profilePath+".adsys.new", profilePath)
 }
 
-// dconfNeedsUpdate will notify if we need to run dconf update for that binary database.
-// For now, it only checks its existence.
-func dconfNeedsUpdate(path string) bool {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return true
-	}
+// isAdsysDB returns true if the profile line is one of the databases adsys appends to the profile of user.
+func isAdsysDB(user, line string) bool {
+	return line == "system-db:machine" || line == fmt.Sprintf("system-db:%s", user)
+}
 
+// hasAdsysDBs returns true if the profile content references the adsys machine database, which is always added
+// with the user one.
+func hasAdsysDBs(content []byte) bool {
+	for _, d := range bytes.Split(content, []byte("\n")) {
+		if string(bytes.TrimSpace(d)) == "system-db:machine" {
+			return true
+		}
+	}
 	return false
 }
 
+// removeIfEmpty removes the directory path if it exists and is empty.
+func removeIfEmpty(path string) error {
+	files, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// readIfExists returns the content of path, or nil if path does not exist.
+func readIfExists(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	return content, nil
+}
+
 // normalizeValue simplify user entry by handling common mistakes on key types
 func normalizeValue(keyType, value string) string {
 	value = strings.TrimSpace(value)
@@ -283,11 +733,147 @@ func normalizeValue(keyType, value string) string {
 		return normalizeAIVariant(value)
 	}
 
+	return normalizeGVariant(keyType, value)
+}
+
+// normalizeGVariant returns value in the GVariant text format, annotated so that dconf stores it as keyType.
+// Values which are already correct are kept as is. Missing delimiters and quotes in containers are added.
+// Values which can't be fixed are returned unmodified, to be reported by checkSignature.
+func normalizeGVariant(keyType, value string) string {
+	if gvariant.ValidType(keyType) != nil {
+		return value
+	}
+	if v, err := gvariant.Parse(value, ""); err == nil && v.Signature().String() == keyType {
+		return value
+	}
+
+	v, err := gvariant.Parse(value, keyType)
+	if err != nil {
+		if v, err = gvariant.Parse(fixGVariant(keyType, value), keyType); err != nil {
+			return value
+		}
+	}
+	return gvariant.Print(v)
+}
+
+// fixGVariant adds the missing delimiters of containers and quotes of strings to value of type keyType.
+// Elements of arrays and dictionaries can be separated by commas or line breaks.
+func fixGVariant(keyType, value string) string {
+	value = strings.TrimSpace(value)
+	switch keyType[0] {
+	case 's':
+		return quoteValue(value)
+	case 'b':
+		return normalizeBoolean(value)
+	case 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h', 'd':
+		return strings.ReplaceAll(strings.ReplaceAll(value, `"`, ""), "'", "")
+	case 'v':
+		if strings.HasPrefix(value, "<") {
+			return value
+		}
+		return "<" + value + ">"
+	case '(':
+		types := gvariant.MemberTypes(keyType)
+		members := splitTopLevel(trimDelimiters(value, '(', ')'), ",", -1)
+		if len(members) != len(types) {
+			return value
+		}
+		for i := range members {
+			members[i] = fixGVariant(types[i], members[i])
+		}
+		if len(members) == 1 {
+			return "(" + members[0] + ",)"
+		}
+		return "(" + strings.Join(members, ", ") + ")"
+	case 'a':
+		elem := keyType[1:]
+		if elem[0] == '{' {
+			entry := gvariant.MemberTypes(elem)
+			var entries []string
+			for _, e := range splitTopLevel(trimDelimiters(value, '{', '}'), ",\n", -1) {
+				if e == "" {
+					continue
+				}
+				kv := splitTopLevel(e, ":", 2)
+				if len(kv) != 2 {
+					return value
+				}
+				entries = append(entries, fixGVariant(entry[0], kv[0])+": "+fixGVariant(entry[1], kv[1]))
+			}
+			return "{" + strings.Join(entries, ", ") + "}"
+		}
+
+		inner := trimDelimiters(value, '[', ']')
+		seps := ",\n"
+		// Containers elements have their own commas: without delimiters, only line breaks separate them
+		if strings.ContainsAny(elem[:1], "(a") {
+			for _, e := range splitTopLevel(inner, ",\n", -1) {
+				if e != "" && !strings.HasPrefix(e, map[byte]string{'(': "(", 'a': "["}[elem[0]]) {
+					seps = "\n"
+					break
+				}
+			}
+		}
+		var elems []string
+		for _, e := range splitTopLevel(inner, seps, -1) {
+			if e == "" {
+				continue
+			}
+			elems = append(elems, fixGVariant(elem, e))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+
 	return value
 }
 
+// trimDelimiters removes the opening and closing delimiters around v, if they enclose the whole value.
+func trimDelimiters(v string, opening, closing byte) string {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 || v[0] != opening || v[len(v)-1] != closing {
+		return v
+	}
+	if parts := splitTopLevel(v[1:], string(closing), 2); len(parts) < 2 || parts[1] != "" {
+		// the opening delimiter is closed before the end, as in "(a), (b)"
+		return v
+	}
+	return v[1 : len(v)-1]
+}
+
+// splitTopLevel splits v on any of the characters of seps which are not in a quoted string or a nested container.
+// Each part is trimmed of surrounding spaces. As with strings.SplitN, n < 0 returns all parts, otherwise at most n.
+func splitTopLevel(v, seps string, n int) []string {
+	var parts []string
+	var depth int
+	var quote rune
+	var escaped bool
+	start := 0
+	for i, c := range v {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.ContainsRune(seps, c) && depth == 0 && (n < 0 || len(parts) < n-1):
+			parts = append(parts, strings.TrimSpace(v[start:i]))
+			start = i + 1
+		case strings.ContainsRune("([{<", c):
+			depth++
+		case strings.ContainsRune(")]}>", c):
+			depth--
+		}
+	}
+	return append(parts, strings.TrimSpace(v[start:]))
+}
+
 // quoteValue ensures the string starts and ends with ' in s.
-// We will escape each non leading character in s.
+// We will escape each non leading character in s, and line breaks to keep the value on one keyfile line.
 func quoteValue(s string) string {
 	// quote automatically single quote
 	if s == "'" {
@@ -297,6 +883,7 @@ func quoteValue(s string) string {
 	}
 
 	s = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "'"), "'")
+	s = strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(s)
 	return fmt.Sprintf("'%s'", strings.Join(splitOnNonEscaped(s, "'"), `\'`))
 }
 
@@ -407,12 +994,10 @@ func checkSignature(meta, value string) (err error) {
 		return fmt.Errorf(i18n.G("empty signature for %v"), meta)
 	}
 
-	sig, err := dbus.ParseSignature(meta)
-	if err != nil {
+	if err := gvariant.ValidType(meta); err != nil {
 		return fmt.Errorf(i18n.G("%s is not a valid gsettings signature: %v"), meta, err)
 	}
-	_, err = dbus.ParseVariant(value, sig)
-	if err != nil {
+	if _, err := gvariant.Parse(value, meta); err != nil {
 		return fmt.Errorf(i18n.G("can't parse %q as %q: %v"), value, meta, err)
 	}
 
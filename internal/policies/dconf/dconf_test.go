@@ -2,10 +2,13 @@ package dconf_test
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
@@ -23,6 +26,8 @@ func TestApplyPolicy(t *testing.T) {
 		isComputer       bool
 		entries          []entry.Entry
 		existingDconfDir string
+		schemasDir       string
+		keyfile          string
 
 		wantErr bool
 	}{
@@ -101,6 +106,19 @@ func TestApplyPolicy(t *testing.T) {
 			{Key: "com/ubuntu/category/key-ai", Value: "[42]", Meta: "ai"},
 			{Key: "com/ubuntu/category/key-returnedunmodified", Value: "[[1,2,3],[4,5,6]]", Meta: "aai"},
 		}},
+		"normalized canonical form for other gvariant types": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-u", Value: "42", Meta: "u"},
+			{Key: "com/ubuntu/category/key-t", Value: "'42'", Meta: "t"},
+			{Key: "com/ubuntu/category/key-d", Value: "2", Meta: "d"},
+			{Key: "com/ubuntu/category/key-tuple", Value: "1, 2", Meta: "(ii)"},
+			{Key: "com/ubuntu/category/key-sources", Value: "xkb, us\nxkb, fr", Meta: "a(ss)"},
+			{Key: "com/ubuntu/category/key-dict", Value: "a: b\nc: d", Meta: "a{ss}"},
+			{Key: "com/ubuntu/category/key-dictv", Value: "a: 1, b: 'c'", Meta: "a{sv}"},
+		}},
+		"empty containers are annotated": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-sources", Value: "", Meta: "a(ss)"},
+			{Key: "com/ubuntu/category/key-dictv", Value: "{}", Meta: "a{sv}"},
+		}},
 
 		// help users with quoting, normalizing… (common use cases here: more tests in internal_tests)
 		"unquoted string": {entries: []entry.Entry{
@@ -143,6 +161,18 @@ func TestApplyPolicy(t *testing.T) {
 			{Key: "com/ubuntu/category/key-ai", Value: "1,2\n3\n", Meta: "ai"},
 		}},
 
+		// Enforce option
+		"keys not enforced are not locked": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s'", Meta: "s", DefaultOnly: true},
+			{Key: "com/ubuntu/category/key-i", Value: "42", Meta: "i"},
+		}},
+		"keys not enforced are not locked on machine": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s'", Meta: "s", DefaultOnly: true},
+		}, isComputer: true},
+		"disabled keys not enforced are locked": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Disabled: true, Meta: "s", DefaultOnly: true},
+		}},
+
 		// Profiles tests
 		"update existing correct profile stays unchanged": {entries: nil,
 			existingDconfDir: "existing-user"},
@@ -171,7 +201,37 @@ func TestApplyPolicy(t *testing.T) {
 			{Key: "com/ubuntu/category/key-as", Value: `[value1, ] value2]`, Meta: "as"},
 		}},
 
+		// Schemas constraints
+		"value in schema range": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "100", Meta: "i"},
+			{Key: "com/ubuntu/category/key-u", Value: "10", Meta: "u"},
+			{Key: "com/ubuntu/category/key-d", Value: "0.5", Meta: "d"},
+		}},
+		"valid schema choices": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-choices", Value: "second", Meta: "s"},
+			{Key: "com/ubuntu/category/key-enum", Value: "manual", Meta: "s"},
+			{Key: "com/ubuntu/category/key-flags", Value: "feature-a, feature-b", Meta: "as"},
+		}},
+		"relocatable schema key on any path": {entries: []entry.Entry{
+			{Key: "com/ubuntu/relocatable/custom0/binding", Value: "'<Super>b'", Meta: "s"},
+		}},
+		"unknown keys are accepted without installed schemas": {entries: []entry.Entry{
+			{Key: "com/ubuntu/unknown/key-s", Value: "'onekey-s'", Meta: "s"},
+		}, schemasDir: "-"},
+
+		// Other keyfiles
+		"machine policy in another keyfile": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}},
+			isComputer: true, keyfile: "other"},
+		"user policy in another keyfile keeps adsys one": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "42", Meta: "i"}},
+			existingDconfDir: "existing-user", keyfile: "other"},
+		"no entries in a new keyfile writes nothing":  {isComputer: true, keyfile: "other"},
+		"no entries resets an existing other keyfile": {isComputer: true, existingDconfDir: "machine-with-other-keyfile", keyfile: "other"},
+
 		// Error cases
+		"error on keyfile name with directory": {keyfile: "../other", wantErr: true},
+		"error on adsys keyfile name":          {keyfile: "adsys", wantErr: true},
 		"no machine db will fail": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"},
 		}, existingDconfDir: "-", wantErr: true},
@@ -181,12 +241,52 @@ func TestApplyPolicy(t *testing.T) {
 		"error on invalid value for unnormalized type": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-i", Value: "NaN", Meta: "i"},
 		}, wantErr: true},
+		"error on invalid tuple": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-tuple", Value: "(1, 2, 3)", Meta: "(ii)"},
+		}, wantErr: true},
+		"error on invalid dictionary": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-dict", Value: "{'a': 'b', 'c'}", Meta: "a{ss}"},
+		}, wantErr: true},
 		"error on invalid type": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-something", Value: "value", Meta: "sometype"},
 		}, wantErr: true},
 		"error on empty meta": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-something", Value: "value", Meta: ""},
 		}, wantErr: true},
+		"error on key not in schemas": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s'", Meta: "s"},
+			{Key: "com/ubuntu/unknown/key-s", Value: "'onekey-s'", Meta: "s"},
+		}, wantErr: true},
+		"error on disabled key not in schemas": {entries: []entry.Entry{
+			{Key: "com/ubuntu/unknown/key-s", Disabled: true, Meta: "s"},
+		}, wantErr: true},
+		"error on type different from schema": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "42", Meta: "u"},
+		}, wantErr: true},
+		"error on value lower than schema range": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-u", Value: "9", Meta: "u"},
+		}, wantErr: true},
+		"error on value greater than schema range": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-d", Value: "2.6", Meta: "d"},
+		}, wantErr: true},
+		"error on invalid schema choice": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-choices", Value: "third", Meta: "s"},
+		}, wantErr: true},
+		"error on invalid schema enum value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "automatic", Meta: "s"},
+		}, wantErr: true},
+		"error on invalid schema flag": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-flags", Value: "feature-a, feature-c", Meta: "as"},
+		}, wantErr: true},
+		"error on invalid relocatable schema key value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/relocatable/custom0/binding", Value: "[1]", Meta: "ai"},
+		}, wantErr: true},
+		"error on invalid schema file": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s'", Meta: "s"},
+		}, schemasDir: "schemas-invalid", wantErr: true},
+		"error on schema with missing enum": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s'", Meta: "s"},
+		}, schemasDir: "schemas-missing-enum", wantErr: true},
 	}
 
 	for name, tc := range tests {
@@ -209,10 +309,25 @@ func TestApplyPolicy(t *testing.T) {
 					"Setup: can't create initial dconf directory")
 			}
 
-			m := dconf.NewWithDconfDir(dconfDir)
-			err := m.ApplyPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			if tc.schemasDir == "" {
+				tc.schemasDir = "schemas"
+			}
+			schemasDir := filepath.Join("testdata", tc.schemasDir)
+			if tc.schemasDir == "-" {
+				schemasDir = t.TempDir()
+			}
+
+			m := dconf.NewWithDconfDir(dconfDir, dconf.WithSchemasDir(schemasDir))
+			var err error
+			if tc.keyfile != "" {
+				err = m.ApplyKeyfilePolicy(context.Background(), "ubuntu", tc.isComputer, tc.keyfile, tc.entries)
+			} else {
+				err = m.ApplyPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			}
 			if tc.wantErr {
 				require.NotNil(t, err, "ApplyPolicy should have failed but didn't")
+				_, err = os.Stat(filepath.Join(dconfDir, "profile", "ubuntu"))
+				require.ErrorIs(t, err, os.ErrNotExist, "ApplyPolicy should not write anything on failure")
 				return
 			}
 			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
@@ -222,6 +337,381 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
+func TestKeyDefined(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		key        string
+		schemasDir string
+
+		want    bool
+		wantErr bool
+	}{
+		"key in schema":                      {key: "com/ubuntu/category/key-s", want: true},
+		"relocatable key on any path":        {key: "com/ubuntu/relocatable/custom0/binding", want: true},
+		"key not in schema":                  {key: "com/ubuntu/unknown/key-s"},
+		"any key is defined without schemas": {key: "com/ubuntu/unknown/key-s", schemasDir: "-", want: true},
+		"error on invalid schema file":       {key: "com/ubuntu/category/key-s", schemasDir: "schemas-invalid", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.schemasDir == "" {
+				tc.schemasDir = "schemas"
+			}
+			schemasDir := filepath.Join("testdata", tc.schemasDir)
+			if tc.schemasDir == "-" {
+				schemasDir = t.TempDir()
+			}
+
+			m := dconf.NewWithDconfDir(t.TempDir(), dconf.WithSchemasDir(schemasDir))
+			got, err := m.KeyDefined(context.Background(), tc.key)
+			if tc.wantErr {
+				require.Error(t, err, "KeyDefined should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "KeyDefined failed but shouldn't have")
+			require.Equal(t, tc.want, got, "KeyDefined returns expected result")
+		})
+	}
+}
+
+func TestSchemasAreReloadedOnChange(t *testing.T) {
+	t.Parallel()
+
+	schemasDir := t.TempDir()
+	src := filepath.Join("testdata", "schemas", "com.ubuntu.category.gschema.xml")
+	dst := filepath.Join(schemasDir, "com.ubuntu.category.gschema.xml")
+	for _, f := range []string{"com.ubuntu.enums.gschema.xml", "com.ubuntu.relocatable.gschema.xml"} {
+		require.NoError(t, shutil.CopyFile(filepath.Join("testdata", "schemas", f), filepath.Join(schemasDir, f), false),
+			"Setup: can't copy schema")
+	}
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(schemasDir, past, past), "Setup: can't change schemas directory time")
+
+	m := dconf.NewWithDconfDir(t.TempDir(), dconf.WithSchemasDir(schemasDir))
+	got, err := m.KeyDefined(context.Background(), "com/ubuntu/category/key-s")
+	require.NoError(t, err, "KeyDefined failed but shouldn't have")
+	require.False(t, got, "Key should not be defined before its schema is installed")
+
+	// Loaded schemas are kept while the directory doesn't change
+	require.NoError(t, shutil.CopyFile(src, dst, false), "Setup: can't copy schema")
+	require.NoError(t, os.Chtimes(schemasDir, past, past), "Setup: can't change schemas directory time")
+	got, err = m.KeyDefined(context.Background(), "com/ubuntu/category/key-s")
+	require.NoError(t, err, "KeyDefined failed but shouldn't have")
+	require.False(t, got, "Schemas should not be reloaded if the directory didn't change")
+
+	// Installing a schema changes the directory
+	now := time.Now()
+	require.NoError(t, os.Chtimes(schemasDir, now, now), "Setup: can't change schemas directory time")
+	got, err = m.KeyDefined(context.Background(), "com/ubuntu/category/key-s")
+	require.NoError(t, err, "KeyDefined failed but shouldn't have")
+	require.True(t, got, "Key should be defined once its schema is installed")
+}
+
+func TestPlanPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		isComputer       bool
+		entries          []entry.Entry
+		existingDconfDir string
+
+		wantErr bool
+	}{
+		"new user":                      {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}}},
+		"new user without machine db":   {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}}, existingDconfDir: "-"},
+		"user updates existing value":   {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-thirdvalue'", Meta: "s"}}, existingDconfDir: "existing-user"},
+		"user key is now disabled":      {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Disabled: true, Meta: "s"}}, existingDconfDir: "existing-user"},
+		"user profile without adsys db": {entries: nil, existingDconfDir: "existing-user-no-adsysdb"},
+		"first boot": {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}},
+			isComputer: true, existingDconfDir: "-"},
+		"machine updates existing value": {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-thirdvalue'", Meta: "s"}},
+			isComputer: true},
+		"normalized values are planned": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "onekey-s", Meta: "s"},
+			{Key: "com/ubuntu/category/key-as", Value: "first\nsecond\n", Meta: "as"},
+		}, isComputer: true},
+
+		"no change when policy is already applied": {entries: []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}},
+			existingDconfDir: "existing-user"},
+
+		// Error cases
+		"error on invalid value":                   {entries: []entry.Entry{{Key: "com/ubuntu/category/key-i", Value: "NaN", Meta: "i"}}, wantErr: true},
+		"error on value greater than schema range": {entries: []entry.Entry{{Key: "com/ubuntu/category/key-i", Value: "101", Meta: "i"}}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dconfDir := t.TempDir()
+
+			if tc.existingDconfDir == "" {
+				tc.existingDconfDir = "machine-base"
+			}
+			if tc.existingDconfDir != "-" {
+				require.NoError(t, os.Remove(dconfDir), "Setup: can't delete dconf base directory before recreation")
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join("testdata", "dconf", tc.existingDconfDir), dconfDir,
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't create initial dconf directory")
+			}
+			m := dconf.NewWithDconfDir(dconfDir, dconf.WithSchemasDir(filepath.Join("testdata", "schemas")))
+			got, err := m.PlanPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			if tc.wantErr {
+				require.NotNil(t, err, "PlanPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "PlanPolicy failed but shouldn't have")
+
+			got = strings.ReplaceAll(got, dconfDir, "/etc/dconf")
+			goldPath := filepath.Join("testdata", "golden_plan", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load plan golden file")
+			require.Equal(t, string(want), got, "PlanPolicy returned expected plan")
+
+			if tc.existingDconfDir == "-" {
+				files, err := os.ReadDir(dconfDir)
+				require.NoError(t, err, "Can't read dconf directory")
+				require.Empty(t, files, "PlanPolicy should not write anything")
+				return
+			}
+
+			// Applying the policy should lead to the planned state
+			err = m.ApplyPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			got, err = m.PlanPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			require.NoError(t, err, "PlanPolicy failed but shouldn't have")
+			require.Empty(t, got, "PlanPolicy should plan no change once the policy is applied")
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		objectName       string
+		existingDconfDir string
+
+		wantErr bool
+	}{
+		"purge user db and profile":             {existingDconfDir: "existing-user"},
+		"keep other keyfiles and recompile db":  {existingDconfDir: "existing-user-with-extra-files"},
+		"keep non adsys lines in profile":       {existingDconfDir: "existing-user-one-adsysdb-middle"},
+		"do not interfere with other user":      {existingDconfDir: "existing-other-user"},
+		"nothing to purge":                      {existingDconfDir: "machine-base"},
+		"nothing to purge without dconf dir":    {existingDconfDir: "-"},
+		"do not touch profile not set by adsys": {objectName: "gdm", existingDconfDir: "existing-non-adsys-profile"},
+
+		// Error cases
+		"error on purging machine": {objectName: "machine", existingDconfDir: "machine-base", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dconfDir := filepath.Join(t.TempDir(), "dconf")
+			if tc.existingDconfDir != "-" {
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join("testdata", "dconf", tc.existingDconfDir), dconfDir,
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't create initial dconf directory")
+			}
+			if tc.objectName == "" {
+				tc.objectName = "ubuntu"
+			}
+
+			m := dconf.NewWithDconfDir(dconfDir)
+			err := m.Purge(context.Background(), tc.objectName)
+			if tc.wantErr {
+				require.NotNil(t, err, "Purge should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Purge failed but shouldn't have")
+
+			if tc.existingDconfDir == "-" {
+				require.NoDirExists(t, dconfDir, "Purge should not create the dconf directory")
+				return
+			}
+			testutils.RemoveEmptyDirs(t, dconfDir)
+			testutils.CompareTreesWithFiltering(t, dconfDir, filepath.Join("testdata", "golden_purge", name), update)
+
+			// Compiled databases are filtered from the golden files: check that it was removed with its keyfiles
+			if _, err := os.Stat(filepath.Join(dconfDir, "db", tc.objectName+".d")); errors.Is(err, os.ErrNotExist) {
+				_, err = os.Stat(filepath.Join(dconfDir, "db", tc.objectName))
+				require.ErrorIs(t, err, os.ErrNotExist, "Compiled database should be removed with its keyfiles")
+			}
+		})
+	}
+}
+
+func TestUsers(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		existingDconfDir string
+
+		want []string
+	}{
+		"user with db and profile":           {existingDconfDir: "existing-user", want: []string{"ubuntu"}},
+		"multiple users":                     {existingDconfDir: "existing-other-user", want: []string{"otheruser", "ubuntu"}},
+		"user with only adsys profile lines": {existingDconfDir: "existing-user-one-adsysdb-middle", want: []string{"ubuntu"}},
+		"gdm greeter is not an user":         {existingDconfDir: "existing-user-and-gdm", want: []string{"ubuntu"}},
+		"machine only":                       {existingDconfDir: "machine-base"},
+		"profile not set by adsys":           {existingDconfDir: "existing-non-adsys-profile"},
+		"no dconf dir":                       {existingDconfDir: "-"},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dconfDir := filepath.Join("testdata", "dconf", tc.existingDconfDir)
+			if tc.existingDconfDir == "-" {
+				dconfDir = filepath.Join(t.TempDir(), "doesnotexist")
+			}
+
+			m := dconf.NewWithDconfDir(dconfDir)
+			got, err := m.Users()
+			require.NoError(t, err, "Users failed but shouldn't have")
+			require.Equal(t, tc.want, got, "Users returned unexpected users")
+		})
+	}
+}
+
+func TestCompileDB(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		dbsDir string
+
+		wantErr bool
+	}{
+		"keyfiles and locks":                   {},
+		"later keyfiles override earlier ones": {},
+		"no locks directory":                   {},
+		"empty database":                       {},
+
+		// Error cases
+		"error on invalid value":        {dbsDir: "invalid value", wantErr: true},
+		"error on invalid group":        {dbsDir: "invalid group", wantErr: true},
+		"error on line outside a group": {dbsDir: "line outside a group", wantErr: true},
+		"error on invalid lock":         {dbsDir: "invalid lock", wantErr: true},
+		"error on missing database":     {dbsDir: "-", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dbsPath := t.TempDir()
+			if tc.dbsDir == "" {
+				tc.dbsDir = name
+			}
+			if tc.dbsDir != "-" {
+				require.NoError(t, os.Remove(dbsPath), "Setup: can't delete dconf db directory before recreation")
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join("testdata", "compile", tc.dbsDir), dbsPath,
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't create initial dconf db directory")
+			}
+
+			err := dconf.CompileDB(dbsPath, "machine")
+			if tc.wantErr {
+				require.NotNil(t, err, "CompileDB should have failed but didn't")
+				_, err = os.Stat(filepath.Join(dbsPath, "machine"))
+				require.ErrorIs(t, err, os.ErrNotExist, "CompileDB should not write a database on failure")
+				return
+			}
+			require.NoError(t, err, "CompileDB failed but shouldn't have")
+
+			got, err := os.ReadFile(filepath.Join(dbsPath, "machine"))
+			require.NoError(t, err, "Can't read compiled database")
+			goldPath := filepath.Join("testdata", "golden_compile", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, got, 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load golden file")
+			require.Equal(t, want, got, "CompileDB wrote unexpected database")
+
+			files, err := os.ReadDir(dbsPath)
+			require.NoError(t, err, "Can't read dconf db directory")
+			require.Len(t, files, 2, "CompileDB should only leave the database and its keyfiles directory")
+		})
+	}
+}
+
+func TestCompileDBInvalidatesPreviousDB(t *testing.T) {
+	t.Parallel()
+
+	dbsPath := t.TempDir()
+	require.NoError(t, os.Remove(dbsPath), "Setup: can't delete dconf db directory before recreation")
+	require.NoError(t,
+		shutil.CopyTree(
+			filepath.Join("testdata", "compile", "keyfiles and locks"), dbsPath,
+			&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+		"Setup: can't create initial dconf db directory")
+	dbPath := filepath.Join(dbsPath, "machine")
+
+	needsCompile, err := dconf.DBNeedsCompile(dbsPath, "machine")
+	require.NoError(t, err, "DBNeedsCompile failed but shouldn't have")
+	require.True(t, needsCompile, "Missing database needs to be compiled")
+
+	require.NoError(t, dconf.CompileDB(dbsPath, "machine"), "Setup: CompileDB failed but shouldn't have")
+	previous, err := os.Open(dbPath)
+	require.NoError(t, err, "Setup: can't open compiled database")
+	defer previous.Close()
+
+	needsCompile, err = dconf.DBNeedsCompile(dbsPath, "machine")
+	require.NoError(t, err, "DBNeedsCompile failed but shouldn't have")
+	require.False(t, needsCompile, "Up to date database doesn't need to be compiled")
+
+	// Keyfile modified after compilation
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dbsPath, "machine.d", "adsys"), future, future), "Setup: can't change keyfile time")
+	needsCompile, err = dconf.DBNeedsCompile(dbsPath, "machine")
+	require.NoError(t, err, "DBNeedsCompile failed but shouldn't have")
+	require.True(t, needsCompile, "Database older than its keyfiles needs to be compiled")
+
+	require.NoError(t, dconf.CompileDB(dbsPath, "machine"), "CompileDB failed but shouldn't have")
+
+	header := make([]byte, 8)
+	_, err = previous.ReadAt(header, 0)
+	require.NoError(t, err, "Can't read previous database")
+	require.Equal(t, make([]byte, 8), header, "Previous database should have been invalidated")
+
+	current, err := os.ReadFile(dbPath)
+	require.NoError(t, err, "Can't read compiled database")
+	require.Equal(t, "GVariant", string(current[:8]), "New database should be valid")
+}
+
 func TestMain(m *testing.M) {
 	flag.BoolVar(&update, "update", false, "update golden files")
 	flag.Parse()
@@ -0,0 +1,7 @@
+package dconf
+
+// CompileDB compiles the system database name in dbsPath.
+var CompileDB = compileDB
+
+// DBNeedsCompile returns if the system database name in dbsPath is outdated.
+var DBNeedsCompile = dbNeedsCompile
@@ -0,0 +1,186 @@
+package gvariant_test
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvariant"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		text string
+		typ  string
+
+		wantType string
+		// wantText is the printed value, which defaults to text.
+		wantText string
+		wantErr  bool
+	}{
+		// Basic types
+		"boolean":                  {text: "true", wantType: "b"},
+		"integer is int32":         {text: "42", wantType: "i"},
+		"negative integer":         {text: "-42", wantType: "i"},
+		"hexadecimal integer":      {text: "0x2a", wantType: "i", wantText: "42"},
+		"double":                   {text: "0.5", wantType: "d"},
+		"double with exponent":     {text: "1e+100", wantType: "d"},
+		"infinite double":          {text: "-inf", wantType: "d"},
+		"integer parsed as double": {text: "2", typ: "d", wantType: "d", wantText: "2.0"},
+		"byte":                     {text: "byte 0x41", wantType: "y"},
+		"int16":                    {text: "int16 -3", wantType: "n"},
+		"uint16":                   {text: "uint16 3", wantType: "q"},
+		"uint32":                   {text: "uint32 3", wantType: "u"},
+		"int64":                    {text: "int64 -9223372036854775808", wantType: "x"},
+		"uint64":                   {text: "uint64 18446744073709551615", wantType: "t"},
+		"handle":                   {text: "handle 1", wantType: "h"},
+		"annotated uint32":         {text: "@u 3", wantType: "u", wantText: "uint32 3"},
+		"integer parsed as uint32": {text: "3", typ: "u", wantType: "u", wantText: "uint32 3"},
+		"single quoted string":     {text: "'hello world'", wantType: "s"},
+		"double quoted string":     {text: `"it's"`, wantType: "s", wantText: `'it\'s'`},
+		"string with escapes":      {text: `'a\tb\nc\\dé'`, wantType: "s", wantText: `'a\tb\nc\\dé'`},
+		"object path":              {text: "objectpath '/org/gnome'", wantType: "o"},
+		"signature":                {text: "signature 'a{sv}'", wantType: "g"},
+		"bytestring":               {text: "b'abc'", wantType: "ay", wantText: "[byte 0x61, byte 0x62, byte 0x63, byte 0x00]"},
+		"variant":                  {text: "<uint32 1>", wantType: "v"},
+		"spaces around value":      {text: "  \t42\n ", wantType: "i", wantText: "42"},
+
+		// Containers
+		"array":                        {text: "[1, 2, 3]", wantType: "ai"},
+		"array of strings":             {text: "['a', 'b']", wantType: "as"},
+		"array mixing int and double":  {text: "[1, 2.5]", wantType: "ad", wantText: "[1.0, 2.5]"},
+		"array typed by one element":   {text: "[1, uint32 2]", wantType: "au", wantText: "[uint32 1, uint32 2]"},
+		"annotated empty array":        {text: "@as []", wantType: "as"},
+		"empty array of expected type": {text: "[]", typ: "a(ss)", wantType: "a(ss)", wantText: "@a(ss) []"},
+		"array of arrays":              {text: "[[1, 2], []]", wantType: "aai", wantText: "[[1, 2], @ai []]"},
+		"tuple":                        {text: "(1, 'a', true)", wantType: "(isb)"},
+		"one member tuple":             {text: "('a',)", wantType: "(s)"},
+		"empty tuple":                  {text: "()", wantType: "()"},
+		"array of tuples":              {text: "[('xkb', 'us'), ('xkb', 'fr')]", wantType: "a(ss)"},
+		"dictionary":                   {text: "{'a': 1, 'b': 2}", wantType: "a{si}"},
+		"dictionary keeps its order":   {text: "{'b': 1, 'a': 2}", wantType: "a{si}"},
+		"dictionary of variants":       {text: "{'a': <1>, 'b': <['c']>}", wantType: "a{sv}"},
+		"annotated empty dictionary":   {text: "@a{sv} {}", wantType: "a{sv}"},
+		"dictionary of expected type":  {text: "{'a': 1}", typ: "a{sd}", wantType: "a{sd}", wantText: "{'a': 1.0}"},
+
+		// Error cases
+		"error on invalid expected type":              {text: "1", typ: "z", wantErr: true},
+		"error on incomplete expected type":           {text: "1", typ: "a", wantErr: true},
+		"error on multiple expected types":            {text: "1", typ: "ii", wantErr: true},
+		"error on non basic dictionary key type":      {text: "{}", typ: "a{vs}", wantErr: true},
+		"error on empty text":                         {text: "", wantErr: true},
+		"error on trailing content":                   {text: "1 2", wantErr: true},
+		"error on unterminated string":                {text: "'abc", wantErr: true},
+		"error on unterminated array":                 {text: "[1, 2", wantErr: true},
+		"error on unquoted string":                    {text: "hello", wantErr: true},
+		"error on empty array without type":           {text: "[]", wantErr: true},
+		"error on empty dictionary without type":      {text: "{}", wantErr: true},
+		"error on mixed array elements":               {text: "[1, 'a']", wantErr: true},
+		"error on one member tuple without comma":     {text: "('a')", wantErr: true},
+		"error on integer out of range":               {text: "uint32 -1", wantErr: true},
+		"error on integer out of range of expected":   {text: "256", typ: "y", wantErr: true},
+		"error on double as integer":                  {text: "1.5", typ: "i", wantErr: true},
+		"error on value not of expected type":         {text: "'a'", typ: "i", wantErr: true},
+		"error on annotation not of expected type":    {text: "@as []", typ: "ai", wantErr: true},
+		"error on tuple with wrong number of members": {text: "(1, 2, 3)", typ: "(ii)", wantErr: true},
+		"error on dictionary entry without value":     {text: "{'a'}", wantErr: true},
+		"error on invalid annotation":                 {text: "@z 1", wantErr: true},
+		"error on maybe type":                         {text: "@ms nothing", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v, err := gvariant.Parse(tc.text, tc.typ)
+			if tc.wantErr {
+				require.Error(t, err, "Parse should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Parse failed but shouldn't have")
+			require.Equal(t, tc.wantType, v.Signature().String(), "Parse returned a value of unexpected type")
+
+			if tc.wantText == "" {
+				tc.wantText = tc.text
+			}
+			got := gvariant.Print(v)
+			require.Equal(t, tc.wantText, got, "Print returned unexpected text")
+
+			// Printed values are parsed back to the same value without expected type
+			again, err := gvariant.Parse(got, "")
+			require.NoError(t, err, "Parse of the printed value failed but shouldn't have")
+			require.Equal(t, v, again, "Printed value should be parsed back to the same value")
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value dbus.Variant
+
+		want string
+	}{
+		"go string":                 {value: dbus.MakeVariant("a'b"), want: `'a\'b'`},
+		"go uint32":                 {value: dbus.MakeVariant(uint32(5)), want: "uint32 5"},
+		"go float":                  {value: dbus.MakeVariant(float64(3)), want: "3.0"},
+		"go slice":                  {value: dbus.MakeVariant([]string{"a", "b"}), want: "['a', 'b']"},
+		"go empty slice":            {value: dbus.MakeVariant([]int32{}), want: "@ai []"},
+		"go struct as tuple":        {value: dbus.MakeVariant(struct{ A, B string }{"xkb", "us"}), want: "('xkb', 'us')"},
+		"go map is sorted":          {value: dbus.MakeVariant(map[string]int32{"b": 2, "a": 1}), want: "{'a': 1, 'b': 2}"},
+		"go empty map":              {value: dbus.MakeVariant(map[string]dbus.Variant{}), want: "@a{sv} {}"},
+		"go variant":                {value: dbus.MakeVariant(dbus.MakeVariant(true)), want: "<true>"},
+		"go object path":            {value: dbus.MakeVariant(dbus.ObjectPath("/a")), want: "objectpath '/a'"},
+		"control characters escape": {value: dbus.MakeVariant("\x01\r"), want: `'\u0001\r'`},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := gvariant.Print(tc.value)
+			require.Equal(t, tc.want, got, "Print returned unexpected text")
+		})
+	}
+}
+
+func TestZero(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		typ string
+
+		want    string
+		wantErr bool
+	}{
+		"boolean":               {typ: "b", want: "false"},
+		"int32":                 {typ: "i", want: "0"},
+		"uint64":                {typ: "t", want: "uint64 0"},
+		"double":                {typ: "d", want: "0.0"},
+		"string":                {typ: "s", want: "''"},
+		"object path":           {typ: "o", want: "objectpath '/'"},
+		"variant":               {typ: "v", want: "<''>"},
+		"array":                 {typ: "a(ss)", want: "@a(ss) []"},
+		"dictionary":            {typ: "a{sv}", want: "@a{sv} {}"},
+		"tuple":                 {typ: "(iu)", want: "(0, uint32 0)"},
+		"one member tuple":      {typ: "(as)", want: "(@as [],)"},
+		"error on invalid type": {typ: "myunsupportedtype", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := gvariant.Zero(tc.typ)
+			if tc.wantErr {
+				require.Error(t, err, "Zero should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Zero failed but shouldn't have")
+			require.Equal(t, tc.want, got, "Zero returned unexpected text")
+		})
+	}
+}
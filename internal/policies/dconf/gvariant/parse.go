@@ -0,0 +1,735 @@
+// Package gvariant parses and prints values in the GVariant text format, as written in dconf keyfiles.
+package gvariant
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+/*
+	Notes:
+	Values are parsed in two steps, as GLib does:
+	- the text is parsed to a tree of nodes, which can carry an explicit type from a type annotation (@as []) or
+	  keyword (uint32 42).
+	- the tree is converted to a value of the expected type. Without expected type, it is inferred from the nodes:
+	  numbers are int32 or double, and containers take the type of their annotated or inferable members.
+	Values are returned as dbus variants: tuples and dictionary entries are []interface{} of their members, arrays are
+	[]interface{} of their elements, and dictionaries are arrays of entries, which keeps their order.
+	Maybe types aren't supported, as they can't be represented as dbus values.
+*/
+
+type nodeKind int
+
+const (
+	numberNode nodeKind = iota
+	stringNode
+	booleanNode
+	bytestringNode
+	arrayNode
+	tupleNode
+	dictNode
+	variantNode
+)
+
+// node is a parsed value, before conversion to its type.
+type node struct {
+	kind nodeKind
+	// text is the literal of numbers, or the decoded content of strings and bytestrings.
+	text string
+	// children are the members of containers. Dictionaries alternate keys and values.
+	children []*node
+	// typ is the explicit type of the value, if any.
+	typ string
+}
+
+// keywordTypes are the types which can be set by a keyword before a value.
+var keywordTypes = map[string]string{
+	"boolean":    "b",
+	"byte":       "y",
+	"int16":      "n",
+	"uint16":     "q",
+	"int32":      "i",
+	"uint32":     "u",
+	"int64":      "x",
+	"uint64":     "t",
+	"handle":     "h",
+	"double":     "d",
+	"string":     "s",
+	"objectpath": "o",
+	"signature":  "g",
+}
+
+// Parse parses text as a value of type typ. If typ is empty, the type is inferred from the text.
+func Parse(text, typ string) (v dbus.Variant, err error) {
+	if typ != "" {
+		if err := ValidType(typ); err != nil {
+			return dbus.Variant{}, err
+		}
+	}
+
+	p := &parser{text: text}
+	n, err := p.value()
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.text) {
+		return dbus.Variant{}, p.errorf(i18n.G("unexpected %q after the value"), p.text[p.pos:])
+	}
+
+	if typ == "" {
+		if typ, err = n.inferType(); err != nil {
+			return dbus.Variant{}, err
+		}
+	}
+	value, err := n.convert(typ)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	return makeVariant(value, typ)
+}
+
+// makeVariant returns a variant of type typ holding value.
+func makeVariant(value interface{}, typ string) (dbus.Variant, error) {
+	sig, err := dbus.ParseSignature(typ)
+	if err != nil {
+		return dbus.Variant{}, fmt.Errorf(i18n.G("type %q is not supported: %v"), typ, err)
+	}
+	return dbus.MakeVariantWithSignature(value, sig), nil
+}
+
+// ValidType returns an error if typ is not a single complete supported type.
+func ValidType(typ string) error {
+	t, rest, err := nextType(typ)
+	if err != nil {
+		return err
+	}
+	if rest != "" {
+		return fmt.Errorf(i18n.G("%q is not a single type: unexpected %q after %q"), typ, rest, t)
+	}
+	return nil
+}
+
+// nextType splits the first complete type of signature sig from the remaining types.
+func nextType(sig string) (t string, rest string, err error) {
+	if sig == "" {
+		return "", "", errors.New(i18n.G("missing type"))
+	}
+
+	switch sig[0] {
+	case 'b', 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h', 'd', 's', 'o', 'g', 'v':
+		return sig[:1], sig[1:], nil
+	case 'a':
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return sig[:1] + elem, rest, nil
+	case '(':
+		rest := sig[1:]
+		for {
+			if rest == "" {
+				return "", "", fmt.Errorf(i18n.G("unterminated tuple in type %q"), sig)
+			}
+			if rest[0] == ')' {
+				break
+			}
+			if _, rest, err = nextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+		n := len(sig) - len(rest) + 1
+		return sig[:n], sig[n:], nil
+	case '{':
+		key, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.Contains("bynqiuxthdsog", key) {
+			return "", "", fmt.Errorf(i18n.G("dictionary key type %q is not a basic type"), key)
+		}
+		value, rest, err := nextType(rest)
+		if err != nil {
+			return "", "", err
+		}
+		if rest == "" || rest[0] != '}' {
+			return "", "", fmt.Errorf(i18n.G("dictionary entry type %q must have exactly two members"), sig)
+		}
+		n := len(key) + len(value) + 2
+		return sig[:n], sig[n:], nil
+	}
+
+	return "", "", fmt.Errorf(i18n.G("unsupported type %q"), sig[0])
+}
+
+// MemberTypes returns the types of the members of a valid tuple or dictionary entry type.
+func MemberTypes(typ string) []string {
+	var types []string
+	members := typ[1 : len(typ)-1]
+	for members != "" {
+		var t string
+		// typ is already validated
+		t, members, _ = nextType(members)
+		types = append(types, t)
+	}
+	return types
+}
+
+// parser reads nodes from text.
+type parser struct {
+	text string
+	pos  int
+}
+
+// errorf returns an error at the current position.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(i18n.G("position %d: %s"), p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.text) && strings.ContainsRune(" \t\n\r", rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, or 0 at the end of the text.
+func (p *parser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+// expect consumes the character c, which must be next.
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		if p.pos >= len(p.text) {
+			return p.errorf(i18n.G("expected %q, got the end of the value"), c)
+		}
+		return p.errorf(i18n.G("expected %q, got %q"), c, p.text[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+// word returns the next run of letters, digits and number characters.
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '+' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// value parses the next value.
+func (p *parser) value() (n *node, err error) {
+	switch c := p.peek(); c {
+	case 0:
+		return nil, p.errorf(i18n.G("expected a value, got the end of the text"))
+	case '@':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.text) && !strings.ContainsRune(" \t\n\r", rune(p.text[p.pos])) {
+			p.pos++
+		}
+		t, rest, err := nextType(p.text[start:p.pos])
+		if err != nil {
+			return nil, p.errorf(i18n.G("invalid type annotation: %v"), err)
+		}
+		p.pos -= len(rest)
+		return p.typedValue(t)
+	case '\'', '"':
+		s, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: stringNode, text: s}, nil
+	case '[':
+		p.pos++
+		n = &node{kind: arrayNode}
+		if n.children, err = p.list(']'); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case '(':
+		return p.tuple()
+	case '{':
+		return p.dict()
+	case '<':
+		p.pos++
+		child, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('>'); err != nil {
+			return nil, err
+		}
+		return &node{kind: variantNode, children: []*node{child}}, nil
+	}
+
+	if strings.HasPrefix(p.text[p.pos:], "b'") || strings.HasPrefix(p.text[p.pos:], `b"`) {
+		p.pos++
+		s, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: bytestringNode, text: s}, nil
+	}
+
+	start := p.pos
+	w := p.word()
+	switch w {
+	case "":
+		return nil, p.errorf(i18n.G("unexpected %q"), p.text[p.pos])
+	case "true", "false":
+		return &node{kind: booleanNode, text: w}, nil
+	case "nothing", "just":
+		return nil, p.errorf(i18n.G("maybe values are not supported"))
+	}
+	if t, ok := keywordTypes[w]; ok {
+		return p.typedValue(t)
+	}
+	if c := w[0]; !(c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.' || w == "inf" || w == "nan") {
+		p.pos = start
+		return nil, p.errorf(i18n.G("unrecognized value %q"), w)
+	}
+	return &node{kind: numberNode, text: w}, nil
+}
+
+// typedValue parses the next value, which is explicitly of type t.
+func (p *parser) typedValue(t string) (*node, error) {
+	n, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if n.typ != "" && n.typ != t {
+		return nil, p.errorf(i18n.G("value of type %q can't have type %q"), n.typ, t)
+	}
+	n.typ = t
+	return n, nil
+}
+
+// list parses values separated by commas, up to the closing character.
+func (p *parser) list(closing byte) (nodes []*node, err error) {
+	if p.peek() == closing {
+		p.pos++
+		return nil, nil
+	}
+	for {
+		n, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.peek() == closing {
+			p.pos++
+			return nodes, nil
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tuple parses a tuple, where single member tuples have a trailing comma.
+func (p *parser) tuple() (n *node, err error) {
+	p.pos++
+	n = &node{kind: tupleNode}
+	if p.peek() == ')' {
+		p.pos++
+		return n, nil
+	}
+	for {
+		child, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, child)
+		if p.peek() == ')' {
+			if len(n.children) == 1 {
+				return nil, p.errorf(i18n.G("single member tuples need a trailing comma"))
+			}
+			p.pos++
+			return n, nil
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+		if len(n.children) == 1 && p.peek() == ')' {
+			p.pos++
+			return n, nil
+		}
+	}
+}
+
+// dict parses a dictionary {key: value, ...}.
+func (p *parser) dict() (n *node, err error) {
+	p.pos++
+	n = &node{kind: dictNode}
+	if p.peek() == '}' {
+		p.pos++
+		return n, nil
+	}
+	for {
+		key, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, key, value)
+		if p.peek() == '}' {
+			p.pos++
+			return n, nil
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// quoted parses a quoted string and returns its decoded content.
+func (p *parser) quoted() (string, error) {
+	quote := p.text[p.pos]
+	p.pos++
+
+	var s strings.Builder
+	for {
+		if p.pos >= len(p.text) {
+			return "", p.errorf(i18n.G("unterminated string"))
+		}
+		c := p.text[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return s.String(), nil
+		case '\\':
+			if p.pos >= len(p.text) {
+				return "", p.errorf(i18n.G("unterminated string"))
+			}
+			e := p.text[p.pos]
+			p.pos++
+			switch e {
+			case 'a':
+				s.WriteByte('\a')
+			case 'b':
+				s.WriteByte('\b')
+			case 'f':
+				s.WriteByte('\f')
+			case 'n':
+				s.WriteByte('\n')
+			case 'r':
+				s.WriteByte('\r')
+			case 't':
+				s.WriteByte('\t')
+			case 'v':
+				s.WriteByte('\v')
+			case 'u', 'U':
+				size := 4
+				if e == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.text) {
+					return "", p.errorf(i18n.G("invalid unicode escape"))
+				}
+				r, err := strconv.ParseUint(p.text[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf(i18n.G("invalid unicode escape %q"), p.text[p.pos:p.pos+size])
+				}
+				p.pos += size
+				s.WriteRune(rune(r))
+			default:
+				s.WriteByte(e)
+			}
+		default:
+			s.WriteByte(c)
+		}
+	}
+}
+
+// inferType returns the type of n, from its explicit type or its content.
+func (n *node) inferType() (string, error) {
+	if n.typ != "" {
+		return n.typ, nil
+	}
+
+	switch n.kind {
+	case numberNode:
+		if isFloat(n.text) {
+			return "d", nil
+		}
+		return "i", nil
+	case stringNode:
+		return "s", nil
+	case booleanNode:
+		return "b", nil
+	case bytestringNode:
+		return "ay", nil
+	case variantNode:
+		return "v", nil
+	case tupleNode:
+		typ := "("
+		for _, c := range n.children {
+			t, err := c.inferType()
+			if err != nil {
+				return "", err
+			}
+			typ += t
+		}
+		return typ + ")", nil
+	case arrayNode:
+		elem, err := commonType(n.children)
+		if err != nil {
+			return "", err
+		}
+		return "a" + elem, nil
+	case dictNode:
+		var keys, values []*node
+		for i := 0; i < len(n.children); i += 2 {
+			keys = append(keys, n.children[i])
+			values = append(values, n.children[i+1])
+		}
+		key, err := commonType(keys)
+		if err != nil {
+			return "", err
+		}
+		value, err := commonType(values)
+		if err != nil {
+			return "", err
+		}
+		return "a{" + key + value + "}", nil
+	}
+
+	return "", errors.New(i18n.G("can't infer the type of the value"))
+}
+
+// commonType returns the type shared by all nodes, preferring explicit types. Integers and doubles are doubles.
+func commonType(nodes []*node) (string, error) {
+	var typ string
+	for _, n := range nodes {
+		if n.typ != "" {
+			return n.typ, nil
+		}
+		t, err := n.inferType()
+		if err != nil {
+			// Empty containers only get their type from other members
+			continue
+		}
+		switch {
+		case typ == "":
+			typ = t
+		case typ == "i" && t == "d":
+			typ = "d"
+		}
+	}
+	if typ == "" {
+		return "", errors.New(i18n.G("can't infer the type of an empty container, please annotate it like @as []"))
+	}
+	return typ, nil
+}
+
+// isFloat returns true if the number literal is a double.
+func isFloat(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return false
+	}
+	return strings.ContainsAny(s, ".eE") || s == "inf" || s == "nan"
+}
+
+// convert returns the value of n as type typ.
+func (n *node) convert(typ string) (v interface{}, err error) {
+	if n.typ != "" && n.typ != typ {
+		return nil, fmt.Errorf(i18n.G("value of type %q is not of type %q"), n.typ, typ)
+	}
+
+	switch typ[0] {
+	case 'b':
+		if n.kind != booleanNode {
+			return nil, n.typeError(typ)
+		}
+		return n.text == "true", nil
+	case 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h':
+		return n.integer(typ)
+	case 'd':
+		if n.kind != numberNode {
+			return nil, n.typeError(typ)
+		}
+		f, err := strconv.ParseFloat(n.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("invalid double %q"), n.text)
+		}
+		return f, nil
+	case 's':
+		if n.kind != stringNode {
+			return nil, n.typeError(typ)
+		}
+		return n.text, nil
+	case 'o':
+		if n.kind != stringNode || !dbus.ObjectPath(n.text).IsValid() {
+			return nil, fmt.Errorf(i18n.G("invalid object path %q"), n.text)
+		}
+		return dbus.ObjectPath(n.text), nil
+	case 'g':
+		if n.kind != stringNode {
+			return nil, n.typeError(typ)
+		}
+		sig, err := dbus.ParseSignature(n.text)
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("invalid signature %q"), n.text)
+		}
+		return sig, nil
+	case 'v':
+		if n.kind != variantNode {
+			return nil, n.typeError(typ)
+		}
+		child := n.children[0]
+		t, err := child.inferType()
+		if err != nil {
+			return nil, err
+		}
+		value, err := child.convert(t)
+		if err != nil {
+			return nil, err
+		}
+		return makeVariant(value, t)
+	case '(':
+		if n.kind != tupleNode {
+			return nil, n.typeError(typ)
+		}
+		types := MemberTypes(typ)
+		if len(types) != len(n.children) {
+			return nil, fmt.Errorf(i18n.G("tuple of %d members is not of type %q"), len(n.children), typ)
+		}
+		return convertAll(n.children, types)
+	case 'a':
+		return n.array(typ)
+	}
+
+	return nil, fmt.Errorf(i18n.G("unsupported type %q"), typ)
+}
+
+// array returns the value of n as an array of type typ.
+func (n *node) array(typ string) (interface{}, error) {
+	elem := typ[1:]
+
+	var types []string
+	switch {
+	case elem == "y" && n.kind == bytestringNode:
+		// Bytestrings are nul terminated
+		b := make([]interface{}, 0, len(n.text)+1)
+		for i := 0; i < len(n.text); i++ {
+			b = append(b, n.text[i])
+		}
+		return append(b, byte(0)), nil
+	case elem[0] == '{' && n.kind == dictNode:
+		entry := MemberTypes(elem)
+		values := make([]interface{}, 0, len(n.children)/2)
+		for i := 0; i < len(n.children); i += 2 {
+			e, err := convertAll(n.children[i:i+2], entry)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, e)
+		}
+		return values, nil
+	case n.kind == arrayNode:
+		for range n.children {
+			types = append(types, elem)
+		}
+	default:
+		return nil, n.typeError(typ)
+	}
+
+	values, err := convertAll(n.children, types)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = []interface{}{}
+	}
+	return values, nil
+}
+
+// convertAll converts each node to its type.
+func convertAll(nodes []*node, types []string) ([]interface{}, error) {
+	var values []interface{}
+	for i, c := range nodes {
+		v, err := c.convert(types[i])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// integer returns the value of n as an integer of type typ, checking that it fits in it.
+func (n *node) integer(typ string) (interface{}, error) {
+	if n.kind != numberNode || isFloat(n.text) {
+		return nil, n.typeError(typ)
+	}
+
+	bits := map[byte]int{'y': 8, 'n': 16, 'q': 16, 'i': 32, 'u': 32, 'x': 64, 't': 64, 'h': 32}[typ[0]]
+	if strings.ContainsRune("yqut", rune(typ[0])) {
+		u, err := strconv.ParseUint(strings.TrimPrefix(n.text, "+"), 0, bits)
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("%s is not a valid %q integer"), n.text, typ)
+		}
+		switch typ[0] {
+		case 'y':
+			return byte(u), nil
+		case 'q':
+			return uint16(u), nil
+		case 'u':
+			return uint32(u), nil
+		}
+		return u, nil
+	}
+
+	i, err := strconv.ParseInt(n.text, 0, bits)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("%s is not a valid %q integer"), n.text, typ)
+	}
+	switch typ[0] {
+	case 'n':
+		return int16(i), nil
+	case 'i', 'h':
+		return int32(i), nil
+	}
+	return i, nil
+}
+
+// typeError reports a node which can't be converted to typ.
+func (n *node) typeError(typ string) error {
+	kinds := map[nodeKind]string{
+		numberNode:     i18n.G("number"),
+		stringNode:     i18n.G("string"),
+		booleanNode:    i18n.G("boolean"),
+		bytestringNode: i18n.G("bytestring"),
+		arrayNode:      i18n.G("array"),
+		tupleNode:      i18n.G("tuple"),
+		dictNode:       i18n.G("dictionary"),
+		variantNode:    i18n.G("variant"),
+	}
+	if n.text != "" && n.kind == numberNode {
+		return fmt.Errorf(i18n.G("%s %s is not of type %q"), kinds[n.kind], n.text, typ)
+	}
+	return fmt.Errorf(i18n.G("%s is not of type %q"), kinds[n.kind], typ)
+}
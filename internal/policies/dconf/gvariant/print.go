@@ -0,0 +1,215 @@
+package gvariant
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Print returns the text of v, with the type annotations needed to parse it back to the same type without
+// expected type.
+func Print(v dbus.Variant) string {
+	return printValue(v.Signature().String(), reflect.ValueOf(v.Value()))
+}
+
+// printValue returns the text of the value v of type typ.
+func printValue(typ string, v reflect.Value) string {
+	for v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	switch typ[0] {
+	case 'b':
+		return strconv.FormatBool(v.Bool())
+	case 'y':
+		return fmt.Sprintf("byte 0x%02x", v.Uint())
+	case 'n', 'x', 'h', 'q', 't', 'u':
+		keyword := map[byte]string{'n': "int16 ", 'x': "int64 ", 'h': "handle ", 'q': "uint16 ", 't': "uint64 ", 'u': "uint32 "}[typ[0]]
+		if v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr {
+			return keyword + strconv.FormatUint(v.Uint(), 10)
+		}
+		return keyword + strconv.FormatInt(v.Int(), 10)
+	case 'i':
+		return strconv.FormatInt(v.Int(), 10)
+	case 'd':
+		return formatDouble(v.Float())
+	case 's':
+		return Quote(v.String())
+	case 'o':
+		return "objectpath " + Quote(v.String())
+	case 'g':
+		if sig, ok := v.Interface().(dbus.Signature); ok {
+			return "signature " + Quote(sig.String())
+		}
+		return "signature " + Quote(v.String())
+	case 'v':
+		return "<" + Print(v.Interface().(dbus.Variant)) + ">"
+	case '(':
+		types := MemberTypes(typ)
+		members := make([]string, 0, len(types))
+		for i, t := range types {
+			members = append(members, printValue(t, member(v, i)))
+		}
+		if len(members) == 1 {
+			return "(" + members[0] + ",)"
+		}
+		return "(" + strings.Join(members, ", ") + ")"
+	case 'a':
+		return printArray(typ, v)
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// printArray returns the text of an array or dictionary v of type typ.
+func printArray(typ string, v reflect.Value) string {
+	elem := typ[1:]
+
+	// Dictionaries can be maps: print them in a reproducible order
+	if v.Kind() == reflect.Map {
+		var entries []string
+		entry := MemberTypes(elem)
+		for _, k := range v.MapKeys() {
+			entries = append(entries, printValue(entry[0], k)+": "+printValue(entry[1], v.MapIndex(k)))
+		}
+		sort.Strings(entries)
+		if len(entries) == 0 {
+			return "@" + typ + " {}"
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	}
+
+	if v.Len() == 0 {
+		if elem[0] == '{' {
+			return "@" + typ + " {}"
+		}
+		return "@" + typ + " []"
+	}
+
+	elems := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if elem[0] != '{' {
+			elems = append(elems, printValue(elem, e))
+			continue
+		}
+		entry := MemberTypes(elem)
+		elems = append(elems, printValue(entry[0], member(e, 0))+": "+printValue(entry[1], member(e, 1)))
+	}
+	if elem[0] == '{' {
+		return "{" + strings.Join(elems, ", ") + "}"
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// member returns the member i of a tuple, as a slice or a struct.
+func member(v reflect.Value, i int) reflect.Value {
+	for v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		return v.Field(i)
+	}
+	return v.Index(i)
+}
+
+// formatDouble returns the text of f, which is always parsed back as a double.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// Quote returns s as a single quoted string, escaping quotes, backslashes and control characters.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// Zero returns the text of the zero value of type typ: false, 0, empty strings and containers, and tuples of zero
+// values. Variants hold an empty string.
+func Zero(typ string) (string, error) {
+	if err := ValidType(typ); err != nil {
+		return "", err
+	}
+	v, err := Parse(zeroText(typ), typ)
+	if err != nil {
+		return "", err
+	}
+	return Print(v), nil
+}
+
+// zeroText returns the text of the zero value of a valid type, without type annotation.
+func zeroText(typ string) string {
+	switch typ[0] {
+	case 'b':
+		return "false"
+	case 'd':
+		return "0.0"
+	case 's', 'g':
+		return "''"
+	case 'o':
+		return "'/'"
+	case 'v':
+		return "<''>"
+	case 'a':
+		if typ[1] == '{' {
+			return "{}"
+		}
+		return "[]"
+	case '(':
+		var members []string
+		for _, t := range MemberTypes(typ) {
+			members = append(members, zeroText(t))
+		}
+		if len(members) == 1 {
+			return "(" + members[0] + ",)"
+		}
+		return "(" + strings.Join(members, ", ") + ")"
+	}
+	return "0"
+}
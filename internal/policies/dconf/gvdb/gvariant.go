@@ -0,0 +1,368 @@
+package gvdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+/*
+	Notes:
+	This is the GVariant serialization format, as documented in GLib and used by dconf:
+	- fixed size basic types are stored in little endian, aligned on their size.
+	- strings, object paths and signatures are nul terminated.
+	- a variant is its child followed by a nul byte and the child type string, aligned on 8.
+	- arrays of fixed size elements are their elements one after the other. Arrays of variable size elements are
+	  followed by a table of framing offsets: the end of each element.
+	- tuples and dictionary entries have their members aligned. The end of each variable size member, but the last one,
+	  is stored in reverse order in the framing offsets table. Fixed size tuples are padded to their alignment.
+	Offsets in framing tables are relative to the container start, and stored on the smallest size (1, 2, 4 or 8 bytes)
+	which can address the whole container.
+*/
+
+// nextType splits the first complete type of signature sig from the remaining types.
+func nextType(sig string) (t string, rest string, err error) {
+	if sig == "" {
+		return "", "", errors.New(i18n.G("missing type in signature"))
+	}
+
+	switch sig[0] {
+	case 'b', 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h', 'd', 's', 'o', 'g', 'v':
+		return sig[:1], sig[1:], nil
+	case 'a', 'm':
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return sig[:1] + elem, rest, nil
+	case '(', '{':
+		closing := map[byte]byte{'(': ')', '{': '}'}[sig[0]]
+		rest := sig[1:]
+		for {
+			if rest == "" {
+				return "", "", fmt.Errorf(i18n.G("unterminated container in signature %q"), sig)
+			}
+			if rest[0] == closing {
+				break
+			}
+			if _, rest, err = nextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+		n := len(sig) - len(rest) + 1
+		return sig[:n], sig[n:], nil
+	}
+
+	return "", "", fmt.Errorf(i18n.G("unsupported type %q in signature"), sig[0])
+}
+
+// memberTypes returns the types of the members of a tuple or dictionary entry type.
+func memberTypes(sig string) (types []string, err error) {
+	members := sig[1 : len(sig)-1]
+	for members != "" {
+		var t string
+		if t, members, err = nextType(members); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// alignment returns the alignment of a complete type.
+func alignment(sig string) int {
+	switch sig[0] {
+	case 'n', 'q':
+		return 2
+	case 'i', 'u', 'h':
+		return 4
+	case 'x', 't', 'd', 'v':
+		return 8
+	case 'a', 'm':
+		return alignment(sig[1:])
+	case '(', '{':
+		a := 1
+		types, _ := memberTypes(sig)
+		for _, t := range types {
+			if ta := alignment(t); ta > a {
+				a = ta
+			}
+		}
+		return a
+	}
+	return 1
+}
+
+// fixedSize returns the size of a complete type if all of its values have the same size, or 0 otherwise.
+func fixedSize(sig string) int {
+	switch sig[0] {
+	case 'b', 'y':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'i', 'u', 'h':
+		return 4
+	case 'x', 't', 'd':
+		return 8
+	case '(', '{':
+		types, _ := memberTypes(sig)
+		if len(types) == 0 {
+			// The unit type takes one byte
+			return 1
+		}
+		size := 0
+		for _, t := range types {
+			s := fixedSize(t)
+			if s == 0 {
+				return 0
+			}
+			size = align(size, alignment(t)) + s
+		}
+		return align(size, alignment(sig))
+	}
+	return 0
+}
+
+// align returns offset rounded up to a multiple of a.
+func align(offset, a int) int {
+	return (offset + a - 1) / a * a
+}
+
+// offsetSize returns the size of the framing offsets of a container of body size with n offsets.
+func offsetSize(body, n int) int {
+	if n == 0 {
+		return 0
+	}
+	for _, s := range []int{1, 2, 4} {
+		if uint64(body+s*n) <= uint64(1)<<(8*s)-1 {
+			return s
+		}
+	}
+	return 8
+}
+
+// appendOffsets appends the framing offsets to the container body.
+func appendOffsets(body []byte, offsets []int) []byte {
+	size := offsetSize(len(body), len(offsets))
+	for _, o := range offsets {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(o))
+		body = append(body, b[:size]...)
+	}
+	return body
+}
+
+// pad appends zeros to b until its length is a multiple of a.
+func pad(b []byte, a int) []byte {
+	return append(b, make([]byte, align(len(b), a)-len(b))...)
+}
+
+// marshalVariant serializes v as a GVariant of type v, which is how values are stored in GVDB files.
+func marshalVariant(v dbus.Variant) ([]byte, error) {
+	return marshal("v", reflect.ValueOf(v))
+}
+
+// marshal serializes v as a GVariant of type sig.
+func marshal(sig string, v reflect.Value) (data []byte, err error) {
+	for v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, fmt.Errorf(i18n.G("missing value for type %q"), sig)
+	}
+
+	switch sig[0] {
+	case 'b':
+		if v.Kind() != reflect.Bool {
+			return nil, typeError(sig, v)
+		}
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case 'y', 'n', 'q', 'i', 'u', 'x', 't', 'h':
+		n, err := integer(sig, v)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, n)
+		return data[:fixedSize(sig)], nil
+	case 'd':
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return nil, typeError(sig, v)
+		}
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, math.Float64bits(v.Float()))
+		return data, nil
+	case 's', 'o', 'g':
+		var s string
+		if sv, ok := v.Interface().(dbus.Signature); ok {
+			s = sv.String()
+		} else if v.Kind() == reflect.String {
+			s = v.String()
+		} else {
+			return nil, typeError(sig, v)
+		}
+		if strings.ContainsRune(s, 0) {
+			return nil, fmt.Errorf(i18n.G("string %q contains a nul byte"), s)
+		}
+		return append([]byte(s), 0), nil
+	case 'v':
+		child, ok := v.Interface().(dbus.Variant)
+		if !ok {
+			return nil, typeError(sig, v)
+		}
+		childSig := child.Signature().String()
+		if childSig == "" {
+			return nil, errors.New(i18n.G("variant without type"))
+		}
+		data, err := marshal(childSig, reflect.ValueOf(child.Value()))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, 0)
+		return append(data, childSig...), nil
+	case 'a':
+		return marshalArray(sig, v)
+	case '(', '{':
+		return marshalTuple(sig, v)
+	}
+
+	return nil, fmt.Errorf(i18n.G("unsupported type %q"), sig)
+}
+
+// integer returns the bits of the integer v, checking that it fits in the type sig.
+func integer(sig string, v reflect.Value) (uint64, error) {
+	var n uint64
+	var signed bool
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, signed = uint64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = v.Uint()
+	default:
+		return 0, typeError(sig, v)
+	}
+
+	bits := uint(fixedSize(sig) * 8)
+	var outOfRange bool
+	switch sig[0] {
+	case 'n', 'i', 'x', 'h':
+		if signed {
+			i := int64(n)
+			outOfRange = bits < 64 && (i < -(1<<(bits-1)) || i >= 1<<(bits-1))
+		} else {
+			outOfRange = n >= 1<<(bits-1)
+		}
+	default:
+		outOfRange = (signed && int64(n) < 0) || (bits < 64 && n >= 1<<bits)
+	}
+	if outOfRange {
+		return 0, fmt.Errorf(i18n.G("%v overflows type %q"), v.Interface(), sig)
+	}
+	return n, nil
+}
+
+// marshalArray serializes a slice or a map (for dictionaries) as a GVariant array.
+func marshalArray(sig string, v reflect.Value) (data []byte, err error) {
+	elemSig := sig[1:]
+
+	var elems []reflect.Value
+	switch {
+	case elemSig[0] == '{' && v.Kind() == reflect.Map:
+		// Dictionary entries, sorted for a reproducible output
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface()) })
+		for _, k := range keys {
+			elems = append(elems, reflect.ValueOf([]interface{}{k.Interface(), v.MapIndex(k).Interface()}))
+		}
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, v.Index(i))
+		}
+	default:
+		return nil, typeError(sig, v)
+	}
+
+	a := alignment(elemSig)
+	fixed := fixedSize(elemSig) != 0
+	var offsets []int
+	for _, e := range elems {
+		d, err := marshal(elemSig, e)
+		if err != nil {
+			return nil, err
+		}
+		data = append(pad(data, a), d...)
+		if !fixed {
+			offsets = append(offsets, len(data))
+		}
+	}
+
+	return appendOffsets(data, offsets), nil
+}
+
+// marshalTuple serializes a slice or a struct as a GVariant tuple or dictionary entry.
+func marshalTuple(sig string, v reflect.Value) (data []byte, err error) {
+	types, err := memberTypes(sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		return []byte{0}, nil
+	}
+
+	var members []reflect.Value
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			members = append(members, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			members = append(members, v.Field(i))
+		}
+	default:
+		return nil, typeError(sig, v)
+	}
+	if len(members) != len(types) {
+		return nil, fmt.Errorf(i18n.G("%d values for type %q"), len(members), sig)
+	}
+
+	var offsets []int
+	for i, t := range types {
+		d, err := marshal(t, members[i])
+		if err != nil {
+			return nil, err
+		}
+		data = append(pad(data, alignment(t)), d...)
+		if fixedSize(t) == 0 && i != len(types)-1 {
+			offsets = append(offsets, len(data))
+		}
+	}
+
+	if fixedSize(sig) != 0 {
+		return pad(data, alignment(sig)), nil
+	}
+
+	// Offsets are stored in reverse order
+	for i, j := 0, len(offsets)-1; i < j; i, j = i+1, j-1 {
+		offsets[i], offsets[j] = offsets[j], offsets[i]
+	}
+	return appendOffsets(data, offsets), nil
+}
+
+// typeError reports a Go value which can't be serialized as type sig.
+func typeError(sig string, v reflect.Value) error {
+	return fmt.Errorf(i18n.G("can't serialize %v (%s) as type %q"), v.Interface(), v.Type(), sig)
+}
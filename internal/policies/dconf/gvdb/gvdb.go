@@ -0,0 +1,220 @@
+// Package gvdb writes GVariant database files, the binary format of dconf databases.
+package gvdb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/decorate"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+/*
+	Notes:
+	A GVDB file is a header followed by chunks referenced by pointers (start and end offsets in the file):
+	- header: "GVariant" signature, version, options and pointer to the root hash table.
+	- hash table: header (bloom filter size, number of buckets), bloom filter words (we don't use any), buckets
+	  (index of the first item of each bucket) and items, sorted by bucket.
+	- item: hash of the full key, index of its parent item, pointer to its key relative to its parent key, type and
+	  pointer to its content: 'v' for a value stored as a GVariant of type v, 'L' for the indexes of its children and
+	  'H' for a nested hash table.
+	All integers are little endian. Values are serialized in little endian too, which readers on big endian machines
+	recognize from the signature.
+*/
+
+const (
+	headerSize   = 24
+	hashItemSize = 24
+	noParent     = 0xffffffff
+)
+
+// Table is a GVDB hash table, indexing items by their key.
+type Table struct {
+	items []*Item
+	byKey map[string]*Item
+}
+
+// Item is an entry of a table. It is either a value, a list of children items or a nested table.
+type Item struct {
+	key      string
+	parent   *Item
+	children []*Item
+	value    *dbus.Variant
+	table    *Table
+
+	// Set when writing the table
+	hash  uint32
+	index uint32
+}
+
+// New returns an empty table.
+func New() *Table {
+	return &Table{byKey: make(map[string]*Item)}
+}
+
+// Insert returns the item of t for key, creating it if needed.
+func (t *Table) Insert(key string) *Item {
+	if item, ok := t.byKey[key]; ok {
+		return item
+	}
+	item := &Item{key: key}
+	t.items = append(t.items, item)
+	t.byKey[key] = item
+	return item
+}
+
+// Lookup returns the item of t for key, or nil if there is none.
+func (t *Table) Lookup(key string) *Item {
+	return t.byKey[key]
+}
+
+// InsertString creates or replaces the item of t for key with a string value.
+func (t *Table) InsertString(key, value string) {
+	t.Insert(key).SetValue(dbus.MakeVariant(value))
+}
+
+// InsertTable creates an item of t for key holding a new nested table, which is returned.
+func (t *Table) InsertTable(key string) *Table {
+	nested := New()
+	t.Insert(key).table = nested
+	return nested
+}
+
+// SetValue sets the value of the item.
+func (i *Item) SetValue(v dbus.Variant) {
+	i.value = &v
+}
+
+// SetParent attaches the item to its parent, which lists it as a child.
+// The key of the parent must be a prefix of the item key, as only the remaining part is stored.
+func (i *Item) SetParent(parent *Item) {
+	i.parent = parent
+	parent.children = append(parent.children, i)
+}
+
+// Bytes returns the content of a GVDB file with t as its root table.
+func (t *Table) Bytes() (data []byte, err error) {
+	defer decorate.OnError(&err, i18n.G("can't serialize GVDB table"))
+
+	w := &fileWriter{data: make([]byte, headerSize)}
+	rootStart, rootEnd, err := w.addTable(t)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(w.data, "GVariant")
+	// version and options are 0
+	binary.LittleEndian.PutUint32(w.data[16:], rootStart)
+	binary.LittleEndian.PutUint32(w.data[20:], rootEnd)
+
+	return w.data, nil
+}
+
+// fileWriter accumulates the chunks of a GVDB file.
+type fileWriter struct {
+	data []byte
+}
+
+// allocate reserves size bytes aligned on a, and returns their start and end offsets.
+func (w *fileWriter) allocate(a, size int) (start, end uint32) {
+	w.data = pad(w.data, a)
+	start = uint32(len(w.data))
+	w.data = append(w.data, make([]byte, size)...)
+	return start, uint32(len(w.data))
+}
+
+// addTable writes the hash table t and all its items content.
+func (w *fileWriter) addTable(t *Table) (start, end uint32, err error) {
+	// Group items by bucket: there are as many buckets as items.
+	nBuckets := uint32(len(t.items))
+	buckets := make([][]*Item, nBuckets)
+	for _, item := range t.items {
+		item.hash = djbHash(item.key)
+		b := item.hash % nBuckets
+		buckets[b] = append(buckets[b], item)
+	}
+	var ordered []*Item
+	for _, b := range buckets {
+		for _, item := range b {
+			item.index = uint32(len(ordered))
+			ordered = append(ordered, item)
+		}
+	}
+
+	start, end = w.allocate(4, 8+4*len(buckets)+hashItemSize*len(ordered))
+	// No bloom filter words
+	binary.LittleEndian.PutUint32(w.data[start+4:], nBuckets)
+	var index uint32
+	for i, b := range buckets {
+		binary.LittleEndian.PutUint32(w.data[start+8+4*uint32(i):], index)
+		index += uint32(len(b))
+	}
+
+	itemsStart := start + 8 + 4*nBuckets
+	for _, item := range ordered {
+		// Items content is appended after the table: compute the record position each time
+		record := itemsStart + hashItemSize*item.index
+
+		parent := uint32(noParent)
+		basename := item.key
+		if item.parent != nil {
+			if t.byKey[item.parent.key] != item.parent {
+				return 0, 0, fmt.Errorf(i18n.G("parent %q of %q is not in the same table"), item.parent.key, item.key)
+			}
+			if len(item.key) < len(item.parent.key) || item.key[:len(item.parent.key)] != item.parent.key {
+				return 0, 0, fmt.Errorf(i18n.G("parent key %q is not a prefix of %q"), item.parent.key, item.key)
+			}
+			parent = item.parent.index
+			basename = item.key[len(item.parent.key):]
+		}
+		if len(basename) > 0xffff {
+			return 0, 0, fmt.Errorf(i18n.G("key %q is too long"), item.key)
+		}
+		keyStart, _ := w.allocate(1, len(basename))
+		copy(w.data[keyStart:], basename)
+
+		var itemType byte
+		var contentStart, contentEnd uint32
+		switch {
+		case item.value != nil:
+			itemType = 'v'
+			v, err := marshalVariant(*item.value)
+			if err != nil {
+				return 0, 0, fmt.Errorf(i18n.G("invalid value for %q: %v"), item.key, err)
+			}
+			contentStart, contentEnd = w.allocate(8, len(v))
+			copy(w.data[contentStart:], v)
+		case item.children != nil:
+			itemType = 'L'
+			contentStart, contentEnd = w.allocate(4, 4*len(item.children))
+			for i, child := range item.children {
+				binary.LittleEndian.PutUint32(w.data[contentStart+4*uint32(i):], child.index)
+			}
+		case item.table != nil:
+			itemType = 'H'
+			if contentStart, contentEnd, err = w.addTable(item.table); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		binary.LittleEndian.PutUint32(w.data[record:], item.hash)
+		binary.LittleEndian.PutUint32(w.data[record+4:], parent)
+		binary.LittleEndian.PutUint32(w.data[record+8:], keyStart)
+		binary.LittleEndian.PutUint16(w.data[record+12:], uint16(len(basename)))
+		w.data[record+14] = itemType
+		binary.LittleEndian.PutUint32(w.data[record+16:], contentStart)
+		binary.LittleEndian.PutUint32(w.data[record+20:], contentEnd)
+	}
+
+	return start, end, nil
+}
+
+// djbHash is the hash function of GVDB keys. Bytes are signed, as the C char type.
+func djbHash(key string) uint32 {
+	hash := uint32(5381)
+	for i := 0; i < len(key); i++ {
+		hash = hash*33 + uint32(int32(int8(key[i])))
+	}
+	return hash
+}
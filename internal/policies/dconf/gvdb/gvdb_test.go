@@ -0,0 +1,295 @@
+package gvdb_test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvdb"
+)
+
+var update bool
+
+func TestBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		table func() *gvdb.Table
+
+		wantErr bool
+	}{
+		"empty table": {table: gvdb.New},
+		"one value": {table: func() *gvdb.Table {
+			t := gvdb.New()
+			t.InsertString("key", "value")
+			return t
+		}},
+		"dconf database": {table: func() *gvdb.Table {
+			t := gvdb.New()
+			root := t.Insert("/")
+			dir := t.Insert("/org/gnome/")
+			dir.SetParent(root)
+			for _, kv := range []struct {
+				key   string
+				value interface{}
+			}{
+				{"/org/gnome/clock-format", "24h"},
+				{"/org/gnome/enabled", true},
+				{"/org/gnome/delay", uint32(300)},
+				{"/org/gnome/sources", []string{"us", "fr"}},
+			} {
+				item := t.Insert(kv.key)
+				item.SetValue(dbus.MakeVariant(kv.value))
+				item.SetParent(dir)
+			}
+			locks := t.InsertTable(".locks")
+			locks.InsertString("/org/gnome/clock-format", "")
+			return t
+		}},
+
+		// Error cases
+		"error on parent not in table": {table: func() *gvdb.Table {
+			t := gvdb.New()
+			t.Insert("/org/key").SetParent(gvdb.New().Insert("/org/"))
+			return t
+		}, wantErr: true},
+		"error on parent key not a prefix": {table: func() *gvdb.Table {
+			t := gvdb.New()
+			t.Insert("/org/key").SetParent(t.Insert("/com/"))
+			return t
+		}, wantErr: true},
+		"error on invalid value": {table: func() *gvdb.Table {
+			t := gvdb.New()
+			t.Insert("key").SetValue(dbus.MakeVariantWithSignature("a\x00b", dbus.SignatureOf("")))
+			return t
+		}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tc.table().Bytes()
+			if tc.wantErr {
+				require.Error(t, err, "Bytes should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Bytes failed but shouldn't have")
+
+			goldPath := filepath.Join("testdata", "golden", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, got, 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load golden file")
+			require.Equal(t, want, got, "Bytes returned unexpected content")
+
+			// Serializing again gives the same content
+			again, err := tc.table().Bytes()
+			require.NoError(t, err, "Bytes failed but shouldn't have")
+			require.Equal(t, got, again, "Bytes should be reproducible")
+		})
+	}
+}
+
+func TestGoldenFilesLookup(t *testing.T) {
+	t.Parallel()
+
+	// Golden files are read back following GLib gvdb-reader.c lookups, independently of the writer.
+	// Expected values are serialized by GLib g_variant_store() of the value wrapped in a variant.
+	tests := map[string]struct {
+		values   map[string]string
+		children map[string][]string
+		tables   map[string]map[string]string
+		missing  []string
+	}{
+		"empty table": {missing: []string{"key", ""}},
+		"one value": {
+			values:  map[string]string{"key": "76616c7565000073"},
+			missing: []string{"ke", "keyy", "value"}},
+		"dconf database": {
+			values: map[string]string{
+				"/org/gnome/clock-format": "323468000073",
+				"/org/gnome/enabled":      "010062",
+				"/org/gnome/delay":        "2c0100000075",
+				"/org/gnome/sources":      "7573006672000306006173",
+			},
+			children: map[string][]string{
+				"/":           {"/org/gnome/"},
+				"/org/gnome/": {"/org/gnome/clock-format", "/org/gnome/delay", "/org/gnome/enabled", "/org/gnome/sources"},
+			},
+			tables: map[string]map[string]string{
+				".locks": {"/org/gnome/clock-format": "000073"},
+			},
+			missing: []string{"/org/", "clock-format", "/org/gnome/clock", "/org/gnome/clock-format/"}},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := os.ReadFile(filepath.Join("testdata", "golden", name))
+			require.NoError(t, err, "Setup: cannot load golden file")
+
+			require.GreaterOrEqual(t, len(data), 24, "File should contain a header")
+			require.Equal(t, "GVariant", string(data[:8]), "Header should start with the GVDB signature")
+			require.Equal(t, uint32(0), binary.LittleEndian.Uint32(data[8:]), "Header should have version 0")
+			root := openTestTable(t, data, binary.LittleEndian.Uint32(data[16:]), binary.LittleEndian.Uint32(data[20:]))
+
+			for key, want := range tc.values {
+				require.Equal(t, want, root.value(t, key), "Unexpected value for %q", key)
+			}
+			for key, want := range tc.children {
+				require.Equal(t, want, root.children(t, key), "Unexpected children for %q", key)
+			}
+			for key, values := range tc.tables {
+				sub := root.table(t, key)
+				for k, want := range values {
+					require.Equal(t, want, sub.value(t, k), "Unexpected value for %q in table %q", k, key)
+				}
+			}
+			for _, key := range tc.missing {
+				_, found := root.lookup(key)
+				require.False(t, found, "%q should not be found", key)
+			}
+		})
+	}
+}
+
+// testTable is a GVDB hash table decoded as GLib gvdb-reader.c does.
+type testTable struct {
+	data       []byte
+	buckets    []uint32
+	itemsStart int
+	nItems     int
+}
+
+// openTestTable decodes the hash table header, bloom filter and buckets between start and end.
+func openTestTable(t *testing.T, data []byte, start, end uint32) testTable {
+	t.Helper()
+
+	require.True(t, start%4 == 0 && start+8 <= end && int(end) <= len(data), "Hash table pointer should be aligned and in the file")
+	nBloom := binary.LittleEndian.Uint32(data[start:]) & (1<<27 - 1)
+	nBuckets := binary.LittleEndian.Uint32(data[start+4:])
+	bucketsStart := start + 8 + 4*nBloom
+	itemsStart := bucketsStart + 4*nBuckets
+	require.LessOrEqual(t, itemsStart, end, "Bloom filter and buckets should fit in the hash table")
+	require.Equal(t, uint32(0), (end-itemsStart)%24, "Items should fill the end of the hash table")
+
+	tb := testTable{data: data, itemsStart: int(itemsStart), nItems: int(end-itemsStart) / 24}
+	for i := uint32(0); i < nBuckets; i++ {
+		tb.buckets = append(tb.buckets, binary.LittleEndian.Uint32(data[bucketsStart+4*i:]))
+	}
+	return tb
+}
+
+// item returns the raw fields of item i.
+func (tb testTable) item(i int) (hash, parent uint32, key []byte, typ byte, start, end uint32) {
+	r := tb.data[tb.itemsStart+24*i:]
+	keyStart := binary.LittleEndian.Uint32(r[8:])
+	keySize := binary.LittleEndian.Uint16(r[12:])
+	return binary.LittleEndian.Uint32(r), binary.LittleEndian.Uint32(r[4:]),
+		tb.data[keyStart : keyStart+uint32(keySize)], r[14],
+		binary.LittleEndian.Uint32(r[16:]), binary.LittleEndian.Uint32(r[20:])
+}
+
+// checkName returns true if key is the full key of item i, made of its own key prefixed by its parents ones.
+func (tb testTable) checkName(i int, key string) bool {
+	_, parent, k, _, _, _ := tb.item(i)
+	if !strings.HasSuffix(key, string(k)) {
+		return false
+	}
+	key = strings.TrimSuffix(key, string(k))
+	if parent == 0xffffffff {
+		return key == ""
+	}
+	if int(parent) >= tb.nItems {
+		return false
+	}
+	return tb.checkName(int(parent), key)
+}
+
+// lookup returns the index of the item with the full key, looking only in its bucket.
+func (tb testTable) lookup(key string) (int, bool) {
+	if len(tb.buckets) == 0 {
+		return 0, false
+	}
+	hash := uint32(5381)
+	for i := 0; i < len(key); i++ {
+		hash = hash*33 + uint32(int32(int8(key[i])))
+	}
+	bucket := hash % uint32(len(tb.buckets))
+	itemNo := int(tb.buckets[bucket])
+	lastNo := tb.nItems
+	if int(bucket) != len(tb.buckets)-1 && int(tb.buckets[bucket+1]) < lastNo {
+		lastNo = int(tb.buckets[bucket+1])
+	}
+	for ; itemNo < lastNo; itemNo++ {
+		if h, _, _, _, _, _ := tb.item(itemNo); h == hash && tb.checkName(itemNo, key) {
+			return itemNo, true
+		}
+	}
+	return 0, false
+}
+
+// value returns the hex encoded variant of the 'v' item key.
+func (tb testTable) value(t *testing.T, key string) string {
+	t.Helper()
+
+	i, found := tb.lookup(key)
+	require.True(t, found, "%q should be found", key)
+	_, _, _, typ, start, end := tb.item(i)
+	require.Equal(t, byte('v'), typ, "%q should be a value", key)
+	require.True(t, start <= end && int(end) <= len(tb.data), "Value pointer of %q should be in the file", key)
+	return hex.EncodeToString(tb.data[start:end])
+}
+
+// children returns the sorted full keys of the 'L' item key.
+func (tb testTable) children(t *testing.T, key string) (children []string) {
+	t.Helper()
+
+	i, found := tb.lookup(key)
+	require.True(t, found, "%q should be found", key)
+	_, _, _, typ, start, end := tb.item(i)
+	require.Equal(t, byte('L'), typ, "%q should be a list", key)
+	require.True(t, start%4 == 0 && start <= end && int(end) <= len(tb.data), "List pointer of %q should be aligned and in the file", key)
+	for p := start; p+4 <= end; p += 4 {
+		child := int(binary.LittleEndian.Uint32(tb.data[p:]))
+		require.Less(t, child, tb.nItems, "Child of %q should be in the table", key)
+		_, parent, k, _, _, _ := tb.item(child)
+		require.Equal(t, uint32(i), parent, "Child of %q should have it as parent", key)
+		children = append(children, key+string(k))
+	}
+	sort.Strings(children)
+	return children
+}
+
+// table returns the nested table of the 'H' item key.
+func (tb testTable) table(t *testing.T, key string) testTable {
+	t.Helper()
+
+	i, found := tb.lookup(key)
+	require.True(t, found, "%q should be found", key)
+	_, _, _, typ, start, end := tb.item(i)
+	require.Equal(t, byte('H'), typ, "%q should be a hash table", key)
+	return openTestTable(t, tb.data, start, end)
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,91 @@
+package gvdb
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalVariant(t *testing.T) {
+	t.Parallel()
+
+	// Expected values are serialized by GLib g_variant_store() of the value wrapped in a variant.
+	tests := map[string]struct {
+		text  string
+		value *dbus.Variant
+
+		want    string
+		wantErr bool
+	}{
+		"boolean":              {text: "true", want: "010062"},
+		"byte":                 {text: "byte 0x41", want: "410079"},
+		"int16":                {text: "int16 -2", want: "feff006e"},
+		"uint16":               {text: "uint16 65535", want: "ffff0071"},
+		"int32":                {text: "42", want: "2a0000000069"},
+		"negative int32":       {text: "-7", want: "f9ffffff0069"},
+		"uint32":               {text: "uint32 7", want: "070000000075"},
+		"int64":                {text: "int64 -5", want: "fbffffffffffffff0078"},
+		"uint64":               {text: "uint64 18446744073709551615", want: "ffffffffffffffff0074"},
+		"double":               {text: "3.5", want: "0000000000000c400064"},
+		"string":               {text: "'hello world'", want: "68656c6c6f20776f726c64000073"},
+		"empty string":         {text: "''", want: "000073"},
+		"object path":          {text: "objectpath '/org/gnome'", want: "2f6f72672f676e6f6d6500006f"},
+		"bytestring":           {text: "b'bytes'", want: "627974657300006179"},
+		"variant":              {text: "<'inner'>", want: "696e6e65720000730076"},
+		"array of strings":     {text: "['i', 'can', 'has', 'strings?']", want: "690063616e0068617300737472696e67733f0002060a13006173"},
+		"empty array":          {text: "@as []", want: "006173"},
+		"array of int32":       {text: "[1, 2, 3]", want: "010000000200000003000000006169"},
+		"array of booleans":    {text: "[true, false, true]", want: "010001006162"},
+		"array of variants":    {text: "[<1>, <'a'>]", want: "010000000069000061000073060c006176"},
+		"array of arrays":      {text: "[['a', 'b'], ['c']]", want: "610062000204630002060900616173"},
+		"dictionary":           {text: "{'hi': -2}", want: "68690000feffffff030900617b73697d"},
+		"tuple":                {value: variant(t, []interface{}{"foo", int32(-1)}, "(si)"), want: "666f6f00ffffffff040028736929"},
+		"fixed size tuple":     {value: variant(t, []interface{}{int32(1), byte(2)}, "(iy)"), want: "01000000020000000028697929"},
+		"unit tuple":           {value: variant(t, []interface{}{}, "()"), want: "00002829"},
+		"tuple from struct":    {value: variant(t, struct{ A string }{"a"}, "(s)"), want: "610000287329"},
+		"array of tuples":      {value: variant(t, [][]interface{}{{int32(1), "a"}, {int32(2), "bb"}}, "a(is)"), want: "010000006100000002000000626200060f006128697329"},
+		"offsets on two bytes": {text: "['" + strings.Repeat("a", 300) + "', 'b']", want: strings.Repeat("61", 300) + "0062002d012f01006173"},
+
+		// Error cases
+		"error on overflow":             {value: variant(t, int64(1<<40), "i"), wantErr: true},
+		"error on negative unsigned":    {value: variant(t, int32(-1), "u"), wantErr: true},
+		"error on nul byte in string":   {value: variant(t, "a\x00b", "s"), wantErr: true},
+		"error on type mismatch":        {value: variant(t, "a", "i"), wantErr: true},
+		"error on tuple members number": {value: variant(t, []interface{}{"a"}, "(si)"), wantErr: true},
+		"error on variant without type": {value: &dbus.Variant{}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := tc.value
+			if v == nil {
+				parsed, err := dbus.ParseVariant(tc.text, dbus.Signature{})
+				require.NoError(t, err, "Setup: can't parse variant")
+				v = &parsed
+			}
+
+			got, err := marshalVariant(*v)
+			if tc.wantErr {
+				require.Error(t, err, "marshalVariant should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "marshalVariant failed but shouldn't have")
+			require.Equal(t, tc.want, hex.EncodeToString(got), "marshalVariant returned unexpected data")
+		})
+	}
+}
+
+// variant returns a variant of v with the type sig.
+func variant(t *testing.T, v interface{}, sig string) *dbus.Variant {
+	t.Helper()
+
+	s, err := dbus.ParseSignature(sig)
+	require.NoError(t, err, "Setup: invalid signature")
+	r := dbus.MakeVariantWithSignature(v, s)
+	return &r
+}
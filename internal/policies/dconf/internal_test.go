@@ -29,6 +29,7 @@ func TestNormalize(t *testing.T) {
 		"string with escaped quotes":                      {keyType: "s", value: `this isn\'t a quote`, want: `'this isn\'t a quote'`},
 		"string with multiple backslashes escaped quotes": {keyType: "s", value: `this isn\\\'t a quote`, want: `'this isn\\\'t a quote'`},
 		"string with two backslashes don’t escape quotes": {keyType: "s", value: `this isn\\'t a quote`, want: `'this isn\\\'t a quote'`},
+		"multi-lines string has escaped line breaks":      {keyType: "s", value: "first line\nsecond line\r\nthird line", want: `'first line\nsecond line\r\nthird line'`},
 
 		// boolean cases
 		"simple boolean true":             {keyType: "b", value: "true", want: "true"},
@@ -99,6 +100,45 @@ func TestNormalize(t *testing.T) {
 		"Multi-lines ai with leading or trailing empty lines before [] are ignored": {keyType: "ai", value: "[\n\n\n\n1\n2\n3\n\n\n\n\n]", want: "[1, 2, 3]"},
 		"Multi-lines ai with leading or trailing empty lines after [] are ignored":  {keyType: "ai", value: "\n\n\n\n[1\n2\n3]\n\n\n\n\n", want: "[1, 2, 3]"},
 
+		// other basic types cases
+		"double":                                {keyType: "d", value: "0.5", want: "0.5"},
+		"integer double gets a decimal point":   {keyType: "d", value: "2", want: "2.0"},
+		"quoted double":                         {keyType: "d", value: "'1.5'", want: "1.5"},
+		"uint32 is annotated":                   {keyType: "u", value: "5", want: "uint32 5"},
+		"already annotated uint32 is kept":      {keyType: "u", value: "uint32 5", want: "uint32 5"},
+		"quoted uint64 is annotated":            {keyType: "t", value: `"18446744073709551615"`, want: "uint64 18446744073709551615"},
+		"hexadecimal int16 is annotated":        {keyType: "n", value: "0x10", want: "int16 16"},
+		"out of range uint32 is returned as is": {keyType: "u", value: "-1", want: "-1"},
+
+		// tuples cases
+		"simple tuple":                             {keyType: "(ii)", value: "(1, 2)", want: "(1, 2)"},
+		"tuple without parenthesis":                {keyType: "(ii)", value: "1,2", want: "(1, 2)"},
+		"tuple with unquoted strings":              {keyType: "(ss)", value: "(xkb, us)", want: "('xkb', 'us')"},
+		"tuple with members of other types":        {keyType: "(sub)", value: "name, 3, yes", want: "('name', uint32 3, true)"},
+		"one member tuple":                         {keyType: "(s)", value: "alone", want: "('alone',)"},
+		"tuple with wrong number of members as is": {keyType: "(ii)", value: "1,2,3", want: "1,2,3"},
+		"tuple with quoted comma in string":        {keyType: "(ss)", value: "'a,b', c", want: "('a,b', 'c')"},
+
+		// arrays of containers cases
+		"simple array of tuples":                          {keyType: "a(ss)", value: "[('xkb', 'us'), ('xkb', 'fr')]", want: "[('xkb', 'us'), ('xkb', 'fr')]"},
+		"array of tuples without brackets":                {keyType: "a(ss)", value: "(xkb, us), (xkb, fr)", want: "[('xkb', 'us'), ('xkb', 'fr')]"},
+		"Multi-lines array of tuples without parenthesis": {keyType: "a(ss)", value: "xkb, us\n\nxkb, fr\n", want: "[('xkb', 'us'), ('xkb', 'fr')]"},
+		"one tuple array without parenthesis":             {keyType: "a(ss)", value: "xkb, us", want: "[('xkb', 'us')]"},
+		"empty array of tuples is annotated":              {keyType: "a(ss)", value: "", want: "@a(ss) []"},
+		"array of arrays of strings":                      {keyType: "aas", value: "[a, b]\n[c]", want: "[['a', 'b'], ['c']]"},
+		"array of doubles":                                {keyType: "ad", value: "1, 2.5", want: "[1.0, 2.5]"},
+
+		// dictionaries cases
+		"simple dictionary":                             {keyType: "a{ss}", value: "{'a': 'b', 'c': 'd'}", want: "{'a': 'b', 'c': 'd'}"},
+		"dictionary without braces nor quotes":          {keyType: "a{ss}", value: "a: b, c: d", want: "{'a': 'b', 'c': 'd'}"},
+		"Multi-lines dictionary":                        {keyType: "a{ss}", value: "a: b\nc: d\n", want: "{'a': 'b', 'c': 'd'}"},
+		"dictionary values can contain colons":          {keyType: "a{ss}", value: "url: http://example.com", want: "{'url': 'http://example.com'}"},
+		"dictionary of integers is annotated":           {keyType: "a{su}", value: "a: 1", want: "{'a': uint32 1}"},
+		"dictionary of variants":                        {keyType: "a{sv}", value: "{'a': <1>, 'b': <'c'>}", want: "{'a': <1>, 'b': <'c'>}"},
+		"dictionary of variants without angle brackets": {keyType: "a{sv}", value: "a: 1, b: 'c'", want: "{'a': <1>, 'b': <'c'>}"},
+		"empty dictionary is annotated":                 {keyType: "a{sv}", value: "{}", want: "@a{sv} {}"},
+		"dictionary entry without value is as is":       {keyType: "a{ss}", value: "a: b, c", want: "a: b, c"},
+
 		// Unmanaged cases
 		"unmanaged types are returned as is": {keyType: "xxx", value: "hello [ %x bar 🤪", want: "hello [ %x bar 🤪"},
 	}
@@ -0,0 +1,303 @@
+package dconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/dconf/gvariant"
+)
+
+/*
+	Notes:
+	Keys are looked up in the installed gsettings schemas by their path. Keys of relocatable schemas (without any path)
+	can be set on any path: a key which isn't part of a schema with a path is then checked against every relocatable
+	schema key of the same name, and accepted if any of them validates its value.
+	Enums are stored as their nick in dconf, and flags as an array of nicks.
+	If no schema is installed at all, we can't check anything and only the signature of the values is checked.
+*/
+
+// schemaKey is a key of a gsettings schema, with the constraints on its value.
+type schemaKey struct {
+	schema  string
+	keyType string
+	// min and max are GVariant text values of the key type, empty if not set.
+	min, max string
+	// choices are the only values a string key, or the elements of an array of strings, can take.
+	choices []string
+}
+
+// schemas are the keys of the installed gsettings schemas.
+type schemas struct {
+	// byPath indexes keys of schemas with a path, by their path without leading /.
+	byPath map[string]schemaKey
+	// relocatable indexes keys of relocatable schemas by key name.
+	relocatable map[string][]schemaKey
+}
+
+// gschemaList is the content of a gsettings schema file.
+type gschemaList struct {
+	Enums []gschemaEnum `xml:"enum"`
+	Flags []gschemaEnum `xml:"flags"`
+
+	Schemas []struct {
+		ID   string `xml:"id,attr"`
+		Path string `xml:"path,attr"`
+		Keys []struct {
+			Name  string `xml:"name,attr"`
+			Type  string `xml:"type,attr"`
+			Enum  string `xml:"enum,attr"`
+			Flags string `xml:"flags,attr"`
+			Range *struct {
+				Min string `xml:"min,attr"`
+				Max string `xml:"max,attr"`
+			} `xml:"range"`
+			Choices []struct {
+				Value string `xml:"value,attr"`
+			} `xml:"choices>choice"`
+		} `xml:"key"`
+	} `xml:"schema"`
+}
+
+// gschemaEnum is an enum or flags definition of a gsettings schema file.
+type gschemaEnum struct {
+	ID     string `xml:"id,attr"`
+	Values []struct {
+		Nick string `xml:"nick,attr"`
+	} `xml:"value"`
+}
+
+// installedSchemas returns the gsettings schemas installed in the schemas directory of the manager.
+// They are only loaded again when the modification time of the directory changes, as installing or removing a
+// schema file does.
+func (m *Manager) installedSchemas(ctx context.Context) (*schemas, error) {
+	dir := m.schemasDir
+	if dir == "" {
+		dir = consts.DefaultGSettingsSchemasDir
+	}
+
+	m.schemasMu.Lock()
+	defer m.schemasMu.Unlock()
+
+	var modTime time.Time
+	if fi, err := os.Stat(dir); err == nil {
+		modTime = fi.ModTime()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(i18n.G("can't load gsettings schemas from %s: %v"), dir, err)
+	}
+	if m.schemasLoaded && modTime.Equal(m.schemasModTime) {
+		return m.schemas, nil
+	}
+
+	s, err := loadSchemas(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	m.schemas, m.schemasModTime, m.schemasLoaded = s, modTime, true
+	return s, nil
+}
+
+// loadSchemas loads the gsettings schemas installed in dir.
+// It returns nil if there is no schema installed.
+func loadSchemas(ctx context.Context, dir string) (s *schemas, err error) {
+	defer decorate.OnError(&err, i18n.G("can't load gsettings schemas from %s"), dir)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.gschema.xml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		log.Warningf(ctx, "No gsettings schema installed in %s: dconf keys can't be checked against them", dir)
+		return nil, nil
+	}
+
+	// Enums and flags can be defined in other files than the schemas using them
+	var lists []gschemaList
+	enums := make(map[string][]string)
+	flags := make(map[string][]string)
+	for _, p := range paths {
+		d, err := os.ReadFile(filepath.Clean(p))
+		if err != nil {
+			return nil, err
+		}
+		var l gschemaList
+		if err := xml.Unmarshal(d, &l); err != nil {
+			return nil, fmt.Errorf(i18n.G("%s is an invalid schema: %v"), p, err)
+		}
+		for _, e := range l.Enums {
+			for _, v := range e.Values {
+				enums[e.ID] = append(enums[e.ID], v.Nick)
+			}
+		}
+		for _, f := range l.Flags {
+			for _, v := range f.Values {
+				flags[f.ID] = append(flags[f.ID], v.Nick)
+			}
+		}
+		lists = append(lists, l)
+	}
+
+	s = &schemas{
+		byPath:      make(map[string]schemaKey),
+		relocatable: make(map[string][]schemaKey),
+	}
+	for _, l := range lists {
+		for _, schema := range l.Schemas {
+			for _, k := range schema.Keys {
+				key := schemaKey{schema: schema.ID, keyType: k.Type}
+				for _, c := range k.Choices {
+					key.choices = append(key.choices, c.Value)
+				}
+				if k.Range != nil {
+					key.min, key.max = k.Range.Min, k.Range.Max
+				}
+
+				var ok bool
+				switch {
+				case k.Enum != "":
+					key.keyType = "s"
+					if key.choices, ok = enums[k.Enum]; !ok {
+						return nil, fmt.Errorf(i18n.G("enum %s referenced by %s doesn't exist"), k.Enum, schema.ID)
+					}
+				case k.Flags != "":
+					key.keyType = "as"
+					if key.choices, ok = flags[k.Flags]; !ok {
+						return nil, fmt.Errorf(i18n.G("flags %s referenced by %s don't exist"), k.Flags, schema.ID)
+					}
+				}
+
+				if schema.Path == "" {
+					s.relocatable[k.Name] = append(s.relocatable[k.Name], key)
+					continue
+				}
+				s.byPath[strings.TrimPrefix(schema.Path, "/")+k.Name] = key
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// check returns an error if key is not defined in the schemas, or if the value, of type keyType, is not valid for
+// this key.
+// The value of disabled keys is not checked, as they are not set.
+func (s schemas) check(key, keyType, value string, disabled bool) error {
+	candidates := s.relocatable[filepath.Base(key)]
+	if k, ok := s.byPath[key]; ok {
+		candidates = []schemaKey{k}
+	}
+	if len(candidates) == 0 {
+		return errors.New(i18n.G("key is not defined in any installed gsettings schema"))
+	}
+	if disabled {
+		return nil
+	}
+
+	var err error
+	for _, k := range candidates {
+		if err = k.check(keyType, value); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// check returns an error if value, of type keyType, is not of the key type or doesn't respect its range or choices.
+func (k schemaKey) check(keyType, value string) error {
+	if keyType != k.keyType {
+		return fmt.Errorf(i18n.G("type %q doesn't match type %q of schema %s"), keyType, k.keyType, k.schema)
+	}
+
+	v, err := gvariant.Parse(value, keyType)
+	if err != nil {
+		return err
+	}
+
+	if k.choices != nil {
+		var values []interface{}
+		switch val := v.Value().(type) {
+		case string:
+			values = []interface{}{val}
+		case []interface{}:
+			values = val
+		}
+		for _, val := range values {
+			if !contains(k.choices, val.(string)) {
+				return fmt.Errorf(i18n.G("%q is not a valid choice of schema %s, expected one of: %s"), val, k.schema, strings.Join(k.choices, ", "))
+			}
+		}
+	}
+
+	if k.min == "" && k.max == "" {
+		return nil
+	}
+	n, err := number(v.Value())
+	if err != nil {
+		return err
+	}
+	for _, limit := range []struct {
+		value string
+		cmp   int
+		msg   string
+	}{
+		{k.min, -1, i18n.G("%s is lower than the minimum %s of schema %s")},
+		{k.max, 1, i18n.G("%s is greater than the maximum %s of schema %s")},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		l, err := gvariant.Parse(limit.value, keyType)
+		if err != nil {
+			return fmt.Errorf(i18n.G("invalid range value %q in schema %s: %v"), limit.value, k.schema, err)
+		}
+		ln, err := number(l.Value())
+		if err != nil {
+			return err
+		}
+		if n.Cmp(ln) == limit.cmp {
+			return fmt.Errorf(limit.msg, value, limit.value, k.schema)
+		}
+	}
+
+	return nil
+}
+
+// number returns v as a number which can be compared whatever its type.
+func number(v interface{}) (*big.Float, error) {
+	n := new(big.Float).SetPrec(128)
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return n.SetInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return n.SetUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(rv.Float()) {
+			return nil, errors.New(i18n.G("NaN is not comparable"))
+		}
+		return n.SetFloat64(rv.Float()), nil
+	}
+	return nil, fmt.Errorf(i18n.G("range is not supported on %v"), v)
+}
+
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
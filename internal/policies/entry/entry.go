@@ -10,6 +10,7 @@ import (
 
 	"github.com/ubuntu/adsys/internal/decorate"
 	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
 	"gopkg.in/yaml.v3"
 )
 
@@ -21,11 +22,22 @@ type Entry struct {
 	Value    string
 	Disabled bool
 	Meta     string
+	// ReleaseOverride is the release (VERSION_ID) whose Override<VERSION_ID> option replaced the default value.
+	ReleaseOverride string `yaml:",omitempty"`
+	// DefaultOnly is set when the Enforce option of the policy is unchecked: the value is only a default that users
+	// can change.
+	DefaultOnly bool `yaml:",omitempty"`
 }
 
 const (
 	// GPORulesCacheBaseName is the base directory where we want to cache gpo rules
 	GPORulesCacheBaseName = "gpo_rules"
+	// GPORulesHistoryBaseName is the base directory where we keep previous snapshots of gpo rules
+	GPORulesHistoryBaseName = "gpo_rules_history"
+	// GPOCacheBaseName is the base directory where we want to cache downloaded gpos
+	GPOCacheBaseName = "gpo_cache"
+	// Krb5CCBaseName is the base directory, in the run directory, where we store kerberos ticket symlinks
+	Krb5CCBaseName = "krb5cc"
 )
 
 // GPO is a representation of a GPO with rules we support
@@ -34,6 +46,8 @@ type GPO struct {
 	Name string
 	// the string is the domain of rules (dconf, install…)
 	Rules map[string][]Entry
+	// Extensions are the GUIDs of the client-side extensions having settings in the GPO for the object, if known.
+	Extensions []string `yaml:",omitempty"`
 }
 
 // GetUniqueRules return order rules, with one entry per key for a given type.
@@ -74,9 +88,17 @@ func GetUniqueRules(gpos []GPO) map[string][]Entry {
 	return r
 }
 
-// FormatGPO write to w a formatted GPO. overridden entries are prepended with -
+// FormatGPO write to w a formatted GPO, followed by its client-side extensions if known. overridden entries are
+// prepended with -
 func (g GPO) FormatGPO(w io.Writer, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}) map[string]struct{} {
 	fmt.Fprintf(w, "* %s (%s)\n", g.Name, g.ID)
+	if len(g.Extensions) > 0 {
+		var names []string
+		for _, e := range g.Extensions {
+			names = append(names, adcommon.ExtensionName(e))
+		}
+		fmt.Fprintf(w, "  Extensions: %s\n", strings.Join(names, ", "))
+	}
 
 	if !withRules {
 		return nil
@@ -268,7 +268,8 @@ func TestCacheGPOList(t *testing.T) {
 				{Key: "B", Value: "standardB", Disabled: true},
 				// this value will be overridden with the higher one
 				{Key: "C", Value: "standardC"},
-			}}},
+			}},
+			Extensions: []string{"{35378EAC-683F-11D2-A89A-0080C7A4D040}"}},
 	}
 
 	p := filepath.Join(t.TempDir(), "gpos-list-cache")
@@ -291,14 +292,19 @@ func TestFormatGPO(t *testing.T) {
 	}
 
 	tests := map[string]struct {
+		gpo                   int
 		withRules             bool
 		withOverridden        bool
 		alreadyProcessedRules map[string]struct{}
 
 		wantAlreadyProcessedRules map[string]struct{}
 	}{
-		"GPO summary":    {},
-		"GPO with rules": {withRules: true, wantAlreadyProcessedRules: defaultProcessedRules},
+		"GPO summary":                 {},
+		"GPO with rules":              {withRules: true, wantAlreadyProcessedRules: defaultProcessedRules},
+		"GPO summary with extensions": {gpo: 1},
+		"GPO with rules and extensions": {gpo: 1, withRules: true, wantAlreadyProcessedRules: map[string]struct{}{
+			"dconf/path/to/key4": {},
+		}},
 		"GPO with rules and overrides, no rules processed": {withRules: true, withOverridden: true, wantAlreadyProcessedRules: defaultProcessedRules},
 		"GPO with rules, appending to existing treated key": {
 			withRules:             true,
@@ -338,7 +344,7 @@ func TestFormatGPO(t *testing.T) {
 
 			var out strings.Builder
 
-			got := gpos[0].FormatGPO(&out, tc.withRules, tc.withOverridden, tc.alreadyProcessedRules)
+			got := gpos[tc.gpo].FormatGPO(&out, tc.withRules, tc.withOverridden, tc.alreadyProcessedRules)
 
 			// check cache between FormatGPO calls
 			require.Equal(t, tc.wantAlreadyProcessedRules, got, "FormatGPO returns expected alreadyProcessedRules cache")
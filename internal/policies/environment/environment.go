@@ -0,0 +1,360 @@
+// Package environment sets environment variables in the sessions of the machine users, and for specific users.
+package environment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+/*
+	Notes:
+	Variables come from 2 kinds of "environment" rules:
+	  - administrative templates: "system-environment" and "user-environment" keys, a list of NAME=value lines.
+	  - Group Policy Preferences environment variables: the key is the variable name, and the entry Meta contains the
+	    item properties, like its "partial" flag to append the value to the existing one.
+	Preferences override the administrative templates for the same variable. Deleted preferences remove it.
+	Values can reference other variables with ${NAME} or $NAME.
+
+	Machine variables are written to <environment.d dir>/90-adsys.conf, read by systemd for every user session.
+	User variables are written to <run dir>/environment/<uid>.conf. The 90-adsys user environment generator runs
+	"adsysd environment <file>" when the user systemd instance starts, which expands and exports them.
+	Files are removed when no variable applies anymore.
+*/
+
+const (
+	machineKey = "system-environment"
+	usersKey   = "user-environment"
+
+	adsysMachineFileName = "90-adsys.conf"
+
+	fileHeader = `# This file is managed by adsys.
+# Do not edit this file manually.
+# Any changes will be overwritten.
+`
+)
+
+// variableNameRe matches valid environment variable names.
+var variableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Manager serializes ApplyPolicy calls, so that the machine environment.d file and the per-user environment files are
+// not written concurrently.
+type Manager struct {
+	mu sync.Mutex
+
+	environmentDir string
+	runDir         string
+	userLookup     func(string) (*user.User, error)
+}
+
+type options struct {
+	environmentDir string
+	runDir         string
+	userLookup     func(string) (*user.User, error)
+}
+type option func(*options) error
+
+// WithEnvironmentDir specifies a personalized environment.d directory for machine variables
+func WithEnvironmentDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.environmentDir = p
+		return nil
+	}
+}
+
+// WithRunDir specifies a personalized /run
+func WithRunDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.runDir = p
+		return nil
+	}
+}
+
+// WithUserLookup specifies a personalized function to resolve user names
+func WithUserLookup(f func(string) (*user.User, error)) func(o *options) error {
+	return func(o *options) error {
+		o.userLookup = f
+		return nil
+	}
+}
+
+// New returns a new manager for environment policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new environment handler manager"))
+
+	// defaults
+	args := options{
+		environmentDir: "/etc/environment.d",
+		runDir:         consts.DefaultRunDir,
+		userLookup:     user.Lookup,
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		environmentDir: args.environmentDir,
+		runDir:         args.runDir,
+		userLookup:     args.userLookup,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the environment manager:
+// variables come from registry values, and from the environment preferences of the GPO, which Windows administrators
+// use to set them.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension, adcommon.EnvironmentExtension}
+}
+
+type variable struct {
+	name  string
+	value string
+}
+
+// ApplyPolicy writes the environment variables of the machine or of a user, removing the ones not set anymore.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply environment policy to %s"), objectName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy environment policy to %s", objectName)
+
+	variables, err := parseVariables(ctx, objectName, isComputer, entries)
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if len(variables) > 0 {
+		content = fileHeader
+		for _, v := range variables {
+			content += fmt.Sprintf("%s=%s\n", v.name, v.value)
+		}
+	}
+
+	if isComputer {
+		return writeOrRemove(ctx, filepath.Join(m.environmentDir, adsysMachineFileName), content, 0644, -1, -1)
+	}
+
+	u, err := m.userLookup(objectName)
+	if err != nil {
+		return fmt.Errorf(i18n.G("can't find user %q: %v"), objectName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid uid %q for %q: %v"), u.Uid, objectName, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid gid %q for %q: %v"), u.Gid, objectName, err)
+	}
+
+	// Users need to traverse the run directory to read their own variables
+	usersDir := filepath.Join(m.runDir, "environment")
+	if content != "" {
+		if err := os.MkdirAll(usersDir, 0755); err != nil {
+			return err
+		}
+		for _, d := range []string{m.runDir, usersDir} {
+			// #nosec G302 - directories only contain files with their own permissions
+			if err := os.Chmod(d, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return writeOrRemove(ctx, filepath.Join(usersDir, u.Uid+".conf"), content, 0600, uid, gid)
+}
+
+// parseVariables returns the variables to set, in order, from the administrative templates and preferences entries.
+func parseVariables(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) ([]variable, error) {
+	key, otherKey := machineKey, usersKey
+	if !isComputer {
+		key, otherKey = usersKey, machineKey
+	}
+
+	var variables []variable
+	index := make(map[string]int)
+	set := func(name, value string) {
+		if i, ok := index[name]; ok {
+			variables[i].value = value
+			return
+		}
+		index[name] = len(variables)
+		variables = append(variables, variable{name: name, value: value})
+	}
+
+	var errMsgs []string
+	var preferences []entry.Entry
+	for _, e := range entries {
+		switch e.Key {
+		case otherKey:
+			log.Warningf(ctx, i18n.G("Ignoring unsupported environment key %q for %s"), e.Key, objectName)
+			continue
+		case key:
+		default:
+			preferences = append(preferences, e)
+			continue
+		}
+
+		if e.Disabled {
+			continue
+		}
+		for _, l := range strings.Split(e.Value, "\n") {
+			l = strings.TrimSpace(l)
+			if l == "" {
+				continue
+			}
+			kv := strings.SplitN(l, "=", 2)
+			if len(kv) != 2 {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: expected NAME=value"), l))
+				continue
+			}
+			name := strings.TrimSpace(kv[0])
+			if !variableNameRe.MatchString(name) {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: invalid variable name %q"), l, name))
+				continue
+			}
+			set(name, strings.TrimSpace(kv[1]))
+		}
+	}
+
+	// Preferences override the variables set by administrative templates
+	for _, e := range preferences {
+		if !variableNameRe.MatchString(e.Key) {
+			errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on preference %s: invalid variable name"), e.Key))
+			continue
+		}
+		if e.Disabled {
+			if i, ok := index[e.Key]; ok {
+				variables = append(variables[:i], variables[i+1:]...)
+				delete(index, e.Key)
+				for n, j := range index {
+					if j > i {
+						index[n] = j - 1
+					}
+				}
+			}
+			continue
+		}
+		if strings.Contains(e.Value, "\n") {
+			errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on preference %s: value can't span multiple lines"), e.Key))
+			continue
+		}
+
+		var props map[string]string
+		if e.Meta != "" {
+			if err := json.Unmarshal([]byte(e.Meta), &props); err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on preference %s: invalid properties: %v"), e.Key, err))
+				continue
+			}
+		}
+		value := e.Value
+		if props["partial"] == "1" {
+			value = fmt.Sprintf("${%s}:%s", e.Key, value)
+		}
+		set(e.Key, value)
+	}
+
+	if errMsgs != nil {
+		return nil, errors.New(strings.Join(errMsgs, "\n"))
+	}
+	return variables, nil
+}
+
+// writeOrRemove atomically writes content to p, or removes p if content is empty.
+// The file is owned by uid and gid if they are not -1.
+func writeOrRemove(ctx context.Context, p, content string, perm os.FileMode, uid, gid int) error {
+	if content == "" {
+		log.Debugf(ctx, "No environment variable to set, removing %s", p)
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if old, err := os.ReadFile(p); err == nil && string(old) == content {
+		log.Debugf(ctx, "%s didn't change", p)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", []byte(content), perm); err != nil {
+		return err
+	}
+	if err := os.Lchown(p+".new", uid, gid); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// Generate writes to w the variables of the environment file p, in the format of systemd environment generators.
+// References to other variables are expanded from the variables already defined in the file, then from the current
+// environment. A missing file sets no variable.
+func Generate(ctx context.Context, w io.Writer, p string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't generate environment from %s"), p)
+
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debugf(ctx, "No environment file %s", p)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	defined := make(map[string]string)
+	lookup := func(name string) string {
+		if v, ok := defined[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || !variableNameRe.MatchString(kv[0]) {
+			log.Warningf(ctx, i18n.G("Skipping invalid environment line %q"), l)
+			continue
+		}
+		value := os.Expand(kv[1], lookup)
+		defined[kv[0]] = value
+		if _, err := fmt.Fprintf(w, "%s=%s\n", kv[0], quote(value)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// quote returns value as a double quoted string, escaping the characters interpreted by systemd environment files.
+func quote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	return `"` + r.Replace(value) + `"`
+}
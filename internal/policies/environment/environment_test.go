@@ -0,0 +1,217 @@
+package environment_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+)
+
+var update bool
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries          []entry.Entry
+		isUser           bool
+		existing         string
+		userLookupFails  bool
+		userLookupBadUID bool
+
+		wantNoFile bool
+		wantErr    bool
+	}{
+		// machine cases
+		"machine, one variable": {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim"}}},
+		"machine, multiple variables": {entries: []entry.Entry{{Key: "system-environment", Value: `EDITOR=vim
+
+  PAGER = less  `}}},
+		"machine, value with equal signs and references": {entries: []entry.Entry{{Key: "system-environment", Value: "JAVA_TOOL_OPTIONS=-Dfile.encoding=UTF-8 -Duser.home=${HOME}"}}},
+		"machine, last definition wins":                  {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nPAGER=less\nEDITOR=nano"}}},
+		"machine, user key is ignored": {entries: []entry.Entry{
+			{Key: "system-environment", Value: "EDITOR=vim"},
+			{Key: "user-environment", Value: "PAGER=less"}}},
+		"machine, preferences override templates": {entries: []entry.Entry{
+			{Key: "system-environment", Value: "EDITOR=vim\nPAGER=less"},
+			{Key: "EDITOR", Value: "nano", Meta: `{"action":"U","name":"EDITOR","partial":"0","value":"nano"}`},
+			{Key: "JAVA_HOME", Value: "/usr/lib/jvm/default-java", Meta: `{"action":"C","name":"JAVA_HOME","value":"/usr/lib/jvm/default-java"}`}}},
+		"machine, partial preference appends to existing value": {entries: []entry.Entry{
+			{Key: "PATH", Value: "/opt/tools/bin", Meta: `{"action":"U","name":"PATH","partial":"1","value":"/opt/tools/bin"}`}}},
+		"machine, deleted preference removes variable": {entries: []entry.Entry{
+			{Key: "system-environment", Value: "EDITOR=vim\nPAGER=less\nOLD_TOOLS=/opt/old"},
+			{Key: "OLD_TOOLS", Disabled: true, Meta: `{"action":"D","name":"OLD_TOOLS","value":""}`}}},
+		"machine, disabled entry sets nothing":           {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim", Disabled: true}}, wantNoFile: true},
+		"machine, no entries sets nothing":               {wantNoFile: true},
+		"machine, previous variables are replaced":       {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim"}}, existing: "machine"},
+		"machine, unchanged variables are kept":          {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nPAGER=less"}}, existing: "machine"},
+		"machine, no entries removes previous variables": {existing: "machine", wantNoFile: true},
+
+		// user cases
+		"user, one variable": {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=emacs"}}, isUser: true},
+		"user, machine key is ignored": {entries: []entry.Entry{
+			{Key: "user-environment", Value: "EDITOR=emacs"},
+			{Key: "system-environment", Value: "PAGER=less"}}, isUser: true},
+		"user, preferences only": {entries: []entry.Entry{
+			{Key: "GOPATH", Value: "${HOME}/go", Meta: `{"action":"U","name":"GOPATH","value":"${HOME}/go"}`}}, isUser: true},
+		"user, previous variables are replaced":       {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=emacs"}}, isUser: true, existing: "user"},
+		"user, no entries removes previous variables": {isUser: true, existing: "user", wantNoFile: true},
+		"user, no entries and no previous variables":  {isUser: true, wantNoFile: true},
+
+		// error cases
+		"error on line without equal sign":       {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nPAGER"}}, wantErr: true},
+		"error on invalid variable name":         {entries: []entry.Entry{{Key: "system-environment", Value: "MY-VAR=value"}}, wantErr: true},
+		"error on invalid preference name":       {entries: []entry.Entry{{Key: "MY VAR", Value: "value", Meta: `{"action":"U"}`}}, wantErr: true},
+		"error on multiline preference value":    {entries: []entry.Entry{{Key: "EDITOR", Value: "vim\nnano", Meta: `{"action":"U"}`}}, wantErr: true},
+		"error on invalid preference properties": {entries: []entry.Entry{{Key: "EDITOR", Value: "vim", Meta: `not json`}}, wantErr: true},
+		"error on invalid entry keeps previous variables": {
+			entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\n1NVALID=value"}}, existing: "machine", wantErr: true},
+		"error on user lookup failure": {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=emacs"}}, isUser: true, userLookupFails: true, wantErr: true},
+		"error on invalid user uid":    {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=emacs"}}, isUser: true, userLookupBadUID: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			environmentDir := t.TempDir()
+			runDir := t.TempDir()
+
+			objectName := "hostname"
+			p := filepath.Join(environmentDir, "90-adsys.conf")
+			if tc.isUser {
+				objectName = "user@example.com"
+				p = filepath.Join(runDir, "environment", fmt.Sprintf("%d.conf", os.Getuid()))
+			}
+
+			if tc.existing != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750), "Setup: can't create destination directory")
+				content, err := os.ReadFile(filepath.Join("testdata", "existing", tc.existing))
+				require.NoError(t, err, "Setup: can't read existing variables")
+				require.NoError(t, os.WriteFile(p, content, 0600), "Setup: can't create existing variables")
+			}
+
+			m, err := environment.New(
+				environment.WithEnvironmentDir(environmentDir),
+				environment.WithRunDir(runDir),
+				environment.WithUserLookup(func(name string) (*user.User, error) {
+					if tc.userLookupFails {
+						return nil, errors.New("user lookup failure")
+					}
+					u := &user.User{Username: name, Uid: fmt.Sprint(os.Getuid()), Gid: fmt.Sprint(os.Getgid())}
+					if tc.userLookupBadUID {
+						u.Uid = "notanumber"
+					}
+					return u, nil
+				}))
+			require.NoError(t, err, "Setup: can't create environment manager")
+
+			err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			require.NoFileExists(t, p+".new", "Temporary file should be cleaned up")
+			if tc.wantNoFile || (tc.wantErr && tc.existing == "") {
+				require.NoFileExists(t, p, "No environment file should be written")
+				return
+			}
+
+			got, err := os.ReadFile(p)
+			require.NoError(t, err, "Environment file should exist")
+
+			goldPath := filepath.Join("testdata", "golden", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				require.NoError(t, os.WriteFile(goldPath, got, 0600), "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load environment golden file")
+
+			require.Equal(t, string(want), string(got), "ApplyPolicy wrote expected variables")
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content    string
+		noFile     bool
+		unreadable bool
+
+		want    string
+		wantErr bool
+	}{
+		"simple variables": {content: "EDITOR=vim\nPAGER=less\n", want: "EDITOR=\"vim\"\nPAGER=\"less\"\n"},
+		"comments and empty lines are skipped": {
+			content: "# This file is managed by adsys.\n\nEDITOR=vim\n  \n",
+			want:    "EDITOR=\"vim\"\n"},
+		"variables of the file are expanded": {
+			content: "TOOLS=/opt/tools\nTOOLS_BIN=${TOOLS}/bin\nTOOLS_LIB=$TOOLS/lib\n",
+			want:    "TOOLS=\"/opt/tools\"\nTOOLS_BIN=\"/opt/tools/bin\"\nTOOLS_LIB=\"/opt/tools/lib\"\n"},
+		"variables of the environment are expanded": {
+			content: "PATH=${PATH}:/opt/tools/bin\n",
+			want:    fmt.Sprintf("PATH=%q\n", os.Getenv("PATH")+":/opt/tools/bin")},
+		"unset variables expand to empty": {
+			content: "LIBS=${ADSYS_TEST_DOESNOTEXIST}:/opt/lib\n",
+			want:    "LIBS=\":/opt/lib\"\n"},
+		"special characters are escaped": {
+			content: "MESSAGE=say \"hello\" with `echo` and \\n for 5$\n",
+			want:    "MESSAGE=\"say \\\"hello\\\" with \\`echo\\` and \\\\n for 5\\$\"\n"},
+		"invalid lines are skipped": {
+			content: "EDITOR=vim\nNOVALUE\nMY-VAR=value\nPAGER=less\n",
+			want:    "EDITOR=\"vim\"\nPAGER=\"less\"\n"},
+		"missing file sets nothing": {noFile: true},
+
+		"error on unreadable file": {content: "EDITOR=vim\n", unreadable: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := filepath.Join(t.TempDir(), "variables.conf")
+			if !tc.noFile {
+				require.NoError(t, os.WriteFile(p, []byte(tc.content), 0600), "Setup: can't create environment file")
+			}
+			if tc.unreadable {
+				// A directory can't be read as a file
+				require.NoError(t, os.Remove(p), "Setup: can't remove environment file")
+				require.NoError(t, os.Mkdir(p, 0700), "Setup: can't create directory in place of environment file")
+			}
+
+			var out bytes.Buffer
+			err := environment.Generate(context.Background(), &out, p)
+			if tc.wantErr {
+				require.Error(t, err, "Generate should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Generate failed but shouldn't have")
+
+			require.Equal(t, tc.want, out.String(), "Generate printed expected variables")
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,93 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/dconf"
+)
+
+// ruleDefinition is a GPO defining a given key.
+type ruleDefinition struct {
+	gpo  AppliedGPO
+	rule AppliedRule
+}
+
+// ExplainPolicy shows the precedence chain of key for objectName: every applied GPO defining it, in priority order,
+// the first one winning. key is the rule domain followed by the key, like dconf/org/gnome/desktop/interface/clock-format,
+// or only the key to match it in every domain.
+func (m *Manager) ExplainPolicy(ctx context.Context, objectName, key string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to explain %q for %q"), key, objectName)
+
+	log.Infof(ctx, "Explain %s for %s", key, objectName)
+
+	gpos, err := m.AppliedPolicies(ctx, objectName)
+	if err != nil {
+		return "", err
+	}
+
+	key = strings.TrimPrefix(key, "/")
+	definitions := make(map[string][]ruleDefinition)
+	for _, g := range gpos {
+		for _, r := range g.Rules {
+			fullKey := filepath.Join(r.Domain, r.Key)
+			if fullKey != key && r.Key != key {
+				continue
+			}
+			definitions[fullKey] = append(definitions[fullKey], ruleDefinition{gpo: g, rule: r})
+		}
+	}
+	if len(definitions) == 0 {
+		return "", fmt.Errorf(i18n.G("no GPO applied to %q defines %q"), objectName, key)
+	}
+
+	var keys []string
+	for k := range definitions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	isComputer, err := isMachine(objectName)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, i18n.G("%s for %s, highest priority first:\n"), k, objectName)
+		for _, d := range definitions[k] {
+			state := i18n.G("applied")
+			if d.rule.Overridden {
+				state = i18n.G("overridden")
+			}
+			origin := i18n.G("user")
+			if d.gpo.IsComputer {
+				origin = i18n.G("machine")
+			}
+			fmt.Fprintf(&out, "  %s: %s (%s), %s: %s", state, d.gpo.Name, d.gpo.ID, origin, formatRuleValue(d.rule.Entry))
+			if d.rule.ReleaseOverride != "" {
+				fmt.Fprintf(&out, i18n.G(", release override Override%s"), d.rule.ReleaseOverride)
+			}
+			if d.rule.DefaultOnly && !d.rule.Disabled {
+				out.WriteString(i18n.G(", not enforced"))
+			}
+			out.WriteString("\n")
+		}
+
+		winner := definitions[k][0]
+		if winner.rule.Domain == "dconf" {
+			fmt.Fprintf(&out, "  %s\n", dconf.ExplainLock(isComputer, winner.gpo.IsComputer, winner.rule.Disabled, winner.rule.DefaultOnly))
+		}
+	}
+
+	return out.String(), nil
+}
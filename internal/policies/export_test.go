@@ -1,9 +1,20 @@
 package policies
 
 import (
+	"os/user"
+
+	"github.com/ubuntu/adsys/internal/policies/apparmor"
+	"github.com/ubuntu/adsys/internal/policies/environment"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
+	"github.com/ubuntu/adsys/internal/policies/mount"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"github.com/ubuntu/adsys/internal/policies/proxy"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
 )
 
+// MaxHistorySnapshots is the number of rules snapshots kept for each object.
+const MaxHistorySnapshots = maxHistorySnapshots
+
 // WithGDM specifies a personalized gdm manager
 func WithGDM(m *gdm.Manager) Option {
 	return func(o *options) error {
@@ -11,3 +22,59 @@ func WithGDM(m *gdm.Manager) Option {
 		return nil
 	}
 }
+
+// WithScripts specifies a personalized scripts manager
+func WithScripts(m *scripts.Manager) Option {
+	return func(o *options) error {
+		o.scripts = m
+		return nil
+	}
+}
+
+// WithApparmor specifies a personalized apparmor manager
+func WithApparmor(m *apparmor.Manager) Option {
+	return func(o *options) error {
+		o.apparmor = m
+		return nil
+	}
+}
+
+// WithPrivilege specifies a personalized privilege manager
+func WithPrivilege(m *privilege.Manager) Option {
+	return func(o *options) error {
+		o.privilege = m
+		return nil
+	}
+}
+
+// WithMount specifies a personalized mount manager
+func WithMount(m *mount.Manager) Option {
+	return func(o *options) error {
+		o.mount = m
+		return nil
+	}
+}
+
+// WithUserLookup specifies a personalized function to resolve user names
+func WithUserLookup(f func(string) (*user.User, error)) Option {
+	return func(o *options) error {
+		o.userLookup = f
+		return nil
+	}
+}
+
+// WithEnvironment specifies a personalized environment manager
+func WithEnvironment(m *environment.Manager) Option {
+	return func(o *options) error {
+		o.environment = m
+		return nil
+	}
+}
+
+// WithProxy specifies a personalized proxy manager
+func WithProxy(m *proxy.Manager) Option {
+	return func(o *options) error {
+		o.proxy = m
+		return nil
+	}
+}
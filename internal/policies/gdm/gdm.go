@@ -8,6 +8,7 @@ import (
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
 	"github.com/ubuntu/adsys/internal/policies/dconf"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"golang.org/x/sync/errgroup"
@@ -52,6 +53,12 @@ func New(opts ...option) (m *Manager, err error) {
 	}, nil
 }
 
+// Extensions returns the client-side extensions whose settings are applied by the gdm manager.
+// The login screen settings are machine administrative templates.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
 // ApplyPolicy generates a dconf computer or user policy based on a list of entries
 func (m *Manager) ApplyPolicy(ctx context.Context, entries []entry.Entry) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't apply gdm policy"))
@@ -60,13 +67,7 @@ func (m *Manager) ApplyPolicy(ctx context.Context, entries []entry.Entry) (err e
 
 	log.Debug(ctx, "ApplyPolicy gdm policy")
 
-	// Order all entries by keytype for gdm
-	sortedEntries := make(map[string][]entry.Entry)
-	for _, e := range entries {
-		keyType := strings.Split(e.Key, "/")[0]
-		e.Key = strings.TrimPrefix(e.Key, keyType+"/")
-		sortedEntries[keyType] = append(sortedEntries[keyType], e)
-	}
+	sortedEntries := sortByKeyType(entries)
 
 	var g errgroup.Group
 	g.Go(func() error { return m.dconf.ApplyPolicy(ctx, "gdm", false, sortedEntries["dconf"]) })
@@ -77,3 +78,26 @@ func (m *Manager) ApplyPolicy(ctx context.Context, entries []entry.Entry) (err e
 
 	return nil
 }
+
+// PlanPolicy returns the changes ApplyPolicy would make for a list of entries, without applying them.
+func (m *Manager) PlanPolicy(ctx context.Context, entries []entry.Entry) (plan string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't plan gdm policy"))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	log.Debug(ctx, "PlanPolicy gdm policy")
+
+	return m.dconf.PlanPolicy(ctx, "gdm", false, sortByKeyType(entries)["dconf"])
+}
+
+// sortByKeyType orders all entries by keytype for gdm, stripping the keytype prefix from their key.
+func sortByKeyType(entries []entry.Entry) map[string][]entry.Entry {
+	sortedEntries := make(map[string][]entry.Entry)
+	for _, e := range entries {
+		keyType := strings.Split(e.Key, "/")[0]
+		e.Key = strings.TrimPrefix(e.Key, keyType+"/")
+		sortedEntries[keyType] = append(sortedEntries[keyType], e)
+	}
+	return sortedEntries
+}
@@ -3,7 +3,9 @@ package gdm_test
 import (
 	"context"
 	"flag"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -37,7 +39,7 @@ func TestApplyPolicy(t *testing.T) {
 			dconfDir := t.TempDir()
 
 			// Apply machine configuration
-			dconfManager := dconf.NewWithDconfDir(dconfDir)
+			dconfManager := dconf.NewWithDconfDir(dconfDir, dconf.WithSchemasDir(filepath.Join("testdata", "schemas")))
 			err := dconfManager.ApplyPolicy(context.Background(), "ubuntu", true, nil)
 			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
 
@@ -56,6 +58,56 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
+func TestPlanPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries []entry.Entry
+
+		wantErr bool
+	}{
+		"dconf policy": {entries: []entry.Entry{
+			{Key: "dconf/com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}}},
+		"no policy": {},
+
+		// Error cases
+		"invalid dconf policy": {entries: []entry.Entry{
+			{Key: "dconf/com/ubuntu/category/key-i", Value: "NaN", Meta: "i"}}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dconfDir := t.TempDir()
+
+			m, err := gdm.New(gdm.WithDconf(dconf.NewWithDconfDir(dconfDir, dconf.WithSchemasDir(filepath.Join("testdata", "schemas")))))
+			require.NoError(t, err, "Setup: can't create gdm manager")
+
+			got, err := m.PlanPolicy(context.Background(), tc.entries)
+			if tc.wantErr {
+				require.NotNil(t, err, "PlanPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "PlanPolicy failed but shouldn't have")
+
+			got = strings.ReplaceAll(got, dconfDir, "/etc/dconf")
+			goldPath := filepath.Join("testdata", "golden_plan", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load plan golden file")
+			require.Equal(t, string(want), got, "PlanPolicy returned expected plan")
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.BoolVar(&update, "update", false, "update golden files")
 	flag.Parse()
@@ -0,0 +1,323 @@
+package policies
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+/*
+	Notes:
+	Each time the rules applied to an object change, a copy of its gpo_rules cache is stored in
+	<cache dir>/gpo_rules_history/<object>/<time>, the time being the one of the cache modification.
+	Snapshots are recorded once the new rules are saved in the cache. The only exception is the cache of an object
+	without any history yet, whose rules were applied before history was recorded: it becomes its first snapshot.
+	Only the last maxHistorySnapshots snapshots are kept.
+	Snapshots are numbered from the most recent, 0 being the currently applied rules.
+*/
+
+const (
+	// maxHistorySnapshots is the number of rules snapshots kept for each object.
+	maxHistorySnapshots = 30
+
+	// snapshotNameLayout is the file name of a snapshot, sorting them chronologically.
+	snapshotNameLayout = "20060102T150405.000000000Z"
+	// historyTimeLayout is how snapshot times are displayed.
+	historyTimeLayout = "Mon Jan 2 15:04:05 2006"
+)
+
+// snapshot is a previous version of the rules applied to an object.
+type snapshot struct {
+	path string
+	time time.Time
+}
+
+// recordHistory adds the rules currently cached for objectName to its history, if they changed
+// since the last snapshot. Older snapshots are then removed.
+func (m *Manager) recordHistory(objectName string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't record policy history for %q"), objectName)
+
+	cachePath := filepath.Join(m.gpoRulesCacheDir, objectName)
+	info, err := os.Stat(cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(cachePath)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := m.snapshots(objectName)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 {
+		last, err := os.ReadFile(snapshots[0].path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(last, content) {
+			return nil
+		}
+	}
+
+	historyDir := filepath.Join(m.gpoRulesHistoryDir, objectName)
+	if err := os.MkdirAll(historyDir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(historyDir, info.ModTime().UTC().Format(snapshotNameLayout)), content, 0600); err != nil {
+		return err
+	}
+
+	// The new snapshot is not part of the list, hence the shift by one
+	for i := maxHistorySnapshots - 1; i < len(snapshots); i++ {
+		if err := os.Remove(snapshots[i].path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateHistory records the rules applied to objectName before its history existed as its first snapshot.
+// It does nothing once objectName has an history.
+func (m *Manager) migrateHistory(objectName string) error {
+	if _, err := os.Stat(filepath.Join(m.gpoRulesHistoryDir, objectName)); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(i18n.G("can't record policy history for %q: %v"), objectName, err)
+	}
+	return m.recordHistory(objectName)
+}
+
+// snapshots returns the history of objectName, the most recent snapshot first.
+func (m *Manager) snapshots(objectName string) (snapshots []snapshot, err error) {
+	historyDir := filepath.Join(m.gpoRulesHistoryDir, objectName)
+	files, err := os.ReadDir(historyDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		t, err := time.Parse(snapshotNameLayout, f.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{path: filepath.Join(historyDir, f.Name()), time: t})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].time.After(snapshots[j].time) })
+
+	return snapshots, nil
+}
+
+// PolicyHistory lists the snapshots of the rules applied to objectName, with their GPOs.
+func (m *Manager) PolicyHistory(ctx context.Context, objectName string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to get policy history for %q"), objectName)
+
+	log.Infof(ctx, "Get policy history for %s", objectName)
+
+	snapshots, err := m.snapshots(objectName)
+	if err != nil {
+		return "", err
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf(i18n.G("no policy history for %q"), objectName)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, i18n.G("Policy history for %s, most recent first:\n"), objectName)
+	for i, s := range snapshots {
+		gpos, err := entry.NewGPOs(s.path)
+		if err != nil {
+			return "", err
+		}
+		var names []string
+		for _, g := range gpos {
+			names = append(names, g.Name)
+		}
+		fmt.Fprintf(&out, "%d: %s - %s\n", i, s.time.Local().Format(historyTimeLayout), strings.Join(names, ", "))
+	}
+
+	return out.String(), nil
+}
+
+// DiffPolicies shows the rules changes of objectName since a previous snapshot.
+// since is either the number of snapshots to go back to, a duration before now, or a date. The default is the
+// previous snapshot.
+func (m *Manager) DiffPolicies(ctx context.Context, objectName, since string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to diff policies for %q"), objectName)
+
+	log.Infof(ctx, "Diff policies for %s since %q", objectName, since)
+
+	snapshots, err := m.snapshots(objectName)
+	if err != nil {
+		return "", err
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf(i18n.G("no policy history for %q"), objectName)
+	}
+
+	from, err := snapshotSince(snapshots, since, time.Now())
+	if err != nil {
+		return "", err
+	}
+	oldGPOs, err := entry.NewGPOs(from.path)
+	if err != nil {
+		return "", err
+	}
+	newGPOs, err := entry.NewGPOs(snapshots[0].path)
+	if err != nil {
+		return "", err
+	}
+
+	changes := rulesDiff(oldGPOs, newGPOs)
+	if changes == "" {
+		return fmt.Sprintf(i18n.G("No change for %s since %s.\n"), objectName, from.time.Local().Format(historyTimeLayout)), nil
+	}
+	return fmt.Sprintf(i18n.G("Changes for %s since %s:\n"), objectName, from.time.Local().Format(historyTimeLayout)) + changes, nil
+}
+
+// snapshotSince returns the snapshot matching since: the state of the rules at that time.
+func snapshotSince(snapshots []snapshot, since string, now time.Time) (snapshot, error) {
+	if since == "" {
+		since = "1"
+	}
+
+	// Number of snapshots to go back to
+	if n, err := strconv.Atoi(since); err == nil {
+		if n < 0 || n >= len(snapshots) {
+			return snapshot{}, fmt.Errorf(i18n.G("no snapshot %d: history has %d snapshots"), n, len(snapshots))
+		}
+		return snapshots[n], nil
+	}
+
+	var t time.Time
+	if d, err := time.ParseDuration(since); err == nil {
+		t = now.Add(-d)
+	} else {
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+			if t, err = time.ParseInLocation(layout, since, now.Location()); err == nil {
+				break
+			}
+		}
+		if t.IsZero() {
+			return snapshot{}, fmt.Errorf(i18n.G("%q is neither a number of snapshots, a duration nor a date"), since)
+		}
+	}
+
+	// The rules applied at t are the ones of the last snapshot before it
+	for _, s := range snapshots {
+		if !s.time.After(t) {
+			return s, nil
+		}
+	}
+	return snapshot{}, fmt.Errorf(i18n.G("history starts on %s, after %s"),
+		snapshots[len(snapshots)-1].time.Local().Format(historyTimeLayout), t.Format(historyTimeLayout))
+}
+
+// gpoRule is a rule with the name of the GPO it comes from.
+type gpoRule struct {
+	entry.Entry
+	gpo string
+}
+
+// uniqueRulesWithGPO returns, for each rule domain, the rules of the GPOs indexed by key with their GPO.
+// As with entry.GetUniqueRules, the first GPO wins for a given key.
+func uniqueRulesWithGPO(gpos []entry.GPO) map[string]map[string]gpoRule {
+	r := make(map[string]map[string]gpoRule)
+	for _, g := range gpos {
+		for t, entries := range g.Rules {
+			if r[t] == nil {
+				r[t] = make(map[string]gpoRule)
+			}
+			for _, e := range entries {
+				if _, exists := r[t][e.Key]; exists {
+					continue
+				}
+				r[t][e.Key] = gpoRule{Entry: e, gpo: g.Name}
+			}
+		}
+	}
+	return r
+}
+
+// rulesDiff lists, for each rule domain, the keys added, removed and changed between the old and new GPOs, with
+// the GPO each rule comes from. Domains and keys are sorted.
+func rulesDiff(old, new []entry.GPO) string {
+	oldRules, newRules := uniqueRulesWithGPO(old), uniqueRulesWithGPO(new)
+
+	var domains []string
+	for t := range oldRules {
+		domains = append(domains, t)
+	}
+	for t := range newRules {
+		if _, ok := oldRules[t]; !ok {
+			domains = append(domains, t)
+		}
+	}
+	sort.Strings(domains)
+
+	var out strings.Builder
+	for _, t := range domains {
+		var keys []string
+		for k := range oldRules[t] {
+			keys = append(keys, k)
+		}
+		for k := range newRules[t] {
+			if _, ok := oldRules[t][k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []string
+		for _, k := range keys {
+			o, inOld := oldRules[t][k]
+			n, inNew := newRules[t][k]
+			switch {
+			case !inOld:
+				changes = append(changes, fmt.Sprintf("+ %s: %s (%s)", k, formatRuleValue(n.Entry), n.gpo))
+			case !inNew:
+				changes = append(changes, fmt.Sprintf("- %s: %s (%s)", k, formatRuleValue(o.Entry), o.gpo))
+			case o.Entry != n.Entry || o.gpo != n.gpo:
+				origin := n.gpo
+				if o.gpo != n.gpo {
+					origin = fmt.Sprintf("%s -> %s", o.gpo, n.gpo)
+				}
+				changes = append(changes, fmt.Sprintf("~ %s: %s -> %s (%s)", k, formatRuleValue(o.Entry), formatRuleValue(n.Entry), origin))
+			}
+		}
+		if changes == nil {
+			continue
+		}
+		fmt.Fprintf(&out, "%s:\n", t)
+		for _, c := range changes {
+			fmt.Fprintf(&out, "  %s\n", c)
+		}
+	}
+	return out.String()
+}
+
+// formatRuleValue returns the value of e on a single line.
+func formatRuleValue(e entry.Entry) string {
+	if e.Disabled {
+		return i18n.G("disabled")
+	}
+	return strings.ReplaceAll(e.Value, "\n", `\n`)
+}
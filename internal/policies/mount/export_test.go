@@ -0,0 +1,4 @@
+package mount
+
+// RunMountsWithGioCmd mounts shares staged in dir with a personalized gio command.
+var RunMountsWithGioCmd = runMounts
@@ -0,0 +1,543 @@
+// Package mount mounts network shares referenced by GPOs, for the machine and for users.
+package mount
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	"github.com/ubuntu/adsys/internal/fileutils"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+)
+
+/*
+	Notes:
+	Shares are referenced in the "mount" rules as a list of smb:// or nfs:// URLs, one per line.
+
+	Machine shares ("system-mounts" key) are converted to systemd .mount and .automount units in the systemd
+	directory, mounted under <mounts dir>/<host>/<path>. Units not referenced anymore are stopped and removed.
+
+	User shares ("user-mounts" key) are staged in <run dir>/mounts/<uid>/ which contains:
+	  - mounts: the list of URLs to mount.
+	  - krb5ccname: the user kerberos ticket, resolved from the symlink maintained by adsys in <run dir>/krb5cc/.
+	They are mounted with gio by "adsysd mount <dir>", run at login by the adsys-user-mounts.service user unit.
+*/
+
+const (
+	machineKey = "system-mounts"
+	usersKey   = "user-mounts"
+
+	mountsFileName     = "mounts"
+	krb5CCNameFileName = "krb5ccname"
+
+	unitHeader = `# This file is managed by adsys.
+# Do not edit this file manually.
+# Any changes will be overwritten.
+`
+)
+
+// Manager serializes ApplyPolicy calls, so that systemd units are not written, enabled or disabled concurrently.
+type Manager struct {
+	mu sync.Mutex
+
+	runDir       string
+	systemdDir   string
+	mountsDir    string
+	systemctlCmd []string
+	userLookup   func(string) (*user.User, error)
+}
+
+type options struct {
+	runDir       string
+	systemdDir   string
+	mountsDir    string
+	systemctlCmd []string
+	userLookup   func(string) (*user.User, error)
+}
+type option func(*options) error
+
+// WithRunDir specifies a personalized /run
+func WithRunDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.runDir = p
+		return nil
+	}
+}
+
+// WithSystemdDir specifies a personalized directory where to write systemd units
+func WithSystemdDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.systemdDir = p
+		return nil
+	}
+}
+
+// WithMountsDir specifies a personalized directory under which machine shares are mounted
+func WithMountsDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.mountsDir = p
+		return nil
+	}
+}
+
+// WithSystemCtlCmd specifies a personalized systemctl command
+func WithSystemCtlCmd(cmd []string) func(o *options) error {
+	return func(o *options) error {
+		o.systemctlCmd = cmd
+		return nil
+	}
+}
+
+// WithUserLookup specifies a personalized function to resolve user names
+func WithUserLookup(f func(string) (*user.User, error)) func(o *options) error {
+	return func(o *options) error {
+		o.userLookup = f
+		return nil
+	}
+}
+
+// New returns a new manager for mount policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new mount handler manager"))
+
+	// defaults
+	args := options{
+		runDir:       consts.DefaultRunDir,
+		systemdDir:   "/etc/systemd/system",
+		mountsDir:    consts.DefaultMountsDir,
+		systemctlCmd: []string{"systemctl"},
+		userLookup:   user.Lookup,
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	if !filepath.IsAbs(args.mountsDir) {
+		return nil, fmt.Errorf(i18n.G("mounts directory %q should be absolute"), args.mountsDir)
+	}
+
+	return &Manager{
+		runDir:       args.runDir,
+		systemdDir:   args.systemdDir,
+		mountsDir:    args.mountsDir,
+		systemctlCmd: args.systemctlCmd,
+		userLookup:   args.userLookup,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the mount manager:
+// the shares to mount are registry values, nothing is read from the GPO files.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
+// ApplyPolicy generates systemd mount units for the machine, or stages the shares to mount at login for users.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply mount policy to %s"), objectName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy mount policy to %s", objectName)
+
+	key := machineKey
+	if !isComputer {
+		key = usersKey
+	}
+
+	var shares []*url.URL
+	seen := make(map[string]struct{})
+	var errMsgs []string
+	for _, e := range entries {
+		if e.Key != key {
+			log.Warningf(ctx, i18n.G("Ignoring unsupported mount key %q for %s"), e.Key, objectName)
+			continue
+		}
+		if e.Disabled {
+			continue
+		}
+		for _, s := range strings.Split(e.Value, "\n") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			u, err := parseShare(s)
+			if err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), s, err))
+				continue
+			}
+			if _, ok := seen[u.String()]; ok {
+				continue
+			}
+			seen[u.String()] = struct{}{}
+			shares = append(shares, u)
+		}
+	}
+	if errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+
+	if isComputer {
+		return m.applyMachinePolicy(ctx, shares)
+	}
+	return m.applyUserPolicy(ctx, objectName, shares)
+}
+
+// applyMachinePolicy writes a mount and automount unit per share and enables them.
+// Units not referenced anymore are disabled and removed.
+func (m *Manager) applyMachinePolicy(ctx context.Context, shares []*url.URL) (err error) {
+	// Generate all wanted units
+	units := make(map[string]string)
+	var automounts []string
+	for _, u := range shares {
+		where := filepath.Join(m.mountsDir, u.Hostname(), filepath.Clean("/"+u.Path))
+		// Units outside of the mounts directory would never be cleaned up
+		if !strings.HasPrefix(where, filepath.Clean(m.mountsDir)+"/") {
+			return fmt.Errorf(i18n.G("mount point %q of share %q is not under %q"), where, u, m.mountsDir)
+		}
+		name := escapePath(where)
+		units[name+".mount"] = mountUnit(u, where)
+		units[name+".automount"] = automountUnit(u, where)
+		automounts = append(automounts, name+".automount")
+	}
+
+	// List previous units managed by adsys, which are all under the mounts directory
+	prefix := escapePath(m.mountsDir) + "-"
+	files, err := os.ReadDir(m.systemdDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var removedUnits []string
+	changed := false
+	for _, f := range files {
+		n := f.Name()
+		if f.IsDir() || !strings.HasPrefix(n, prefix) || (!strings.HasSuffix(n, ".mount") && !strings.HasSuffix(n, ".automount")) {
+			continue
+		}
+		if _, ok := units[n]; ok {
+			continue
+		}
+		removedUnits = append(removedUnits, n)
+	}
+
+	// Stop and remove units not referenced anymore. Automounts go first, as they trigger their mount unit.
+	sort.Slice(removedUnits, func(i, j int) bool {
+		ai, aj := strings.HasSuffix(removedUnits[i], ".automount"), strings.HasSuffix(removedUnits[j], ".automount")
+		if ai != aj {
+			return ai
+		}
+		return removedUnits[i] < removedUnits[j]
+	})
+	for _, n := range removedUnits {
+		action := []string{"stop", n}
+		if strings.HasSuffix(n, ".automount") {
+			action = []string{"disable", "--now", n}
+		}
+		if err := m.systemctl(ctx, action...); err != nil {
+			// The share may be already unmounted: this should not prevent removing the unit.
+			log.Warning(ctx, err)
+		}
+		if err := os.Remove(filepath.Join(m.systemdDir, n)); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	// Write new or changed units atomically
+	var names []string
+	for n := range units {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		p := filepath.Join(m.systemdDir, n)
+		if old, err := os.ReadFile(p); err == nil && string(old) == units[n] {
+			continue
+		}
+		if err := os.MkdirAll(m.systemdDir, 0755); err != nil {
+			return err
+		}
+		// #nosec G306 - systemd units are world readable
+		if err := os.WriteFile(p+".new", []byte(units[n]), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(p+".new", p); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed {
+		log.Debug(ctx, "Mount units didn't change")
+		return nil
+	}
+
+	if err := m.systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	sort.Strings(automounts)
+	var errMsgs []string
+	for _, n := range automounts {
+		if err := m.systemctl(ctx, "enable", "--now", n); err != nil {
+			msg := fmt.Sprintf(i18n.G("- error on %s: %v"), n, err)
+			log.Warning(ctx, msg)
+			errMsgs = append(errMsgs, msg)
+		}
+	}
+	if errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+
+	return nil
+}
+
+// applyUserPolicy stages the shares and the user kerberos ticket for the user mount unit.
+func (m *Manager) applyUserPolicy(ctx context.Context, objectName string, shares []*url.URL) (err error) {
+	u, err := m.userLookup(objectName)
+	if err != nil {
+		return fmt.Errorf(i18n.G("can't find user %q: %v"), objectName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid uid %q for %q: %v"), u.Uid, objectName, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid gid %q for %q: %v"), u.Gid, objectName, err)
+	}
+	objectDir := filepath.Join(m.runDir, "mounts", u.Uid)
+
+	// Nothing to mount: clean up any previous staging.
+	if len(shares) == 0 {
+		log.Debugf(ctx, "No shares to mount for %s", objectName)
+		return os.RemoveAll(objectDir)
+	}
+
+	// Users need to traverse the run directory to access their own shares list
+	if err := os.MkdirAll(filepath.Dir(objectDir), 0755); err != nil {
+		return err
+	}
+	for _, d := range []string{m.runDir, filepath.Dir(objectDir)} {
+		// #nosec G302 - directories only contain subdirectories with their own permissions
+		if err := os.Chmod(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	stagingDir := objectDir + ".new"
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Info(ctx, i18n.G("Could not clean up temporary directory:"), err)
+		}
+	}()
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return err
+	}
+
+	var content string
+	for _, s := range shares {
+		content += s.String() + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(stagingDir, mountsFileName), []byte(content), 0600); err != nil {
+		return err
+	}
+
+	// The ticket symlink directory is only accessible by root: resolve it for the user.
+	krb5CCName, err := os.Readlink(filepath.Join(m.runDir, entry.Krb5CCBaseName, objectName))
+	if err != nil {
+		log.Warningf(ctx, i18n.G("No kerberos ticket found for %s, shares will be mounted without it: %v"), objectName, err)
+	} else if err := os.WriteFile(filepath.Join(stagingDir, krb5CCNameFileName), []byte(krb5CCName+"\n"), 0600); err != nil {
+		return err
+	}
+
+	if err := fileutils.ChownTree(stagingDir, uid, gid); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(objectDir); err != nil {
+		return err
+	}
+	return os.Rename(stagingDir, objectDir)
+}
+
+// systemctl runs systemctl with args.
+func (m *Manager) systemctl(ctx context.Context, args ...string) error {
+	cmdArgs := append([]string{}, m.systemctlCmd...) // Copy systemctlCmd to prevent data race
+	cmdArgs = append(cmdArgs, args...)
+	// #nosec G204 - we control the input
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	smbsafe.WaitExec()
+	out, err := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if err != nil {
+		return fmt.Errorf(i18n.G("systemctl %s failed: %v\n%s"), strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// RunMounts mounts with gio all the shares staged in dir for the current user.
+// Failing mounts are logged and don’t prevent following shares to be mounted.
+func RunMounts(ctx context.Context, dir string) error {
+	return runMounts(ctx, dir, []string{"gio"})
+}
+
+func runMounts(ctx context.Context, dir string, gioCmd []string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't mount shares listed in %s"), dir)
+
+	f, err := os.Open(filepath.Join(dir, mountsFileName))
+	if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	var shares []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		shares = append(shares, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	env := os.Environ()
+	d, err := os.ReadFile(filepath.Join(dir, krb5CCNameFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if krb5CCName := strings.TrimSpace(string(d)); krb5CCName != "" {
+		env = append(env, fmt.Sprintf("KRB5CCNAME=FILE:%s", krb5CCName))
+	}
+
+	for _, s := range shares {
+		if _, err := parseShare(s); err != nil {
+			log.Warningf(ctx, i18n.G("Skipping %s: %v"), s, err)
+			continue
+		}
+		log.Infof(ctx, "Mounting %s", s)
+		args := append([]string{}, gioCmd...)
+		args = append(args, "mount", s)
+		// #nosec G204 - shares are validated before being mounted
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Warningf(ctx, i18n.G("Mounting %s failed: %v\n%s"), s, err, out)
+		}
+	}
+
+	return nil
+}
+
+// parseShare parses and validates a share URL.
+func parseShare(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "smb" && u.Scheme != "nfs" {
+		return nil, fmt.Errorf(i18n.G("unsupported share protocol %q, only smb and nfs are supported"), u.Scheme)
+	}
+	switch h := u.Hostname(); {
+	case h == "":
+		return nil, fmt.Errorf(i18n.G("share %q has no host"), s)
+	case h == "." || h == ".." || strings.ContainsAny(h, `/\`):
+		return nil, fmt.Errorf(i18n.G("share %q has an invalid host %q"), s, h)
+	}
+	if p := path.Clean("/" + u.Path); p == "/" {
+		return nil, fmt.Errorf(i18n.G("share %q has no path"), s)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf(i18n.G("share %q should only contain a host and a path"), s)
+	}
+	return u, nil
+}
+
+// mountUnit returns the content of the systemd mount unit for share u mounted on where.
+func mountUnit(u *url.URL, where string) string {
+	p := path.Clean("/" + u.Path)
+	what, fsType, opts := "//"+u.Host+p, "cifs", "sec=krb5,cruid=0,_netdev"
+	if u.Scheme == "nfs" {
+		what, fsType, opts = u.Host+":"+p, "nfs", "_netdev"
+	}
+
+	return fmt.Sprintf(`%s
+[Unit]
+Description=Mount %s
+Wants=network-online.target
+After=network-online.target
+
+[Mount]
+What=%s
+Where=%s
+Type=%s
+Options=%s
+`, unitHeader, escapeSpecifiers(u.String()), escapeSpecifiers(what), escapeSpecifiers(where), fsType, opts)
+}
+
+// automountUnit returns the content of the systemd automount unit for share u mounted on where.
+func automountUnit(u *url.URL, where string) string {
+	return fmt.Sprintf(`%s
+[Unit]
+Description=Automount %s
+
+[Automount]
+Where=%s
+
+[Install]
+WantedBy=remote-fs.target
+`, unitHeader, escapeSpecifiers(u.String()), escapeSpecifiers(where))
+}
+
+// escapeSpecifiers prevents systemd from expanding % specifiers in unit values.
+func escapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// escapePath escapes an absolute path the same way "systemd-escape --path" does.
+func escapePath(p string) string {
+	p = strings.Trim(filepath.Clean(p), "/")
+	if p == "" {
+		return "-"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,24 +14,47 @@ import (
 	"github.com/ubuntu/adsys/internal/decorate"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/apparmor"
 	"github.com/ubuntu/adsys/internal/policies/dconf"
 	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
+	"github.com/ubuntu/adsys/internal/policies/mount"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"github.com/ubuntu/adsys/internal/policies/proxy"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
 	"golang.org/x/sync/errgroup"
 )
 
 // Manager handles all managers for various policy handlers.
 type Manager struct {
-	gpoRulesCacheDir string
+	gpoRulesCacheDir   string
+	gpoRulesHistoryDir string
+	userLookup         func(string) (*user.User, error)
 
-	dconf *dconf.Manager
-	gdm   *gdm.Manager
+	dconf       *dconf.Manager
+	gdm         *gdm.Manager
+	scripts     *scripts.Manager
+	apparmor    *apparmor.Manager
+	privilege   *privilege.Manager
+	mount       *mount.Manager
+	environment *environment.Manager
+	proxy       *proxy.Manager
 }
 
 type options struct {
-	cacheDir string
-	dconfDir string
-	gdm      *gdm.Manager
+	cacheDir    string
+	runDir      string
+	dconfDir    string
+	schemasDir  string
+	gdm         *gdm.Manager
+	scripts     *scripts.Manager
+	apparmor    *apparmor.Manager
+	privilege   *privilege.Manager
+	mount       *mount.Manager
+	environment *environment.Manager
+	proxy       *proxy.Manager
+	userLookup  func(string) (*user.User, error)
 }
 
 // Option reprents an optional function to change Policies behavior.
@@ -43,6 +68,14 @@ func WithCacheDir(p string) Option {
 	}
 }
 
+// WithRunDir specifies a personalized run directory
+func WithRunDir(p string) Option {
+	return func(o *options) error {
+		o.runDir = p
+		return nil
+	}
+}
+
 // WithDconfDir specifies a personalized dconf directory
 func WithDconfDir(p string) Option {
 	return func(o *options) error {
@@ -51,14 +84,24 @@ func WithDconfDir(p string) Option {
 	}
 }
 
+// WithGSettingsSchemasDir specifies a personalized directory of gsettings schemas to check dconf policies against
+func WithGSettingsSchemasDir(p string) Option {
+	return func(o *options) error {
+		o.schemasDir = p
+		return nil
+	}
+}
+
 // New returns a new manager with all default policy handlers.
 func New(opts ...Option) (m *Manager, err error) {
 	defer decorate.OnError(&err, i18n.G("can't create a new policy handlers manager"))
 
 	// defaults
 	args := options{
-		cacheDir: consts.DefaultCacheDir,
-		gdm:      nil,
+		cacheDir:   consts.DefaultCacheDir,
+		runDir:     consts.DefaultRunDir,
+		gdm:        nil,
+		userLookup: user.Lookup,
 	}
 	// applied options (including dconf manager used by gdm)
 	for _, o := range opts {
@@ -67,10 +110,11 @@ func New(opts ...Option) (m *Manager, err error) {
 		}
 	}
 	// dconf manager
-	dconfManager := &dconf.Manager{}
-	if args.dconfDir != "" {
-		dconfManager = dconf.NewWithDconfDir(args.dconfDir)
+	var dconfOptions []dconf.Option
+	if args.schemasDir != "" {
+		dconfOptions = append(dconfOptions, dconf.WithSchemasDir(args.schemasDir))
 	}
+	dconfManager := dconf.NewWithDconfDir(args.dconfDir, dconfOptions...)
 
 	// inject applied dconf mangager if we need to build a gdm manager
 	if args.gdm == nil {
@@ -79,19 +123,105 @@ func New(opts ...Option) (m *Manager, err error) {
 		}
 	}
 
+	// scripts manager
+	if args.scripts == nil {
+		if args.scripts, err = scripts.New(
+			scripts.WithRunDir(args.runDir),
+			scripts.WithGPOCacheDir(filepath.Join(args.cacheDir, entry.GPOCacheBaseName))); err != nil {
+			return nil, err
+		}
+	}
+
+	// apparmor manager
+	if args.apparmor == nil {
+		if args.apparmor, err = apparmor.New(
+			apparmor.WithGPOCacheDir(filepath.Join(args.cacheDir, entry.GPOCacheBaseName))); err != nil {
+			return nil, err
+		}
+	}
+
+	// privilege manager
+	if args.privilege == nil {
+		if args.privilege, err = privilege.New(); err != nil {
+			return nil, err
+		}
+	}
+
+	// mount manager
+	if args.mount == nil {
+		if args.mount, err = mount.New(mount.WithRunDir(args.runDir)); err != nil {
+			return nil, err
+		}
+	}
+
+	// environment manager
+	if args.environment == nil {
+		if args.environment, err = environment.New(environment.WithRunDir(args.runDir)); err != nil {
+			return nil, err
+		}
+	}
+
+	// inject applied dconf manager if we need to build a proxy manager
+	if args.proxy == nil {
+		if args.proxy, err = proxy.New(
+			proxy.WithDconf(dconfManager),
+			proxy.WithCacheDir(args.cacheDir)); err != nil {
+			return nil, err
+		}
+	}
+
 	gpoRulesCacheDir := filepath.Join(args.cacheDir, entry.GPORulesCacheBaseName)
 	if err := os.MkdirAll(gpoRulesCacheDir, 0700); err != nil {
 		return nil, err
 	}
+	gpoRulesHistoryDir := filepath.Join(args.cacheDir, entry.GPORulesHistoryBaseName)
+	if err := os.MkdirAll(gpoRulesHistoryDir, 0700); err != nil {
+		return nil, err
+	}
 
 	return &Manager{
-		gpoRulesCacheDir: gpoRulesCacheDir,
+		gpoRulesCacheDir:   gpoRulesCacheDir,
+		gpoRulesHistoryDir: gpoRulesHistoryDir,
+		userLookup:         args.userLookup,
 
-		dconf: dconfManager,
-		gdm:   args.gdm,
+		dconf:       dconfManager,
+		gdm:         args.gdm,
+		scripts:     args.scripts,
+		apparmor:    args.apparmor,
+		privilege:   args.privilege,
+		mount:       args.mount,
+		environment: args.environment,
+		proxy:       args.proxy,
 	}, nil
 }
 
+// Extensions returns the sorted GUIDs of the client-side extensions handled by any of the policy managers.
+// GPOs carrying none of them are not downloaded.
+func (m *Manager) Extensions() []string {
+	seen := make(map[string]struct{})
+	var extensions []string
+	for _, e := range [][]string{
+		m.dconf.Extensions(),
+		m.gdm.Extensions(),
+		m.scripts.Extensions(),
+		m.apparmor.Extensions(),
+		m.privilege.Extensions(),
+		m.mount.Extensions(),
+		m.environment.Extensions(),
+		m.proxy.Extensions(),
+	} {
+		for _, guid := range e {
+			if _, ok := seen[guid]; ok {
+				continue
+			}
+			seen[guid] = struct{}{}
+			extensions = append(extensions, guid)
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
 // ApplyPolicy generates a computer or user policy based on a list of entries
 // retrieved from a directory service.
 func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, gpos []entry.GPO) (err error) {
@@ -102,9 +232,12 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	rules := entry.GetUniqueRules(gpos)
 	var g errgroup.Group
 	g.Go(func() error { return m.dconf.ApplyPolicy(ctx, objectName, isComputer, rules["dconf"]) })
-
-	// TODO g.Go(func() error { return m.scripts.ApplyPolicy(ctx, objectName, isComputer, rules["scripts"]) })
-	// TODO g.Go(func() error { return m.apparmor.ApplyPolicy(ctx, objectName, isComputer, rules["apparmor"]) })
+	g.Go(func() error { return m.scripts.ApplyPolicy(ctx, objectName, isComputer, rules["scripts"]) })
+	g.Go(func() error { return m.apparmor.ApplyPolicy(ctx, objectName, isComputer, rules["apparmor"]) })
+	g.Go(func() error { return m.privilege.ApplyPolicy(ctx, objectName, isComputer, rules["privilege"]) })
+	g.Go(func() error { return m.mount.ApplyPolicy(ctx, objectName, isComputer, rules["mount"]) })
+	g.Go(func() error { return m.environment.ApplyPolicy(ctx, objectName, isComputer, rules["environment"]) })
+	g.Go(func() error { return m.proxy.ApplyPolicy(ctx, objectName, isComputer, rules["proxy"]) })
 	if err := g.Wait(); err != nil {
 		return err
 	}
@@ -116,8 +249,91 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		}
 	}
 
-	// Write cache GPO results
-	return entry.SaveGPOs(gpos, filepath.Join(m.gpoRulesCacheDir, objectName))
+	// Write cache GPO results, keeping track of the changes.
+	decorate.LogOnErrorContext(ctx, m.migrateHistory(objectName))
+	if err := entry.SaveGPOs(gpos, filepath.Join(m.gpoRulesCacheDir, objectName)); err != nil {
+		return err
+	}
+	decorate.LogOnErrorContext(ctx, m.recordHistory(objectName))
+
+	return nil
+}
+
+// PlanPolicy returns what ApplyPolicy would change for a computer or user policy, without applying it.
+// The plan lists the rules changed since the last applied policy, from the GPO rules cache, and the files
+// the dconf and gdm managers would write. Other managers, including the GNOME settings written by the proxy
+// manager, can't be dry run: they are only named when they have rules to apply or to reset.
+func (m *Manager) PlanPolicy(ctx context.Context, objectName string, isComputer bool, gpos []entry.GPO) (plan string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to plan policy for %q"), objectName)
+
+	log.Infof(ctx, "Plan policy for %s (machine: %v)", objectName, isComputer)
+
+	rules := entry.GetUniqueRules(gpos)
+
+	// No cache means that no policy was applied yet
+	var appliedGPOs []entry.GPO
+	cachePath := filepath.Join(m.gpoRulesCacheDir, objectName)
+	if _, err := os.Stat(cachePath); err == nil {
+		if appliedGPOs, err = entry.NewGPOs(cachePath); err != nil {
+			return "", err
+		}
+	}
+
+	var dconfPlan, gdmPlan string
+	var g errgroup.Group
+	g.Go(func() (err error) {
+		dconfPlan, err = m.dconf.PlanPolicy(ctx, objectName, isComputer, rules["dconf"])
+		return err
+	})
+	if isComputer {
+		g.Go(func() (err error) {
+			gdmPlan, err = m.gdm.PlanPolicy(ctx, rules["gdm"])
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return "", err
+	}
+
+	// Those managers have no dry run: list them whenever they have rules to apply or to reset.
+	appliedRules := entry.GetUniqueRules(appliedGPOs)
+	var unplanned []string
+	for _, domain := range []string{"scripts", "apparmor", "privilege", "mount", "environment", "proxy"} {
+		if len(rules[domain]) == 0 && len(appliedRules[domain]) == 0 {
+			continue
+		}
+		unplanned = append(unplanned, domain)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, i18n.G("Planned changes for %s:\n"), objectName)
+	changes := rulesDiff(appliedGPOs, gpos)
+	if changes == "" && dconfPlan == "" && gdmPlan == "" && len(unplanned) == 0 {
+		fmt.Fprintln(&out, i18n.G("No change."))
+		return out.String(), nil
+	}
+	if changes != "" {
+		fmt.Fprintln(&out, i18n.G("Rules, compared to the last applied policy:"))
+		out.WriteString(changes)
+	}
+	for _, p := range []struct {
+		name string
+		plan string
+	}{
+		{"dconf", dconfPlan},
+		{"gdm", gdmPlan},
+	} {
+		if p.plan == "" {
+			continue
+		}
+		fmt.Fprintf(&out, i18n.G("Files written by %s:\n"), p.name)
+		out.WriteString(p.plan)
+	}
+	if len(unplanned) > 0 {
+		fmt.Fprintf(&out, i18n.G("Changes not planned, applied on next update by: %s\n"), strings.Join(unplanned, ", "))
+	}
+
+	return out.String(), nil
 }
 
 // DumpPolicies displays the currently applied policies and rules (since last update) for objectName.
@@ -130,8 +346,7 @@ func (m *Manager) DumpPolicies(ctx context.Context, objectName string, withRules
 	var out strings.Builder
 
 	// Load machine for user
-	// FIXME: fqdn in hostname?
-	hostname, err := os.Hostname()
+	hostname, err := machineName()
 	if err != nil {
 		return "", err
 	}
@@ -161,6 +376,93 @@ func (m *Manager) DumpPolicies(ctx context.Context, objectName string, withRules
 	return out.String(), nil
 }
 
+// AppliedGPO is a GPO applied to an object since its last update.
+type AppliedGPO struct {
+	ID   string
+	Name string
+	// IsComputer is true for GPOs coming from the machine configuration.
+	IsComputer bool
+	Rules      []AppliedRule
+	// Extensions are the GUIDs of the client-side extensions having settings in the GPO, if known.
+	Extensions []string
+}
+
+// AppliedRule is a rule of an applied GPO.
+type AppliedRule struct {
+	entry.Entry
+	// Domain is the rule category (dconf, scripts…).
+	Domain string
+	// Overridden is true when a GPO with a higher priority defines the same key.
+	Overridden bool
+}
+
+// AppliedPolicies returns the currently applied GPOs (since last update) for objectName, in priority order,
+// with all their rules. For an user, the GPOs from the machine configuration come first.
+func (m *Manager) AppliedPolicies(ctx context.Context, objectName string) (gpos []AppliedGPO, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to get applied policies for %q"), objectName)
+
+	log.Infof(ctx, "Getting applied policies for %s", objectName)
+
+	hostname, err := machineName()
+	if err != nil {
+		return nil, err
+	}
+
+	var gposHost []entry.GPO
+	if objectName != hostname {
+		gposHost, err = entry.NewGPOs(filepath.Join(m.gpoRulesCacheDir, hostname))
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("no policy applied for %q: %v"), hostname, err)
+		}
+	}
+	gposTarget, err := entry.NewGPOs(filepath.Join(m.gpoRulesCacheDir, objectName))
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("no policy applied for %q: %v"), objectName, err)
+	}
+
+	alreadyProcessedRules := make(map[string]struct{})
+	appendGPOs := func(gpos []AppliedGPO, from []entry.GPO, isComputer bool) []AppliedGPO {
+		for _, g := range from {
+			a := AppliedGPO{ID: g.ID, Name: g.Name, IsComputer: isComputer, Extensions: g.Extensions}
+
+			var domains []string
+			for d := range g.Rules {
+				domains = append(domains, d)
+			}
+			sort.Strings(domains)
+			for _, d := range domains {
+				for _, r := range g.Rules[d] {
+					k := filepath.Join(d, r.Key)
+					_, overridden := alreadyProcessedRules[k]
+					a.Rules = append(a.Rules, AppliedRule{Entry: r, Domain: d, Overridden: overridden})
+					alreadyProcessedRules[k] = struct{}{}
+				}
+			}
+			gpos = append(gpos, a)
+		}
+		return gpos
+	}
+	gpos = appendGPOs(gpos, gposHost, true)
+	gpos = appendGPOs(gpos, gposTarget, objectName == hostname)
+
+	return gpos, nil
+}
+
+// machineName returns the name of the machine, which is the object name of its policy.
+func machineName() (string, error) {
+	// FIXME: fqdn in hostname?
+	return os.Hostname()
+}
+
+// isMachine returns true if objectName is the machine, and not an user.
+func isMachine(objectName string) (bool, error) {
+	hostname, err := machineName()
+	if err != nil {
+		return false, err
+	}
+	return objectName == hostname, nil
+}
+
 // LastUpdateFor returns the last update time for object or current machine.
 func (m *Manager) LastUpdateFor(ctx context.Context, objectName string, isMachine bool) (t time.Time, err error) {
 	defer decorate.OnError(&err, i18n.G("failed to get policy last update time %q (machine: %q)"), objectName, isMachine)
@@ -168,7 +470,7 @@ func (m *Manager) LastUpdateFor(ctx context.Context, objectName string, isMachin
 	log.Infof(ctx, "Get policies last update time %q (machine: %t)", objectName, isMachine)
 
 	if isMachine {
-		hostname, err := os.Hostname()
+		hostname, err := machineName()
 		if err != nil {
 			return time.Time{}, err
 		}
@@ -2,18 +2,31 @@ package policies_test
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
+	"gopkg.in/yaml.v3"
 
 	"github.com/ubuntu/adsys/internal/policies"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/apparmor"
+	"github.com/ubuntu/adsys/internal/policies/dconf"
 	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/policies/mount"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"github.com/ubuntu/adsys/internal/policies/proxy"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -48,6 +61,9 @@ func TestDumpPolicies(t *testing.T) {
 		"Multiple GPOs": {
 			cacheUser: "two_gpos_no_override",
 		},
+		"GPO with extensions": {
+			cacheUser: "one_gpo_with_extensions",
+		},
 
 		// Show rules
 		"One GPO with rules": {
@@ -121,7 +137,7 @@ func TestDumpPolicies(t *testing.T) {
 			t.Parallel()
 
 			cacheDir := t.TempDir()
-			m, err := policies.New(policies.WithCacheDir(cacheDir))
+			m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
 			err = os.MkdirAll(filepath.Join(cacheDir, entry.GPORulesCacheBaseName), 0755)
@@ -167,17 +183,339 @@ func TestDumpPolicies(t *testing.T) {
 
 }
 
-func TestApplyPolicy(t *testing.T) {
+func TestAppliedPolicies(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		cacheUser    string
+		cacheMachine string
+		target       string
+
+		wantErr bool
+	}{
+		"One GPO User": {cacheUser: "one_gpo"},
+		"One GPO Machine": {
+			cacheMachine: "one_gpo",
+			target:       hostname,
+		},
+		"Multiple GPOs with overrides": {cacheUser: "two_gpos_with_overrides"},
+		"GPO with extensions":          {cacheUser: "one_gpo_with_extensions"},
+		"Overrides between machine and user GPOs": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "two_gpos_override_one_gpo",
+		},
+
+		// Error cases
+		"Error on missing target cache": {wantErr: true},
+		"Error on missing machine cache when targeting user": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "-",
+			wantErr:      true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if tc.cacheUser != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheUser), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, "user"), false)
+				require.NoError(t, err, "Setup: couldn’t copy user cache")
+			}
+			if tc.cacheMachine == "" {
+				f, err := os.Create(filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname))
+				require.NoError(t, err, "Setup: failed to create empty machine cache file")
+				f.Close()
+			} else if tc.cacheMachine != "-" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheMachine), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname), false)
+				require.NoError(t, err, "Setup: couldn’t copy machine cache")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			gpos, err := m.AppliedPolicies(context.Background(), tc.target)
+			if tc.wantErr {
+				require.Error(t, err, "AppliedPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "AppliedPolicies should return no error but got one")
+
+			got, err := yaml.Marshal(gpos)
+			require.NoError(t, err, "Setup: can't marshal applied policies")
+
+			goldPath := filepath.Join("testdata", "golden_applied", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, got, 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load applied policies golden file")
+			require.Equal(t, string(want), string(got), "AppliedPolicies returned expected GPOs")
+		})
+	}
+}
+
+func TestExplainPolicy(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		cacheUser    string
+		cacheMachine string
+		target       string
+		key          string
+
+		wantErr bool
+	}{
+		"Single GPO defining the key":                  {cacheUser: "one_gpo", key: "dconf/path/to/key1"},
+		"User GPO overridden by a higher priority one": {cacheUser: "two_gpos_with_overrides", key: "dconf/path/to/Gpo1key1"},
+		"Machine GPO overrides user one": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "two_gpos_override_one_gpo",
+			key:          "dconf/path/to/key2",
+		},
+		"Machine GPO with release override overrides user one": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "machine_with_release_override",
+			key:          "dconf/path/to/key1",
+		},
+		"Disabled machine GPO overrides user one": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "machine_with_release_override",
+			key:          "dconf/path/to/key2",
+		},
+		"Machine target": {
+			cacheMachine: "machine_with_release_override",
+			target:       hostname,
+			key:          "dconf/path/to/key1",
+		},
+		"Disabled key on machine target": {
+			cacheMachine: "machine_with_release_override",
+			target:       hostname,
+			key:          "dconf/path/to/key2",
+		},
+		"Not enforced machine GPO is only a default": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "default_only",
+			key:          "dconf/path/to/key1",
+		},
+		"Not enforced user GPO is only a default": {cacheUser: "default_only", key: "dconf/path/to/key1"},
+		"Not enforced key on machine target": {
+			cacheMachine: "default_only",
+			target:       hostname,
+			key:          "dconf/path/to/key1",
+		},
+		"Disabled key is locked even when not enforced": {cacheUser: "default_only", key: "dconf/path/to/key2"},
+		"Key without domain matches every domain": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "machine_with_release_override",
+			key:          "path/to/key1",
+		},
+		"No dconf lock explanation for other domains": {cacheUser: "one_gpo", key: "scripts/path/to/key3"},
+		"Leading slash is ignored":                    {cacheUser: "one_gpo", key: "/dconf/path/to/key1"},
+
+		// Error cases
+		"Error on key not defined by any GPO": {cacheUser: "one_gpo", key: "dconf/path/to/unknown", wantErr: true},
+		"Error on domain not matching":        {cacheUser: "one_gpo", key: "scripts/path/to/key1", wantErr: true},
+		"Error on missing target cache":       {key: "dconf/path/to/key1", wantErr: true},
+		"Error on missing machine cache when targeting user": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "-",
+			key:          "dconf/path/to/key1",
+			wantErr:      true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if tc.cacheUser != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheUser), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, "user"), false)
+				require.NoError(t, err, "Setup: couldn’t copy user cache")
+			}
+			if tc.cacheMachine == "" {
+				f, err := os.Create(filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname))
+				require.NoError(t, err, "Setup: failed to create empty machine cache file")
+				f.Close()
+			} else if tc.cacheMachine != "-" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheMachine), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname), false)
+				require.NoError(t, err, "Setup: couldn’t copy machine cache")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			got, err := m.ExplainPolicy(context.Background(), tc.target, tc.key)
+			if tc.wantErr {
+				require.Error(t, err, "ExplainPolicy should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ExplainPolicy should return no error but got one")
+
+			// Make the output independent of the hostname
+			got = strings.ReplaceAll(got, hostname, "machine-hostname")
+
+			goldPath := filepath.Join("testdata", "golden_explain", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load explain golden file")
+			require.Equal(t, string(want), got, "ExplainPolicy returned expected precedence chain")
+		})
+	}
+}
+
+func TestHTMLReport(t *testing.T) {
 	t.Parallel()
 
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	displayNames := map[string]map[string]string{
+		"Machine": {"dconf/path/to/key1": "Machine display name of key1"},
+		"User": {
+			"dconf/path/to/key1":   "User display name of key1",
+			"scripts/path/to/key3": "Display name <with> HTML & characters",
+		},
+	}
+
 	tests := map[string]struct {
-		gposFile              string
-		secondCallWithNoRules bool
+		cacheUser    string
+		cacheMachine string
+		target       string
+		offline      bool
 
 		wantErr bool
 	}{
-		"succeed": {gposFile: "all_entry_types.gpos"},
-		"second call with no rules deletes everything": {gposFile: "all_entry_types.gpos", secondCallWithNoRules: true},
+		"User with machine and user GPOs": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "machine_with_release_override",
+		},
+		"User with overrides between user GPOs": {cacheUser: "two_gpos_with_overrides"},
+		"Machine target":                        {cacheMachine: "machine_with_release_override", target: hostname},
+		"Offline":                               {cacheUser: "one_gpo", offline: true},
+		"Not enforced rules":                    {cacheUser: "default_only"},
+
+		// Error cases
+		"Error on missing target cache": {wantErr: true},
+		"Error on missing machine cache when targeting user": {
+			cacheUser:    "one_gpo",
+			cacheMachine: "-",
+			wantErr:      true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			userCache := filepath.Join(cacheDir, entry.GPORulesCacheBaseName, "user")
+			machineCache := filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname)
+			if tc.cacheUser != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheUser), userCache, false)
+				require.NoError(t, err, "Setup: couldn’t copy user cache")
+				refresh := time.Date(2026, time.October, 17, 9, 30, 0, 0, time.UTC)
+				require.NoError(t, os.Chtimes(userCache, refresh, refresh), "Setup: couldn’t set user cache time")
+			}
+			if tc.cacheMachine == "" {
+				f, err := os.Create(machineCache)
+				require.NoError(t, err, "Setup: failed to create empty machine cache file")
+				f.Close()
+			} else if tc.cacheMachine != "-" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cacheMachine), machineCache, false)
+				require.NoError(t, err, "Setup: couldn’t copy machine cache")
+			}
+			if tc.cacheMachine != "-" {
+				refresh := time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC)
+				require.NoError(t, os.Chtimes(machineCache, refresh, refresh), "Setup: couldn’t set machine cache time")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			got, err := m.HTMLReport(context.Background(), tc.target, tc.offline, displayNames)
+			if tc.wantErr {
+				require.Error(t, err, "HTMLReport should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "HTMLReport should return no error but got one")
+
+			// Make the output independent of the hostname
+			got = strings.ReplaceAll(got, hostname, "machine-hostname")
+
+			goldPath := filepath.Join("testdata", "golden_report", name+".html")
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load report golden file")
+			require.Equal(t, string(want), got, "HTMLReport returned expected report")
+		})
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	m, err := policies.New(policies.WithCacheDir(t.TempDir()), policies.WithRunDir(t.TempDir()))
+	require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+	require.Equal(t, []string{adcommon.EnvironmentExtension, adcommon.RegistryExtension}, m.Extensions(), "Extensions returns the extensions of all managers, once")
+}
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		gposFile                string
+		existingCache           string
+		existingSnapshots       int
+		secondCallWithNoRules   bool
+		secondCallWithSameRules bool
+
+		wantSnapshots int
+		wantErr       bool
+	}{
+		"succeed": {gposFile: "all_entry_types.gpos", wantSnapshots: 1},
+		"second call with no rules deletes everything": {gposFile: "all_entry_types.gpos", secondCallWithNoRules: true, wantSnapshots: 2},
+		"second call with same rules is recorded once": {gposFile: "all_entry_types.gpos", secondCallWithSameRules: true, wantSnapshots: 1},
+		"rules applied before history are recorded":    {gposFile: "all_entry_types.gpos", existingCache: "one_gpo", wantSnapshots: 2},
+		"rules are only recorded once applied":         {gposFile: "all_entry_types.gpos", existingCache: "one_gpo_other", existingSnapshots: 1, wantSnapshots: 2},
+		"oldest snapshots are removed":                 {gposFile: "all_entry_types.gpos", existingSnapshots: 30, wantSnapshots: 30},
 
 		"dconf apply policy fails": {gposFile: "dconf_failing.gpos", wantErr: true},
 	}
@@ -193,12 +531,64 @@ func TestApplyPolicy(t *testing.T) {
 			fakeRootDir := t.TempDir()
 			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
 			dconfDir := filepath.Join(fakeRootDir, "etc", "dconf")
+			// run, apparmor, privilege, systemd, environment and proxy directories are tested in their own packages and not part of the golden tree
+			runDir := t.TempDir()
+			scriptsManager, err := scripts.New(scripts.WithRunDir(runDir),
+				scripts.WithGPOCacheDir(filepath.Join("testdata", "gpo_cache")),
+				scripts.WithSystemCtlCmd([]string{"true"}))
+			require.NoError(t, err, "Setup: couldn’t get a new scripts manager")
+			apparmorDir := t.TempDir()
+			apparmorManager, err := apparmor.New(apparmor.WithApparmorDir(apparmorDir),
+				apparmor.WithGPOCacheDir(filepath.Join("testdata", "gpo_cache")),
+				apparmor.WithApparmorParserCmd([]string{"true"}))
+			require.NoError(t, err, "Setup: couldn’t get a new apparmor manager")
+			sudoersDir, polkitDir := t.TempDir(), t.TempDir()
+			privilegeManager, err := privilege.New(privilege.WithSudoersDir(sudoersDir),
+				privilege.WithPolicyKitDir(polkitDir),
+				privilege.WithVisudoCmd([]string{"true"}))
+			require.NoError(t, err, "Setup: couldn’t get a new privilege manager")
+			systemdDir := t.TempDir()
+			mountManager, err := mount.New(mount.WithRunDir(runDir),
+				mount.WithSystemdDir(systemdDir),
+				mount.WithSystemCtlCmd([]string{"true"}))
+			require.NoError(t, err, "Setup: couldn’t get a new mount manager")
+			environmentDir := t.TempDir()
+			environmentManager, err := environment.New(environment.WithRunDir(runDir),
+				environment.WithEnvironmentDir(environmentDir))
+			require.NoError(t, err, "Setup: couldn’t get a new environment manager")
+			proxyDir := t.TempDir()
+			proxyManager, err := proxy.New(proxy.WithEnvironmentFile(filepath.Join(proxyDir, "environment")),
+				proxy.WithAptConfDir(filepath.Join(proxyDir, "apt.conf.d")),
+				proxy.WithCacheDir(proxyDir),
+				proxy.WithSnapCmd([]string{"true"}),
+				proxy.WithDconf(dconf.NewWithDconfDir(filepath.Join(proxyDir, "dconf"),
+					dconf.WithSchemasDir(filepath.Join("testdata", "schemas")))))
+			require.NoError(t, err, "Setup: couldn’t get a new proxy manager")
 			m, err := policies.New(policies.WithCacheDir(cacheDir),
-				policies.WithDconfDir(dconfDir))
+				policies.WithDconfDir(dconfDir),
+				policies.WithGSettingsSchemasDir(filepath.Join("testdata", "schemas")),
+				policies.WithScripts(scriptsManager),
+				policies.WithApparmor(apparmorManager),
+				policies.WithPrivilege(privilegeManager),
+				policies.WithMount(mountManager),
+				policies.WithEnvironment(environmentManager),
+				policies.WithProxy(proxyManager))
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
 			err = os.MkdirAll(filepath.Join(cacheDir, entry.GPORulesCacheBaseName), 0755)
 			require.NoError(t, err, "Setup: cant not create gpo rule cache directory")
+			if tc.existingCache != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.existingCache), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, "hostname"), false)
+				require.NoError(t, err, "Setup: couldn’t copy existing cache")
+			}
+			historyDir := filepath.Join(cacheDir, entry.GPORulesHistoryBaseName)
+			for i := 0; i < tc.existingSnapshots; i++ {
+				err := os.MkdirAll(filepath.Join(historyDir, "hostname"), 0700)
+				require.NoError(t, err, "Setup: couldn’t create history directory")
+				err = shutil.CopyFile(filepath.Join("testdata", "cache", "one_gpo"),
+					filepath.Join(historyDir, "hostname", fmt.Sprintf("200001%02dT000000.000000000Z", i+1)), false)
+				require.NoError(t, err, "Setup: couldn’t copy snapshot")
+			}
 
 			err = m.ApplyPolicy(context.Background(), "hostname", true, gpos)
 			if tc.wantErr {
@@ -207,16 +597,229 @@ func TestApplyPolicy(t *testing.T) {
 			}
 			require.NoError(t, err, "ApplyPolicy should return no error but got one")
 
+			require.FileExists(t, filepath.Join(runDir, "machine", "startup"), "Startup scripts should be staged")
+			require.FileExists(t, filepath.Join(apparmorDir, "machine", "{GPOId}", "Machine", "Apparmor", "usr.bin.foo"), "Apparmor profile should be deployed")
+			require.FileExists(t, filepath.Join(sudoersDir, "99-adsys-privilege-redirect"), "Sudoers file should be deployed")
+			require.FileExists(t, filepath.Join(polkitDir, "localauthority.conf.d", "99-adsys-privilege-redirect.conf"), "Polkit configuration should be deployed")
+			require.FileExists(t, filepath.Join(systemdDir, "media-adsys-example.com-share.automount"), "Automount unit should be deployed")
+			require.FileExists(t, filepath.Join(environmentDir, "90-adsys.conf"), "Environment variables should be deployed")
+			require.FileExists(t, filepath.Join(proxyDir, "apt.conf.d", "99adsys-proxy"), "Apt proxy configuration should be deployed")
+
 			if tc.secondCallWithNoRules {
 				err = m.ApplyPolicy(context.Background(), "hostname", true, nil)
 				require.NoError(t, err, "ApplyPolicy should return no error but got one")
+				require.NoDirExists(t, filepath.Join(runDir, "machine"), "Scripts should be unstaged")
+				require.NoDirExists(t, filepath.Join(apparmorDir, "machine"), "Apparmor profiles should be removed")
+				require.NoFileExists(t, filepath.Join(sudoersDir, "99-adsys-privilege-redirect"), "Sudoers file should be removed")
+				require.NoFileExists(t, filepath.Join(polkitDir, "localauthority.conf.d", "99-adsys-privilege-redirect.conf"), "Polkit configuration should be removed")
+				require.NoFileExists(t, filepath.Join(systemdDir, "media-adsys-example.com-share.automount"), "Automount unit should be removed")
+				require.NoFileExists(t, filepath.Join(environmentDir, "90-adsys.conf"), "Environment variables should be removed")
+				require.NoFileExists(t, filepath.Join(proxyDir, "apt.conf.d", "99adsys-proxy"), "Apt proxy configuration should be removed")
+			}
+			if tc.secondCallWithSameRules {
+				err = m.ApplyPolicy(context.Background(), "hostname", true, gpos)
+				require.NoError(t, err, "ApplyPolicy should return no error but got one")
+			}
+
+			// Snapshots are named after their time and are not part of the golden tree
+			if tc.existingSnapshots >= 30 {
+				require.NoFileExists(t, filepath.Join(historyDir, "hostname", "20000101T000000.000000000Z"), "Oldest snapshot should be removed")
 			}
+			snapshots, err := os.ReadDir(filepath.Join(historyDir, "hostname"))
+			require.NoError(t, err, "Policy history should be recorded")
+			require.Len(t, snapshots, tc.wantSnapshots, "Policy history should have the expected number of snapshots")
+			require.NoError(t, os.RemoveAll(historyDir), "Teardown: can't remove policy history")
 
 			testutils.CompareTreesWithFiltering(t, fakeRootDir, filepath.Join("testdata", "golden", name), update)
 		})
 	}
 }
 
+func TestPlanPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		gposFile   string
+		cache      string
+		isComputer bool
+
+		wantErr bool
+	}{
+		"machine without applied policy":            {gposFile: "all_entry_types.gpos", isComputer: true},
+		"machine with previously applied policy":    {gposFile: "all_entry_types.gpos", cache: "one_gpo", isComputer: true},
+		"user without applied policy":               {gposFile: "all_entry_types.gpos"},
+		"user with previously applied policy":       {gposFile: "all_entry_types.gpos", cache: "one_gpo"},
+		"unchanged policy lists unplanned managers": {gposFile: "cache/scripts_only", cache: "scripts_only"},
+
+		"dconf plan fails": {gposFile: "dconf_failing.gpos", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			gpos, err := entry.NewGPOs(filepath.Join("testdata", tc.gposFile))
+			require.NoError(t, err, "Setup: can not load gpo list")
+
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			dconfDir := filepath.Join(fakeRootDir, "etc", "dconf")
+			m, err := policies.New(policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(t.TempDir()),
+				policies.WithDconfDir(dconfDir),
+				policies.WithGSettingsSchemasDir(filepath.Join("testdata", "schemas")))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			cachePath := filepath.Join(cacheDir, entry.GPORulesCacheBaseName, "hostname")
+			if tc.cache != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", tc.cache), cachePath, false)
+				require.NoError(t, err, "Setup: couldn’t copy cache")
+			}
+
+			got, err := m.PlanPolicy(context.Background(), "hostname", tc.isComputer, gpos)
+			if tc.wantErr {
+				require.Error(t, err, "PlanPolicy should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "PlanPolicy should return no error but got one")
+
+			require.NoDirExists(t, dconfDir, "PlanPolicy should not write dconf policy")
+			if tc.cache == "" {
+				require.NoFileExists(t, cachePath, "PlanPolicy should not save GPO rules")
+			}
+
+			got = strings.ReplaceAll(got, fakeRootDir, "")
+			goldPath := filepath.Join("testdata", "golden_plan", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load plan golden file")
+			require.Equal(t, string(want), got, "PlanPolicy returned expected plan")
+		})
+	}
+}
+
+func TestPolicyHistory(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		history string
+
+		wantErr bool
+	}{
+		"List snapshots, most recent first": {history: "three_snapshots"},
+
+		// Error cases
+		"Error on no history": {wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newManagerWithHistory(t, tc.history)
+
+			got, err := m.PolicyHistory(context.Background(), "user")
+			if tc.wantErr {
+				require.Error(t, err, "PolicyHistory should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "PolicyHistory should return no error but got one")
+
+			goldPath := filepath.Join("testdata", "golden_history", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load history golden file")
+			require.Equal(t, string(want), got, "PolicyHistory returned expected output")
+		})
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		history string
+		since   string
+
+		wantErr bool
+	}{
+		"Default is since previous snapshot":       {history: "three_snapshots"},
+		"Since a number of snapshots":              {history: "three_snapshots", since: "2"},
+		"Since current snapshot has no change":     {history: "three_snapshots", since: "0"},
+		"Since a date uses the snapshot before it": {history: "three_snapshots", since: "2026-10-17"},
+		"Since a date and time":                    {history: "three_snapshots", since: "2026-10-17 08:00"},
+		"Since a RFC3339 time":                     {history: "three_snapshots", since: "2026-10-17T09:00:00Z"},
+		"Since a date after the last snapshot":     {history: "three_snapshots", since: "2026-10-19"},
+
+		// Error cases
+		"Error on no history":                    {since: "1", wantErr: true},
+		"Error on too many snapshots back":       {history: "three_snapshots", since: "3", wantErr: true},
+		"Error on negative number of snapshots":  {history: "three_snapshots", since: "-1", wantErr: true},
+		"Error on date before history":           {history: "three_snapshots", since: "2026-10-15", wantErr: true},
+		"Error on duration going before history": {history: "three_snapshots", since: "1000000h", wantErr: true},
+		"Error on invalid since":                 {history: "three_snapshots", since: "yesterday", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newManagerWithHistory(t, tc.history)
+
+			got, err := m.DiffPolicies(context.Background(), "user", tc.since)
+			if tc.wantErr {
+				require.Error(t, err, "DiffPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "DiffPolicies should return no error but got one")
+
+			goldPath := filepath.Join("testdata", "golden_history", name)
+			// Update golden file
+			if update {
+				t.Logf("updating golden file %s", goldPath)
+				err = os.WriteFile(goldPath, []byte(got), 0600)
+				require.NoError(t, err, "Cannot write golden file")
+			}
+			want, err := os.ReadFile(goldPath)
+			require.NoError(t, err, "Cannot load history golden file")
+			require.Equal(t, string(want), got, "DiffPolicies returned expected output")
+		})
+	}
+}
+
+// newManagerWithHistory returns a policy manager with the rules history from testdata/history/<history>.
+func newManagerWithHistory(t *testing.T, history string) *policies.Manager {
+	t.Helper()
+
+	cacheDir := t.TempDir()
+	m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+	require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+	if history != "" {
+		historyDir := filepath.Join(cacheDir, entry.GPORulesHistoryBaseName)
+		require.NoError(t, os.Remove(historyDir), "Setup: can't delete history directory before recreation")
+		err := shutil.CopyTree(filepath.Join("testdata", "history", history), historyDir, nil)
+		require.NoError(t, err, "Setup: couldn’t copy history")
+	}
+
+	return m
+}
+
 func TestLastUpdateFor(t *testing.T) {
 	t.Parallel()
 
@@ -244,7 +847,7 @@ func TestLastUpdateFor(t *testing.T) {
 			t.Parallel()
 
 			cacheDir := t.TempDir()
-			m, err := policies.New(policies.WithCacheDir(cacheDir))
+			m, err := policies.New(policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
 			err = os.MkdirAll(filepath.Join(cacheDir, entry.GPORulesCacheBaseName), 0755)
@@ -275,9 +878,96 @@ func TestLastUpdateFor(t *testing.T) {
 
 }
 
+func TestPurge(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		target   string
+		allStale bool
+
+		wantPurged []string
+		wantErr    bool
+	}{
+		"Purge user":                      {target: "alice"},
+		"Purge user keeps other keyfiles": {target: "dave"},
+		"Purge user without policy":       {target: "doesnotexist"},
+		"Purge all stale users":           {allStale: true, wantPurged: []string{"bob", "carol", "dave", "eve"}},
+
+		// Error cases
+		"Error on purging machine": {target: hostname, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeRootDir := t.TempDir()
+			require.NoError(t, os.Remove(fakeRootDir), "Setup: can't delete root directory before recreation")
+			require.NoError(t,
+				shutil.CopyTree(
+					filepath.Join("testdata", "purge"), fakeRootDir,
+					&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+				"Setup: can't create initial root directory")
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			// Machine cache is named after the current host
+			err := shutil.CopyFile(filepath.Join("testdata", "cache", "one_gpo"), filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname), false)
+			require.NoError(t, err, "Setup: couldn’t copy machine cache")
+
+			// alice, carol, dave and the gdm system user exist, frank can't be looked up and every other user is deleted
+			userLookup := func(name string) (*user.User, error) {
+				switch name {
+				case "alice", "carol", "dave", "gdm":
+					return &user.User{Username: name}, nil
+				case "frank":
+					return nil, errors.New("NSS is not reachable")
+				}
+				return nil, user.UnknownUserError(name)
+			}
+
+			apparmorManager, err := apparmor.New(apparmor.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				apparmor.WithApparmorParserCmd([]string{"true"}))
+			require.NoError(t, err, "Setup: couldn’t get a new apparmor manager")
+			m, err := policies.New(policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(t.TempDir()),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithApparmor(apparmorManager),
+				policies.WithUserLookup(userLookup))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			var purged []string
+			if tc.allStale {
+				purged, err = m.PurgeStale(context.Background())
+			} else {
+				err = m.Purge(context.Background(), tc.target)
+			}
+			if tc.wantErr {
+				require.Error(t, err, "Purge should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Purge should return no error but got one")
+			require.Equal(t, tc.wantPurged, purged, "PurgeStale should return purged users")
+
+			// Machine cache and empty history are not part of the golden tree
+			require.FileExists(t, filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname), "Machine cache should be kept")
+			require.NoError(t, os.Remove(filepath.Join(cacheDir, entry.GPORulesCacheBaseName, hostname)), "Teardown: can't remove machine cache")
+			require.NoError(t, os.RemoveAll(filepath.Join(cacheDir, entry.GPORulesHistoryBaseName)), "Teardown: can't remove policy history")
+
+			testutils.CompareTreesWithFiltering(t, fakeRootDir, filepath.Join("testdata", "golden_purge", name), update)
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.BoolVar(&update, "update", false, "update golden files")
 	flag.Parse()
 
+	// Snapshot times are displayed in local time
+	time.Local = time.UTC
+
 	m.Run()
 }
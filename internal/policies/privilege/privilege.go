@@ -0,0 +1,266 @@
+// Package privilege manages who can be a local administrator on the machine, through sudo and polkit.
+package privilege
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+)
+
+/*
+	Notes:
+	Privilege rules are machine only:
+	  - allow-local-admins: "false" (or a disabled policy) revokes administrator privileges from local sudo and admin groups.
+	  - client-admins: list of users and groups (prefixed by %), one per line, which are granted administrator privileges.
+
+	They are converted to:
+	  - a sudoers file, <sudoers dir>/99-adsys-privilege-redirect, validated with visudo before being installed.
+	  - a polkit configuration file, <polkit dir>/localauthority.conf.d/99-adsys-privilege-redirect.conf, setting
+	    AdminIdentities.
+	Both files are removed when no privilege rule applies.
+*/
+
+const (
+	adsysBaseSudoersName = "99-adsys-privilege-redirect"
+	adsysBasePolkitName  = "99-adsys-privilege-redirect.conf"
+
+	fileHeader = `# This file is managed by adsys.
+# Do not edit this file manually.
+# Any changes will be overwritten.
+`
+)
+
+// localAdminGroups are the local groups granted administrator privileges by default on Ubuntu.
+var localAdminGroups = []string{"sudo", "admin"}
+
+// Manager serializes ApplyPolicy calls, so that the sudoers file is validated with visudo and installed along with the
+// polkit configuration of the same policy.
+type Manager struct {
+	mu sync.Mutex
+
+	sudoersDir string
+	polkitDir  string
+	visudoCmd  []string
+}
+
+type options struct {
+	sudoersDir string
+	polkitDir  string
+	visudoCmd  []string
+}
+type option func(*options) error
+
+// WithSudoersDir specifies a personalized sudoers.d directory
+func WithSudoersDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.sudoersDir = p
+		return nil
+	}
+}
+
+// WithPolicyKitDir specifies a personalized polkit configuration directory
+func WithPolicyKitDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.polkitDir = p
+		return nil
+	}
+}
+
+// WithVisudoCmd specifies a personalized visudo command used to validate the sudoers file
+func WithVisudoCmd(cmd []string) func(o *options) error {
+	return func(o *options) error {
+		o.visudoCmd = cmd
+		return nil
+	}
+}
+
+// New returns a new manager for privilege policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new privilege handler manager"))
+
+	// defaults
+	args := options{
+		sudoersDir: "/etc/sudoers.d",
+		polkitDir:  "/etc/polkit-1",
+		visudoCmd:  []string{"visudo"},
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		sudoersDir: args.sudoersDir,
+		polkitDir:  args.polkitDir,
+		visudoCmd:  args.visudoCmd,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the privilege manager:
+// the lists of client administrators are registry values, nothing is read from the GPO files.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
+// ApplyPolicy generates sudoers and polkit configuration from machine privilege rules.
+// User policies are ignored.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply privilege policy to %s"), objectName)
+
+	if !isComputer {
+		if len(entries) > 0 {
+			log.Warningf(ctx, i18n.G("Privilege policies are only supported on machine, ignoring them for %s"), objectName)
+		}
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy privilege policy to %s", objectName)
+
+	sudoersPath := filepath.Join(m.sudoersDir, adsysBaseSudoersName)
+	polkitPath := filepath.Join(m.polkitDir, "localauthority.conf.d", adsysBasePolkitName)
+
+	var configured, disallowLocalAdmins bool
+	var admins []string
+	for _, e := range entries {
+		switch e.Key {
+		case "allow-local-admins":
+			configured = true
+			disallowLocalAdmins = e.Disabled || e.Value == "false"
+		case "client-admins":
+			if e.Disabled {
+				continue
+			}
+			for _, a := range strings.Split(e.Value, "\n") {
+				a = strings.TrimSpace(a)
+				if a == "" {
+					continue
+				}
+				admins = append(admins, a)
+			}
+			if len(admins) > 0 {
+				configured = true
+			}
+		default:
+			log.Warningf(ctx, i18n.G("Ignoring unsupported privilege key %q for %s"), e.Key, objectName)
+		}
+	}
+
+	// Nothing to enforce: keep system defaults
+	if !configured {
+		log.Debugf(ctx, "No privilege policy: removing %s and %s", sudoersPath, polkitPath)
+		for _, p := range []string{sudoersPath, polkitPath} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// sudoers: last matching rule wins, so revoke local groups first, then grant client admins.
+	sudoersContent := fileHeader + "\n"
+	var polkitIdentities []string
+	if disallowLocalAdmins {
+		for _, g := range localAdminGroups {
+			sudoersContent += fmt.Sprintf("%%%s\tALL=(ALL:ALL) !ALL\n", g)
+		}
+	} else {
+		for _, g := range localAdminGroups {
+			polkitIdentities = append(polkitIdentities, "unix-group:"+g)
+		}
+	}
+	for _, a := range admins {
+		sudoersContent += fmt.Sprintf("%s\tALL=(ALL:ALL) ALL\n", escapeSudoersName(a))
+		if strings.HasPrefix(a, "%") {
+			polkitIdentities = append(polkitIdentities, "unix-group:"+strings.TrimPrefix(a, "%"))
+			continue
+		}
+		polkitIdentities = append(polkitIdentities, "unix-user:"+a)
+	}
+
+	if err := m.writeSudoers(ctx, sudoersPath, sudoersContent); err != nil {
+		return err
+	}
+
+	polkitContent := fmt.Sprintf("%s\n[Configuration]\nAdminIdentities=%s\n", fileHeader, strings.Join(polkitIdentities, ";"))
+	return writeAtomically(polkitPath, polkitContent, 0644)
+}
+
+// writeSudoers validates the sudoers content before installing it atomically.
+func (m *Manager) writeSudoers(ctx context.Context, path, content string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't write sudoers file"))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// sudo ignores files containing a dot in sudoers.d, so the temporary file is never used before being validated.
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(content), 0440); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Info(ctx, i18n.G("Could not clean up temporary file:"), err)
+		}
+	}()
+
+	args := append([]string{}, m.visudoCmd...) // Copy visudoCmd to prevent data race
+	args = append(args, "-c", "-f", tmp)
+	// #nosec G204 - we control the input
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	smbsafe.WaitExec()
+	out, errExec := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if errExec != nil {
+		return fmt.Errorf(i18n.G("invalid sudoers content: %v\n%s"), errExec, out)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// writeAtomically writes content to path through a temporary file.
+func writeAtomically(path, content string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".new", []byte(content), perm); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// escapeSudoersName escapes characters with a special meaning in sudoers user and group names.
+func escapeSudoersName(name string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		" ", `\ `,
+		",", `\,`,
+		":", `\:`,
+		"=", `\=`,
+		"#", `\#`,
+		"(", `\(`,
+		")", `\)`,
+	)
+	// Keep group prefix unescaped
+	if strings.HasPrefix(name, "%") {
+		return "%" + r.Replace(strings.TrimPrefix(name, "%"))
+	}
+	return r.Replace(name)
+}
@@ -0,0 +1,116 @@
+package privilege_test
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+var update bool
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries     []entry.Entry
+		isUser      bool
+		existing    bool
+		visudoFails bool
+
+		wantNoFiles bool
+		wantErr     bool
+	}{
+		"disallow local admins":                      {entries: []entry.Entry{{Key: "allow-local-admins", Value: "false"}}},
+		"disabled allow local admins disallows them": {entries: []entry.Entry{{Key: "allow-local-admins", Disabled: true}}},
+		"allow local admins only":                    {entries: []entry.Entry{{Key: "allow-local-admins", Value: "true"}}},
+		"client admins users and groups": {entries: []entry.Entry{
+			{Key: "client-admins", Value: "alice@example.com\n%domain admins@example.com\n\n  bob@example.com  \n"}}},
+		"client admins and disallowed local admins": {entries: []entry.Entry{
+			{Key: "allow-local-admins", Value: "false"},
+			{Key: "client-admins", Value: "alice@example.com\n%domain admins@example.com"}}},
+		"special characters are escaped in sudoers": {entries: []entry.Entry{
+			{Key: "client-admins", Value: `al,ice:(x)=#y\z`}}},
+		"unsupported keys are ignored": {entries: []entry.Entry{
+			{Key: "allow-local-admins", Value: "false"},
+			{Key: "unsupported", Value: "something"}}},
+		"existing files are replaced": {entries: []entry.Entry{
+			{Key: "client-admins", Value: "alice@example.com"}}, existing: true},
+
+		// No configuration cases
+		"no entries removes existing files":         {existing: true, wantNoFiles: true},
+		"no entries and no existing files":          {wantNoFiles: true},
+		"disabled client admins only removes files": {entries: []entry.Entry{{Key: "client-admins", Value: "alice@example.com", Disabled: true}}, existing: true, wantNoFiles: true},
+		"empty client admins only removes files":    {entries: []entry.Entry{{Key: "client-admins", Value: "\n"}}, existing: true, wantNoFiles: true},
+		"user policies are ignored":                 {entries: []entry.Entry{{Key: "allow-local-admins", Value: "false"}}, isUser: true, wantNoFiles: true},
+
+		// Error cases
+		"error on invalid sudoers keeps existing files": {entries: []entry.Entry{
+			{Key: "client-admins", Value: "alice@example.com"}}, existing: true, visudoFails: true, wantErr: true},
+		"error on invalid sudoers without existing files": {entries: []entry.Entry{
+			{Key: "client-admins", Value: "alice@example.com"}}, visudoFails: true, wantErr: true, wantNoFiles: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rootDir := t.TempDir()
+			sudoersDir := filepath.Join(rootDir, "etc", "sudoers.d")
+			polkitDir := filepath.Join(rootDir, "etc", "polkit-1")
+
+			if tc.existing {
+				require.NoError(t,
+					shutil.CopyTree(filepath.Join("testdata", "existing"), filepath.Join(rootDir, "etc"),
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't copy existing configuration")
+			}
+
+			visudoCmd := []string{"true"}
+			if tc.visudoFails {
+				visudoCmd = []string{"false"}
+			}
+			m, err := privilege.New(
+				privilege.WithSudoersDir(sudoersDir),
+				privilege.WithPolicyKitDir(polkitDir),
+				privilege.WithVisudoCmd(visudoCmd))
+			require.NoError(t, err, "Setup: can't create privilege manager")
+
+			objectName := "hostname"
+			if tc.isUser {
+				objectName = "user@example.com"
+			}
+
+			err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			require.NoFileExists(t, filepath.Join(sudoersDir, "99-adsys-privilege-redirect.new"), "Temporary sudoers file should be cleaned up")
+
+			if tc.wantNoFiles {
+				require.NoFileExists(t, filepath.Join(sudoersDir, "99-adsys-privilege-redirect"), "Sudoers file should not exist")
+				require.NoFileExists(t, filepath.Join(polkitDir, "localauthority.conf.d", "99-adsys-privilege-redirect.conf"), "Polkit configuration should not exist")
+				return
+			}
+
+			testutils.CompareTreesWithFiltering(t, rootDir, filepath.Join("testdata", "golden", name), update)
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
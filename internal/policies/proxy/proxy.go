@@ -0,0 +1,520 @@
+// Package proxy applies the machine proxy settings to the system environment, apt, snapd and GNOME.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/dconf"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+/*
+	Notes:
+	A single proxy definition, from the "proxy-url", "proxy-bypass" and "proxy-autoconfig-url" machine keys, is
+	applied to every back-end:
+	  - /etc/environment: a block of http_proxy, https_proxy and no_proxy variables (and their uppercase forms),
+	    between adsys markers. Other lines of the file are kept.
+	  - apt: Acquire::http::Proxy and Acquire::https::Proxy in <apt.conf.d>/99adsys-proxy, with DIRECT connections
+	    for the bypassed host names.
+	  - snapd: proxy.http, proxy.https and proxy.no-proxy system options, set with the snap command. The applied
+	    options are kept in the cache directory to only call snap on changes.
+	  - GNOME: org.gnome.system.proxy keys, written by the dconf manager in the adsys-proxy keyfile of the machine
+	    database. This is the only back-end supporting the autoconfiguration URL.
+	Back-ends are reset when no proxy is defined anymore.
+*/
+
+const (
+	urlKey        = "proxy-url"
+	bypassKey     = "proxy-bypass"
+	autoconfigKey = "proxy-autoconfig-url"
+
+	aptConfFileName  = "99adsys-proxy"
+	dconfKeyfileName = "adsys-proxy"
+	snapStateName    = "snapd"
+
+	environmentBegin = "# BEGIN adsys proxy settings. Do not edit this block manually."
+	environmentEnd   = "# END adsys proxy settings"
+
+	fileHeader = `# This file is managed by adsys.
+# Do not edit this file manually.
+# Any changes will be overwritten.
+`
+)
+
+// Manager serializes ApplyPolicy calls, as /etc/environment, the apt configuration, the snap settings and the cache of
+// the last applied settings are all updated together.
+type Manager struct {
+	mu sync.Mutex
+
+	environmentFile string
+	aptConfDir      string
+	cacheDir        string
+	snapCmd         []string
+	dconf           *dconf.Manager
+}
+
+type options struct {
+	environmentFile string
+	aptConfDir      string
+	cacheDir        string
+	snapCmd         []string
+	dconf           *dconf.Manager
+}
+type option func(*options) error
+
+// WithEnvironmentFile specifies a personalized system environment file
+func WithEnvironmentFile(p string) func(o *options) error {
+	return func(o *options) error {
+		o.environmentFile = p
+		return nil
+	}
+}
+
+// WithAptConfDir specifies a personalized apt.conf.d directory
+func WithAptConfDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.aptConfDir = p
+		return nil
+	}
+}
+
+// WithCacheDir specifies a personalized daemon cache directory
+func WithCacheDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.cacheDir = p
+		return nil
+	}
+}
+
+// WithSnapCmd specifies a personalized snap command
+func WithSnapCmd(cmd []string) func(o *options) error {
+	return func(o *options) error {
+		o.snapCmd = cmd
+		return nil
+	}
+}
+
+// WithDconf specifies a personalized dconf manager
+func WithDconf(m *dconf.Manager) func(o *options) error {
+	return func(o *options) error {
+		o.dconf = m
+		return nil
+	}
+}
+
+// New returns a new manager for proxy policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new proxy handler manager"))
+
+	// defaults
+	args := options{
+		environmentFile: "/etc/environment",
+		aptConfDir:      "/etc/apt/apt.conf.d",
+		cacheDir:        consts.DefaultCacheDir,
+		snapCmd:         []string{"snap"},
+		dconf:           &dconf.Manager{},
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		environmentFile: args.environmentFile,
+		aptConfDir:      args.aptConfDir,
+		cacheDir:        args.cacheDir,
+		snapCmd:         args.snapCmd,
+		dconf:           args.dconf,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the proxy manager.
+// The proxy settings are registry values of the machine only: users can't get their own system proxy.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
+// settings is the proxy definition applied to every back-end.
+type settings struct {
+	url        *url.URL
+	bypass     []string
+	autoconfig string
+}
+
+// ApplyPolicy applies the machine proxy settings to every back-end, resetting the ones which are not defined anymore.
+// Proxy settings are only defined for the machine.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply proxy policy to %s"), objectName)
+
+	if !isComputer {
+		if len(entries) > 0 {
+			log.Warningf(ctx, i18n.G("Ignoring proxy settings for user %s: they only apply to the machine"), objectName)
+		}
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy proxy policy to %s", objectName)
+
+	s, err := parseSettings(entries)
+	if err != nil {
+		return err
+	}
+
+	// Apply to every back-end, even if one fails, so that they don't get more inconsistent
+	var errMsgs []string
+	for _, apply := range []func(context.Context, settings) error{
+		m.applyEnvironment,
+		m.applyApt,
+		m.applySnap,
+		m.applyGNOME,
+	} {
+		if err := apply(ctx, s); err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+	if errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+	return nil
+}
+
+// parseSettings returns the proxy settings from the entries, after validating them.
+func parseSettings(entries []entry.Entry) (s settings, err error) {
+	var errMsgs []string
+	for _, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		value := strings.TrimSpace(e.Value)
+		switch e.Key {
+		case urlKey:
+			if value == "" {
+				continue
+			}
+			u, err := parseURL(value, "http", "https")
+			if err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
+				continue
+			}
+			s.url = u
+		case bypassKey:
+			for _, l := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ',' }) {
+				l = strings.TrimSpace(l)
+				if l == "" {
+					continue
+				}
+				if strings.ContainsAny(l, " \t\"'") {
+					errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: invalid host %q"), e.Key, l))
+					continue
+				}
+				s.bypass = append(s.bypass, l)
+			}
+		case autoconfigKey:
+			if value == "" {
+				continue
+			}
+			u, err := parseURL(value, "http", "https", "file")
+			if err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
+				continue
+			}
+			s.autoconfig = u.String()
+		default:
+			errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: unsupported proxy key"), e.Key))
+		}
+	}
+
+	if errMsgs != nil {
+		return settings{}, errors.New(strings.Join(errMsgs, "\n"))
+	}
+	return s, nil
+}
+
+// parseURL parses and validates a proxy URL with one of the given schemes.
+// Credentials are refused as every back-end stores them in world readable files.
+func parseURL(value string, schemes ...string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	supported := false
+	for _, s := range schemes {
+		if u.Scheme == s {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf(i18n.G("unsupported scheme %q, expected one of %s"), u.Scheme, strings.Join(schemes, ", "))
+	}
+	if u.Scheme != "file" && u.Hostname() == "" {
+		return nil, fmt.Errorf(i18n.G("missing host in %q"), value)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf(i18n.G("credentials in %q are not supported as they would be readable by every user"), value)
+	}
+	return u, nil
+}
+
+// noProxy returns the bypass list in the no_proxy format, where domain wildcards are leading dots.
+func (s settings) noProxy() string {
+	var hosts []string
+	for _, h := range s.bypass {
+		hosts = append(hosts, strings.TrimPrefix(h, "*"))
+	}
+	return strings.Join(hosts, ",")
+}
+
+// applyEnvironment replaces the adsys block of proxy variables in the system environment file.
+func (m *Manager) applyEnvironment(ctx context.Context, s settings) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't update %s"), m.environmentFile)
+
+	content, err := os.ReadFile(m.environmentFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Keep everything outside of our block
+	var lines []string
+	var inBlock, hadBlock bool
+	for _, l := range strings.SplitAfter(string(content), "\n") {
+		switch strings.TrimSpace(l) {
+		case environmentBegin:
+			inBlock, hadBlock = true, true
+			continue
+		case environmentEnd:
+			inBlock = false
+			continue
+		}
+		if inBlock || l == "" {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if !hadBlock && s.url == nil {
+		log.Debugf(ctx, "No proxy variables to set or remove in %s", m.environmentFile)
+		return nil
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+
+	if s.url != nil {
+		lines = append(lines, environmentBegin+"\n")
+		for _, name := range []string{"http_proxy", "https_proxy"} {
+			lines = append(lines, fmt.Sprintf("%s=%q\n%s=%q\n", name, s.url, strings.ToUpper(name), s.url))
+		}
+		if noProxy := s.noProxy(); noProxy != "" {
+			lines = append(lines, fmt.Sprintf("no_proxy=%q\nNO_PROXY=%q\n", noProxy, noProxy))
+		}
+		lines = append(lines, environmentEnd+"\n")
+	}
+
+	newContent := strings.Join(lines, "")
+	if newContent == string(content) {
+		log.Debugf(ctx, "%s didn't change", m.environmentFile)
+		return nil
+	}
+
+	log.Debugf(ctx, "Updating proxy variables in %s", m.environmentFile)
+	// #nosec G306 - the system environment is readable by everyone
+	if err := os.WriteFile(m.environmentFile+".new", []byte(newContent), 0644); err != nil {
+		return err
+	}
+	return os.Rename(m.environmentFile+".new", m.environmentFile)
+}
+
+// applyApt writes the apt proxy configuration, or removes it if no proxy is defined.
+// The autoconfiguration URL is not supported by apt.
+func (m *Manager) applyApt(ctx context.Context, s settings) (err error) {
+	p := filepath.Join(m.aptConfDir, aptConfFileName)
+	defer decorate.OnError(&err, i18n.G("can't update apt proxy configuration %s"), p)
+
+	if s.url == nil {
+		log.Debugf(ctx, "No proxy for apt, removing %s", p)
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	var content strings.Builder
+	content.WriteString(fileHeader)
+	for _, scheme := range []string{"http", "https"} {
+		fmt.Fprintf(&content, "Acquire::%s::Proxy %q;\n", scheme, s.url)
+	}
+	// apt can only bypass the proxy for exact host names
+	for _, h := range s.bypass {
+		if strings.HasPrefix(h, "*") || strings.HasPrefix(h, ".") || strings.ContainsAny(h, "/:") {
+			log.Debugf(ctx, "apt can't bypass the proxy for %q, skipping", h)
+			continue
+		}
+		for _, scheme := range []string{"http", "https"} {
+			fmt.Fprintf(&content, "Acquire::%s::Proxy::%s \"DIRECT\";\n", scheme, h)
+		}
+	}
+
+	if old, err := os.ReadFile(p); err == nil && string(old) == content.String() {
+		log.Debugf(ctx, "%s didn't change", p)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.aptConfDir, 0755); err != nil {
+		return err
+	}
+	// #nosec G306 - apt configuration is readable by everyone
+	if err := os.WriteFile(p+".new", []byte(content.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// applySnap sets or unsets the snapd proxy options when they changed since they were last applied.
+// It is skipped if snap is not installed.
+func (m *Manager) applySnap(ctx context.Context, s settings) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't update snapd proxy configuration"))
+
+	var proxyURL, noProxy string
+	if s.url != nil {
+		proxyURL, noProxy = s.url.String(), s.noProxy()
+	}
+	options := []struct {
+		name  string
+		value string
+	}{
+		{"proxy.http", proxyURL},
+		{"proxy.https", proxyURL},
+		{"proxy.no-proxy", noProxy},
+	}
+
+	var set, unset []string
+	var state strings.Builder
+	for _, o := range options {
+		if o.value == "" {
+			unset = append(unset, o.name)
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s=%s", o.name, o.value))
+		fmt.Fprintf(&state, "%s=%s\n", o.name, o.value)
+	}
+
+	statePath := filepath.Join(m.cacheDir, "proxy", snapStateName)
+	applied, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if string(applied) == state.String() {
+		log.Debugf(ctx, "snapd proxy configuration didn't change")
+		return nil
+	}
+
+	if _, err := exec.LookPath(m.snapCmd[0]); err != nil {
+		log.Infof(ctx, "snap is not installed, skipping snapd proxy configuration: %v", err)
+		return nil
+	}
+
+	for _, args := range [][]string{
+		append([]string{"set", "system"}, set...),
+		append([]string{"unset", "system"}, unset...),
+	} {
+		if len(args) == 2 {
+			continue
+		}
+		// #nosec G204 - We are in control of the arguments
+		cmd := exec.CommandContext(ctx, m.snapCmd[0], append(m.snapCmd[1:], args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf(i18n.G("failed to run snap %s: %v\n%s"), strings.Join(args, " "), err, out)
+		}
+	}
+
+	if state.Len() == 0 {
+		if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, []byte(state.String()), 0600)
+}
+
+// applyGNOME writes the GNOME proxy settings to the machine dconf database.
+// It is skipped, after resetting any previous settings, if the GNOME proxy schema is not installed.
+func (m *Manager) applyGNOME(ctx context.Context, s settings) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't update GNOME proxy settings"))
+
+	var entries []entry.Entry
+	if s.url != nil || s.autoconfig != "" {
+		defined, err := m.dconf.KeyDefined(ctx, "system/proxy/mode")
+		if err != nil {
+			return err
+		}
+		if defined {
+			entries = gnomeEntries(s)
+		} else {
+			log.Infof(ctx, "GNOME proxy settings are not installed, skipping them")
+		}
+	}
+
+	return m.dconf.ApplyKeyfilePolicy(ctx, "machine", true, dconfKeyfileName, entries)
+}
+
+// gnomeEntries returns the dconf entries of the org.gnome.system.proxy settings.
+// The autoconfiguration URL takes precedence over the proxy URL, like in GNOME.
+func gnomeEntries(s settings) []entry.Entry {
+	mode := "manual"
+	if s.autoconfig != "" {
+		mode = "auto"
+	}
+	entries := []entry.Entry{
+		{Key: "system/proxy/mode", Value: mode, Meta: "s"},
+	}
+	if s.autoconfig != "" {
+		entries = append(entries, entry.Entry{Key: "system/proxy/autoconfig-url", Value: s.autoconfig, Meta: "s"})
+	}
+	if s.url != nil {
+		port := s.url.Port()
+		if port == "" {
+			port = "80"
+			if s.url.Scheme == "https" {
+				port = "443"
+			}
+		}
+		for _, scheme := range []string{"http", "https"} {
+			entries = append(entries,
+				entry.Entry{Key: fmt.Sprintf("system/proxy/%s/host", scheme), Value: s.url.Hostname(), Meta: "s"},
+				entry.Entry{Key: fmt.Sprintf("system/proxy/%s/port", scheme), Value: port, Meta: "i"})
+		}
+	}
+	if len(s.bypass) > 0 {
+		var hosts []string
+		for _, h := range s.bypass {
+			// GNOME needs a wildcard to match subdomains
+			if strings.HasPrefix(h, ".") {
+				h = "*" + h
+			}
+			hosts = append(hosts, fmt.Sprintf("'%s'", h))
+		}
+		entries = append(entries, entry.Entry{Key: "system/proxy/ignore-hosts", Value: "[" + strings.Join(hosts, ", ") + "]", Meta: "as"})
+	}
+	return entries
+}
@@ -0,0 +1,152 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+/*
+	Notes:
+	Policies are only applied when an user logs in, so nothing removes them when the user is deleted or when its GPOs
+	are unlinked and it doesn't log in anymore.
+	An user is stale when it doesn't exist anymore in NSS: every persistent policy artifact outliving it, its dconf
+	database and profile and its apparmor hat, then its rules cache are removed. The rules cache is removed last so that
+	a failed purge is retried on next run. Only unknown users are considered deleted, not lookup failures, which can be
+	temporary. Artifacts staged in the run directory for scripts, mounts and environment don't outlive a reboot.
+	An existing user is stale for dconf when it has dconf artifacts but no dconf rule in its cache: only its dconf
+	artifacts are removed, as the next login will regenerate the other ones.
+	The history of the rules is kept in both cases.
+	Purging is only done on request: when sssd is not reachable, NSS falls back to local files and reports every
+	domain user as unknown, which would purge all of them.
+*/
+
+// Purge removes the policies applied to the user objectName which outlive it: its dconf database and profile, its
+// apparmor hat, and its rules cache. The history of its applied rules is kept.
+func (m *Manager) Purge(ctx context.Context, objectName string) (err error) {
+	defer decorate.OnError(&err, i18n.G("failed to purge policy of %q"), objectName)
+
+	log.Infof(ctx, "Purge policy of %s", objectName)
+
+	isComputer, err := isMachine(objectName)
+	if err != nil {
+		return err
+	}
+	if isComputer {
+		return errors.New(i18n.G("machine policy can't be purged"))
+	}
+
+	if err := m.dconf.Purge(ctx, objectName); err != nil {
+		return err
+	}
+	// No apparmor rule removes the user hat
+	if err := m.apparmor.ApplyPolicy(ctx, objectName, false, nil); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(m.gpoRulesCacheDir, objectName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PurgeStale purges the policies of users which don't exist anymore, and the dconf artifacts of users without any
+// dconf policy left. It returns the list of purged users.
+func (m *Manager) PurgeStale(ctx context.Context) (purged []string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to purge stale policies"))
+
+	log.Info(ctx, "Purge stale policies")
+
+	hostname, err := machineName()
+	if err != nil {
+		return nil, err
+	}
+
+	dconfUsers, err := m.dconf.Users()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[string]bool)
+	for _, u := range dconfUsers {
+		candidates[u] = true
+	}
+	cached, err := os.ReadDir(m.gpoRulesCacheDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range cached {
+		if f.IsDir() || f.Name() == hostname {
+			continue
+		}
+		if _, ok := candidates[f.Name()]; !ok {
+			candidates[f.Name()] = false
+		}
+	}
+
+	var users []string
+	for u := range candidates {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+
+	for _, u := range users {
+		_, err := m.userLookup(u)
+		var unknownUser user.UnknownUserError
+		if errors.As(err, &unknownUser) {
+			log.Infof(ctx, "User %s doesn't exist anymore", u)
+			if err := m.Purge(ctx, u); err != nil {
+				return purged, err
+			}
+			purged = append(purged, u)
+			continue
+		} else if err != nil {
+			log.Warningf(ctx, "Can't check if user %s still exists, keeping its policies: %v", u, err)
+			continue
+		}
+
+		hasDconfArtifacts := candidates[u]
+		if !hasDconfArtifacts {
+			continue
+		}
+		hasRules, err := m.hasDconfRules(u)
+		if err != nil {
+			return purged, err
+		}
+		if hasRules {
+			continue
+		}
+		log.Infof(ctx, "User %s has no dconf policy anymore", u)
+		if err := m.dconf.Purge(ctx, u); err != nil {
+			return purged, fmt.Errorf(i18n.G("failed to purge policy of %q: %v"), u, err)
+		}
+		purged = append(purged, u)
+	}
+
+	return purged, nil
+}
+
+// hasDconfRules returns true if the cached rules of objectName contain any dconf rule.
+func (m *Manager) hasDconfRules(objectName string) (bool, error) {
+	cachePath := filepath.Join(m.gpoRulesCacheDir, objectName)
+	if _, err := os.Stat(cachePath); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	gpos, err := entry.NewGPOs(cachePath)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range gpos {
+		if len(g.Rules["dconf"]) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,166 @@
+package policies
+
+import (
+	"context"
+	"html/template"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/decorate"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+// reportTemplate is the Resultant Set of Policy HTML report, in the spirit of gpresult /h.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"T": i18n.G}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{T "Resultant Set of Policy"}} - {{.Target}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.key { color: #666; font-family: monospace; font-size: 0.9em; }
+.value { font-family: monospace; white-space: pre-wrap; }
+.overridden { color: #999; text-decoration: line-through; }
+.offline { background: #fdd; border: 1px solid #c00; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>{{T "Resultant Set of Policy"}}</h1>
+<p>{{T "Target:"}} {{.Target}}</p>
+{{- if .Offline}}
+<p class="offline">{{T "Offline mode: policies were applied from the cache of the last online update."}}</p>
+{{- end}}
+{{- range .Sections}}
+<h2>{{.Title}}</h2>
+<p>{{T "Last refresh:"}} {{.LastUpdate}}</p>
+{{- if not .GPOs}}
+<p>{{T "No GPO applied."}}</p>
+{{- end}}
+{{- range .GPOs}}
+<h3>{{.Name}} <span class="key">{{.ID}}</span></h3>
+{{- range .Domains}}
+<table>
+<tr><th colspan="3">{{.Name}}</th></tr>
+<tr><th>{{T "Policy"}}</th><th>{{T "Value"}}</th><th>{{T "State"}}</th></tr>
+{{- range .Rules}}
+<tr{{if .Overridden}} class="overridden"{{end}}>
+<td>{{if .DisplayName}}{{.DisplayName}}<br><span class="key">{{.Key}}</span>{{else}}<span class="key">{{.Key}}</span>{{end}}</td>
+<td class="value">{{if .Disabled}}{{T "disabled"}}{{else}}{{.Value}}{{end}}</td>
+<td>{{if .Overridden}}{{T "overridden"}}{{else}}{{T "applied"}}{{end}}{{if .ReleaseOverride}}, {{T "release override"}} Override{{.ReleaseOverride}}{{end}}{{if and .DefaultOnly (not .Disabled)}}, {{T "not enforced"}}{{end}}</td>
+</tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// reportSection is the machine or user part of a report.
+type reportSection struct {
+	Title      string
+	LastUpdate string
+	GPOs       []reportGPO
+}
+
+// reportGPO is a GPO of a report, with its rules grouped by domain.
+type reportGPO struct {
+	Name    string
+	ID      string
+	Domains []reportDomain
+}
+
+// reportDomain is a rule domain of a GPO in a report.
+type reportDomain struct {
+	Name  string
+	Rules []reportRule
+}
+
+// reportRule is a rule of a report, with the display name of its policy.
+type reportRule struct {
+	AppliedRule
+	DisplayName string
+}
+
+// HTMLReport renders the currently applied policies (since last update) for objectName as an HTML page, with their
+// last refresh time. displayNames are the policy display names, indexed by object class (Machine or User), then by
+// rule domain and key. Rules without a display name are shown with their key.
+func (m *Manager) HTMLReport(ctx context.Context, objectName string, offline bool, displayNames map[string]map[string]string) (report string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to generate policy report for %q"), objectName)
+
+	log.Infof(ctx, "Generating policy report for %s", objectName)
+
+	gpos, err := m.AppliedPolicies(ctx, objectName)
+	if err != nil {
+		return "", err
+	}
+
+	isComputer, err := isMachine(objectName)
+	if err != nil {
+		return "", err
+	}
+
+	machine := reportSection{Title: i18n.G("Machine configuration")}
+	user := reportSection{Title: i18n.G("User configuration")}
+	t, err := m.LastUpdateFor(ctx, "", true)
+	if err != nil {
+		return "", err
+	}
+	machine.LastUpdate = t.Local().Format(historyTimeLayout)
+	if !isComputer {
+		if t, err = m.LastUpdateFor(ctx, objectName, false); err != nil {
+			return "", err
+		}
+		user.LastUpdate = t.Local().Format(historyTimeLayout)
+	}
+
+	for _, g := range gpos {
+		section, class := &user, "User"
+		if g.IsComputer {
+			section, class = &machine, "Machine"
+		}
+
+		rg := reportGPO{Name: g.Name, ID: g.ID}
+		rulesByDomain := make(map[string][]reportRule)
+		var domains []string
+		for _, r := range g.Rules {
+			if _, ok := rulesByDomain[r.Domain]; !ok {
+				domains = append(domains, r.Domain)
+			}
+			rulesByDomain[r.Domain] = append(rulesByDomain[r.Domain], reportRule{
+				AppliedRule: r,
+				DisplayName: displayNames[class][r.Domain+"/"+r.Key],
+			})
+		}
+		sort.Strings(domains)
+		for _, d := range domains {
+			rg.Domains = append(rg.Domains, reportDomain{Name: d, Rules: rulesByDomain[d]})
+		}
+		section.GPOs = append(section.GPOs, rg)
+	}
+
+	sections := []reportSection{machine}
+	if !isComputer {
+		sections = append(sections, user)
+	}
+
+	var out strings.Builder
+	if err := reportTemplate.Execute(&out, struct {
+		Target   string
+		Offline  bool
+		Sections []reportSection
+	}{
+		Target:   objectName,
+		Offline:  offline,
+		Sections: sections,
+	}); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
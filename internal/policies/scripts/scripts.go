@@ -0,0 +1,423 @@
+// Package scripts stages and runs startup/shutdown and logon/logoff scripts referenced by GPOs.
+package scripts
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/decorate"
+	"github.com/ubuntu/adsys/internal/fileutils"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	adcommon "github.com/ubuntu/adsys/internal/policies/ad/common"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+)
+
+/*
+	Notes:
+	Scripts are referenced in the "scripts" rules as a list of paths, one per line, relative to the GPO cache directory:
+	  <GPO ID>/<Machine|User>/Scripts/<path to script>
+	Once resolved, a script path must stay in the Scripts directory of the GPO it starts with.
+
+	For each object, we stage them in:
+	  <run dir>/machine/                  for the computer
+	  <run dir>/users/<uid>/              for users
+	which contains:
+	  - scripts/: copy of all referenced scripts, keeping their relative path from the GPO cache.
+	  - one order file per script type (startup, shutdown, logon, logoff), listing the scripts to run in order.
+	  - .ready: flag file signaling that the staging is complete.
+
+	The staged scripts are executed by "adsysd runscripts <order file>" from systemd units:
+	  - adsys-machine-scripts.service (system unit) for startup and shutdown.
+	  - adsys-user-scripts.service (user unit) for logon and logoff.
+*/
+
+const (
+	// MachineScriptsUnit is the systemd system unit running machine startup and shutdown scripts.
+	MachineScriptsUnit = "adsys-machine-scripts.service"
+
+	scriptsDirName = "scripts"
+	readyFlag      = ".ready"
+)
+
+var (
+	machineScriptTypes = []string{"startup", "shutdown"}
+	userScriptTypes    = []string{"logon", "logoff"}
+)
+
+// Manager serializes ApplyPolicy calls, so that only one staging directory is swapped with the scripts directory of
+// an object at a time.
+type Manager struct {
+	mu sync.Mutex
+
+	runDir       string
+	gpoCacheDir  string
+	systemctlCmd []string
+	userLookup   func(string) (*user.User, error)
+}
+
+type options struct {
+	runDir       string
+	gpoCacheDir  string
+	systemctlCmd []string
+	userLookup   func(string) (*user.User, error)
+}
+type option func(*options) error
+
+// WithRunDir specifies a personalized /run
+func WithRunDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.runDir = p
+		return nil
+	}
+}
+
+// WithGPOCacheDir specifies a personalized directory where GPOs are downloaded
+func WithGPOCacheDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.gpoCacheDir = p
+		return nil
+	}
+}
+
+// WithSystemCtlCmd specifies a personalized systemctl command
+func WithSystemCtlCmd(cmd []string) func(o *options) error {
+	return func(o *options) error {
+		o.systemctlCmd = cmd
+		return nil
+	}
+}
+
+// WithUserLookup specifies a personalized function to resolve user names
+func WithUserLookup(f func(string) (*user.User, error)) func(o *options) error {
+	return func(o *options) error {
+		o.userLookup = f
+		return nil
+	}
+}
+
+// New returns a new manager for scripts policy handlers.
+func New(opts ...option) (m *Manager, err error) {
+	defer decorate.OnError(&err, i18n.G("can't create a new scripts handler manager"))
+
+	// defaults
+	args := options{
+		runDir:       consts.DefaultRunDir,
+		gpoCacheDir:  filepath.Join(consts.DefaultCacheDir, entry.GPOCacheBaseName),
+		systemctlCmd: []string{"systemctl"},
+		userLookup:   user.Lookup,
+	}
+	// applied options
+	for _, o := range opts {
+		if err := o(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		runDir:       args.runDir,
+		gpoCacheDir:  args.gpoCacheDir,
+		systemctlCmd: args.systemctlCmd,
+		userLookup:   args.userLookup,
+	}, nil
+}
+
+// Extensions returns the client-side extensions whose settings are applied by the scripts manager.
+// Only registry settings are needed: they list the scripts to run, and the script files are downloaded with the files
+// of the GPO.
+func (m *Manager) Extensions() []string {
+	return []string{adcommon.RegistryExtension}
+}
+
+// ApplyPolicy stages the scripts referenced by entries for the machine or the user and
+// generates the order files executed by the systemd units.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply scripts policy to %s"), objectName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "ApplyPolicy scripts policy to %s", objectName)
+
+	objectDir := filepath.Join(m.runDir, "machine")
+	scriptTypes := machineScriptTypes
+	uid, gid := -1, -1
+	if !isComputer {
+		u, err := m.userLookup(objectName)
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't find user %q: %v"), objectName, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf(i18n.G("invalid uid %q for %q: %v"), u.Uid, objectName, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf(i18n.G("invalid gid %q for %q: %v"), u.Gid, objectName, err)
+		}
+		objectDir = filepath.Join(m.runDir, "users", u.Uid)
+		scriptTypes = userScriptTypes
+	}
+
+	// Collect scripts to run per type, with the resolved path of each script in the GPO cache
+	orders := make(map[string][]string)
+	sources := make(map[string]string)
+	var errMsgs []string
+	for _, e := range entries {
+		if !isValidScriptType(e.Key, scriptTypes) {
+			log.Warningf(ctx, i18n.G("Ignoring unsupported script type %q for %s"), e.Key, objectName)
+			continue
+		}
+		if e.Disabled {
+			continue
+		}
+		for _, s := range strings.Split(e.Value, "\n") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			src, err := gpoScriptPath(m.gpoCacheDir, s)
+			if err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
+				continue
+			}
+			orders[e.Key] = append(orders[e.Key], s)
+			sources[s] = src
+		}
+	}
+	if errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
+
+	// Nothing to run: clean up any previous staging.
+	if len(orders) == 0 {
+		log.Debugf(ctx, "No scripts to stage for %s", objectName)
+		return os.RemoveAll(objectDir)
+	}
+
+	// Stage everything in a temporary directory and only commit it if fully created without any errors.
+	if err := os.MkdirAll(filepath.Dir(objectDir), 0755); err != nil {
+		return err
+	}
+	// Users need to traverse the run directory to access their own scripts
+	if !isComputer {
+		for _, d := range []string{m.runDir, filepath.Dir(objectDir)} {
+			// #nosec G302 - directories only contain subdirectories with their own permissions
+			if err := os.Chmod(d, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	stagingDir := objectDir + ".new"
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Info(ctx, i18n.G("Could not clean up temporary directory:"), err)
+		}
+	}()
+	if err := os.MkdirAll(filepath.Join(stagingDir, scriptsDirName), 0700); err != nil {
+		return err
+	}
+
+	for _, t := range scriptTypes {
+		scripts, ok := orders[t]
+		if !ok {
+			continue
+		}
+		var orderContent []string
+		for _, s := range scripts {
+			if err := copyScript(sources[s], filepath.Join(stagingDir, scriptsDirName, s)); err != nil {
+				return err
+			}
+			orderContent = append(orderContent, filepath.Join(scriptsDirName, s))
+		}
+		if err := os.WriteFile(filepath.Join(stagingDir, t), []byte(strings.Join(orderContent, "\n")+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(stagingDir, readyFlag), nil, 0600); err != nil {
+		return err
+	}
+
+	// Users are running their scripts themselves
+	if !isComputer {
+		if err := fileutils.ChownTree(stagingDir, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(objectDir); err != nil {
+		return err
+	}
+	if err := os.Rename(stagingDir, objectDir); err != nil {
+		return err
+	}
+
+	if !isComputer {
+		return nil
+	}
+
+	// Ensure the machine scripts unit is started so that startup scripts are run now (if not already done since boot)
+	// and shutdown scripts will be run when the machine stops.
+	args := append([]string{}, m.systemctlCmd...) // Copy systemctlCmd to prevent data race
+	args = append(args, "start", "--no-block", MachineScriptsUnit)
+	// #nosec G204 - we control the input
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	smbsafe.WaitExec()
+	out, errExec := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if errExec != nil {
+		return fmt.Errorf(i18n.G("can't start %s: %v\n%s"), MachineScriptsUnit, errExec, out)
+	}
+
+	return nil
+}
+
+// RunScripts executes sequentially all scripts listed in the order file.
+// Scripts paths are relative to the directory containing the order file.
+// Failing scripts are logged and don’t prevent following scripts to run.
+func RunScripts(ctx context.Context, order string, allowOrderMissing bool) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't run scripts listed in %s"), order)
+
+	// Only run scripts from a complete staging
+	baseDir := filepath.Dir(order)
+	if _, err := os.Stat(filepath.Join(baseDir, readyFlag)); err != nil {
+		if os.IsNotExist(err) && allowOrderMissing {
+			log.Debugf(ctx, "%s is not ready: no scripts to run", baseDir)
+			return nil
+		}
+		return err
+	}
+
+	f, err := os.Open(filepath.Clean(order))
+	if err != nil {
+		if os.IsNotExist(err) && allowOrderMissing {
+			log.Debugf(ctx, "%s doesn't exist: no scripts to run", order)
+			return nil
+		}
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	var scripts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		scripts = append(scripts, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range scripts {
+		if err := validScriptPath(s); err != nil {
+			log.Warningf(ctx, i18n.G("Skipping %s: %v"), s, err)
+			continue
+		}
+		script := filepath.Join(baseDir, s)
+		log.Infof(ctx, "Running script %s", script)
+		// #nosec G204 - scripts are staged by adsys from the GPOs
+		cmd := exec.CommandContext(ctx, script)
+		cmd.Dir = filepath.Dir(script)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Warningf(ctx, i18n.G("Script %s failed: %v"), script, err)
+		}
+	}
+
+	return nil
+}
+
+// isValidScriptType returns if t is one of the supported script types.
+func isValidScriptType(t string, supported []string) bool {
+	for _, s := range supported {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validScriptPath ensures that p is a relative path which doesn’t escape its parent directory.
+func validScriptPath(p string) error {
+	if filepath.IsAbs(p) {
+		return fmt.Errorf(i18n.G("script path %q should be relative"), p)
+	}
+	if c := filepath.Clean(p); c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf(i18n.G("script path %q is outside of its directory"), p)
+	}
+	return nil
+}
+
+// gpoScriptPath returns the path in gpoCacheDir of the script p, referenced as
+// <GPO ID>/<Machine|User>/Scripts/<path to script>, with all symlinks resolved.
+// The resolved path must stay in the Scripts directory of the GPO referencing it.
+func gpoScriptPath(gpoCacheDir, p string) (string, error) {
+	if err := validScriptPath(p); err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(p, "/", 4)
+	if len(parts) != 4 {
+		return "", fmt.Errorf(i18n.G("script path %q should be <GPO ID>/<Machine|User>/Scripts/<script>"), p)
+	}
+	for _, part := range parts[:3] {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf(i18n.G("script path %q should be <GPO ID>/<Machine|User>/Scripts/<script>"), p)
+		}
+	}
+
+	scriptsDir, err := filepath.EvalSymlinks(filepath.Join(gpoCacheDir, parts[0], parts[1], parts[2]))
+	if err != nil {
+		return "", err
+	}
+	script, err := filepath.EvalSymlinks(filepath.Join(gpoCacheDir, p))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(script, scriptsDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf(i18n.G("script path %q is outside of the scripts directory of its GPO"), p)
+	}
+	return script, nil
+}
+
+// copyScript copies src to dst and make it executable.
+func copyScript(src, dst string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't stage script %s"), src)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnError(in.Close)
+
+	// #nosec G302 - scripts need to be executable
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0700)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,236 @@
+package scripts_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+var update bool
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	machineStartup := "{GPOId}/Machine/Scripts/startup/script-machine-startup"
+	machineShutdown := "{GPOId}/Machine/Scripts/shutdown/script-machine-shutdown"
+	userLogon := "{GPOId}/User/Scripts/logon/script-user-logon"
+	userLogoff := "{GPOId}/User/Scripts/logoff/script-user-logoff"
+
+	tests := map[string]struct {
+		entries               []entry.Entry
+		isUser                bool
+		existingStaging       bool
+		systemctlCmd          []string
+		userLookupFails       bool
+		userLookupBadUID      bool
+		secondCallWithNoRules bool
+
+		wantNoStaging bool
+		wantErr       bool
+	}{
+		// machine cases
+		"machine, startup script": {entries: []entry.Entry{{Key: "startup", Value: machineStartup}}},
+		"machine, startup and shutdown scripts": {entries: []entry.Entry{
+			{Key: "startup", Value: machineStartup},
+			{Key: "shutdown", Value: machineShutdown}}},
+		"machine, multiple scripts are ordered": {entries: []entry.Entry{{Key: "startup", Value: strings.Join([]string{
+			"{GPOId}/Machine/Scripts/startup/other-script-machine-startup",
+			machineStartup,
+			"{OtherGPOId}/Machine/Scripts/startup/script-machine-startup"}, "\n")}}},
+		"machine, script in subdirectory": {entries: []entry.Entry{
+			{Key: "startup", Value: "{GPOId}/Machine/Scripts/subdir/in/tree/script-machine-startup"}}},
+		"machine, empty lines are ignored": {entries: []entry.Entry{{Key: "startup", Value: "\n" + machineStartup + "\n\n"}}},
+		"machine, user script types are ignored": {entries: []entry.Entry{
+			{Key: "startup", Value: machineStartup},
+			{Key: "logon", Value: userLogon}}},
+		"machine, disabled entry is not staged": {entries: []entry.Entry{
+			{Key: "startup", Value: machineStartup},
+			{Key: "shutdown", Value: machineShutdown, Disabled: true}}},
+		"machine, previous staging is replaced": {
+			entries:         []entry.Entry{{Key: "shutdown", Value: machineShutdown}},
+			existingStaging: true},
+
+		// user cases
+		"user, logon script": {entries: []entry.Entry{{Key: "logon", Value: userLogon}}, isUser: true},
+		"user, logon and logoff scripts": {entries: []entry.Entry{
+			{Key: "logon", Value: userLogon},
+			{Key: "logoff", Value: userLogoff}}, isUser: true},
+		"user, machine script types are ignored": {entries: []entry.Entry{
+			{Key: "logon", Value: userLogon},
+			{Key: "startup", Value: machineStartup}}, isUser: true},
+
+		// no scripts cases
+		"no entries does not stage anything":                 {wantNoStaging: true},
+		"only disabled entries does not stage anything":      {entries: []entry.Entry{{Key: "startup", Value: machineStartup, Disabled: true}}, wantNoStaging: true},
+		"no entries removes previous staging":                {existingStaging: true, wantNoStaging: true},
+		"second call with no rules removes previous staging": {entries: []entry.Entry{{Key: "startup", Value: machineStartup}}, secondCallWithNoRules: true, wantNoStaging: true},
+		"user, no entries does not stage anything":           {isUser: true, wantNoStaging: true},
+
+		// error cases
+		"error on missing script":                   {entries: []entry.Entry{{Key: "startup", Value: "{GPOId}/Machine/Scripts/startup/doesnotexist"}}, wantErr: true},
+		"error on absolute script path":             {entries: []entry.Entry{{Key: "startup", Value: "/etc/passwd"}}, wantErr: true},
+		"error on script path outside of gpo cache": {entries: []entry.Entry{{Key: "startup", Value: "../../../etc/passwd"}}, wantErr: true},
+		"error on script path in another gpo": {entries: []entry.Entry{{Key: "startup",
+			Value: "{GPOId}/Machine/Scripts/../../../{OtherGPOId}/Machine/Scripts/startup/script-machine-startup"}}, wantErr: true},
+		"error on script path outside of scripts directory": {entries: []entry.Entry{{Key: "startup",
+			Value: "{GPOId}/Machine/Scripts/../../User/Scripts/logon/script-user-logon"}}, wantErr: true},
+		"error on script path not in a gpo": {entries: []entry.Entry{{Key: "startup", Value: "{GPOId}/script"}}, wantErr: true},
+		"error on systemctl failure":        {entries: []entry.Entry{{Key: "startup", Value: machineStartup}}, systemctlCmd: []string{"false"}, wantErr: true},
+		"error on user lookup failure":      {entries: []entry.Entry{{Key: "logon", Value: userLogon}}, isUser: true, userLookupFails: true, wantErr: true},
+		"error on invalid user uid":         {entries: []entry.Entry{{Key: "logon", Value: userLogon}}, isUser: true, userLookupBadUID: true, wantErr: true},
+		"error on missing script keeps previous staging": {
+			entries:         []entry.Entry{{Key: "startup", Value: "{GPOId}/Machine/Scripts/startup/doesnotexist"}},
+			existingStaging: true,
+			wantErr:         true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runDir := t.TempDir()
+			if tc.systemctlCmd == nil {
+				tc.systemctlCmd = []string{"true"}
+			}
+
+			objectName := "hostname"
+			objectDir := filepath.Join(runDir, "machine")
+			if tc.isUser {
+				objectName = "user@example.com"
+				objectDir = filepath.Join(runDir, "users", fmt.Sprint(os.Getuid()))
+			}
+
+			if tc.existingStaging {
+				require.NoError(t, os.MkdirAll(filepath.Join(objectDir, "scripts", "old"), 0700), "Setup: can't create previous staging")
+				require.NoError(t, os.WriteFile(filepath.Join(objectDir, "startup"), []byte("scripts/old/script\n"), 0600), "Setup: can't create previous order file")
+			}
+
+			m, err := scripts.New(
+				scripts.WithRunDir(runDir),
+				scripts.WithGPOCacheDir(filepath.Join("testdata", "gpo_cache")),
+				scripts.WithSystemCtlCmd(tc.systemctlCmd),
+				scripts.WithUserLookup(func(name string) (*user.User, error) {
+					if tc.userLookupFails {
+						return nil, errors.New("user lookup failure")
+					}
+					u := &user.User{Username: name, Uid: fmt.Sprint(os.Getuid()), Gid: fmt.Sprint(os.Getgid())}
+					if tc.userLookupBadUID {
+						u.Uid = "notanumber"
+					}
+					return u, nil
+				}))
+			require.NoError(t, err, "Setup: can't create scripts manager")
+
+			err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				if tc.existingStaging {
+					require.FileExists(t, filepath.Join(objectDir, "startup"), "Previous staging should be kept on error")
+				}
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			if tc.secondCallWithNoRules {
+				err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, nil)
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			if tc.wantNoStaging {
+				require.NoDirExists(t, objectDir, "No scripts should be staged")
+				return
+			}
+			require.NoDirExists(t, objectDir+".new", "Temporary staging directory should be cleaned up")
+
+			testutils.CompareTreesWithFiltering(t, objectDir, filepath.Join("testdata", "golden", name), update)
+		})
+	}
+}
+
+func TestRunScripts(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		scripts           []string
+		noOrderFile       bool
+		notReady          bool
+		allowOrderMissing bool
+
+		want    string
+		wantErr bool
+	}{
+		"run one script":                         {scripts: []string{"scripts/first"}, want: "first\n"},
+		"run scripts in order":                   {scripts: []string{"scripts/second", "scripts/first"}, want: "second\nfirst\n"},
+		"failing script does not stop execution": {scripts: []string{"scripts/failing", "scripts/first"}, want: "failing\nfirst\n"},
+		"missing script does not stop execution": {scripts: []string{"scripts/doesnotexist", "scripts/first"}, want: "first\n"},
+		"script outside of directory is skipped": {scripts: []string{"../first", "scripts/first"}, want: "first\n"},
+		"empty order file runs nothing":          {},
+		"missing order file is allowed":          {noOrderFile: true, allowOrderMissing: true},
+		"not ready staging is allowed":           {scripts: []string{"scripts/first"}, notReady: true, allowOrderMissing: true},
+
+		"error on missing order file": {noOrderFile: true, wantErr: true},
+		"error on not ready staging":  {scripts: []string{"scripts/first"}, notReady: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			output := filepath.Join(dir, "output")
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "scripts"), 0700), "Setup: can't create scripts directory")
+			for _, s := range []string{"first", "second", "failing"} {
+				content := fmt.Sprintf("#!/bin/sh\necho %s >> %s\n", s, output)
+				if s == "failing" {
+					content += "exit 1\n"
+				}
+				// #nosec G306 - scripts need to be executable
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "scripts", s), []byte(content), 0700), "Setup: can't create script")
+			}
+
+			if !tc.notReady {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ".ready"), nil, 0600), "Setup: can't create ready flag")
+			}
+
+			order := filepath.Join(dir, "startup")
+			if !tc.noOrderFile {
+				require.NoError(t, os.WriteFile(order, []byte(strings.Join(tc.scripts, "\n")), 0600), "Setup: can't create order file")
+			}
+
+			err := scripts.RunScripts(context.Background(), order, tc.allowOrderMissing)
+			if tc.wantErr {
+				require.Error(t, err, "RunScripts should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "RunScripts failed but shouldn't have")
+
+			got, err := os.ReadFile(output)
+			if tc.want == "" {
+				require.True(t, os.IsNotExist(err), "No script should have been executed")
+				return
+			}
+			require.NoError(t, err, "Scripts should have been executed")
+			require.Equal(t, tc.want, string(got), "Scripts should be executed in order")
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.BoolVar(&update, "update", false, "update golden files")
+	flag.Parse()
+
+	m.Run()
+}
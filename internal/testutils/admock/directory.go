@@ -0,0 +1,199 @@
+// Package admock provides an in-memory LDAP directory standing in for Active Directory in tests.
+package admock
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Directory is an in-memory LDAP directory.
+// It answers the subset of searches adsys is doing against Active Directory.
+type Directory struct {
+	baseDN  string
+	localIP net.IP
+
+	entries map[string]*ldap.Entry
+	mu      sync.RWMutex
+}
+
+// NewDirectory returns an empty directory with baseDN as its default naming context.
+func NewDirectory(baseDN string) *Directory {
+	d := &Directory{
+		baseDN:  baseDN,
+		entries: make(map[string]*ldap.Entry),
+	}
+	d.Add(baseDN, map[string][]string{"objectClass": {"top", "domain", "domainDNS"}})
+	return d
+}
+
+// Add adds or replaces the entry dn with the given attributes.
+// Binary attribute values are passed as raw strings.
+func (d *Directory) Add(dn string, attributes map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries[strings.ToLower(dn)] = ldap.NewEntry(dn, attributes)
+}
+
+// Search runs a search request against the directory.
+// Base, single level and subtree scopes are supported with equality, presence, and, or and not filters.
+// An empty base DN returns the root DSE.
+func (d *Directory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	filter, err := ldap.CompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.BaseDN == "" && req.Scope == ldap.ScopeBaseObject {
+		attrs := map[string][]string{"defaultNamingContext": {d.baseDN}}
+		// The configuration partition is only advertised when it exists in the directory.
+		if configDN := "CN=Configuration," + d.baseDN; d.entries[strings.ToLower(configDN)] != nil {
+			attrs["configurationNamingContext"] = []string{configDN}
+		}
+		rootDSE := ldap.NewEntry("", attrs)
+		return &ldap.SearchResult{Entries: []*ldap.Entry{filterAttributes(rootDSE, req.Attributes)}}, nil
+	}
+
+	base := strings.ToLower(req.BaseDN)
+	if _, ok := d.entries[base]; !ok {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such object: %s", req.BaseDN))
+	}
+
+	var dns []string
+	for dn := range d.entries {
+		switch req.Scope {
+		case ldap.ScopeBaseObject:
+			if dn != base {
+				continue
+			}
+		case ldap.ScopeSingleLevel:
+			if parent(dn) != base {
+				continue
+			}
+		default:
+			if dn != base && !strings.HasSuffix(dn, ","+base) {
+				continue
+			}
+		}
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+
+	r := &ldap.SearchResult{}
+	for _, dn := range dns {
+		e := d.entries[dn]
+		match, err := matches(e, filter)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
+		r.Entries = append(r.Entries, filterAttributes(e, req.Attributes))
+	}
+	return r, nil
+}
+
+// SetLocalIP sets the address the client is reaching the directory from.
+func (d *Directory) SetLocalIP(ip net.IP) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.localIP = ip
+}
+
+// LocalIP returns the address the client is reaching the directory from. It is nil unless set by SetLocalIP.
+func (d *Directory) LocalIP() net.IP {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.localIP
+}
+
+// Close does nothing but allows the directory to be used as a connection.
+func (d *Directory) Close() error {
+	return nil
+}
+
+// matches returns if the entry e matches the compiled filter f.
+func matches(e *ldap.Entry, f *ber.Packet) (bool, error) {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			ok, err := matches(e, c)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			ok, err := matches(e, c)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case ldap.FilterNot:
+		ok, err := matches(e, f.Children[0])
+		return !ok, err
+	case ldap.FilterPresent:
+		return len(e.GetEqualFoldAttributeValues(f.Data.String())) > 0, nil
+	case ldap.FilterEqualityMatch:
+		attr, ok := f.Children[0].Value.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid attribute in filter: %v", f.Children[0].Value)
+		}
+		want := f.Children[1].Data.String()
+		for _, v := range e.GetEqualFoldAttributeValues(attr) {
+			if strings.EqualFold(v, want) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unsupported filter type %d", f.Tag)
+}
+
+// filterAttributes returns a copy of e only containing the requested attributes.
+func filterAttributes(e *ldap.Entry, attributes []string) *ldap.Entry {
+	r := &ldap.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		if len(attributes) > 0 && !containsFold(attributes, a.Name) {
+			continue
+		}
+		r.Attributes = append(r.Attributes, a)
+	}
+	return r
+}
+
+func containsFold(l []string, s string) bool {
+	for _, e := range l {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parent returns the parent DN of dn, splitting on the first unescaped comma.
+func parent(dn string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[i+1:]
+		}
+	}
+	return ""
+}
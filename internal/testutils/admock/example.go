@@ -0,0 +1,394 @@
+package admock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Flags as stored in Active Directory for GPOs, links and containers.
+const (
+	gpLinkOptDisable      = 1 << 0
+	gpLinkOptEnforce      = 1 << 1
+	gpoBlockInheritance   = 1 << 0
+	gpoFlagUserDisable    = 1 << 0
+	gpoFlagMachineDisable = 1 << 1
+)
+
+const (
+	// ExampleBaseDN is the default naming context of the example directory.
+	ExampleBaseDN = "DC=example,DC=com"
+	// ExampleDomainSID is the domain SID of the example directory.
+	ExampleDomainSID = "S-1-5-21-16178157-162784614-155579044"
+	// ExampleAccountSID is the SID given to every user account of the example directory.
+	ExampleAccountSID = ExampleDomainSID + "-1103"
+	// ExampleComputerSID is the SID given to every computer account of the example directory.
+	ExampleComputerSID = ExampleDomainSID + "-1105"
+
+	configurationDN = "CN=Configuration," + ExampleBaseDN
+	sitesDN         = "CN=Sites," + configurationDN
+	subnetsDN       = "CN=Subnets," + sitesDN
+
+	// DefaultGPOExtensionNames lists the registry client-side extension with its administrative templates tool, as
+	// set on the example GPOs for machines and users.
+	DefaultGPOExtensionNames = "[{35378EAC-683F-11D2-A89A-0080C7A4D040}{0F6B957D-509E-11D1-A7CC-0000F87571E3}]"
+
+	authenticatedUsersSID = "S-1-5-11"
+	otherUserSID          = ExampleDomainSID + "-1104"
+)
+
+// Dial mimics connecting and binding with Kerberos to url.
+// krb5CCPath must be a symlink to a valid ticket, as adsys creates.
+// URLs of the form ldap://NT_STATUS_* fail with a network error.
+// It returns the Example directory.
+func Dial(url, krb5CCPath string) (*Directory, error) {
+	if strings.HasPrefix(url, "ldap://NT_STATUS_") {
+		return nil, ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("dial %s: %s", url, strings.TrimPrefix(url, "ldap://")))
+	}
+
+	target, err := os.Readlink(krb5CCPath)
+	if err != nil {
+		return nil, fmt.Errorf("ticket %q is not a symlink: %v", krb5CCPath, err)
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return nil, fmt.Errorf("ticket %q does not exist: %v", target, err)
+	}
+	if strings.Contains(strings.Split(string(content), "\n")[0], "invalid") {
+		return nil, errors.New("invalid Kerberos ticket")
+	}
+
+	return Example(), nil
+}
+
+// DefaultGPOSecurityDescriptor is the security descriptor of a GPO created with default settings.
+// Authenticated users can read and apply it.
+var DefaultGPOSecurityDescriptor = EncodeSecurityDescriptor(ExampleDomainSID+"-512", ExampleDomainSID+"-512",
+	defaultGPOACEs(authenticatedUsersSID)...)
+
+func defaultGPOACEs(applySID string) []ACE {
+	return []ACE{
+		{Type: AccessAllowed, Mask: ReadProperty | WriteProperty | ListChildren | ControlAccess | ReadControl, SID: ExampleDomainSID + "-1102"},
+		{Type: AccessAllowed, Flags: ContainerInherit, Mask: FullControl, SID: ExampleDomainSID + "-512"},
+		{Type: AccessAllowed, Flags: ContainerInherit, Mask: FullControl, SID: ExampleDomainSID + "-519"},
+		{Type: AccessAllowed, Flags: ContainerInherit, Mask: GenericRead, SID: "S-1-5-9"},
+		{Type: AccessAllowed, Flags: ContainerInherit, Mask: GenericRead, SID: authenticatedUsersSID},
+		{Type: AccessAllowedObject, Flags: ContainerInherit, Mask: ControlAccess, ObjectType: ApplyGroupPolicyGUID, SID: applySID},
+		{Type: AccessAllowed, Flags: ContainerInherit, Mask: FullControl, SID: "S-1-5-18"},
+		{Type: AccessAllowed, Flags: ContainerInherit | InheritOnly, Mask: FullControl, SID: "S-1-3-0"},
+	}
+}
+
+// Example returns the directory used in adsys tests:
+//
+//	example.com
+//	          -- Default Domain Policy    <- UserAtRoot
+//	example.com/IT
+//	          -- IT GPO
+//	example.com/IT/ITDep1                   <- hostname1   <- hostnameWithLon // truncated computer name
+//	          -- ITDep1 GPO
+//	example.com/IT/ITDep2                   <- hostname2
+//	          -- ITDep2 User only GPO                                 <- machine flag disabled
+//	example.com/IT/ITDep3                   <- hostname3
+//	          -- ITDep3 allow for computers only GPO                  <- apply right only for computers
+//	example.com/RnD                         <- RnDUser
+//	          -- RnD GPO
+//	example.com/RnD/RnDDep1                 <- RnDUserDep1
+//	          -- RnDDep1 GPO1
+//	          -- RnDDep1 GPO2
+//	example.com/RnD/RnDDep2
+//	          -- RnDDep2 GPO
+//	          -- RnDDep2 Forced GPO                                   <- forced GPO
+//	example.com/RnD/RnDDep2/SubDep2ForcedPolicy     <- RndUserSubDep2ForcedPolicy
+//	          -- SubDep2ForcedPolicy Forced GPO                       <- forced GPO
+//	example.com/RnD/RnDDep2/SubDep2BlockInheritance                      <- block inheritance
+//	          -- SubDep2BlockInheritance GPO
+//	example.com/RnD/RnDDep2/SubDep2BlockInheritance/SubBlocked   <- RnDUserWithBlockedInheritanceAndForcedPolicies
+//	          -- SubBlocked GPO
+//	example.com/RnD/RnDDep3                 <- RnDUserDep3
+//	          -- RnDDep3 Disabled GPO                                 <- disabled gpo link
+//	          -- RnDDep3 GPO
+//	example.com/RnD/RnDDep4                 <- RnDUserDep4
+//	          -- RnDDep4 Security descriptor missing GPO              <- security descriptor missing
+//	example.com/RnD/RnDDep5                 <- RnDUserDep5
+//	          -- RnDDep5 security access failed GPO                   <- read access denied to the user
+//	example.com/RnD/RnDDep6                 <- RnDUserDep6
+//	          -- RnDDep6 security access denied GPO                   <- apply right denied to the user
+//	example.com/RnD/RnDDep7                 <- RnDUserDep7
+//	          -- RnDDep7 machine only GPO                             <- user flag disabled
+//	example.com/RnD/RnDDep8                 <- RnDUserDep8
+//	          -- RnDDep8 allow for one user only GPO                  <- apply right only for another user
+//	example.com/RnD/RnDDep9                 <- RnDUserDep9
+//	          -- RnDDep9 no extension GPO                             <- no client-side extension
+//	          -- RnDDep9 scripts and registry GPO                     <- scripts and registry extensions
+//	          -- RnDDep9 invalid extensions GPO                       <- badly formed extension names
+//	example.com/RnD/RnDDepBlockInheritance  <- RnDUserWithBlockedInheritance      <- block inheritance
+//	          -- RnDDepBlockInheritance GPO
+//	example.com/NoGPO                       <- UserNoGPO
+//	example.com/NogPOptions                 <- UserNogPOptions
+//	          -- NogPOptions GPO
+//	example.com/InvalidGPOLink              <- UserInvalidLink
+//
+//	Site Paris                              <- subnets 10.1.0.0/16 and 2001:db8::/32
+//	          -- Paris Site GPO
+//	Site ParisLab                           <- subnet 10.1.2.0/24
+//	          -- ParisLab Site GPO
+//	          -- ParisLab Forced Site GPO                             <- forced GPO
+//	Site NoGPOSite                          <- subnet 10.2.0.0/16
+//
+//	example.com/IntegrationTests/Dep1                 <- [CURRENT_HOSTNAME]
+//	          -- {C4F393CA-AD9A-4595-AEBC-3FA6EE484285} "GPO for current machine"
+//	example.com/IntegrationTests/Dep2                 <- MachineIntegrationTest
+//	          -- {B8D10A86-0B78-4899-91AF-6F0124ECEB48} "GPO for MachineIntegrationTest"
+//	example.com/IntegrationTests/UserDep              <- UserIntegrationTest
+//	          -- {75545F76-DEC2-4ADA-B7B8-D5209FD48727} "GPO for Integration Test User"
+//	example.com/IntegrationTests/UserDep/Dep1         <- [CURRENT_USER]
+//	          -- {5EC4DF8F-FF4E-41DE-846B-52AA6FFAF242} "GPO1 for current User"
+//	          -- {073AA7FC-5C1A-4A12-9AFC-42EC9C5CAF04} "GPO2 for current User"
+//
+// Sites are only used when the local address is set with SetLocalIP.
+// GPOs are served from localhost on the port set in $ADSYS_TESTS_SMB_PORT, if any.
+func Example() *Directory {
+	smbHost := "localhost"
+	if port := os.Getenv("ADSYS_TESTS_SMB_PORT"); port != "" {
+		smbHost += ":" + port
+	}
+	b := exampleBuilder{d: NewDirectory(ExampleBaseDN), smbHost: smbHost}
+
+	b.ou("", 0, gpo{name: "{31B2F340-016D-11D2-945F-00C04FB984F9}", displayName: "Default Domain Policy"})
+	b.account("", "UserAtRoot")
+
+	b.ou("IT", 0, gpo{name: "IT GPO"})
+	b.ou("IT/ITDep1", 0, gpo{name: "ITDep1 GPO"})
+	b.account("IT/ITDep1", "hostname1")
+	b.account("IT/ITDep1", "hostnameWithLon")
+	b.ou("IT/ITDep2", 0, gpo{name: "ITDep2 User only GPO", flags: gpoFlagMachineDisable})
+	b.account("IT/ITDep2", "hostname2")
+	b.ou("IT/ITDep3", 0, gpo{name: "ITDep3 allow for computers only GPO",
+		securityDescriptor: EncodeSecurityDescriptor(ExampleDomainSID+"-512", ExampleDomainSID+"-512", defaultGPOACEs(ExampleComputerSID)...)})
+	b.account("IT/ITDep3", "hostname3")
+
+	b.ou("RnD", 0, gpo{name: "RnD GPO"})
+	b.account("RnD", "RnDUser")
+	b.ou("RnD/RnDDep1", 0, gpo{name: "RnDDep1 GPO1"}, gpo{name: "RnDDep1 GPO2"})
+	b.account("RnD/RnDDep1", "RnDUserDep1")
+	b.ou("RnD/RnDDep2", 0, gpo{name: "RnDDep2 GPO"}, gpo{name: "RnDDep2 Forced GPO", enforced: true})
+	b.ou("RnD/RnDDep2/SubDep2ForcedPolicy", 0, gpo{name: "SubDep2ForcedPolicy Forced GPO", enforced: true})
+	b.account("RnD/RnDDep2/SubDep2ForcedPolicy", "RndUserSubDep2ForcedPolicy")
+	b.ou("RnD/RnDDep2/SubDep2BlockInheritance", gpoBlockInheritance, gpo{name: "SubDep2BlockInheritance GPO"})
+	b.ou("RnD/RnDDep2/SubDep2BlockInheritance/SubBlocked", 0, gpo{name: "SubBlocked GPO"})
+	b.account("RnD/RnDDep2/SubDep2BlockInheritance/SubBlocked", "RnDUserWithBlockedInheritanceAndForcedPolicies")
+	b.ou("RnD/RnDDep3", 0, gpo{name: "RnDDep3 Disabled GPO", disabled: true}, gpo{name: "RnDDep3 GPO"})
+	b.account("RnD/RnDDep3", "RnDUserDep3")
+	b.ou("RnD/RnDDep4", 0, gpo{name: "RnDDep4 Security descriptor missing GPO", noSecurityDescriptor: true})
+	b.account("RnD/RnDDep4", "RnDUserDep4")
+	b.ou("RnD/RnDDep5", 0, gpo{name: "RnDDep5 security access failed GPO",
+		securityDescriptor: EncodeSecurityDescriptor(ExampleDomainSID+"-512", ExampleDomainSID+"-512",
+			append([]ACE{{Type: AccessDenied, Mask: ReadProperty | ListChildren | ReadControl, SID: ExampleAccountSID}},
+				defaultGPOACEs(authenticatedUsersSID)...)...)})
+	b.account("RnD/RnDDep5", "RnDUserDep5")
+	b.ou("RnD/RnDDep6", 0, gpo{name: "RnDDep6 security access denied GPO",
+		securityDescriptor: EncodeSecurityDescriptor(ExampleDomainSID+"-512", ExampleDomainSID+"-512",
+			append([]ACE{{Type: AccessDeniedObject, Mask: ControlAccess, ObjectType: ApplyGroupPolicyGUID, SID: ExampleAccountSID}},
+				defaultGPOACEs(authenticatedUsersSID)...)...)})
+	b.account("RnD/RnDDep6", "RnDUserDep6")
+	b.ou("RnD/RnDDep7", 0, gpo{name: "RnDDep7 machine only GPO", flags: gpoFlagUserDisable})
+	b.account("RnD/RnDDep7", "RnDUserDep7")
+	b.ou("RnD/RnDDep8", 0, gpo{name: "RnDDep8 allow for one user only GPO",
+		securityDescriptor: EncodeSecurityDescriptor(ExampleDomainSID+"-512", ExampleDomainSID+"-512", defaultGPOACEs(otherUserSID)...)})
+	b.account("RnD/RnDDep8", "RnDUserDep8")
+	b.ou("RnD/RnDDep9", 0, gpo{name: "RnDDep9 no extension GPO", noExtensions: true},
+		gpo{name: "RnDDep9 scripts and registry GPO",
+			extensionNames: "[{35378eac-683f-11d2-a89a-0080c7a4d040}{0F6B957D-509E-11D1-A7CC-0000F87571E3}][{42B5FAAE-6536-11D2-AE5A-0000F87571E3}{40B66650-4972-11D1-A7CA-0000F87571E3}]"},
+		gpo{name: "RnDDep9 invalid extensions GPO", extensionNames: "{35378EAC-683F-11D2-A89A-0080C7A4D040}"})
+	b.account("RnD/RnDDep9", "RnDUserDep9")
+	b.ou("RnD/RnDDepBlockInheritance", gpoBlockInheritance, gpo{name: "RnDDepBlockInheritance GPO"})
+	b.account("RnD/RnDDepBlockInheritance", "RnDUserWithBlockedInheritance")
+
+	b.ou("NoGPO", 0)
+	b.account("NoGPO", "UserNoGPO")
+	b.ou("NogPOptions", -1, gpo{name: "NogPOptions GPO"})
+	b.account("NogPOptions", "UserNogPOptions")
+	b.d.Add(b.dn("InvalidGPOLink"), map[string][]string{
+		"objectClass": {"top", "organizationalUnit"},
+		"gPLink":      {"[invalidlink;0]"},
+		"gPOptions":   {"0"},
+	})
+	b.account("InvalidGPOLink", "UserInvalidLink")
+
+	// Sites and subnets in the configuration partition
+	b.site("Paris", []string{"10.1.0.0/16", "2001:db8::/32"}, gpo{name: "Paris Site GPO"})
+	b.site("ParisLab", []string{"10.1.2.0/24"}, gpo{name: "ParisLab Site GPO"}, gpo{name: "ParisLab Forced Site GPO", enforced: true})
+	b.site("NoGPOSite", []string{"10.2.0.0/16"})
+	b.d.Add(fmt.Sprintf("CN=invalid subnet,%s", subnetsDN), map[string][]string{
+		"objectClass": {"top", "subnet"},
+		"cn":          {"invalid subnet"},
+		"siteObject":  {fmt.Sprintf("CN=Paris,%s", sitesDN)},
+	})
+
+	// Integration tests
+	b.ou("IntegrationTests", 0)
+	b.ou("IntegrationTests/Dep1", 0, gpo{name: "{C4F393CA-AD9A-4595-AEBC-3FA6EE484285}", displayName: "GPO for current machine"})
+	if hostname, err := os.Hostname(); err == nil {
+		b.account("IntegrationTests/Dep1", hostname)
+	}
+	b.ou("IntegrationTests/Dep2", 0, gpo{name: "{B8D10A86-0B78-4899-91AF-6F0124ECEB48}", displayName: "GPO for MachineIntegrationTest"})
+	b.account("IntegrationTests/Dep2", "MachineIntegrationTest")
+	b.ou("IntegrationTests/UserDep", 0, gpo{name: "{75545F76-DEC2-4ADA-B7B8-D5209FD48727}", displayName: "GPO for Integration Test User"})
+	b.account("IntegrationTests/UserDep", "UserIntegrationTest")
+	b.ou("IntegrationTests/UserDep/Dep1", 0,
+		gpo{name: "{5EC4DF8F-FF4E-41DE-846B-52AA6FFAF242}", displayName: "GPO1 for current User"},
+		gpo{name: "{073AA7FC-5C1A-4A12-9AFC-42EC9C5CAF04}", displayName: "GPO2 for current User"})
+	if u, err := user.Current(); err == nil {
+		b.account("IntegrationTests/UserDep/Dep1", strings.Split(u.Username, "@")[0])
+	}
+
+	return b.d
+}
+
+type gpo struct {
+	name        string
+	displayName string
+	flags       int
+	enforced    bool
+	disabled    bool
+
+	securityDescriptor   string
+	noSecurityDescriptor bool
+
+	// extensionNames are the client-side extensions of the GPO for machines and users.
+	// They default to DefaultGPOExtensionNames.
+	extensionNames string
+	noExtensions   bool
+}
+
+type exampleBuilder struct {
+	d       *Directory
+	smbHost string
+}
+
+// dn returns the DN of the OU at path, like RnD/RnDDep1, under the base DN.
+func (b exampleBuilder) dn(path string) string {
+	dn := ExampleBaseDN
+	if path == "" {
+		return dn
+	}
+	for _, ou := range strings.Split(path, "/") {
+		dn = fmt.Sprintf("OU=%s,%s", ou, dn)
+	}
+	return dn
+}
+
+// ou adds the OU at path with gPOptions, linked to gpos in order.
+// The base DN is updated when path is empty. gPOptions is not set if negative.
+func (b exampleBuilder) ou(path string, gPOptions int, gpos ...gpo) {
+	attrs := map[string][]string{"objectClass": {"top", "organizationalUnit"}}
+	if path == "" {
+		attrs["objectClass"] = []string{"top", "domain", "domainDNS"}
+	}
+	if gPOptions >= 0 {
+		attrs["gPOptions"] = []string{fmt.Sprint(gPOptions)}
+	}
+
+	if gPLink := b.link(gpos); gPLink != "" {
+		attrs["gPLink"] = []string{gPLink}
+	}
+
+	b.d.Add(b.dn(path), attrs)
+}
+
+// site adds the site name containing subnets to the configuration partition, linked to gpos in order.
+// The configuration partition and its containers are (re)created on each call.
+func (b exampleBuilder) site(name string, subnets []string, gpos ...gpo) {
+	for _, dn := range []string{configurationDN, sitesDN, subnetsDN} {
+		b.d.Add(dn, map[string][]string{"objectClass": {"top", "container"}})
+	}
+
+	dn := fmt.Sprintf("CN=%s,%s", name, sitesDN)
+	attrs := map[string][]string{"objectClass": {"top", "site"}}
+	if gPLink := b.link(gpos); gPLink != "" {
+		attrs["gPLink"] = []string{gPLink}
+	}
+	b.d.Add(dn, attrs)
+
+	for _, subnet := range subnets {
+		b.d.Add(fmt.Sprintf("CN=%s,%s", subnet, subnetsDN), map[string][]string{
+			"objectClass": {"top", "subnet"},
+			"cn":          {subnet},
+			"siteObject":  {dn},
+		})
+	}
+}
+
+// link creates gpos and returns the gPLink value linking to them in order.
+func (b exampleBuilder) link(gpos []gpo) string {
+	var gPLink strings.Builder
+	for _, g := range gpos {
+		id := strings.ReplaceAll(g.name, " ", "_")
+		dn := fmt.Sprintf("CN=%s,CN=Policies,CN=System,%s", id, ExampleBaseDN)
+
+		var opts int
+		if g.disabled {
+			opts |= gpLinkOptDisable
+		}
+		if g.enforced {
+			opts |= gpLinkOptEnforce
+		}
+		fmt.Fprintf(&gPLink, "[LDAP://%s;%d]", dn, opts)
+
+		displayName := g.displayName
+		if displayName == "" {
+			displayName = g.name
+		}
+		gpoAttrs := map[string][]string{
+			"objectClass":    {"top", "container", "groupPolicyContainer"},
+			"name":           {id},
+			"displayName":    {displayName},
+			"flags":          {fmt.Sprint(g.flags)},
+			"gPCFileSysPath": {fmt.Sprintf(`\\%s\SYSVOL\example.com\Policies\%s`, b.smbHost, id)},
+		}
+		if !g.noExtensions {
+			extensionNames := g.extensionNames
+			if extensionNames == "" {
+				extensionNames = DefaultGPOExtensionNames
+			}
+			gpoAttrs["gPCMachineExtensionNames"] = []string{extensionNames}
+			gpoAttrs["gPCUserExtensionNames"] = []string{extensionNames}
+		}
+		if !g.noSecurityDescriptor {
+			sd := g.securityDescriptor
+			if sd == "" {
+				sd = DefaultGPOSecurityDescriptor
+			}
+			gpoAttrs["nTSecurityDescriptor"] = []string{sd}
+		}
+		b.d.Add(dn, gpoAttrs)
+	}
+	return gPLink.String()
+}
+
+// account adds a user or computer account in the OU at path.
+// Accounts starting with "hostname" or matching the current host name are computers.
+func (b exampleBuilder) account(path, name string) {
+	objectClass := []string{"top", "person", "organizationalPerson", "user"}
+	samAccountName := name
+	sid := ExampleAccountSID
+	primaryGroup := ExampleDomainSID + "-513"
+	if hostname, _ := os.Hostname(); strings.HasPrefix(name, "hostname") || name == hostname {
+		objectClass = append(objectClass, "computer")
+		samAccountName += "$"
+		sid = ExampleComputerSID
+		primaryGroup = ExampleDomainSID + "-515"
+	}
+
+	b.d.Add(fmt.Sprintf("CN=%s,%s", name, b.dn(path)), map[string][]string{
+		"objectClass":    objectClass,
+		"sAMAccountName": {samAccountName},
+		"objectSid":      {EncodeSID(sid)},
+		"tokenGroups":    {EncodeSID(primaryGroup)},
+	})
+}
@@ -1,11 +0,0 @@
-MUST_USE_KERBEROS = "KRB5"
-
-class Credentials():
-    def __init__(self):
-        pass
-
-    def set_kerberos_state(self, val):
-        pass
-
-    def guess(self, val):
-        pass
@@ -1,7 +0,0 @@
-GPO_BLOCK_INHERITANCE = 1
-
-GPLINK_OPT_DISABLE = 1<<0
-GPLINK_OPT_ENFORCE = 1<<1
-
-GPO_FLAG_USER_DISABLE = 1<<0
-GPO_FLAG_MACHINE_DISABLE = 1<<1
@@ -1,2 +0,0 @@
-def LoadParm():
-    return
\ No newline at end of file
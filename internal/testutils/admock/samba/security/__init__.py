@@ -1,3 +0,0 @@
-def access_check(secdesc, token, flag):
-    if secdesc.secdesc == "FAILED":
-        raise RuntimeError("access_check() failed requested")
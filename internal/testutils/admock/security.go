@@ -0,0 +1,157 @@
+package admock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ACE types.
+const (
+	AccessAllowed       = 0x00
+	AccessDenied        = 0x01
+	AccessAllowedObject = 0x05
+	AccessDeniedObject  = 0x06
+)
+
+// ACE flags.
+const (
+	ContainerInherit = 0x02
+	InheritOnly      = 0x08
+)
+
+// Access rights.
+const (
+	CreateChild   = 0x00000001
+	DeleteChild   = 0x00000002
+	ListChildren  = 0x00000004
+	SelfWrite     = 0x00000008
+	ReadProperty  = 0x00000010
+	WriteProperty = 0x00000020
+	DeleteTree    = 0x00000040
+	ListObject    = 0x00000080
+	ControlAccess = 0x00000100
+	Delete        = 0x00010000
+	ReadControl   = 0x00020000
+	WriteDAC      = 0x00040000
+	WriteOwner    = 0x00080000
+
+	// FullControl is all the rights above.
+	FullControl = 0x000f01ff
+	// GenericRead is what "Read" is granting on a directory object.
+	GenericRead = ReadControl | ListChildren | ListObject | ReadProperty
+)
+
+// ApplyGroupPolicyGUID is the extended right to apply a GPO.
+const ApplyGroupPolicyGUID = "edacfd8f-ffb3-11d1-b41d-00a0c968f939"
+
+// ACE is an access control entry of a security descriptor DACL.
+// ObjectType is only used for object ACE types.
+type ACE struct {
+	Type       uint8
+	Flags      uint8
+	Mask       uint32
+	ObjectType string
+	SID        string
+}
+
+// EncodeSID returns the binary representation of the string SID s.
+// It panics on invalid SIDs as it is only used to build test fixtures.
+func EncodeSID(s string) string {
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || parts[0] != "S" {
+		panic(fmt.Sprintf("invalid SID %q", s))
+	}
+	rev, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		panic(fmt.Sprintf("invalid SID revision in %q: %v", s, err))
+	}
+	auth, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		panic(fmt.Sprintf("invalid SID authority in %q: %v", s, err))
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(byte(rev))
+	b.WriteByte(byte(len(parts) - 3))
+	var a [8]byte
+	binary.BigEndian.PutUint64(a[:], auth)
+	b.Write(a[2:])
+	for _, p := range parts[3:] {
+		sub, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			panic(fmt.Sprintf("invalid SID sub authority in %q: %v", s, err))
+		}
+		_ = binary.Write(&b, binary.LittleEndian, uint32(sub))
+	}
+	return b.String()
+}
+
+// EncodeSecurityDescriptor returns a self-relative binary security descriptor with owner, group and a DACL made of aces.
+func EncodeSecurityDescriptor(owner, group string, aces ...ACE) string {
+	var dacl bytes.Buffer
+	for _, a := range aces {
+		var body bytes.Buffer
+		_ = binary.Write(&body, binary.LittleEndian, a.Mask)
+		if a.Type == AccessAllowedObject || a.Type == AccessDeniedObject {
+			var flags uint32
+			if a.ObjectType != "" {
+				flags = 1
+			}
+			_ = binary.Write(&body, binary.LittleEndian, flags)
+			if a.ObjectType != "" {
+				body.Write(encodeGUID(a.ObjectType))
+			}
+		}
+		body.WriteString(EncodeSID(a.SID))
+
+		dacl.WriteByte(a.Type)
+		dacl.WriteByte(a.Flags)
+		_ = binary.Write(&dacl, binary.LittleEndian, uint16(4+body.Len()))
+		dacl.Write(body.Bytes())
+	}
+
+	ownerSID, groupSID := EncodeSID(owner), EncodeSID(group)
+
+	const headerLen = 20
+	const aclHeaderLen = 8
+	ownerOffset := headerLen
+	groupOffset := ownerOffset + len(ownerSID)
+	daclOffset := groupOffset + len(groupSID)
+
+	var b bytes.Buffer
+	// Revision, Sbz1, Control: SE_SELF_RELATIVE | SE_DACL_PRESENT
+	b.Write([]byte{1, 0})
+	_ = binary.Write(&b, binary.LittleEndian, uint16(0x8004))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(ownerOffset))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(groupOffset))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(daclOffset))
+	b.WriteString(ownerSID)
+	b.WriteString(groupSID)
+	// ACL header: Revision (DS), Sbz1, AclSize, AceCount, Sbz2
+	b.Write([]byte{4, 0})
+	_ = binary.Write(&b, binary.LittleEndian, uint16(aclHeaderLen+dacl.Len()))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(len(aces)))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(0))
+	b.Write(dacl.Bytes())
+
+	return b.String()
+}
+
+// encodeGUID returns the mixed-endian binary representation of the string GUID s.
+func encodeGUID(s string) []byte {
+	d, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(d) != 16 {
+		panic(fmt.Sprintf("invalid GUID %q", s))
+	}
+	return []byte{
+		d[3], d[2], d[1], d[0],
+		d[5], d[4],
+		d[7], d[6],
+		d[8], d[9], d[10], d[11], d[12], d[13], d[14], d[15],
+	}
+}
@@ -49,7 +49,7 @@ func testCoverageFile() string {
 func appendToFile(src, dst string) error {
 	f, err := os.Open(filepath.Clean(src))
 	if err != nil {
-		return fmt.Errorf("can't open python coverage file named: %v", err)
+		return fmt.Errorf("can't open coverage file named: %v", err)
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
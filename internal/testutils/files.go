@@ -40,7 +40,7 @@ func CompareTreesWithFiltering(t *testing.T, p, goldPath string, update bool) {
 	}
 	assert.Equal(t, goldContent, gotContent, "got and expected content differs")
 
-	// Verify that each <DB>.d has a corresponding gvariant db generated by dconf update
+	// Verify that each <DB>.d has a corresponding compiled gvariant db
 	// search for dconfDir
 	dconfDir := p
 	err = filepath.WalkDir(dconfDir, func(p string, info fs.DirEntry, err error) error {
@@ -61,11 +61,36 @@ func CompareTreesWithFiltering(t *testing.T, p, goldPath string, update bool) {
 	dbs, err := filepath.Glob(filepath.Join(dconfDir, "db", "*.d"))
 	require.NoError(t, err, "Checking pattern for dconf db failed")
 	for _, db := range dbs {
-		_, err = os.Stat(strings.TrimSuffix(db, ".db"))
+		_, err = os.Stat(strings.TrimSuffix(db, ".d"))
 		assert.NoError(t, err, "Binary version of dconf DB should exists")
 	}
 }
 
+// RemoveEmptyDirs removes every empty directory under root, deepest first.
+// Git doesn't keep empty directories: call it before comparing a tree to a golden one.
+func RemoveEmptyDirs(t *testing.T, root string) {
+	t.Helper()
+
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	require.NoError(t, err, "Teardown: can't list directories")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		require.NoError(t, err, "Teardown: can't read directory")
+		if len(entries) == 0 {
+			require.NoError(t, os.Remove(dirs[i]), "Teardown: can't remove empty directory")
+		}
+	}
+}
+
 // treeContent builds a recursive file list of dir with their content
 // It can ignore files starting with ignoreHeaders.
 func treeContent(t *testing.T, dir string, ignoreHeaders []byte) (map[string]string, error) {